@@ -524,6 +524,9 @@ take effect.
 		return err
 	}
 
+	// background pin verification - if Pinning.Verify.Interval is set
+	pinVerifyErrc := runPinVerify(req, node)
+
 	// Add any files downloaded by migration.
 	if cacheMigrations || pinMigrations {
 		err = addMigrations(cctx.Context(), node, fetcher, pinMigrations)
@@ -571,10 +574,16 @@ take effect.
 	// TODO(9285): make metrics more configurable
 	// initialize metrics collector
 	prometheus.MustRegister(&corehttp.IpfsNodeCollector{Node: node})
+	if node.BitswapLedger != nil {
+		prometheus.MustRegister(node.BitswapLedger)
+	}
 
 	// start MFS pinning thread
 	startPinMFS(daemonConfigPollInterval, cctx, &ipfsPinMFSNode{node})
 
+	// start remote pin replication thread
+	startPinReplicate(daemonConfigPollInterval, cctx, &ipfsPinReplicateNode{node})
+
 	// The daemon is *finally* ready.
 	fmt.Printf("Daemon is ready\n")
 	notifyReady()
@@ -622,7 +631,7 @@ take effect.
 	// collect long-running errors and block for shutdown
 	// TODO(cryptix): our fuse currently doesn't follow this pattern for graceful shutdown
 	var errs error
-	for err := range merge(apiErrc, gwErrc, gcErrc, p2pGwErrc) {
+	for err := range merge(apiErrc, gwErrc, gcErrc, p2pGwErrc, pinVerifyErrc) {
 		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
@@ -993,6 +1002,15 @@ func maybeRunGC(req *cmds.Request, node *core.IpfsNode) (<-chan error, error) {
 	return errc, nil
 }
 
+func runPinVerify(req *cmds.Request, node *core.IpfsNode) <-chan error {
+	errc := make(chan error)
+	go func() {
+		errc <- corerepo.PeriodicPinVerify(req.Context, node)
+		close(errc)
+	}()
+	return errc
+}
+
 // merge does fan-in of multiple read-only error channels
 // taken from http://blog.golang.org/pipelines
 func merge(cs ...<-chan error) <-chan error {
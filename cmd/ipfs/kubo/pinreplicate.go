@@ -0,0 +1,417 @@
+package kubo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/libp2p/go-libp2p/core/host"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+
+	pinclient "github.com/ipfs/boxo/pinning/remote/client"
+	cid "github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+
+	config "github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+)
+
+// replog is the logger for remote pin replication.
+var replog = logging.Logger("remotepinning/replicate")
+
+const (
+	defaultReplicateSyncInterval = 5 * time.Minute
+	defaultReplicateRetryBackoff = 30 * time.Second
+	maxReplicateRetryBackoff     = time.Hour
+)
+
+// replicateOriginMetaKey is set in the meta of every remote pin created by
+// the replication policy, with the peer ID of this node as the value. Only
+// remote pins carrying it are ever replaced or removed by the policy.
+const replicateOriginMetaKey = "kubo-replicate-origin"
+
+type pinReplicateNode interface {
+	// RecursivePins returns the local recursive pins mapped to their names.
+	RecursivePins(ctx context.Context) (map[cid.Cid]string, error)
+	Identity() peer.ID
+	PeerHost() host.Host
+}
+
+type ipfsPinReplicateNode struct {
+	node *core.IpfsNode
+}
+
+func (x *ipfsPinReplicateNode) RecursivePins(ctx context.Context) (map[cid.Cid]string, error) {
+	pins := map[cid.Cid]string{}
+	for sp := range x.node.Pinning.RecursiveKeys(ctx, true) {
+		if sp.Err != nil {
+			return nil, sp.Err
+		}
+		pins[sp.Pin.Key] = sp.Pin.Name
+	}
+	return pins, nil
+}
+
+func (x *ipfsPinReplicateNode) Identity() peer.ID {
+	return x.node.Identity
+}
+
+func (x *ipfsPinReplicateNode) PeerHost() host.Host {
+	return x.node.PeerHost
+}
+
+// replicateState tracks the outcome of the last sync with a single service.
+type replicateState struct {
+	ServiceConfig config.RemotePinningService
+	Synced        map[cid.Cid]string // desired pinset at the last successful sync
+	LastSync      time.Time
+	Failures      int
+	NextRetry     time.Time
+}
+
+func startPinReplicate(configPollInterval time.Duration, cctx pinMFSContext, node pinReplicateNode) {
+	errCh := make(chan error)
+	go pinReplicateOnChange(configPollInterval, cctx, node, errCh)
+	go func() {
+		for {
+			select {
+			case err, isOpen := <-errCh:
+				if !isOpen {
+					return
+				}
+				replog.Errorf("%v", err)
+			case <-cctx.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+func pinReplicateOnChange(configPollInterval time.Duration, cctx pinMFSContext, node pinReplicateNode, errCh chan<- error) {
+	defer close(errCh)
+
+	var tmo *time.Timer
+	defer func() {
+		if tmo != nil {
+			tmo.Stop()
+		}
+	}()
+
+	states := map[string]*replicateState{}
+	for first := true; ; first = false {
+		// the first pass runs right away to reconcile remote services with
+		// pins added or removed while the daemon was not running
+		if !first {
+			// polling sleep
+			if tmo == nil {
+				tmo = time.NewTimer(configPollInterval)
+			} else {
+				tmo.Reset(configPollInterval)
+			}
+			select {
+			case <-cctx.Context().Done():
+				return
+			case <-tmo.C:
+			}
+		}
+
+		// reread the config, which may have changed in the meantime
+		cfg, err := cctx.GetConfig()
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("replicating pins reading config (%v)", err):
+			case <-cctx.Context().Done():
+				return
+			}
+			continue
+		}
+
+		enabled := false
+		for _, svcConfig := range cfg.Pinning.RemoteServices {
+			enabled = enabled || svcConfig.Policies.Replicate.Enable
+		}
+		if !enabled {
+			continue
+		}
+		replog.Debugf("replication loop is awake, %d remote services", len(cfg.Pinning.RemoteServices))
+
+		local, err := node.RecursivePins(cctx.Context())
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("replicating pins reading local pins (%v)", err):
+			case <-cctx.Context().Done():
+				return
+			}
+			continue
+		}
+
+		replicateAll(cctx.Context(), node, cfg, local, states, errCh)
+	}
+}
+
+// replicateAll syncs the local pinset to all remote services with the
+// replication policy enabled, in parallel.
+func replicateAll(ctx context.Context, node pinReplicateNode, cfg *config.Config, local map[cid.Cid]string, states map[string]*replicateState, errCh chan<- error) {
+	type result struct {
+		svcName string
+		state   *replicateState
+	}
+	ch := make(chan result, len(cfg.Pinning.RemoteServices))
+	for svcName_, svcConfig_ := range cfg.Pinning.RemoteServices {
+		svcName, svcConfig := svcName_, svcConfig_
+		policy := svcConfig.Policies.Replicate
+		if !policy.Enable {
+			delete(states, svcName)
+			ch <- result{}
+			continue
+		}
+
+		syncInterval, err := parseReplicateDuration(policy.SyncInterval, defaultReplicateSyncInterval)
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("remote pinning service %q has invalid Replicate.SyncInterval (%v)", svcName, err):
+			case <-ctx.Done():
+			}
+			ch <- result{}
+			continue
+		}
+		retryBackoff, err := parseReplicateDuration(policy.RetryBackoff, defaultReplicateRetryBackoff)
+		if err != nil {
+			select {
+			case errCh <- fmt.Errorf("remote pinning service %q has invalid Replicate.RetryBackoff (%v)", svcName, err):
+			case <-ctx.Done():
+			}
+			ch <- result{}
+			continue
+		}
+
+		desired := filterReplicatedPins(local, policy.NamePrefix, defaultReplicatePinName(node.Identity()))
+
+		prev, ok := states[svcName]
+		if ok && prev.ServiceConfig != svcConfig {
+			prev, ok = nil, false
+		}
+		if ok && time.Now().Before(prev.NextRetry) {
+			replog.Debugf("replicating to %q: backing off after %d failures (remaining: %s)", svcName, prev.Failures, time.Until(prev.NextRetry).String())
+			ch <- result{}
+			continue
+		}
+		if ok && prev.Failures == 0 && sameReplicatedPins(prev.Synced, desired) && time.Since(prev.LastSync) < syncInterval {
+			replog.Debugf("replicating to %q: no local changes since %s, skipping", svcName, prev.LastSync.String())
+			ch <- result{}
+			continue
+		}
+
+		go func() {
+			next := &replicateState{ServiceConfig: svcConfig}
+			if prev != nil {
+				next.Synced, next.LastSync, next.Failures = prev.Synced, prev.LastSync, prev.Failures
+			}
+			if err := syncReplicatedPins(ctx, node, svcName, svcConfig, desired); err != nil {
+				next.Failures++
+				next.NextRetry = time.Now().Add(replicateBackoff(retryBackoff, next.Failures))
+				select {
+				case errCh <- fmt.Errorf("replicating pins to %q, retrying in %s (%v)", svcName, time.Until(next.NextRetry).Round(time.Second), err):
+				case <-ctx.Done():
+				}
+			} else {
+				next.Synced, next.LastSync, next.Failures = desired, time.Now(), 0
+			}
+			ch <- result{svcName, next}
+		}()
+	}
+	for i := 0; i < len(cfg.Pinning.RemoteServices); i++ {
+		if x := <-ch; x.state != nil {
+			states[x.svcName] = x.state
+		}
+	}
+}
+
+func parseReplicateDuration(s string, defaultValue time.Duration) (time.Duration, error) {
+	if s == "" {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// replicateBackoff returns the delay before the next attempt after the given
+// number of consecutive failures.
+func replicateBackoff(initial time.Duration, failures int) time.Duration {
+	d := initial
+	for i := 1; i < failures && d < maxReplicateRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxReplicateRetryBackoff {
+		d = maxReplicateRetryBackoff
+	}
+	return d
+}
+
+func defaultReplicatePinName(id peer.ID) string {
+	return fmt.Sprintf("policy/%s/replicate", id.String())
+}
+
+// filterReplicatedPins returns the local pins that match namePrefix, mapped to
+// the name of their remote counterparts.
+func filterReplicatedPins(local map[cid.Cid]string, namePrefix, defaultName string) map[cid.Cid]string {
+	desired := make(map[cid.Cid]string, len(local))
+	for c, name := range local {
+		if !strings.HasPrefix(name, namePrefix) {
+			continue
+		}
+		if name == "" {
+			name = defaultName
+		}
+		desired[c] = name
+	}
+	return desired
+}
+
+func sameReplicatedPins(a, b map[cid.Cid]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for c, name := range a {
+		if other, ok := b[c]; !ok || other != name {
+			return false
+		}
+	}
+	return true
+}
+
+// replicatedPin is a remote pin created by the replication policy.
+type replicatedPin struct {
+	RequestID string
+	Cid       cid.Cid
+	Name      string
+	Status    pinclient.Status
+}
+
+type replicatePlan struct {
+	Add     []cid.Cid
+	Replace map[string]cid.Cid // request ID of the existing remote pin -> CID
+	Remove  []string           // request IDs
+}
+
+// replicatedPinRank orders the duplicate remote pins of a CID, the healthiest
+// first.
+func replicatedPinRank(s pinclient.Status) int {
+	switch s {
+	case pinclient.StatusPinned:
+		return 0
+	case pinclient.StatusPinning:
+		return 1
+	case pinclient.StatusQueued:
+		return 2
+	case pinclient.StatusFailed:
+		return 3
+	}
+	return 4
+}
+
+// planReplication computes the remote operations needed for the remote
+// pinset to match desired. Failed remote pins and pins whose local name
+// changed are replaced, remote pins no longer present locally (and
+// duplicates) are removed. Of duplicates, the pinned one is kept first, then
+// the pinning and the queued ones.
+func planReplication(desired map[cid.Cid]string, remote []replicatedPin) replicatePlan {
+	remote = slices.Clone(remote)
+	slices.SortStableFunc(remote, func(a, b replicatedPin) int {
+		return replicatedPinRank(a.Status) - replicatedPinRank(b.Status)
+	})
+
+	plan := replicatePlan{Replace: map[string]cid.Cid{}}
+	seen := map[cid.Cid]bool{}
+	for _, rp := range remote {
+		name, wanted := desired[rp.Cid]
+		if !wanted || seen[rp.Cid] {
+			plan.Remove = append(plan.Remove, rp.RequestID)
+			continue
+		}
+		seen[rp.Cid] = true
+		if rp.Status == pinclient.StatusFailed || rp.Name != name {
+			plan.Replace[rp.RequestID] = rp.Cid
+		}
+	}
+	for c := range desired {
+		if !seen[c] {
+			plan.Add = append(plan.Add, c)
+		}
+	}
+	return plan
+}
+
+func syncReplicatedPins(
+	ctx context.Context,
+	node pinReplicateNode,
+	svcName string,
+	svcConfig config.RemotePinningService,
+	desired map[cid.Cid]string,
+) error {
+	c := pinclient.NewClient(svcConfig.API.Endpoint, svcConfig.API.Key)
+	origin := node.Identity().String()
+	meta := map[string]string{replicateOriginMetaKey: origin}
+
+	// list pins previously created by this node (across all possible states)
+	pinStatuses := []pinclient.Status{pinclient.StatusQueued, pinclient.StatusPinning, pinclient.StatusPinned, pinclient.StatusFailed}
+	lsPinCh, lsErrCh := c.Ls(ctx, pinclient.PinOpts.LsMeta(meta), pinclient.PinOpts.FilterStatus(pinStatuses...))
+	var remote []replicatedPin
+	for ps := range lsPinCh {
+		// services are not required to support meta filtering, never touch
+		// pins that were not created by this policy
+		if ps.GetPin().GetMeta()[replicateOriginMetaKey] != origin {
+			continue
+		}
+		remote = append(remote, replicatedPin{
+			RequestID: ps.GetRequestId(),
+			Cid:       ps.GetPin().GetCid(),
+			Name:      ps.GetPin().GetName(),
+			Status:    ps.GetStatus(),
+		})
+	}
+	if err := <-lsErrCh; err != nil {
+		return fmt.Errorf("error while listing remote pins: %v", err)
+	}
+
+	plan := planReplication(desired, remote)
+	replog.Debugf("replicating to %q: %d pins to add, %d to replace, %d to remove", svcName, len(plan.Add), len(plan.Replace), len(plan.Remove))
+
+	// Prepare Pin.origins
+	// Add own multiaddrs to the 'origins' array, so Pinning Service can
+	// use that as a hint and connect back to us (if possible)
+	var originAddrs []pinclient.AddOption
+	if node.PeerHost() != nil {
+		addrs, err := peer.AddrInfoToP2pAddrs(host.InfoFromHost(node.PeerHost()))
+		if err != nil {
+			return err
+		}
+		originAddrs = append(originAddrs, pinclient.PinOpts.WithOrigins(addrs...))
+	}
+	addOpts := func(c cid.Cid) []pinclient.AddOption {
+		return append([]pinclient.AddOption{
+			pinclient.PinOpts.WithName(desired[c]),
+			pinclient.PinOpts.AddMeta(meta),
+		}, originAddrs...)
+	}
+
+	var errs error
+	for _, rmID := range plan.Remove {
+		if err := c.DeleteByID(ctx, rmID); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("removing pin identified by requestid=%q failed: %v", rmID, err))
+		}
+	}
+	for requestID, pinCid := range plan.Replace {
+		if _, err := c.Replace(ctx, requestID, pinCid, addOpts(pinCid)...); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("replacing pin for %q failed: %v", pinCid, err))
+		}
+	}
+	for _, pinCid := range plan.Add {
+		if _, err := c.Add(ctx, pinCid, addOpts(pinCid)...); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("adding pin for %q failed: %v", pinCid, err))
+		}
+	}
+	return errs
+}
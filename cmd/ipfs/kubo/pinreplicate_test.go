@@ -0,0 +1,184 @@
+package kubo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pinclient "github.com/ipfs/boxo/pinning/remote/client"
+	cid "github.com/ipfs/go-cid"
+	config "github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p/core/host"
+	peer "github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type testPinReplicateNode struct {
+	pins map[cid.Cid]string
+	err  error
+}
+
+func (x *testPinReplicateNode) RecursivePins(ctx context.Context) (map[cid.Cid]string, error) {
+	return x.pins, x.err
+}
+
+func (x *testPinReplicateNode) Identity() peer.ID {
+	return peer.ID("test_id")
+}
+
+func (x *testPinReplicateNode) PeerHost() host.Host {
+	return nil
+}
+
+func testCid(t *testing.T, s string) cid.Cid {
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func TestPinReplicateConfigError(t *testing.T) {
+	goctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := &testPinMFSContext{
+		ctx: goctx,
+		cfg: nil,
+		err: fmt.Errorf("couldn't read config"),
+	}
+	errCh := make(chan error)
+	go pinReplicateOnChange(testConfigPollInterval, ctx, &testPinReplicateNode{}, errCh)
+	if !isErrorSimilar(<-errCh, ctx.err) {
+		t.Errorf("error did not propagate")
+	}
+}
+
+func TestPinReplicateLocalPinsError(t *testing.T) {
+	goctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := &testPinMFSContext{
+		ctx: goctx,
+		cfg: &config.Config{
+			Pinning: config.Pinning{
+				RemoteServices: map[string]config.RemotePinningService{
+					"enabled": {
+						Policies: config.RemotePinningServicePolicies{
+							Replicate: config.RemotePinningServiceReplicatePolicy{Enable: true},
+						},
+					},
+				},
+			},
+		},
+	}
+	node := &testPinReplicateNode{err: fmt.Errorf("cannot list pins")}
+	errCh := make(chan error)
+	go pinReplicateOnChange(testConfigPollInterval, ctx, node, errCh)
+	if !isErrorSimilar(<-errCh, node.err) {
+		t.Errorf("error did not propagate")
+	}
+}
+
+func TestPinReplicateService(t *testing.T) {
+	goctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := &testPinMFSContext{
+		ctx: goctx,
+		cfg: &config.Config{
+			Pinning: config.Pinning{
+				RemoteServices: map[string]config.RemotePinningService{
+					"invalid_backoff": {
+						Policies: config.RemotePinningServicePolicies{
+							Replicate: config.RemotePinningServiceReplicatePolicy{
+								Enable:       true,
+								RetryBackoff: "INVALID_INTERVAL",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	node := &testPinReplicateNode{pins: map[cid.Cid]string{}}
+	errCh := make(chan error)
+	go pinReplicateOnChange(testConfigPollInterval, ctx, node, errCh)
+	expected := "remote pinning service \"invalid_backoff\" has invalid Replicate.RetryBackoff"
+	if err := <-errCh; !strings.Contains(err.Error(), expected) {
+		t.Errorf("expecting error containing %q, got %q", expected, err)
+	}
+}
+
+func TestFilterReplicatedPins(t *testing.T) {
+	a, b, c := testCid(t, "a"), testCid(t, "b"), testCid(t, "c")
+	local := map[cid.Cid]string{a: "app/a", b: "other/b", c: ""}
+
+	all := filterReplicatedPins(local, "", "default")
+	if len(all) != 3 || all[c] != "default" || all[a] != "app/a" {
+		t.Errorf("unexpected unfiltered pins: %v", all)
+	}
+
+	filtered := filterReplicatedPins(local, "app/", "default")
+	if len(filtered) != 1 || filtered[a] != "app/a" {
+		t.Errorf("unexpected filtered pins: %v", filtered)
+	}
+}
+
+func TestPlanReplication(t *testing.T) {
+	a, b, c, d := testCid(t, "a"), testCid(t, "b"), testCid(t, "c"), testCid(t, "d")
+	desired := map[cid.Cid]string{a: "a", b: "b", c: "c"}
+	remote := []replicatedPin{
+		{RequestID: "1", Cid: a, Name: "a", Status: pinclient.StatusPinned},
+		{RequestID: "2", Cid: a, Name: "a", Status: pinclient.StatusPinned},
+		{RequestID: "3", Cid: b, Name: "b", Status: pinclient.StatusFailed},
+		{RequestID: "4", Cid: d, Name: "d", Status: pinclient.StatusPinned},
+	}
+
+	plan := planReplication(desired, remote)
+	if len(plan.Add) != 1 || plan.Add[0] != c {
+		t.Errorf("expected %s to be added, got %v", c, plan.Add)
+	}
+	if len(plan.Replace) != 1 || plan.Replace["3"] != b {
+		t.Errorf("expected failed pin to be replaced, got %v", plan.Replace)
+	}
+	sort.Strings(plan.Remove)
+	if len(plan.Remove) != 2 || plan.Remove[0] != "2" || plan.Remove[1] != "4" {
+		t.Errorf("expected duplicate and stale pins to be removed, got %v", plan.Remove)
+	}
+
+	// the healthy duplicate is kept, even when listed after a failed one
+	dups := planReplication(map[cid.Cid]string{a: "a"}, []replicatedPin{
+		{RequestID: "1", Cid: a, Name: "a", Status: pinclient.StatusFailed},
+		{RequestID: "2", Cid: a, Name: "a", Status: pinclient.StatusQueued},
+		{RequestID: "3", Cid: a, Name: "a", Status: pinclient.StatusPinned},
+	})
+	sort.Strings(dups.Remove)
+	if len(dups.Replace) != 0 || len(dups.Add) != 0 {
+		t.Errorf("expected the pinned duplicate to be kept, got %v and %v", dups.Replace, dups.Add)
+	}
+	if len(dups.Remove) != 2 || dups.Remove[0] != "1" || dups.Remove[1] != "2" {
+		t.Errorf("expected the other duplicates to be removed, got %v", dups.Remove)
+	}
+
+	renamed := planReplication(map[cid.Cid]string{a: "new"}, remote[:1])
+	if len(renamed.Replace) != 1 || renamed.Replace["1"] != a {
+		t.Errorf("expected renamed pin to be replaced, got %v", renamed.Replace)
+	}
+}
+
+func TestReplicateBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{100, maxReplicateRetryBackoff},
+	} {
+		if d := replicateBackoff(time.Second, tc.failures); d != tc.expected {
+			t.Errorf("failures=%d: expected %s, got %s", tc.failures, tc.expected, d)
+		}
+	}
+}
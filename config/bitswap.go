@@ -0,0 +1,68 @@
+package config
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// DefaultBitswapPeerMetrics is the number of peers with their own
+	// bitswap metrics.
+	DefaultBitswapPeerMetrics = 20
+	// DefaultBitswapLedgerHistoryRetention is how long the daily bitswap
+	// ledgers are kept.
+	DefaultBitswapLedgerHistoryRetention = 30 * 24 * time.Hour
+	// DefaultBitswapMaxPeerShare is the percentage of the bytes recently
+	// sent by bitswap above which a peer is served last by the peer-share
+	// prioritization policy.
+	DefaultBitswapMaxPeerShare = 50
+)
+
+// Bitswap configures the bitswap protocol.
+type Bitswap struct {
+	// ServePolicies restrict the content of some pins to some peers. The
+	// other blocks are served to any peer.
+	ServePolicies []BitswapServePolicy `json:",omitempty"`
+
+	// PeerMetrics is the number of peers, those exchanging the most bytes
+	// with the node, exposed individually in the Prometheus metrics.
+	PeerMetrics *OptionalInteger `json:",omitempty"`
+
+	// LedgerHistory keeps the bytes exchanged with each peer per day.
+	LedgerHistory BitswapLedgerHistory `json:",omitempty"`
+
+	// Prioritization orders the blocks the bitswap server sends to the
+	// peers.
+	Prioritization BitswapPrioritization `json:",omitempty"`
+}
+
+// BitswapServePolicy restricts the blocks of the pins matching PinNames to
+// AllowedPeers.
+type BitswapServePolicy struct {
+	// PinNames are patterns, in the syntax of path.Match, matched against
+	// the names of the pins.
+	PinNames []string
+	// AllowedPeers are the peers allowed to fetch the blocks of these pins.
+	AllowedPeers []peer.ID
+}
+
+// BitswapLedgerHistory configures the history of the bitswap ledgers.
+type BitswapLedgerHistory struct {
+	// Enabled records the daily ledgers in the repo datastore.
+	Enabled Flag `json:",omitempty"`
+	// Retention is how long the daily ledgers are kept.
+	Retention *OptionalDuration `json:",omitempty"`
+}
+
+// BitswapPrioritization configures the order in which the bitswap server
+// sends the blocks wanted by the peers.
+type BitswapPrioritization struct {
+	// Policies are the names of the prioritization policies, built-in or
+	// added by plugins. Each policy orders the requests the previous ones
+	// rank equally. When empty, bitswap uses its default order.
+	Policies []string `json:",omitempty"`
+	// MaxPeerShare is the percentage of the bytes recently sent above which
+	// a peer is served last by the peer-share policy.
+	MaxPeerShare *OptionalInteger `json:",omitempty"`
+}
@@ -26,6 +26,7 @@ type Config struct {
 	API       API       // local node's API settings
 	Swarm     SwarmConfig
 	AutoNAT   AutoNATConfig
+	Bitswap   Bitswap
 	Pubsub    PubsubConfig
 	Peering   Peering
 	DNS       DNS
@@ -37,6 +38,12 @@ type Config struct {
 	Plugins      Plugins
 	Pinning      Pinning
 
+	// HTTPRetrieval fetches blocks from HTTP trustless gateways.
+	HTTPRetrieval HTTPRetrieval
+
+	// Schedules override some settings during time windows.
+	Schedules []Schedule `json:",omitempty"`
+
 	Internal Internal // experimental/unstable options
 }
 
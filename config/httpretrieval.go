@@ -0,0 +1,33 @@
+package config
+
+import "time"
+
+const (
+	// DefaultHTTPRetrievalTimeout is how long a request to a trustless
+	// gateway can take.
+	DefaultHTTPRetrievalTimeout = 30 * time.Second
+	// DefaultHTTPRetrievalMaxRoutingGateways is the number of gateways found
+	// by the routing system fetched from for a DAG.
+	DefaultHTTPRetrievalMaxRoutingGateways = 3
+)
+
+// HTTPRetrieval configures the retrieval of blocks from HTTP trustless
+// gateways, in parallel with bitswap.
+type HTTPRetrieval struct {
+	// Enabled fetches the blocks from the gateways as well as with bitswap.
+	Enabled Flag `json:",omitempty"`
+
+	// Gateways are the URLs of the trustless gateways to fetch from.
+	Gateways []string `json:",omitempty"`
+
+	// UseRouting also fetches from the providers found by the routing
+	// system that have HTTP addresses.
+	UseRouting Flag `json:",omitempty"`
+
+	// MaxRoutingGateways is the number of gateways found by the routing
+	// system fetched from for a DAG.
+	MaxRoutingGateways *OptionalInteger `json:",omitempty"`
+
+	// Timeout is how long a request to a gateway can take.
+	Timeout *OptionalDuration `json:",omitempty"`
+}
@@ -1,5 +1,20 @@
 package config
 
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	DefaultPinSyncInterval   = time.Minute
+	DefaultPinSyncPinTimeout = 10 * time.Minute
+
+	DefaultPinVerifyInterval   = time.Duration(0)
+	DefaultPinVerifySampleSize = 100
+	DefaultPinVerifyRepair     = false
+)
+
 var (
 	RemoteServicesPath     = "Pinning.RemoteServices"
 	PinningConcealSelector = []string{"Pinning", "RemoteServices", "*", "API", "Key"}
@@ -7,6 +22,33 @@ var (
 
 type Pinning struct {
 	RemoteServices map[string]RemotePinningService
+	Sync           PinSync
+	Verify         PinVerify
+}
+
+// PinVerify configures the background verification of recursive pins.
+type PinVerify struct {
+	// Interval is the delay between two background verifications, zero
+	// disables them.
+	Interval *OptionalDuration `json:",omitempty"`
+	// SampleSize is the number of recursive pins checked on each run.
+	SampleSize *OptionalInteger `json:",omitempty"`
+	// Repair enables refetching the missing or corrupt blocks of broken pins.
+	Repair Flag `json:",omitempty"`
+}
+
+// PinSync configures the sync of recursive pins between the members of a
+// replication group.
+type PinSync struct {
+	// Enabled turns on the pinset sync protocol, defaults to off.
+	Enabled Flag `json:",omitempty"`
+	// Peers lists the other members of the replication group. When empty,
+	// the peers from Peering.Peers are used.
+	Peers []peer.ID `json:",omitempty"`
+	// Interval is the delay between two sync rounds.
+	Interval *OptionalDuration `json:",omitempty"`
+	// PinTimeout limits the time spent fetching the content of a single pin.
+	PinTimeout *OptionalDuration `json:",omitempty"`
 }
 
 type RemotePinningService struct {
@@ -20,7 +62,8 @@ type RemotePinningServiceAPI struct {
 }
 
 type RemotePinningServicePolicies struct {
-	MFS RemotePinningServiceMFSPolicy
+	MFS       RemotePinningServiceMFSPolicy
+	Replicate RemotePinningServiceReplicatePolicy
 }
 
 type RemotePinningServiceMFSPolicy struct {
@@ -31,3 +74,14 @@ type RemotePinningServiceMFSPolicy struct {
 	// RepinInterval determines the repin interval when the policy is enabled. In ns, us, ms, s, m, h.
 	RepinInterval string
 }
+
+type RemotePinningServiceReplicatePolicy struct {
+	// Enable enables mirroring of local recursive pins to the remote service.
+	Enable bool
+	// NamePrefix limits mirroring to local pins with names starting with the prefix. Empty means all recursive pins.
+	NamePrefix string
+	// SyncInterval determines how often the remote pinset is reconciled with the local one, even if no local change occurred. In ns, us, ms, s, m, h.
+	SyncInterval string
+	// RetryBackoff is the initial delay before retrying a failed sync, doubled after each consecutive failure. In ns, us, ms, s, m, h.
+	RetryBackoff string
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"time"
 )
 
 var (
@@ -11,6 +12,10 @@ var (
 	DefaultLoopbackAddressesOnLanDHT = false
 )
 
+// DefaultCacheRouterTTL is how long the results of the routers of the cache
+// type are kept by default.
+const DefaultCacheRouterTTL = time.Hour
+
 // Routing defines configuration options for libp2p routing.
 type Routing struct {
 	// Type sets default daemon routing mode.
@@ -24,6 +29,10 @@ type Routing struct {
 
 	LoopbackAddressesOnLanDHT Flag `json:",omitempty"`
 
+	// TrackProvides records the announcements of the keys, reported by
+	// 'ipfs routing provide --status'; defaults to false.
+	TrackProvides Flag `json:",omitempty"`
+
 	Routers Routers
 
 	Methods Methods
@@ -94,6 +103,8 @@ func (r *RouterParser) UnmarshalJSON(b []byte) error {
 		p = &ComposableRouterParams{}
 	case RouterTypeParallel:
 		p = &ComposableRouterParams{}
+	case RouterTypeCache:
+		p = &CacheRouterParams{}
 	}
 
 	if err := json.Unmarshal(*raw, &p); err != nil {
@@ -115,6 +126,7 @@ const (
 	RouterTypeDHT        RouterType = "dht"        // DHT router.
 	RouterTypeSequential RouterType = "sequential" // Router helper to execute several routers sequentially.
 	RouterTypeParallel   RouterType = "parallel"   // Router helper to execute several routers in parallel.
+	RouterTypeCache      RouterType = "cache"      // Router helper to cache the results of another router.
 )
 
 type DHTMode string
@@ -168,6 +180,17 @@ type DHTRouterParams struct {
 type ComposableRouterParams struct {
 	Routers []ConfigRouter
 	Timeout *OptionalDuration `json:",omitempty"`
+	// HedgePercentile makes a parallel router send the lookups to its first
+	// router only, and to the others when it has not answered within this
+	// percentile of its latencies.
+	HedgePercentile *OptionalInteger `json:",omitempty"`
+}
+
+type CacheRouterParams struct {
+	// RouterName is the name of the router whose results are cached.
+	RouterName string
+	// TTL is how long the results are kept in the cache.
+	TTL *OptionalDuration `json:",omitempty"`
 }
 
 type ConfigRouter struct {
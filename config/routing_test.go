@@ -104,6 +104,116 @@ func TestRouterParameters(t *testing.T) {
 	require.IsType(&ComposableRouterParams{}, pp)
 }
 
+func TestHedgeRouterParameters(t *testing.T) {
+	require := require.New(t)
+	r := Routing{
+		Type: NewOptionalString("custom"),
+		Routers: map[string]RouterParser{
+			"router-dht": {Router{
+				Type:       RouterTypeDHT,
+				Parameters: DHTRouterParams{Mode: "auto"},
+			}},
+			"router-parallel": {
+				Router{
+					Type: RouterTypeParallel,
+					Parameters: ComposableRouterParams{
+						Routers: []ConfigRouter{
+							{
+								RouterName: "router-dht",
+								Timeout:    Duration{10 * time.Second},
+							},
+						},
+						HedgePercentile: NewOptionalInteger(90),
+					},
+				},
+			},
+			"router-unhedged": {
+				Router{
+					Type: RouterTypeParallel,
+					Parameters: ComposableRouterParams{
+						Routers: []ConfigRouter{
+							{
+								RouterName: "router-dht",
+								Timeout:    Duration{10 * time.Second},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	out, err := json.Marshal(r)
+	require.NoError(err)
+
+	r2 := &Routing{}
+
+	err = json.Unmarshal(out, r2)
+	require.NoError(err)
+
+	pp := r2.Routers["router-parallel"].Parameters
+	require.IsType(&ComposableRouterParams{}, pp)
+	require.EqualValues(90, pp.(*ComposableRouterParams).HedgePercentile.WithDefault(0))
+
+	// hedging is off unless asked for
+	up := r2.Routers["router-unhedged"].Parameters
+	require.IsType(&ComposableRouterParams{}, up)
+	require.Nil(up.(*ComposableRouterParams).HedgePercentile)
+}
+
+func TestCacheRouterParameters(t *testing.T) {
+	require := require.New(t)
+	min := time.Minute
+	r := Routing{
+		Type: NewOptionalString("custom"),
+		Routers: map[string]RouterParser{
+			"router-dht": {Router{
+				Type:       RouterTypeDHT,
+				Parameters: DHTRouterParams{Mode: "auto"},
+			}},
+			"router-cache": {
+				Router{
+					Type: RouterTypeCache,
+					Parameters: CacheRouterParams{
+						RouterName: "router-dht",
+						TTL:        &OptionalDuration{&min},
+					},
+				},
+			},
+		},
+		Methods: Methods{
+			MethodNameFindPeers: {
+				RouterName: "router-dht",
+			},
+			MethodNameFindProviders: {
+				RouterName: "router-cache",
+			},
+			MethodNameGetIPNS: {
+				RouterName: "router-dht",
+			},
+			MethodNameProvide: {
+				RouterName: "router-dht",
+			},
+			MethodNamePutIPNS: {
+				RouterName: "router-dht",
+			},
+		},
+	}
+
+	out, err := json.Marshal(r)
+	require.NoError(err)
+
+	r2 := &Routing{}
+
+	err = json.Unmarshal(out, r2)
+	require.NoError(err)
+
+	cp := r2.Routers["router-cache"].Parameters
+	require.IsType(&CacheRouterParams{}, cp)
+	require.Equal("router-dht", cp.(*CacheRouterParams).RouterName)
+	require.Equal(min, cp.(*CacheRouterParams).TTL.WithDefault(0))
+}
+
 func TestMethods(t *testing.T) {
 	require := require.New(t)
 
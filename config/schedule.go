@@ -0,0 +1,36 @@
+package config
+
+// Schedule overrides some settings while the current time is within its
+// window. When several schedules are active, the first one in the list wins.
+type Schedule struct {
+	// Name identifies the schedule in 'ipfs stats schedule'.
+	Name string
+
+	// Cron is a cron expression with five fields: minute, hour, day of
+	// month, month and day of week. The schedule is active during every
+	// minute matched by the expression, e.g. "* 9-17 * * 1-5" during work
+	// hours.
+	Cron string
+
+	// Location is the time zone of the cron expression, e.g. "Europe/Paris".
+	// Defaults to the local time zone.
+	Location *OptionalString `json:",omitempty"`
+
+	// BandwidthLimits replaces Swarm.BandwidthLimits.
+	BandwidthLimits *BandwidthLimits `json:",omitempty"`
+
+	// ConnMgr overrides the watermarks of Swarm.ConnMgr.
+	ConnMgr *ScheduleConnMgr `json:",omitempty"`
+
+	// Reprovide enables or pauses the periodic reprovides.
+	Reprovide Flag `json:",omitempty"`
+
+	// BitswapServer enables or disables serving blocks over bitswap.
+	BitswapServer Flag `json:",omitempty"`
+}
+
+// ScheduleConnMgr overrides the watermarks of the connection manager.
+type ScheduleConnMgr struct {
+	LowWater  *OptionalInteger `json:",omitempty"`
+	HighWater *OptionalInteger `json:",omitempty"`
+}
@@ -1,5 +1,11 @@
 package config
 
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
 type SwarmConfig struct {
 	// AddrFilters specifies a set libp2p addresses that we should never
 	// dial or receive connections from.
@@ -32,6 +38,18 @@ type SwarmConfig struct {
 
 	// ResourceMgr configures the libp2p Network Resource Manager
 	ResourceMgr ResourceMgr
+
+	// BandwidthLimits caps the throughput of libp2p streams.
+	BandwidthLimits BandwidthLimits `json:",omitempty"`
+
+	// PeerAllowList restricts connections to a list of peers.
+	PeerAllowList PeerAllowList `json:",omitempty"`
+
+	// Peerstore configures the storage of known peers.
+	Peerstore Peerstore `json:",omitempty"`
+
+	// ConnectionHistory configures the history of closed connections.
+	ConnectionHistory ConnectionHistory `json:",omitempty"`
 }
 
 type RelayClient struct {
@@ -71,6 +89,13 @@ type RelayService struct {
 	MaxReservationsPerIP *OptionalInteger `json:",omitempty"`
 	// MaxReservationsPerASN is the maximum number of reservations origination from the same ASN.
 	MaxReservationsPerASN *OptionalInteger `json:",omitempty"`
+
+	// ReservationAllowList restricts the reservations to these peers. All
+	// peers can make reservations when it is empty.
+	ReservationAllowList []peer.ID `json:",omitempty"`
+	// Accounting enables the accounting of the reservations listed by
+	// 'ipfs swarm relay stats'; defaults to false.
+	Accounting Flag `json:",omitempty"`
 }
 
 type Transports struct {
@@ -110,6 +135,23 @@ type ConnMgr struct {
 	LowWater    *OptionalInteger  `json:",omitempty"`
 	HighWater   *OptionalInteger  `json:",omitempty"`
 	GracePeriod *OptionalDuration `json:",omitempty"`
+
+	// ProtectedPeers are peers whose connections are kept, or trimmed last,
+	// by the connection manager.
+	ProtectedPeers []ConnMgrProtectedPeer `json:",omitempty"`
+}
+
+// ConnMgrProtectedPeer protects the connections to a peer from trimming.
+type ConnMgrProtectedPeer struct {
+	ID peer.ID
+
+	// Tag identifies the protection in the connection manager.
+	Tag *OptionalString `json:",omitempty"`
+
+	// Weight, when set, does not protect the peer but tags it with this
+	// value, so that its connections are trimmed after those of peers with
+	// lower values.
+	Weight *OptionalInteger `json:",omitempty"`
 }
 
 // ResourceMgr defines configuration options for the libp2p Network Resource Manager
@@ -128,6 +170,79 @@ type ResourceMgr struct {
 	Allowlist []string `json:",omitempty"`
 }
 
+// PeerAllowList restricts the connections of the swarm to known peers.
+type PeerAllowList struct {
+	// Enabled refuses connections to and from the peers not in the list.
+	Enabled Flag `json:",omitempty"`
+
+	// Peers are the allowed peer IDs.
+	Peers []peer.ID `json:",omitempty"`
+
+	// File is the path of a file with one allowed peer ID per line, relative
+	// to the repo. It is reloaded when it changes.
+	File *OptionalString `json:",omitempty"`
+
+	// AllowDHT accepts connections to and from any peer, but only for the
+	// DHT, identify and ping protocols with the peers not in the list.
+	AllowDHT Flag `json:",omitempty"`
+}
+
+// DefaultPeerstoreGCInterval is how often expired addresses are removed from
+// the persistent peerstore.
+const DefaultPeerstoreGCInterval = time.Hour
+
+// DefaultPeerstoreDisconnectedAddrTTL is how long the persistent peerstore
+// keeps the addresses of the peers the node disconnected from.
+const DefaultPeerstoreDisconnectedAddrTTL = 24 * time.Hour
+
+// Peerstore configures the libp2p peerstore.
+type Peerstore struct {
+	// Persist stores the peerstore in the repo datastore, so that known
+	// peers survive restarts.
+	Persist Flag `json:",omitempty"`
+
+	// GCInterval is how often expired addresses are removed from the
+	// persistent peerstore.
+	GCInterval *OptionalDuration `json:",omitempty"`
+
+	// DisconnectedAddrTTL is how long the persistent peerstore keeps the
+	// addresses of the peers the node disconnected from.
+	DisconnectedAddrTTL *OptionalDuration `json:",omitempty"`
+}
+
+// DefaultConnectionHistoryMaxEntries is the number of connections kept in
+// the connection history.
+const DefaultConnectionHistoryMaxEntries = 10000
+
+// ConnectionHistory configures the history of closed connections.
+type ConnectionHistory struct {
+	// Enabled records the closed connections in the repo datastore.
+	Enabled Flag `json:",omitempty"`
+
+	// MaxEntries is the number of connections kept, the oldest ones are
+	// removed first.
+	MaxEntries *OptionalInteger `json:",omitempty"`
+}
+
+// BandwidthLimits defines the maximum throughput of libp2p streams.
+type BandwidthLimits struct {
+	// RateIn and RateOut are the global limits, in bytes per second
+	// (e.g. "1MB"). Empty or "0" means unlimited.
+	RateIn  *OptionalString `json:",omitempty"`
+	RateOut *OptionalString `json:",omitempty"`
+
+	// Protocols overrides the global limits for the streams of some
+	// protocols. Keys are "bitswap", "dht", "relay" or a protocol ID prefix
+	// such as "/ipfs/ping".
+	Protocols map[string]BandwidthLimit `json:",omitempty"`
+}
+
+// BandwidthLimit defines the maximum throughput of the streams of a protocol.
+type BandwidthLimit struct {
+	RateIn  *OptionalString `json:",omitempty"`
+	RateOut *OptionalString `json:",omitempty"`
+}
+
 const (
 	ResourceMgrSystemScope         = "system"
 	ResourceMgrTransientScope      = "transient"
@@ -1,15 +1,21 @@
 package commands
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"text/tabwriter"
+	"time"
 
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	e "github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/node"
 
 	humanize "github.com/dustin/go-humanize"
 	bitswap "github.com/ipfs/boxo/bitswap"
 	"github.com/ipfs/boxo/bitswap/server"
+	cid "github.com/ipfs/go-cid"
 	cidutil "github.com/ipfs/go-cidutil"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	peer "github.com/libp2p/go-libp2p/core/peer"
@@ -30,19 +36,49 @@ var BitswapCmd = &cmds.Command{
 }
 
 const (
-	peerOptionName = "peer"
+	peerOptionName  = "peer"
+	traceOptionName = "trace"
 )
 
+// wantlistOutput is the wantlist, or an event of the trace with --trace.
+type wantlistOutput struct {
+	Keys []cid.Cid
+	*node.BitswapTraceEvent
+}
+
+func (o wantlistOutput) MarshalJSON() ([]byte, error) {
+	if o.BitswapTraceEvent != nil {
+		return json.Marshal(o.BitswapTraceEvent)
+	}
+	return json.Marshal(KeyList{o.Keys})
+}
+
 var showWantlistCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Show blocks currently on the wantlist.",
 		ShortDescription: `
-Print out all blocks currently on the bitswap wantlist for the local peer.`,
+Print out all blocks currently on the bitswap wantlist for the local peer.
+
+With --trace, the fetches of the given CIDs, and of the blocks they link to,
+are traced instead, until the command is interrupted. Each event is printed
+as it happens: the wants sent to peers (want-sent, cancel-sent), their
+answers (have-received, dont-have-received, block-received, with the latency
+since the block was first wanted), and the provider searches started when no
+peer has the block after Internal.Bitswap.ProviderSearchDelay
+(provider-search, provider-found). Without CIDs, all the fetches are traced.
+
+  > ipfs bitswap wantlist --trace --enc=json <cid> &
+  > ipfs cat <cid>
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", false, true, "CIDs to trace with --trace."),
 	},
 	Options: []cmds.Option{
 		cmds.StringOption(peerOptionName, "p", "Specify which peer to show wantlist for. Default: self."),
+		cmds.BoolOption(traceOptionName, "Trace the fetches of blocks."),
 	},
-	Type: KeyList{},
+	Type: wantlistOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
 		if err != nil {
@@ -53,6 +89,13 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 			return ErrNotOnline
 		}
 
+		if trace, _ := req.Options[traceOptionName].(bool); trace {
+			return traceWantlist(req, res, nd.BitswapTracer)
+		}
+		if len(req.Arguments) > 0 {
+			return errors.New("CIDs can only be given with --trace")
+		}
+
 		bs, ok := nd.Exchange.(*bitswap.Bitswap)
 		if !ok {
 			return e.TypeErr(bs, nd.Exchange)
@@ -65,14 +108,35 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 				return err
 			}
 			if pid != nd.Identity {
-				return cmds.EmitOnce(res, &KeyList{bs.WantlistForPeer(pid)})
+				return cmds.EmitOnce(res, &wantlistOutput{Keys: bs.WantlistForPeer(pid)})
 			}
 		}
 
-		return cmds.EmitOnce(res, &KeyList{bs.GetWantlist()})
+		return cmds.EmitOnce(res, &wantlistOutput{Keys: bs.GetWantlist()})
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *KeyList) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *wantlistOutput) error {
+			if ev := out.BitswapTraceEvent; ev != nil {
+				fmt.Fprintf(w, "%s %s %s", ev.Time.Format(time.RFC3339Nano), ev.Type, ev.Cid)
+				if ev.Peer != "" {
+					fmt.Fprintf(w, " %s", ev.Peer)
+				}
+				if ev.WantType != "" {
+					fmt.Fprintf(w, " want-%s", ev.WantType)
+				}
+				if ev.Size > 0 {
+					fmt.Fprintf(w, " %s", humanize.Bytes(uint64(ev.Size)))
+				}
+				if ev.Latency > 0 {
+					fmt.Fprintf(w, " %s", ev.Latency)
+				}
+				if ev.Duplicate {
+					fmt.Fprint(w, " duplicate")
+				}
+				fmt.Fprintln(w)
+				return nil
+			}
+
 			enc, err := cmdenv.GetLowLevelCidEncoder(req)
 			if err != nil {
 				return err
@@ -87,6 +151,35 @@ Print out all blocks currently on the bitswap wantlist for the local peer.`,
 	},
 }
 
+// traceWantlist emits the events of the bitswap trace of the CIDs in the
+// arguments until the request is canceled.
+func traceWantlist(req *cmds.Request, res cmds.ResponseEmitter, tracer *node.BitswapTracer) error {
+	if tracer == nil {
+		return errors.New("bitswap tracing is not available")
+	}
+	cids := make([]cid.Cid, 0, len(req.Arguments))
+	for _, arg := range req.Arguments {
+		c, err := cid.Decode(arg)
+		if err != nil {
+			return fmt.Errorf("invalid CID %q: %w", arg, err)
+		}
+		cids = append(cids, c)
+	}
+
+	events, cancel := tracer.Subscribe(cids, 1024)
+	defer cancel()
+	for {
+		select {
+		case ev := <-events:
+			if err := res.Emit(&wantlistOutput{BitswapTraceEvent: &ev}); err != nil {
+				return err
+			}
+		case <-req.Context.Done():
+			return nil
+		}
+	}
+}
+
 const (
 	bitswapVerboseOptionName = "verbose"
 	bitswapHumanOptionName   = "human"
@@ -179,6 +272,9 @@ prints the ledger associated with a given peer.
 	Arguments: []cmds.Argument{
 		cmds.StringArg("peer", true, false, "The PeerID (B58) of the ledger to inspect."),
 	},
+	Subcommands: map[string]*cmds.Command{
+		"history": ledgerHistoryCmd,
+	},
 	Type: server.Receipt{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
@@ -216,6 +312,83 @@ prints the ledger associated with a given peer.
 	},
 }
 
+const ledgerDaysOptionName = "days"
+
+type ledgerHistoryOutput struct {
+	Entries []node.LedgerEntry
+}
+
+var ledgerHistoryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the daily history of the ledgers.",
+		ShortDescription: `
+'ipfs bitswap ledger history' prints the bytes and blocks exchanged with
+each peer per day (UTC), most recent day first. Without a peer, the ledgers
+of all the peers are printed.
+
+The history is kept when Bitswap.LedgerHistory.Enabled is true, for the
+period set by Bitswap.LedgerHistory.Retention.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peer", false, false, "The PeerID (B58) of the ledgers to inspect."),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(ledgerDaysOptionName, "Number of days to show, including today.").WithDefault(7),
+	},
+	Type: ledgerHistoryOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		cfg, err := nd.Repo.Config()
+		if err != nil {
+			return err
+		}
+		if nd.BitswapLedger == nil || !cfg.Bitswap.LedgerHistory.Enabled.WithDefault(false) {
+			return errors.New("the ledger history is disabled, see Bitswap.LedgerHistory.Enabled")
+		}
+
+		var partner peer.ID
+		if len(req.Arguments) > 0 {
+			partner, err = peer.Decode(req.Arguments[0])
+			if err != nil {
+				return err
+			}
+		}
+
+		days, _ := req.Options[ledgerDaysOptionName].(int)
+		if days < 1 {
+			return fmt.Errorf("--%s must be at least 1", ledgerDaysOptionName)
+		}
+		since := time.Now().AddDate(0, 0, 1-days)
+
+		entries, err := nd.BitswapLedger.History(req.Context, partner, since)
+		if err != nil {
+			return err
+		}
+		return cmds.EmitOnce(res, &ledgerHistoryOutput{Entries: entries})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *ledgerHistoryOutput) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			fmt.Fprintln(tw, "Day\tPeer\tSent\tReceived\tBlocks sent\tBlocks received\t")
+			for _, e := range out.Entries {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t\n", e.Day, e.Peer,
+					humanize.Bytes(e.Sent), humanize.Bytes(e.Received),
+					e.BlocksSent, e.BlocksReceived)
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
 var reprovideCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Trigger reprovider.",
@@ -20,6 +20,7 @@ func TestCommands(t *testing.T) {
 		"/add",
 		"/bitswap",
 		"/bitswap/ledger",
+		"/bitswap/ledger/history",
 		"/bitswap/reprovide",
 		"/bitswap/stat",
 		"/bitswap/wantlist",
@@ -67,6 +68,7 @@ func TestCommands(t *testing.T) {
 		"/dht/provide",
 		"/dht/put",
 		"/routing",
+		"/routing/check",
 		"/routing/put",
 		"/routing/get",
 		"/routing/findpeer",
@@ -157,6 +159,9 @@ func TestCommands(t *testing.T) {
 		"/pin/remote/service/ls",
 		"/pin/remote/service/rm",
 		"/pin/rm",
+		"/pin/stat",
+		"/pin/sync",
+		"/pin/sync/status",
 		"/pin/update",
 		"/pin/verify",
 		"/ping",
@@ -182,21 +187,33 @@ func TestCommands(t *testing.T) {
 		"/stats/dht",
 		"/stats/provide",
 		"/stats/repo",
+		"/stats/routing",
+		"/stats/schedule",
 		"/swarm",
 		"/swarm/addrs",
 		"/swarm/addrs/listen",
 		"/swarm/addrs/local",
+		"/swarm/ban",
+		"/swarm/bans",
 		"/swarm/connect",
 		"/swarm/disconnect",
 		"/swarm/filters",
 		"/swarm/filters/add",
 		"/swarm/filters/rm",
+		"/swarm/history",
 		"/swarm/peers",
 		"/swarm/peering",
 		"/swarm/peering/add",
 		"/swarm/peering/ls",
 		"/swarm/peering/rm",
+		"/swarm/probe",
+		"/swarm/protect",
+		"/swarm/reachability",
+		"/swarm/relay",
+		"/swarm/relay/stats",
 		"/swarm/resources",
+		"/swarm/unban",
+		"/swarm/unprotect",
 		"/update",
 		"/version",
 		"/version/deps",
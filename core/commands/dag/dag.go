@@ -16,10 +16,13 @@ import (
 )
 
 const (
-	pinRootsOptionName = "pin-roots"
-	progressOptionName = "progress"
-	silentOptionName   = "silent"
-	statsOptionName    = "stats"
+	pinRootsOptionName  = "pin-roots"
+	pinNameOptionName   = "pin-name"
+	atomicOptionName    = "atomic"
+	reachableOptionName = "reject-unreachable"
+	progressOptionName  = "progress"
+	silentOptionName    = "silent"
+	statsOptionName     = "stats"
 )
 
 // DagCmd provides a subset of commands for interacting with ipld dag objects
@@ -189,6 +192,25 @@ Note:
   currently present in the blockstore does not represent a complete DAG,
   pinning of that individual root will fail.
 
+  The roots can be pinned under a name with --pin-name, and read back via
+  'ipfs pin ls --names'.
+
+Atomic import:
+  By default, blocks are written to the blockstore as they are read, and a
+  failure part-way through leaves the blocks read so far in the blockstore.
+
+  With --atomic, blocks are written to a staging area instead. They are
+  only moved to the blockstore once all CAR files were read, and, when
+  --pin-roots is set, once the DAG of every root is complete. An error
+  while reading or verifying them fails the whole import and discards the
+  staging area, leaving the blockstore untouched. The blocks are then moved
+  in batches: an error while moving them can leave part of them in the
+  blockstore, and importing the CAR files again completes it. The staging
+  areas of interrupted imports are removed by the next atomic import.
+
+  With --reject-unreachable, the import fails if any block of the CAR files
+  is not part of the DAG of one of their roots. It implies --atomic.
+
 Maximum supported CAR version: 2
 Specification of CAR formats: https://ipld.io/specs/transport/car/
 `,
@@ -198,6 +220,9 @@ Specification of CAR formats: https://ipld.io/specs/transport/car/
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRootsOptionName, "Pin optional roots listed in the .car headers after importing.").WithDefault(true),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pins of the roots."),
+		cmds.BoolOption(atomicOptionName, "Only import the blocks if all CAR files are valid and, with --pin-roots, the DAGs of all roots are complete."),
+		cmds.BoolOption(reachableOptionName, "Reject CAR files with blocks that are not reachable from their roots. Implies --atomic."),
 		cmds.BoolOption(silentOptionName, "No output."),
 		cmds.BoolOption(statsOptionName, "Output stats."),
 		cmdutils.AllowBigBlockOption,
@@ -1,6 +1,7 @@
 package dagcmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -40,6 +41,16 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 	}
 
 	doPinRoots, _ := req.Options[pinRootsOptionName].(bool)
+	pinName, _ := req.Options[pinNameOptionName].(string)
+	atomic, _ := req.Options[atomicOptionName].(bool)
+	rejectUnreachable, _ := req.Options[reachableOptionName].(bool)
+
+	if pinName != "" && !doPinRoots {
+		return fmt.Errorf("--%s requires --%s", pinNameOptionName, pinRootsOptionName)
+	}
+	if rejectUnreachable {
+		atomic = true
+	}
 
 	// grab a pinlock ( which doubles as a GC lock ) so that regardless of the
 	// size of the streamed-in cars nothing will disappear on us before we had
@@ -52,10 +63,27 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 		defer unlocker.Unlock(req.Context)
 	}
 
-	// this is *not* a transaction
+	// without --atomic, this is *not* a transaction
 	// it is simply a way to relieve pressure on the blockstore
 	// similar to pinner.Pin/pinner.Flush
-	batch := ipld.NewBatch(req.Context, api.Dag())
+	// with --atomic, blocks are staged and only written to the blockstore
+	// once every CAR file was imported and verified
+	var batch *ipld.Batch
+	var staging *importStaging
+	if atomic {
+		staging, err = newImportStaging(req.Context, node.Repo.Datastore(), node.Blockstore)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			// the staging area must be removed even if the request was canceled
+			if err := staging.discard(context.WithoutCancel(req.Context)); err != nil {
+				log.Errorf("removing dag import staging area: %s", err)
+			}
+		}()
+	} else {
+		batch = ipld.NewBatch(req.Context, api.Dag())
+	}
 
 	roots := cid.NewSet()
 	var blockCount, blockBytesCount uint64
@@ -117,7 +145,12 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 					return importError(previous, block, err)
 				}
 
-				if err := batch.Add(req.Context, nd); err != nil {
+				if staging != nil {
+					err = staging.Put(req.Context, block)
+				} else {
+					err = batch.Add(req.Context, nd)
+				}
+				if err != nil {
 					return importError(previous, block, err)
 				}
 				blockCount++
@@ -131,7 +164,14 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 		}
 	}
 
-	if err := batch.Commit(); err != nil {
+	if staging != nil {
+		if err := staging.verify(req.Context, roots, doPinRoots, rejectUnreachable); err != nil {
+			return fmt.Errorf("import failed: %w", err)
+		}
+		if err := staging.commit(req.Context); err != nil {
+			return err
+		}
+	} else if err := batch.Commit(); err != nil {
 		return err
 	}
 
@@ -152,7 +192,7 @@ func dagImport(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment
 				ret.PinErrorMsg = err.Error()
 			} else if nd, err := blockDecoder.DecodeNode(req.Context, block); err != nil {
 				ret.PinErrorMsg = err.Error()
-			} else if err := node.Pinning.Pin(req.Context, nd, true, ""); err != nil {
+			} else if err := node.Pinning.Pin(req.Context, nd, true, pinName); err != nil {
 				ret.PinErrorMsg = err.Error()
 			} else if err := node.Pinning.Flush(req.Context); err != nil {
 				ret.PinErrorMsg = err.Error()
@@ -0,0 +1,185 @@
+package dagcmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	ipld "github.com/ipfs/go-ipld-format"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("core/commands/dag")
+
+// stagingPrefix is the datastore namespace of the staging areas of atomic
+// imports.
+var stagingPrefix = datastore.NewKey("/local/dagimport/staging")
+
+// commitBatchSize is the number of blocks moved at once from the staging area
+// to the blockstore.
+const commitBatchSize = 256
+
+// importStaging holds the blocks of an atomic import until every CAR file
+// was read and verified.
+type importStaging struct {
+	ds    datastore.Batching
+	bs    blockstore.Blockstore
+	keys  *cid.Set
+	store blockstore.Blockstore
+}
+
+// sweepStaleStaging is done once per process, before its first atomic import.
+var sweepStaleStaging sync.Once
+
+// newImportStaging creates an empty staging area in the repo datastore for
+// blocks to be committed to store.
+func newImportStaging(ctx context.Context, ds datastore.Batching, store blockstore.Blockstore) (*importStaging, error) {
+	// the repo lock keeps other processes out, so the staging areas present
+	// before the first import of this one were left by imports that were
+	// interrupted
+	sweepStaleStaging.Do(func() {
+		if err := deleteAll(ctx, namespace.Wrap(ds, stagingPrefix)); err != nil {
+			log.Errorf("removing the staging areas of interrupted imports: %s", err)
+		}
+	})
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	staged := namespace.Wrap(ds, stagingPrefix.ChildString(hex.EncodeToString(id)))
+	return &importStaging{
+		ds:    staged,
+		bs:    blockstore.NewBlockstore(staged),
+		keys:  cid.NewSet(),
+		store: store,
+	}, nil
+}
+
+// Get returns a staged block, or a block already present in the blockstore.
+func (s *importStaging) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := s.bs.Get(ctx, c)
+	if ipld.IsNotFound(err) {
+		return s.store.Get(ctx, c)
+	}
+	return blk, err
+}
+
+// Put stages a block.
+func (s *importStaging) Put(ctx context.Context, blk blocks.Block) error {
+	s.keys.Add(blk.Cid())
+	return s.bs.Put(ctx, blk)
+}
+
+// verify walks the DAGs of the roots through the staged blocks and the
+// blockstore. When complete is set, every root must be a complete DAG. When
+// reachable is set, every staged block must be part of the DAG of a root.
+func (s *importStaging) verify(ctx context.Context, roots *cid.Set, complete, reachable bool) error {
+	decoder := ipldlegacy.NewDecoder()
+	visited := cid.NewSet()
+
+	err := roots.ForEach(func(root cid.Cid) error {
+		stack := []cid.Cid{root}
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !visited.Visit(c) {
+				continue
+			}
+			blk, err := s.Get(ctx, c)
+			if ipld.IsNotFound(err) {
+				if complete {
+					return fmt.Errorf("DAG of root %q is incomplete: block %q is missing", root, c)
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			nd, err := decoder.DecodeNode(ctx, blk)
+			if err != nil {
+				return fmt.Errorf("decoding block %q: %w", c, err)
+			}
+			for _, l := range nd.Links() {
+				stack = append(stack, l.Cid)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if reachable {
+		return s.keys.ForEach(func(c cid.Cid) error {
+			if !visited.Has(c) {
+				return fmt.Errorf("block %q is not reachable from the roots of the CAR files", c)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// commit moves the staged blocks to the blockstore, in batches. A failure
+// leaves the batches moved so far in the blockstore.
+func (s *importStaging) commit(ctx context.Context) error {
+	batch := make([]blocks.Block, 0, commitBatchSize)
+	err := s.keys.ForEach(func(c cid.Cid) error {
+		blk, err := s.bs.Get(ctx, c)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, blk)
+		if len(batch) < commitBatchSize {
+			return nil
+		}
+		err = s.store.PutMany(ctx, batch)
+		batch = make([]blocks.Block, 0, commitBatchSize)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return s.store.PutMany(ctx, batch)
+	}
+	return nil
+}
+
+// discard removes the staging area from the datastore.
+func (s *importStaging) discard(ctx context.Context) error {
+	return deleteAll(ctx, s.ds)
+}
+
+// deleteAll removes every key of ds.
+func deleteAll(ctx context.Context, ds datastore.Batching) error {
+	results, err := ds.Query(ctx, query.Query{KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	batch, err := ds.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		if err := batch.Delete(ctx, datastore.NewKey(r.Key)); err != nil {
+			return err
+		}
+	}
+	return batch.Commit(ctx)
+}
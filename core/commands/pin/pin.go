@@ -8,11 +8,8 @@ import (
 	"os"
 	"time"
 
-	bserv "github.com/ipfs/boxo/blockservice"
-	offline "github.com/ipfs/boxo/exchange/offline"
 	dag "github.com/ipfs/boxo/ipld/merkledag"
-	verifcid "github.com/ipfs/boxo/verifcid"
-	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-cid"
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	coreiface "github.com/ipfs/kubo/core/coreiface"
@@ -22,6 +19,7 @@ import (
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
 	"github.com/ipfs/kubo/core/commands/cmdutils"
 	e "github.com/ipfs/kubo/core/commands/e"
+	"github.com/ipfs/kubo/core/corerepo"
 )
 
 var PinCmd = &cmds.Command{
@@ -36,6 +34,8 @@ var PinCmd = &cmds.Command{
 		"verify": verifyPinCmd,
 		"update": updatePinCmd,
 		"remote": remotePinCmd,
+		"sync":   syncPinCmd,
+		"stat":   statPinCmd,
 	},
 }
 
@@ -304,6 +304,7 @@ const (
 	pinQuietOptionName  = "quiet"
 	pinStreamOptionName = "stream"
 	pinNamesOptionName  = "names"
+	pinSizeOptionName   = "size"
 )
 
 var listPinCmd = &cmds.Command{
@@ -333,6 +334,11 @@ Valid values are:
 By default, pin names are not included (returned as empty).
 Pass '--names' flag to return pin names (set with '--name' from 'pin add').
 
+Pass '--size' to also return the disk usage of recursive and direct pins: the
+cumulative size of their blocks, followed by the size of the blocks that are
+not referenced by any other pin, which would be freed by a garbage collection
+if they were unpinned. See 'ipfs pin stat' for details.
+
 With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
@@ -362,6 +368,7 @@ Example:
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
 		cmds.BoolOption(pinNamesOptionName, "n", "Enable displaying pin names (slower)."),
+		cmds.BoolOption(pinSizeOptionName, "Enable displaying the disk usage of recursive and direct pins (slower)."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -372,6 +379,7 @@ Example:
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
 		displayNames, _ := req.Options[pinNamesOptionName].(bool)
+		displaySizes, _ := req.Options[pinSizeOptionName].(bool)
 
 		switch typeStr {
 		case "all", "direct", "indirect", "recursive":
@@ -380,12 +388,29 @@ Example:
 			return err
 		}
 
+		var sizes map[cid.Cid]corerepo.PinSize
+		if displaySizes {
+			n, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			sizes, err = corerepo.PinSizes(req.Context, n)
+			if err != nil {
+				return err
+			}
+		}
+
 		// For backward compatibility, we accumulate the pins in the same output type as before.
 		var emit func(PinLsOutputWrapper) error
 		lgcList := map[string]PinLsType{}
 		if !stream {
 			emit = func(v PinLsOutputWrapper) error {
-				lgcList[v.PinLsObject.Cid] = PinLsType{Type: v.PinLsObject.Type, Name: v.PinLsObject.Name}
+				lgcList[v.PinLsObject.Cid] = PinLsType{
+					Type:       v.PinLsObject.Type,
+					Name:       v.PinLsObject.Name,
+					Size:       v.PinLsObject.Size,
+					UniqueSize: v.PinLsObject.UniqueSize,
+				}
 				return nil
 			}
 		} else {
@@ -395,9 +420,9 @@ Example:
 		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+			err = pinLsKeys(req, typeStr, api, sizes, emit)
 		} else {
-			err = pinLsAll(req, typeStr, displayNames, api, emit)
+			err = pinLsAll(req, typeStr, displayNames, api, sizes, emit)
 		}
 		if err != nil {
 			return err
@@ -428,13 +453,18 @@ Example:
 			quiet, _ := req.Options[pinQuietOptionName].(bool)
 			stream, _ := req.Options[pinStreamOptionName].(bool)
 
+			displaySizes, _ := req.Options[pinSizeOptionName].(bool)
+
 			if stream {
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
-				} else if out.PinLsObject.Name == "" {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
 				} else {
-					fmt.Fprintf(w, "%s %s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type, out.PinLsObject.Name)
+					writePinLsLine(w, out.PinLsObject.Cid, PinLsType{
+						Type:       out.PinLsObject.Type,
+						Name:       out.PinLsObject.Name,
+						Size:       out.PinLsObject.Size,
+						UniqueSize: out.PinLsObject.UniqueSize,
+					}, displaySizes)
 				}
 				return nil
 			}
@@ -442,10 +472,8 @@ Example:
 			for k, v := range out.PinLsList.Keys {
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
-				} else if v.Name == "" {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
 				} else {
-					fmt.Fprintf(w, "%s %s %s\n", k, v.Type, v.Name)
+					writePinLsLine(w, k, v, displaySizes)
 				}
 			}
 
@@ -454,6 +482,23 @@ Example:
 	},
 }
 
+// writePinLsLine writes a pin in the text output of pin ls. Sizes are written
+// as "-" for indirect pins.
+func writePinLsLine(w io.Writer, c string, v PinLsType, displaySizes bool) {
+	fmt.Fprintf(w, "%s %s", c, v.Type)
+	if displaySizes {
+		if v.Size == nil {
+			fmt.Fprint(w, " - -")
+		} else {
+			fmt.Fprintf(w, " %d %d", *v.Size, *v.UniqueSize)
+		}
+	}
+	if v.Name != "" {
+		fmt.Fprintf(w, " %s", v.Name)
+	}
+	fmt.Fprintln(w)
+}
+
 // PinLsOutputWrapper is the output type of the pin ls command.
 // Pin ls needs to output two different type depending on if it's streamed or not.
 // We use this to bypass the cmds lib refusing to have interface{}
@@ -469,18 +514,31 @@ type PinLsList struct {
 
 // PinLsType contains the type of a pin
 type PinLsType struct {
-	Type string
-	Name string
+	Type       string
+	Name       string
+	Size       *uint64 `json:",omitempty"`
+	UniqueSize *uint64 `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Name string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid        string  `json:",omitempty"`
+	Name       string  `json:",omitempty"`
+	Type       string  `json:",omitempty"`
+	Size       *uint64 `json:",omitempty"`
+	UniqueSize *uint64 `json:",omitempty"`
+}
+
+// withSize sets the sizes of the pin, if they were computed.
+func (o PinLsObject) withSize(c cid.Cid, sizes map[cid.Cid]corerepo.PinSize) PinLsObject {
+	if s, ok := sizes[c]; ok {
+		o.Size = &s.Size
+		o.UniqueSize = &s.UniqueSize
+	}
+	return o
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value PinLsOutputWrapper) error) error {
+func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, sizes map[cid.Cid]corerepo.PinSize, emit func(value PinLsOutputWrapper) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -527,7 +585,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			PinLsObject: PinLsObject{
 				Type: pinType,
 				Cid:  enc.Encode(rp.RootCid()),
-			},
+			}.withSize(rp.RootCid(), sizes),
 		})
 		if err != nil {
 			return err
@@ -537,7 +595,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, detailed bool, api coreiface.CoreAPI, emit func(value PinLsOutputWrapper) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, detailed bool, api coreiface.CoreAPI, sizes map[cid.Cid]corerepo.PinSize, emit func(value PinLsOutputWrapper) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -569,7 +627,7 @@ func pinLsAll(req *cmds.Request, typeStr string, detailed bool, api coreiface.Co
 				Type: p.Type(),
 				Name: p.Name(),
 				Cid:  enc.Encode(p.Path().RootCid()),
-			},
+			}.withSize(p.Path().RootCid(), sizes),
 		})
 		if err != nil {
 			return err
@@ -655,16 +713,36 @@ pin.
 }
 
 const (
-	pinVerboseOptionName = "verbose"
+	pinVerboseOptionName    = "verbose"
+	pinRepairOptionName     = "repair"
+	pinLastReportOptionName = "last-report"
 )
 
 var verifyPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Verify that recursive pins are complete.",
+		ShortDescription: `
+Checks that every block of each recursive pin is present in the local
+blockstore.
+`,
+		LongDescription: `
+Checks that every block of each recursive pin is present in the local
+blockstore.
+
+Pass '--repair' to also check that every block matches its CID, remove the
+corrupt blocks of broken pins and fetch their missing blocks from the
+network. This requires a running daemon.
+
+When Pinning.Verify.Interval is set, the daemon periodically checks a sample
+of the recursive pins in the background. Pass '--last-report' to print the
+results of the last background verification.
+`,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(pinVerboseOptionName, "Also write the hashes of non-broken pins."),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of broken pins."),
+		cmds.BoolOption(pinRepairOptionName, "Refetch the missing or corrupt blocks of broken pins."),
+		cmds.BoolOption(pinLastReportOptionName, "Print the results of the last background verification."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -674,10 +752,18 @@ var verifyPinCmd = &cmds.Command{
 
 		verbose, _ := req.Options[pinVerboseOptionName].(bool)
 		quiet, _ := req.Options[pinQuietOptionName].(bool)
+		repair, _ := req.Options[pinRepairOptionName].(bool)
+		lastReport, _ := req.Options[pinLastReportOptionName].(bool)
 
 		if verbose && quiet {
 			return fmt.Errorf("the --verbose and --quiet options can not be used at the same time")
 		}
+		if repair && lastReport {
+			return fmt.Errorf("the --repair and --last-report options can not be used at the same time")
+		}
+		if repair && !n.IsOnline {
+			return fmt.Errorf("--repair requires the daemon to be running, missing blocks are fetched from the network")
+		}
 
 		enc, err := cmdenv.GetCidEncoder(req)
 		if err != nil {
@@ -687,7 +773,13 @@ var verifyPinCmd = &cmds.Command{
 		opts := pinVerifyOpts{
 			explain:   !quiet,
 			includeOk: verbose,
+			repair:    repair,
 		}
+
+		if lastReport {
+			return emitLastPinVerifyReport(req, res, n, opts, enc)
+		}
+
 		out, err := pinVerify(req.Context, n, opts, enc)
 		if err != nil {
 			return err
@@ -699,7 +791,7 @@ var verifyPinCmd = &cmds.Command{
 		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinVerifyRes) error {
 			quiet, _ := req.Options[pinQuietOptionName].(bool)
 
-			if quiet && !out.Ok {
+			if quiet && !out.Ok && out.Report == nil {
 				fmt.Fprintf(w, "%s\n", out.Cid)
 			} else if !quiet {
 				out.Format(w)
@@ -715,11 +807,15 @@ type PinVerifyRes struct {
 	Cid string `json:",omitempty"`
 	Err string `json:",omitempty"`
 	PinStatus
+
+	// Report is only set on the first result of "pin verify --last-report"
+	Report *PinVerifyReport `json:",omitempty"`
 }
 
 // PinStatus is part of PinVerifyRes, do not use directly
 type PinStatus struct {
 	Ok       bool      `json:",omitempty"`
+	Repaired bool      `json:",omitempty"`
 	BadNodes []BadNode `json:",omitempty"`
 }
 
@@ -729,57 +825,34 @@ type BadNode struct {
 	Err string
 }
 
+// PinVerifyReport is used in PinVerifyRes
+type PinVerifyReport struct {
+	Started  time.Time
+	Finished time.Time
+	Checked  int
+	Broken   int
+	Repaired int
+}
+
 type pinVerifyOpts struct {
 	explain   bool
 	includeOk bool
+	repair    bool
 }
 
-// FIXME: this implementation is duplicated sith core/coreapi.PinAPI.Verify, remove this one and exclusively rely on CoreAPI.
-func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc cidenc.Encoder) (<-chan any, error) {
-	visited := make(map[cid.Cid]PinStatus)
-
-	bs := n.Blocks.Blockstore()
-	DAG := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
-	getLinks := dag.GetLinksWithDAG(DAG)
-
-	var checkPin func(root cid.Cid) PinStatus
-	checkPin = func(root cid.Cid) PinStatus {
-		key := root
-		if status, ok := visited[key]; ok {
-			return status
+func toPinStatus(s corerepo.PinVerifyStatus, opts pinVerifyOpts, enc cidenc.Encoder) PinStatus {
+	status := PinStatus{Ok: s.Ok, Repaired: s.Repaired}
+	if opts.explain {
+		for _, bad := range s.BadBlocks {
+			status.BadNodes = append(status.BadNodes, BadNode{Cid: enc.Encode(bad.Cid), Err: bad.Err})
 		}
-
-		if err := verifcid.ValidateCid(verifcid.DefaultAllowlist, root); err != nil {
-			status := PinStatus{Ok: false}
-			if opts.explain {
-				status.BadNodes = []BadNode{{Cid: enc.Encode(key), Err: err.Error()}}
-			}
-			visited[key] = status
-			return status
-		}
-
-		links, err := getLinks(ctx, root)
-		if err != nil {
-			status := PinStatus{Ok: false}
-			if opts.explain {
-				status.BadNodes = []BadNode{{Cid: enc.Encode(key), Err: err.Error()}}
-			}
-			visited[key] = status
-			return status
-		}
-
-		status := PinStatus{Ok: true}
-		for _, lnk := range links {
-			res := checkPin(lnk.Cid)
-			if !res.Ok {
-				status.Ok = false
-				status.BadNodes = append(status.BadNodes, res.BadNodes...)
-			}
-		}
-
-		visited[key] = status
-		return status
 	}
+	return status
+}
+
+// FIXME: this implementation is duplicated sith core/coreapi.PinAPI.Verify, remove this one and exclusively rely on CoreAPI.
+func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc cidenc.Encoder) (<-chan any, error) {
+	v := corerepo.NewPinVerifier(n, opts.repair)
 
 	out := make(chan any)
 	go func() {
@@ -789,10 +862,17 @@ func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc ci
 				out <- PinVerifyRes{Err: p.Err.Error()}
 				return
 			}
-			pinStatus := checkPin(p.Pin.Key)
-			if !pinStatus.Ok || opts.includeOk {
+			status := v.Check(ctx, p.Pin.Key)
+			if !status.Ok && opts.repair {
+				repaired, err := v.Repair(ctx, p.Pin.Key, status)
+				if err != nil {
+					repaired.BadBlocks = append(repaired.BadBlocks, corerepo.BadBlock{Cid: p.Pin.Key, Err: "repair failed: " + err.Error()})
+				}
+				status = repaired
+			}
+			if !status.Ok || status.Repaired || opts.includeOk {
 				select {
-				case out <- PinVerifyRes{Cid: enc.Encode(p.Pin.Key), PinStatus: pinStatus}:
+				case out <- PinVerifyRes{Cid: enc.Encode(p.Pin.Key), PinStatus: toPinStatus(status, opts, enc)}:
 				case <-ctx.Done():
 					return
 				}
@@ -803,6 +883,33 @@ func pinVerify(ctx context.Context, n *core.IpfsNode, opts pinVerifyOpts, enc ci
 	return out, nil
 }
 
+func emitLastPinVerifyReport(req *cmds.Request, res cmds.ResponseEmitter, n *core.IpfsNode, opts pinVerifyOpts, enc cidenc.Encoder) error {
+	report, err := corerepo.LastPinVerifyReport(req.Context, n)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		return fmt.Errorf("no background pin verification was run yet, see Pinning.Verify.Interval")
+	}
+
+	err = res.Emit(&PinVerifyRes{Report: &PinVerifyReport{
+		Started:  report.Started,
+		Finished: report.Finished,
+		Checked:  report.Checked,
+		Broken:   report.Broken,
+		Repaired: report.Repaired,
+	}})
+	if err != nil {
+		return err
+	}
+	for _, p := range report.Pins {
+		if err := res.Emit(&PinVerifyRes{Cid: enc.Encode(p.Cid), PinStatus: toPinStatus(p.PinVerifyStatus, opts, enc)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Format formats PinVerifyRes
 func (r PinVerifyRes) Format(out io.Writer) {
 	if r.Err != "" {
@@ -810,6 +917,17 @@ func (r PinVerifyRes) Format(out io.Writer) {
 		return
 	}
 
+	if r.Report != nil {
+		fmt.Fprintf(out, "last verification finished %s: %d pins checked, %d broken, %d repaired\n",
+			r.Report.Finished.Format(time.RFC3339), r.Report.Checked, r.Report.Broken, r.Report.Repaired)
+		return
+	}
+
+	if r.Repaired {
+		fmt.Fprintf(out, "%s repaired\n", r.Cid)
+		return
+	}
+
 	if r.Ok {
 		fmt.Fprintf(out, "%s ok\n", r.Cid)
 		return
@@ -0,0 +1,101 @@
+package pin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/pinsync"
+)
+
+var errPinSyncDisabled = errors.New("pinset sync is not enabled, set Pinning.Sync.Enabled to true and restart the daemon")
+
+var syncPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Sync pins with the peers of a replication group.",
+		ShortDescription: `
+When Pinning.Sync is enabled, recursive pins are kept in sync with the peers
+listed in Pinning.Sync.Peers (or Peering.Peers when empty).
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"status": statusSyncPinCmd,
+	},
+}
+
+var statusSyncPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the state of the pinset sync.",
+		ShortDescription: `
+Prints the local sequence number, the number of synced pins, the number of
+conflicting changes resolved so far, the sync state with each peer of the
+replication group, and the changes of the peers that could not be applied.
+`,
+		LongDescription: `
+Prints the local sequence number, the number of synced pins, the number of
+conflicting changes resolved so far, the sync state with each peer of the
+replication group, and the changes of the peers that could not be applied.
+
+Conflicting changes to the same CID made on different peers are resolved by
+keeping the most recent one. Changes that cannot be applied, for example a
+pin whose blocks cannot be fetched within Pinning.Sync.PinTimeout, do not
+hold back the sync with their peer: they are retried with exponential
+backoff until they succeed or a newer change replaces them.
+
+  $ ipfs pin sync status
+  Seq:       42
+  Pins:      12
+  Conflicts: 0
+  Peers:
+    12D3KooW...  seq=17  synced 2024-05-01T10:00:00Z
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if n.PinSync == nil {
+			return errPinSyncDisabled
+		}
+		return cmds.EmitOnce(res, n.PinSync.Status())
+	},
+	Type: pinsync.Status{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *pinsync.Status) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer tw.Flush()
+
+			fmt.Fprintf(tw, "Seq:\t%d\n", out.Seq)
+			fmt.Fprintf(tw, "Pins:\t%d\n", out.Pins)
+			fmt.Fprintf(tw, "Conflicts:\t%d\n", out.Conflicts)
+			fmt.Fprintf(tw, "Peers:\t\n")
+			for _, p := range out.Peers {
+				synced := "never synced"
+				if !p.LastSync.IsZero() {
+					synced = "synced " + p.LastSync.UTC().Format(time.RFC3339)
+				}
+				if p.Error != "" {
+					synced += " (error: " + p.Error + ")"
+				}
+				fmt.Fprintf(tw, "  %s\tseq=%d\t%s\n", p.ID, p.LastSeq, synced)
+			}
+			if len(out.Failures) > 0 {
+				fmt.Fprintf(tw, "Failures:\t\n")
+			}
+			for _, f := range out.Failures {
+				op := "pin"
+				if f.Entry.Removed {
+					op = "unpin"
+				}
+				fmt.Fprintf(tw, "  %s %s\tfrom %s\tretries=%d\tnext %s\t(error: %s)\n", op, f.Entry.Cid, f.Peer, f.Retries, f.NextRetry.UTC().Format(time.RFC3339), f.Error)
+			}
+			return nil
+		}),
+	},
+}
@@ -0,0 +1,134 @@
+package pin
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+
+	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/commands/cmdutils"
+	options "github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/core/corerepo"
+)
+
+const pinHumanOptionName = "human"
+
+// PinStatOutput is the output type of the pin stat command.
+type PinStatOutput struct {
+	Cid  string
+	Type string
+	corerepo.PinSize
+}
+
+var statPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the disk usage of a pin.",
+		ShortDescription: `
+Prints the cumulative size of the blocks of a recursive or direct pin, and
+the size of the blocks that are not referenced by any other pin.
+`,
+		LongDescription: `
+Prints the cumulative size of the blocks of a recursive or direct pin, and
+the size of the blocks that are not referenced by any other pin.
+
+Size is the cumulative size of the blocks of the pin, each block being
+counted once even if it is linked several times in the DAG.
+
+UniqueSize is the size of the blocks only referenced by this pin, that is
+the space that a garbage collection would free if the pin was removed.
+Blocks shared with other recursive or direct pins, or with the MFS root, are
+not included.
+
+Only blocks stored locally are accounted for, the number of missing blocks
+is reported when the DAG of the pin is incomplete.
+
+Computing the unique sizes requires walking the DAGs of all pins. The result
+is cached in the repository until the pinset changes, so that repeated calls
+to 'pin stat' and 'pin ls --size' are cheap.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, false, "Path to the pinned object."),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(pinHumanOptionName, "H", "Print sizes in human readable format (e.g., 1K 234M 2G)"),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		p, err := cmdutils.PathOrCidPath(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		rp, _, err := api.ResolvePath(req.Context, p)
+		if err != nil {
+			return err
+		}
+
+		pinType, pinned, err := api.Pin().IsPinned(req.Context, rp, options.Pin.IsPinned.All())
+		if err != nil {
+			return err
+		}
+		if !pinned {
+			return fmt.Errorf("path '%s' is not pinned", p)
+		}
+		switch pinType {
+		case "recursive", "direct":
+		default:
+			return fmt.Errorf("path '%s' is not pinned recursively or directly", p)
+		}
+
+		size, err := corerepo.PinSizeOf(req.Context, n, rp.RootCid())
+		if err != nil {
+			return err
+		}
+
+		return cmds.EmitOnce(res, &PinStatOutput{
+			Cid:     enc.Encode(rp.RootCid()),
+			Type:    pinType,
+			PinSize: size,
+		})
+	},
+	Type: &PinStatOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinStatOutput) error {
+			wtr := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+			defer wtr.Flush()
+
+			human, _ := req.Options[pinHumanOptionName].(bool)
+			printSize := func(name string, size uint64) {
+				sizeStr := fmt.Sprintf("%d", size)
+				if human {
+					sizeStr = humanize.Bytes(size)
+				}
+				fmt.Fprintf(wtr, "%s:\t%s\n", name, sizeStr)
+			}
+
+			fmt.Fprintf(wtr, "Cid:\t%s\n", out.Cid)
+			fmt.Fprintf(wtr, "Type:\t%s\n", out.Type)
+			printSize("Size", out.Size)
+			fmt.Fprintf(wtr, "Blocks:\t%d\n", out.Blocks)
+			printSize("UniqueSize", out.UniqueSize)
+			fmt.Fprintf(wtr, "UniqueBlocks:\t%d\n", out.UniqueBlocks)
+			if out.Missing > 0 {
+				fmt.Fprintf(wtr, "Missing:\t%d\n", out.Missing)
+			}
+			return nil
+		}),
+	},
+}
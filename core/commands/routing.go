@@ -3,10 +3,12 @@ package commands
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
@@ -18,6 +20,7 @@ import (
 	ipld "github.com/ipfs/go-ipld-format"
 	iface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/ipfs/kubo/core/coreiface/options"
+	irouting "github.com/ipfs/kubo/routing"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	routing "github.com/libp2p/go-libp2p/core/routing"
 )
@@ -42,6 +45,7 @@ var RoutingCmd = &cmds.Command{
 		"get":       getValueRoutingCmd,
 		"put":       putValueRoutingCmd,
 		"provide":   provideRefRoutingCmd,
+		"check":     checkRoutingCmd,
 	},
 }
 
@@ -132,13 +136,22 @@ var findProvidersRoutingCmd = &cmds.Command{
 }
 
 const (
-	recursiveOptionName = "recursive"
+	recursiveOptionName     = "recursive"
+	provideStatusOptionName = "status"
 )
 
 var provideRefRoutingCmd = &cmds.Command{
 	Status: cmds.Experimental,
 	Helptext: cmds.HelpText{
 		Tagline: "Announce to the network that you are providing given values.",
+		ShortDescription: `
+Announces to the routers that the node provides the given keys.
+
+With --status, the keys are not announced. What the daemon knows of their
+announcements since it started is shown instead: when they were last
+announced, by which routers, and to how many peers for the DHT. The
+announcements are only tracked with Routing.TrackProvides enabled.
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -147,6 +160,7 @@ var provideRefRoutingCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(dhtVerboseOptionName, "v", "Print extra information."),
 		cmds.BoolOption(recursiveOptionName, "r", "Recursively provide entire graph."),
+		cmds.BoolOption(provideStatusOptionName, "Show when the keys were last announced instead."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		nd, err := cmdenv.GetNode(env)
@@ -158,10 +172,6 @@ var provideRefRoutingCmd = &cmds.Command{
 			return ErrNotOnline
 		}
 
-		if len(nd.PeerHost.Network().Conns()) == 0 {
-			return errors.New("cannot provide, no connected peers")
-		}
-
 		// Needed to parse stdin args.
 		// TODO: Lazy Load
 		err = req.ParseBodyArgs()
@@ -169,6 +179,34 @@ var provideRefRoutingCmd = &cmds.Command{
 			return err
 		}
 
+		if status, _ := req.Options[provideStatusOptionName].(bool); status {
+			if !nd.IsOnline {
+				return ErrNotOnline
+			}
+			if nd.ProvideTracker == nil {
+				return errors.New("the announcements are not tracked, see Routing.TrackProvides")
+			}
+			for _, arg := range req.Arguments {
+				c, err := cid.Decode(arg)
+				if err != nil {
+					return err
+				}
+				st, _ := nd.ProvideTracker.Status(c.Hash())
+				err = res.Emit(&provideOutput{Status: &provideStatusOutput{
+					Key:           c.String(),
+					ProvideStatus: st,
+				}})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		if len(nd.PeerHost.Network().Conns()) == 0 {
+			return errors.New("cannot provide, no connected peers")
+		}
+
 		rec, _ := req.Options[recursiveOptionName].(bool)
 
 		var cids []cid.Cid
@@ -210,7 +248,7 @@ var provideRefRoutingCmd = &cmds.Command{
 		}()
 
 		for e := range events {
-			if err := res.Emit(e); err != nil {
+			if err := res.Emit(&provideOutput{Event: e}); err != nil {
 				return err
 			}
 		}
@@ -218,7 +256,11 @@ var provideRefRoutingCmd = &cmds.Command{
 		return provideErr
 	},
 	Encoders: cmds.EncoderMap{
-		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *routing.QueryEvent) error {
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *provideOutput) error {
+			if out.Status != nil {
+				return printProvideStatus(w, out.Status)
+			}
+
 			pfm := pfuncMap{
 				routing.FinalPeer: func(obj *routing.QueryEvent, out io.Writer, verbose bool) error {
 					if verbose {
@@ -229,10 +271,73 @@ var provideRefRoutingCmd = &cmds.Command{
 			}
 
 			verbose, _ := req.Options[dhtVerboseOptionName].(bool)
-			return printEvent(out, w, verbose, pfm)
+			return printEvent(out.Event, w, verbose, pfm)
 		}),
 	},
-	Type: routing.QueryEvent{},
+	Type: provideOutput{},
+}
+
+// provideOutput is an event of the announcement of the keys or, with
+// --status, what is known of the announcements of a key. It is encoded as
+// either of them.
+type provideOutput struct {
+	Event  *routing.QueryEvent
+	Status *provideStatusOutput
+}
+
+type provideStatusOutput struct {
+	Key string
+	irouting.ProvideStatus
+}
+
+func (o *provideOutput) MarshalJSON() ([]byte, error) {
+	if o.Status != nil {
+		return json.Marshal(o.Status)
+	}
+	return json.Marshal(o.Event)
+}
+
+func (o *provideOutput) UnmarshalJSON(b []byte) error {
+	var probe struct{ Key *string }
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return err
+	}
+	if probe.Key != nil {
+		o.Status = new(provideStatusOutput)
+		return json.Unmarshal(b, o.Status)
+	}
+	o.Event = new(routing.QueryEvent)
+	return json.Unmarshal(b, o.Event)
+}
+
+func printProvideStatus(w io.Writer, st *provideStatusOutput) error {
+	tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+	defer tw.Flush()
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.Format(time.RFC3339), time.Since(t).Round(time.Second))
+	}
+
+	fmt.Fprintf(tw, "Key:\t%s\n", st.Key)
+	fmt.Fprintf(tw, "Last provide:\t%s\n", formatTime(st.LastProvide))
+	fmt.Fprintf(tw, "Last attempt:\t%s\n", formatTime(st.LastAttempt))
+	if st.Announcing {
+		fmt.Fprintf(tw, "Announcing:\tyes\n")
+	}
+	if st.Error != "" {
+		fmt.Fprintf(tw, "Error:\t%s\n", st.Error)
+	}
+	for _, r := range st.Routers {
+		fmt.Fprintf(tw, "Router %s:\t%s", r.Router, formatTime(r.Time))
+		if r.Peers > 0 {
+			fmt.Fprintf(tw, ", %d peers", r.Peers)
+		}
+		fmt.Fprintln(tw)
+	}
+	return nil
 }
 
 func provideKeys(ctx context.Context, r routing.Routing, cids []cid.Cid) error {
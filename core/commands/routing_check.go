@@ -0,0 +1,272 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/bitswap"
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	bspb "github.com/ipfs/boxo/bitswap/message/pb"
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	cid "github.com/ipfs/go-cid"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	routingCheckTimeoutOptionName = "provider-timeout"
+	defaultRoutingCheckTimeout    = 10 * time.Second
+)
+
+// Verdicts of ipfs routing check on the providers.
+const (
+	providerCheckOK          = "ok"
+	providerCheckSelf        = "self"
+	providerCheckNoAddrs     = "no-addresses"
+	providerCheckUnreachable = "unreachable"
+	providerCheckNoBitswap   = "no-bitswap"
+	providerCheckDontHave    = "dont-have"
+	providerCheckNoResponse  = "no-response"
+)
+
+type providerCheckOutput struct {
+	Peer    string
+	Verdict string
+	// Reason explains the verdict, when the provider failed a check.
+	Reason string `json:",omitempty"`
+	// Addrs are the results of dialing each address of the provider.
+	Addrs []probeResult
+	// Latency is how long the provider took to answer the bitswap request.
+	Latency time.Duration `json:",omitempty"`
+}
+
+var checkRoutingCmd = &cmds.Command{
+	Status: cmds.Experimental,
+	Helptext: cmds.HelpText{
+		Tagline: "Check whether the providers of a CID actually serve it.",
+		ShortDescription: `
+'ipfs routing check' looks up the providers of a CID with all the routers,
+and checks each provider in parallel: every address it advertises is dialed
+on its own, as with 'ipfs swarm probe', then the node connects to it and asks
+it over bitswap whether it has the block. A verdict is printed per provider:
+
+  ok            the provider answered that it has the block
+  self          the provider is this node
+  no-addresses  no address of the provider could be found
+  unreachable   the node could not connect to the provider
+  no-bitswap    the provider does not speak bitswap 1.2.0
+  dont-have     the provider answered that it does not have the block
+  no-response   the provider did not answer in time
+
+The addresses are those of the provider records, and those of the peer store
+or of a routing lookup when the records have none.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("cid", true, false, "The CID to check the providers of."),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(numProvidersOptionName, "n", "The number of providers to check.").WithDefault(20),
+		cmds.StringOption(routingCheckTimeoutOptionName, "Timeout of the checks of each provider.").WithDefault(defaultRoutingCheckTimeout.String()),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+		if n.BitswapTracer == nil {
+			return errors.New("bitswap tracing is not available, the answers of the providers cannot be received")
+		}
+
+		numProviders, _ := req.Options[numProvidersOptionName].(int)
+		if numProviders < 1 {
+			return fmt.Errorf("number of providers must be greater than 0")
+		}
+
+		timeoutStr, _ := req.Options[routingCheckTimeoutOptionName].(string)
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return fmt.Errorf("invalid provider timeout: %w", err)
+		}
+
+		c, err := cid.Parse(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(req.Context)
+		defer cancel()
+
+		results := make(chan *providerCheckOutput)
+		go func() {
+			var wg sync.WaitGroup
+			for p := range n.Routing.FindProvidersAsync(ctx, c, numProviders) {
+				wg.Add(1)
+				go func(p peer.AddrInfo) {
+					defer wg.Done()
+					out := checkProvider(ctx, n, c, p, timeout)
+					select {
+					case results <- out:
+					case <-ctx.Done():
+					}
+				}(p)
+			}
+			wg.Wait()
+			close(results)
+		}()
+
+		found := false
+		for out := range results {
+			found = true
+			if err := res.Emit(out); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return fmt.Errorf("no provider records found for %s", c)
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *providerCheckOutput) error {
+			fmt.Fprintf(w, "%s: %s", out.Peer, out.Verdict)
+			switch {
+			case out.Reason != "":
+				fmt.Fprintf(w, " (%s)", out.Reason)
+			case out.Verdict == providerCheckOK:
+				fmt.Fprintf(w, " (answered in %s)", out.Latency.Round(time.Millisecond))
+			}
+			fmt.Fprintln(w)
+			for _, r := range out.Addrs {
+				if r.Success {
+					fmt.Fprintf(w, "\t%s: reachable in %s\n", r.Addr, r.Latency.Round(time.Millisecond))
+				} else {
+					fmt.Fprintf(w, "\t%s: unreachable: %s\n", r.Addr, r.Error)
+				}
+			}
+			return nil
+		}),
+	},
+	Type: providerCheckOutput{},
+}
+
+// checkProvider dials each address of the provider, then asks it over bitswap
+// whether it has the block.
+func checkProvider(ctx context.Context, n *core.IpfsNode, c cid.Cid, p peer.AddrInfo, timeout time.Duration) *providerCheckOutput {
+	out := &providerCheckOutput{Peer: p.ID.String(), Addrs: []probeResult{}}
+	if p.ID == n.Identity {
+		out.Verdict = providerCheckSelf
+		return out
+	}
+
+	addrs := p.Addrs
+	if len(addrs) == 0 {
+		addrs = n.Peerstore.Addrs(p.ID)
+	}
+	if len(addrs) == 0 {
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		pi, err := n.Routing.FindPeer(lookupCtx, p.ID)
+		cancel()
+		if err != nil {
+			out.Verdict = providerCheckNoAddrs
+			out.Reason = fmt.Sprintf("peer lookup failed: %s", err)
+			return out
+		}
+		addrs = pi.Addrs
+	}
+	out.Addrs = probeAddrs(ctx, n, p.ID, addrs, timeout)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := n.PeerHost.Connect(ctx, peer.AddrInfo{ID: p.ID, Addrs: addrs}); err != nil {
+		out.Verdict = providerCheckUnreachable
+		out.Reason = err.Error()
+		return out
+	}
+
+	// the answer comes back as a message of its own, which is received by
+	// bitswap and seen by the tracer
+	events, unsubscribe := n.BitswapTracer.Subscribe([]cid.Cid{c}, 16)
+	defer unsubscribe()
+
+	want := bsmsg.New(false)
+	want.AddEntry(c, 1, bspb.Message_Wantlist_Have, true)
+	start := time.Now()
+	if err := sendBitswapMessage(ctx, n.PeerHost, p.ID, want); err != nil {
+		out.Verdict = providerCheckNoBitswap
+		out.Reason = err.Error()
+		return out
+	}
+	defer func() {
+		// the provider forgets the want once it answered that it has the
+		// block, but keeps it after a DONT_HAVE or without an answer. The
+		// want of the node itself for the block must not be cancelled.
+		if (out.Verdict != providerCheckNoResponse && out.Verdict != providerCheckDontHave) || wantedLocally(n, c) {
+			return
+		}
+		cancelMsg := bsmsg.New(false)
+		cancelMsg.Cancel(c)
+		cancelCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = sendBitswapMessage(cancelCtx, n.PeerHost, p.ID, cancelMsg)
+	}()
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Peer != p.ID {
+				continue
+			}
+			switch ev.Type {
+			case node.BitswapTraceHaveReceived, node.BitswapTraceBlockReceived:
+				out.Verdict = providerCheckOK
+				out.Latency = time.Since(start)
+				return out
+			case node.BitswapTraceDontHaveReceived:
+				out.Verdict = providerCheckDontHave
+				return out
+			}
+		case <-ctx.Done():
+			out.Verdict = providerCheckNoResponse
+			out.Reason = fmt.Sprintf("no answer to the bitswap request in %s", timeout)
+			return out
+		}
+	}
+}
+
+// wantedLocally returns whether the bitswap client of the node wants c. It
+// is assumed to when the exchange is not bitswap itself.
+func wantedLocally(n *core.IpfsNode, c cid.Cid) bool {
+	bs, ok := n.Exchange.(*bitswap.Bitswap)
+	if !ok {
+		return true
+	}
+	return slices.Contains(bs.GetWantlist(), c)
+}
+
+// sendBitswapMessage sends a message on a stream of its own, the way bitswap
+// does for single messages.
+func sendBitswapMessage(ctx context.Context, h host.Host, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	s, err := h.NewStream(ctx, p, bsnet.ProtocolBitswap)
+	if err != nil {
+		return err
+	}
+	if err := msg.ToNetV1(s); err != nil {
+		_ = s.Reset()
+		return err
+	}
+	return s.Close()
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	cmdenv "github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
 
 	humanize "github.com/dustin/go-humanize"
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -26,11 +27,13 @@ for your IPFS node.`,
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"bw":      statBwCmd,
-		"repo":    repoStatCmd,
-		"bitswap": bitswapStatCmd,
-		"dht":     statDhtCmd,
-		"provide": statProvideCmd,
+		"bw":       statBwCmd,
+		"repo":     repoStatCmd,
+		"bitswap":  bitswapStatCmd,
+		"dht":      statDhtCmd,
+		"provide":  statProvideCmd,
+		"routing":  statRoutingCmd,
+		"schedule": statScheduleCmd,
 	},
 }
 
@@ -76,6 +79,19 @@ Example:
     TotalOut: 12MB
     RateIn: 0B/s
     RateOut: 0B/s
+
+When Swarm.BandwidthLimits is set, the limits that apply are displayed as
+well, and marked as throttled when streams recently had to wait for them:
+
+    > ipfs stats bw -t /ipfs/bitswap/1.2.0
+    Bandwidth
+    TotalIn: 5.0MB
+    TotalOut: 0B
+    RateIn: 1.0MB/s
+    RateOut: 0B/s
+    Limits (bitswap)
+    LimitIn: 1.0MB/s (throttled)
+    LimitOut: unlimited
 `,
 	},
 	Options: []cmds.Option{
@@ -126,23 +142,28 @@ Example:
 
 		doPoll, _ := req.Options[statPollOptionName].(bool)
 		for {
+			var stats BandwidthStats
 			if pfound {
-				stats := nd.Reporter.GetBandwidthForPeer(pid)
-				if err := res.Emit(&stats); err != nil {
-					return err
-				}
+				stats.Stats = nd.Reporter.GetBandwidthForPeer(pid)
 			} else if tfound {
 				protoID := protocol.ID(tstr)
-				stats := nd.Reporter.GetBandwidthForProtocol(protoID)
-				if err := res.Emit(&stats); err != nil {
-					return err
-				}
+				stats.Stats = nd.Reporter.GetBandwidthForProtocol(protoID)
 			} else {
-				totals := nd.Reporter.GetBandwidthTotals()
-				if err := res.Emit(&totals); err != nil {
-					return err
+				stats.Stats = nd.Reporter.GetBandwidthTotals()
+			}
+			if nd.BandwidthThrottler != nil {
+				switch {
+				case tfound:
+					stats.Limits = []libp2p.BandwidthThrottleStatus{nd.BandwidthThrottler.Status(protocol.ID(tstr))}
+				case pfound:
+					stats.Limits = []libp2p.BandwidthThrottleStatus{nd.BandwidthThrottler.Status("")}
+				default:
+					stats.Limits = nd.BandwidthThrottler.Statuses()
 				}
 			}
+			if err := res.Emit(&stats); err != nil {
+				return err
+			}
 			if !doPoll {
 				return nil
 			}
@@ -153,7 +174,7 @@ Example:
 			}
 		}
 	},
-	Type: metrics.Stats{},
+	Type: BandwidthStats{},
 	PostRun: cmds.PostRunMap{
 		cmds.CLI: func(res cmds.Response, re cmds.ResponseEmitter) error {
 			polling, _ := res.Request().Options[statPollOptionName].(bool)
@@ -170,7 +191,7 @@ Example:
 					return err
 				}
 
-				bs := v.(*metrics.Stats)
+				bs := v.(*BandwidthStats)
 
 				if !polling {
 					printStats(os.Stdout, bs)
@@ -180,16 +201,64 @@ Example:
 				fmt.Fprintf(os.Stdout, "%8s    ", humanize.Bytes(uint64(bs.TotalOut)))
 				fmt.Fprintf(os.Stdout, "%8s    ", humanize.Bytes(uint64(bs.TotalIn)))
 				fmt.Fprintf(os.Stdout, "%8s/s  ", humanize.Bytes(uint64(bs.RateOut)))
-				fmt.Fprintf(os.Stdout, "%8s/s      \r", humanize.Bytes(uint64(bs.RateIn)))
+				fmt.Fprintf(os.Stdout, "%8s/s  ", humanize.Bytes(uint64(bs.RateIn)))
+				fmt.Fprintf(os.Stdout, "%-10s  \r", throttledDirections(bs.Limits))
 			}
 		},
 	},
 }
 
-func printStats(out io.Writer, bs *metrics.Stats) {
+// BandwidthStats is the output type of the stats bw command.
+type BandwidthStats struct {
+	metrics.Stats
+	// Limits are the bandwidth limits that apply, when Swarm.BandwidthLimits
+	// is set.
+	Limits []libp2p.BandwidthThrottleStatus `json:",omitempty"`
+}
+
+func printStats(out io.Writer, bs *BandwidthStats) {
 	fmt.Fprintln(out, "Bandwidth")
 	fmt.Fprintf(out, "TotalIn: %s\n", humanize.Bytes(uint64(bs.TotalIn)))
 	fmt.Fprintf(out, "TotalOut: %s\n", humanize.Bytes(uint64(bs.TotalOut)))
 	fmt.Fprintf(out, "RateIn: %s/s\n", humanize.Bytes(uint64(bs.RateIn)))
 	fmt.Fprintf(out, "RateOut: %s/s\n", humanize.Bytes(uint64(bs.RateOut)))
+
+	for _, l := range bs.Limits {
+		if l.Protocol == "" {
+			fmt.Fprintln(out, "Limits")
+		} else {
+			fmt.Fprintf(out, "Limits (%s)\n", l.Protocol)
+		}
+		fmt.Fprintf(out, "LimitIn: %s\n", formatBandwidthLimit(l.In))
+		fmt.Fprintf(out, "LimitOut: %s\n", formatBandwidthLimit(l.Out))
+	}
+}
+
+func formatBandwidthLimit(l libp2p.BandwidthLimitStatus) string {
+	if l.Limit == 0 {
+		return "unlimited"
+	}
+	if l.Throttled {
+		return humanize.Bytes(l.Limit) + "/s (throttled)"
+	}
+	return humanize.Bytes(l.Limit) + "/s"
+}
+
+// throttledDirections returns which directions are throttled by any limit.
+func throttledDirections(limits []libp2p.BandwidthThrottleStatus) string {
+	var in, out bool
+	for _, l := range limits {
+		in = in || l.In.Throttled
+		out = out || l.Out.Throttled
+	}
+	switch {
+	case in && out:
+		return "throttled"
+	case in:
+		return "throttled down"
+	case out:
+		return "throttled up"
+	default:
+		return ""
+	}
 }
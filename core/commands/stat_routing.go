@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	irouting "github.com/ipfs/kubo/routing"
+)
+
+type routingStatsOutput struct {
+	Routers []irouting.RouterStat
+}
+
+var statRoutingCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the requests, errors and latencies of each router.",
+		ShortDescription: `
+Shows, for each router and method, how many requests were sent, how many
+failed, how many providers, peers or values were found, and the average
+latency. Lookups that found nothing are not counted as failures. The latency
+of the lookups returning several results is the time to the first one.
+
+HEDGED is how many lookups a parallel router with a HedgePercentile sent to
+its secondary routers.
+
+The same numbers are exported as Prometheus metrics under ipfs_routing_*.
+
+This interface is not stable and may change from release to release.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		return cmds.EmitOnce(res, &routingStatsOutput{Routers: irouting.RouterStats()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *routingStatsOutput) error {
+			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer wtr.Flush()
+
+			fmt.Fprintln(wtr, "ROUTER\tMETHOD\tREQUESTS\tERRORS\tRESULTS\tHEDGED\tAVG LATENCY")
+			for _, s := range out.Routers {
+				fmt.Fprintf(wtr, "%s\t%s\t%d\t%d\t%d\t%d\t%s\n",
+					s.Router, s.Method, s.Requests, s.Errors, s.Results, s.Hedged,
+					s.AverageLatency.Round(time.Millisecond))
+			}
+			return nil
+		}),
+	},
+	Type: routingStatsOutput{},
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/schedule"
+)
+
+var statScheduleCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the active schedule and the settings it applies.",
+		ShortDescription: `
+Shows which of the Schedules of the config is active, and the bandwidth
+limits, connection manager watermarks, reprovider and bitswap server settings
+that currently apply.
+
+This interface is not stable and may change from release to release.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		nd, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !nd.IsOnline {
+			return ErrNotOnline
+		}
+
+		if nd.Scheduler == nil {
+			return errors.New("no schedule configured, see Schedules in the config")
+		}
+
+		return cmds.EmitOnce(res, nd.Scheduler.Status())
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *schedule.Status) error {
+			wtr := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer wtr.Flush()
+
+			active := s.Active
+			if active == "" {
+				active = "none"
+			}
+			fmt.Fprintf(wtr, "Active:\t%s\n", active)
+			fmt.Fprintf(wtr, "Since:\t%s\n", s.Since.Format(time.RFC3339))
+			if !s.NextChange.IsZero() {
+				fmt.Fprintf(wtr, "NextChange:\t%s\n", s.NextChange.Format(time.RFC3339))
+			}
+
+			limits := s.Settings.BandwidthLimits
+			fmt.Fprintf(wtr, "RateIn:\t%s\n", formatScheduledRate(limits.RateIn))
+			fmt.Fprintf(wtr, "RateOut:\t%s\n", formatScheduledRate(limits.RateOut))
+			protocols := make([]string, 0, len(limits.Protocols))
+			for name := range limits.Protocols {
+				protocols = append(protocols, name)
+			}
+			sort.Strings(protocols)
+			for _, name := range protocols {
+				l := limits.Protocols[name]
+				fmt.Fprintf(wtr, "RateIn (%s):\t%s\n", name, formatScheduledRate(l.RateIn))
+				fmt.Fprintf(wtr, "RateOut (%s):\t%s\n", name, formatScheduledRate(l.RateOut))
+			}
+
+			fmt.Fprintf(wtr, "ConnMgr.LowWater:\t%d\n", s.Settings.ConnMgrLowWater)
+			fmt.Fprintf(wtr, "ConnMgr.HighWater:\t%d\n", s.Settings.ConnMgrHighWater)
+			fmt.Fprintf(wtr, "Reprovide:\t%s\n", formatEnabled(s.Settings.Reprovide, "enabled", "paused"))
+			fmt.Fprintf(wtr, "BitswapServer:\t%s\n", formatEnabled(s.Settings.BitswapServer, "enabled", "disabled"))
+			return nil
+		}),
+	},
+	Type: schedule.Status{},
+}
+
+func formatScheduledRate(rate *config.OptionalString) string {
+	return rate.WithDefault("unlimited")
+}
+
+func formatEnabled(enabled bool, yes, no string) string {
+	if enabled {
+		return yes
+	}
+	return no
+}
@@ -54,14 +54,22 @@ ipfs peers in the internet.
 `,
 	},
 	Subcommands: map[string]*cmds.Command{
-		"addrs":      swarmAddrsCmd,
-		"connect":    swarmConnectCmd,
-		"disconnect": swarmDisconnectCmd,
-		"filters":    swarmFiltersCmd,
-		"peers":      swarmPeersCmd,
-		"peering":    swarmPeeringCmd,
-		"resources":  swarmResourcesCmd, // libp2p Network Resource Manager
-
+		"addrs":        swarmAddrsCmd,
+		"ban":          swarmBanCmd,
+		"bans":         swarmBansCmd,
+		"connect":      swarmConnectCmd,
+		"disconnect":   swarmDisconnectCmd,
+		"filters":      swarmFiltersCmd,
+		"history":      swarmHistoryCmd,
+		"peers":        swarmPeersCmd,
+		"peering":      swarmPeeringCmd,
+		"probe":        swarmProbeCmd,
+		"protect":      swarmProtectCmd,
+		"reachability": swarmReachabilityCmd,
+		"relay":        swarmRelayCmd,
+		"resources":    swarmResourcesCmd, // libp2p Network Resource Manager
+		"unban":        swarmUnbanCmd,
+		"unprotect":    swarmUnprotectCmd,
 	},
 }
 
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const swarmBanTTLOptionName = "ttl"
+
+type banList struct {
+	Bans []libp2p.PeerBan
+}
+
+var swarmBanCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Ban peers or addresses.",
+		ShortDescription: `
+'ipfs swarm ban' refuses connections to and from a peer ID or a multiaddr,
+and closes the open connections to them. A multiaddr bans every address
+starting with it, e.g. /ip4/1.2.3.4 bans every port of that IP address.
+
+Bans are stored in the repository and survive restarts. They last until
+removed with 'ipfs swarm unban', or until they expire when --ttl is set.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("target", true, true, "Peer ID or multiaddr to ban.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(swarmBanTTLOptionName, "How long the ban lasts, e.g. '1h'. Bans are permanent by default."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+
+		var ttl time.Duration
+		if s, ok := req.Options[swarmBanTTLOptionName].(string); ok {
+			ttl, err = time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("invalid ttl: %w", err)
+			}
+			if ttl <= 0 {
+				return errors.New("ttl must be positive")
+			}
+		}
+
+		var out banList
+		for _, target := range req.Arguments {
+			if p, err := peer.Decode(target); err == nil && p == n.Identity {
+				return errors.New("cannot ban self")
+			}
+			b, err := n.Bans.Ban(req.Context, target, ttl)
+			if err != nil {
+				return err
+			}
+			out.Bans = append(out.Bans, b)
+		}
+		n.Bans.CloseBanned(n.PeerHost.Network())
+
+		return cmds.EmitOnce(res, &out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *banList) error {
+			for _, b := range out.Bans {
+				if b.Expires == nil {
+					fmt.Fprintf(w, "banned %s\n", b.Target)
+				} else {
+					fmt.Fprintf(w, "banned %s until %s\n", b.Target, b.Expires.Local().Format(time.RFC3339))
+				}
+			}
+			return nil
+		}),
+	},
+	Type: banList{},
+}
+
+var swarmUnbanCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Lift the ban of peers or addresses.",
+		ShortDescription: `
+'ipfs swarm unban' lifts bans set with 'ipfs swarm ban'. The target must be
+given as it was banned.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("target", true, true, "Banned peer ID or multiaddr.").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+
+		var removed []string
+		for _, target := range req.Arguments {
+			ok, err := n.Bans.Unban(req.Context, target)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%s is not banned", target)
+			}
+			removed = append(removed, target)
+		}
+		return cmds.EmitOnce(res, &stringList{removed})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(safeTextListEncoder),
+	},
+	Type: stringList{},
+}
+
+var swarmBansCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List banned peers and addresses.",
+		ShortDescription: `
+'ipfs swarm bans' lists the bans in effect, set with 'ipfs swarm ban', with
+their expiry.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+
+		return cmds.EmitOnce(res, &banList{Bans: n.Bans.List()})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *banList) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer tw.Flush()
+			for _, b := range out.Bans {
+				expires := "never"
+				if b.Expires != nil {
+					expires = b.Expires.Local().Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%s\t%s\n", b.Target, expires)
+			}
+			return nil
+		}),
+	},
+	Type: banList{},
+}
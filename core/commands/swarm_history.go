@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	swarmHistoryPeerOptionName      = "peer"
+	swarmHistorySinceOptionName     = "since"
+	swarmHistoryDirectionOptionName = "direction"
+	swarmHistoryAgentOptionName     = "agent"
+	swarmHistoryLimitOptionName     = "limit"
+	swarmHistoryStatsOptionName     = "stats"
+)
+
+type connHistoryOutput struct {
+	Connections []libp2p.ConnRecord    `json:",omitempty"`
+	Peers       []libp2p.PeerConnStats `json:",omitempty"`
+}
+
+var swarmHistoryCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the closed connections of the node.",
+		ShortDescription: `
+'ipfs swarm history' lists the connections recorded in the connection
+history, most recent first, with the peer, address, direction, duration,
+bytes exchanged, reason of the disconnection and agent version of the peer.
+
+With --stats, the connections are aggregated per peer instead, sorted by the
+total time spent connected to the peer.
+
+The bytes are those exchanged with the peer while the connection was open.
+With several connections to the peer at once, they are counted in the
+connection that closes first, and then in the next ones, so that the bytes
+are never counted twice.
+
+The history is only recorded when Swarm.ConnectionHistory.Enabled is true.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(swarmHistoryPeerOptionName, "Only list the connections to this peer."),
+		cmds.StringOption(swarmHistorySinceOptionName, "Only list the connections closed in this duration, e.g. \"24h\"."),
+		cmds.StringOption(swarmHistoryDirectionOptionName, "Only list the inbound or outbound connections."),
+		cmds.StringOption(swarmHistoryAgentOptionName, "Only list the connections to peers whose agent version contains this string."),
+		cmds.IntOption(swarmHistoryLimitOptionName, "Maximum number of connections or peers to list, 0 for all.").WithDefault(100),
+		cmds.BoolOption(swarmHistoryStatsOptionName, "Aggregate the connections per peer."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+		if n.ConnHistory == nil {
+			return errors.New("the connection history is disabled, see Swarm.ConnectionHistory.Enabled")
+		}
+
+		var filter libp2p.ConnHistoryFilter
+		if s, _ := req.Options[swarmHistoryPeerOptionName].(string); s != "" {
+			filter.Peer, err = peer.Decode(s)
+			if err != nil {
+				return fmt.Errorf("invalid peer ID %q: %w", s, err)
+			}
+		}
+		if s, _ := req.Options[swarmHistorySinceOptionName].(string); s != "" {
+			since, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("invalid duration: %w", err)
+			}
+			filter.Since = time.Now().Add(-since)
+		}
+		filter.Direction, _ = req.Options[swarmHistoryDirectionOptionName].(string)
+		switch filter.Direction {
+		case "", "inbound", "outbound":
+		default:
+			return fmt.Errorf("invalid direction %q, must be inbound or outbound", filter.Direction)
+		}
+		filter.Agent, _ = req.Options[swarmHistoryAgentOptionName].(string)
+		limit, _ := req.Options[swarmHistoryLimitOptionName].(int)
+		if limit < 0 {
+			return errors.New("limit must not be negative")
+		}
+
+		var out connHistoryOutput
+		if stats, _ := req.Options[swarmHistoryStatsOptionName].(bool); stats {
+			out.Peers = libp2p.AggregateConnRecords(n.ConnHistory.Query(filter))
+			if limit > 0 && len(out.Peers) > limit {
+				out.Peers = out.Peers[:limit]
+			}
+		} else {
+			filter.Limit = limit
+			out.Connections = n.ConnHistory.Query(filter)
+		}
+		return cmds.EmitOnce(res, &out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *connHistoryOutput) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer tw.Flush()
+
+			if stats, _ := req.Options[swarmHistoryStatsOptionName].(bool); stats {
+				fmt.Fprintln(tw, "Peer\tConnections\tDuration\tIn\tOut\tLast closed\tAgent")
+				for _, s := range out.Peers {
+					fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.Peer, s.Connections, s.Duration.Round(time.Second),
+						humanize.Bytes(uint64(s.BytesIn)), humanize.Bytes(uint64(s.BytesOut)),
+						s.LastClosed.Format(time.RFC3339), s.AgentVersion)
+				}
+				return nil
+			}
+
+			fmt.Fprintln(tw, "Closed\tPeer\tDirection\tDuration\tIn\tOut\tReason\tAgent\tAddress")
+			for _, r := range out.Connections {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Closed.Format(time.RFC3339), r.Peer, r.Direction,
+					r.Duration().Round(time.Second), humanize.Bytes(uint64(r.BytesIn)), humanize.Bytes(uint64(r.BytesOut)),
+					r.Reason, r.AgentVersion, r.Addr)
+			}
+			return nil
+		}),
+	},
+	Type: connHistoryOutput{},
+}
@@ -0,0 +1,233 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+const (
+	swarmProbeTimeoutOptionName = "dial-timeout"
+	defaultSwarmProbeTimeout    = 10 * time.Second
+)
+
+type probeResult struct {
+	Addr      string
+	Transport string
+	Success   bool
+	Error     string `json:",omitempty"`
+	Latency   time.Duration
+	Security  string `json:",omitempty"`
+	Muxer     string `json:",omitempty"`
+}
+
+type probeOutput struct {
+	Peer    string
+	Results []probeResult
+}
+
+var swarmProbeCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Test every address of a peer separately.",
+		ShortDescription: `
+'ipfs swarm probe' dials each known address of a peer on its own, with a new
+connection that is closed right away, even when the node is already
+connected to the peer. It reports which addresses work and why the others
+fail, the time taken to establish the connection, including the handshake,
+and the negotiated security protocol and stream muxer.
+
+The addresses are looked up in the peer store, and in the routing system
+when there are none. When the argument is a multiaddr, only this address is
+probed. Peers and addresses refused by Swarm.AddrFilters, the ban list or
+Swarm.PeerAllowList are not dialed.
+
+  > ipfs swarm probe 12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peer", true, false, "ID or multiaddr of the peer to probe."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(swarmProbeTimeoutOptionName, "Timeout of each dial.").WithDefault(defaultSwarmProbeTimeout.String()),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+
+		timeoutStr, _ := req.Options[swarmProbeTimeoutOptionName].(string)
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			return fmt.Errorf("invalid dial timeout: %w", err)
+		}
+
+		addr, pid, err := ParsePeerParam(req.Arguments[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse peer address '%s': %s", req.Arguments[0], err)
+		}
+		if pid == n.Identity {
+			return errors.New("cannot probe self")
+		}
+
+		var addrs []ma.Multiaddr
+		if addr != nil {
+			addrs = []ma.Multiaddr{addr}
+		} else {
+			addrs = n.Peerstore.Addrs(pid)
+		}
+		if len(addrs) == 0 {
+			ctx, cancel := context.WithTimeout(req.Context, kPingTimeout)
+			pi, err := n.Routing.FindPeer(ctx, pid)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("peer lookup failed: %s", err)
+			}
+			addrs = pi.Addrs
+		}
+
+		results := probeAddrs(req.Context, n, pid, addrs, timeout)
+		return cmds.EmitOnce(res, &probeOutput{Peer: pid.String(), Results: results})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *probeOutput) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer tw.Flush()
+			for _, r := range out.Results {
+				if r.Success {
+					fmt.Fprintf(tw, "%s\t%s\tsuccess\t%s\t%s\t%s\n", r.Addr, r.Transport, r.Latency.Round(time.Millisecond), r.Security, r.Muxer)
+				} else {
+					fmt.Fprintf(tw, "%s\t%s\tfailure\t%s\t%s\n", r.Addr, r.Transport, r.Latency.Round(time.Millisecond), r.Error)
+				}
+			}
+			return nil
+		}),
+	},
+	Type: probeOutput{},
+}
+
+type dialer interface {
+	TransportForDialing(ma.Multiaddr) transport.Transport
+}
+
+// errProbeGated is the error of the probes of the peers and addresses the
+// connection gater does not allow to dial.
+const errProbeGated = "blocked by connection gater"
+
+// probeAddrs dials the addresses in parallel, bypassing the swarm so that
+// existing connections are not reused and new ones are not kept. The
+// connection gater of the swarm is still applied.
+func probeAddrs(ctx context.Context, n *core.IpfsNode, p peer.ID, addrs []ma.Multiaddr, timeout time.Duration) []probeResult {
+	results := []probeResult{}
+	if n.ConnectionGater != nil && !n.ConnectionGater.InterceptPeerDial(p) {
+		for _, addr := range addrs {
+			results = append(results, probeResult{
+				Addr:      addr.String(),
+				Transport: libp2p.AddrTransport(addr),
+				Error:     errProbeGated,
+			})
+		}
+		return results
+	}
+
+	var resolved []ma.Multiaddr
+	for _, addr := range addrs {
+		raddrs, err := n.DNSResolver.Resolve(ctx, addr)
+		if err != nil {
+			results = append(results, probeResult{
+				Addr:      addr.String(),
+				Transport: libp2p.AddrTransport(addr),
+				Error:     fmt.Sprintf("failed to resolve: %s", err),
+			})
+			continue
+		}
+		resolved = append(resolved, raddrs...)
+	}
+
+	d, _ := n.PeerHost.Network().(dialer)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range resolved {
+		wg.Add(1)
+		go func(addr ma.Multiaddr) {
+			defer wg.Done()
+			r := probeAddr(ctx, d, n.ConnectionGater, p, addr, timeout)
+			mu.Lock()
+			results = append(results, r)
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Addr < results[j].Addr
+	})
+	return results
+}
+
+func probeAddr(ctx context.Context, d dialer, gater connmgr.ConnectionGater, p peer.ID, addr ma.Multiaddr, timeout time.Duration) probeResult {
+	r := probeResult{
+		Addr:      addr.String(),
+		Transport: libp2p.AddrTransport(addr),
+	}
+	if gater != nil && !gater.InterceptAddrDial(p, addr) {
+		r.Error = errProbeGated
+		return r
+	}
+
+	var tpt transport.Transport
+	if d != nil {
+		tpt = d.TransportForDialing(addr)
+	}
+	if tpt == nil {
+		r.Error = "no transport for this address"
+		return r
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	c, err := tpt.Dial(ctx, addr, p)
+	r.Latency = time.Since(start)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	defer c.Close()
+
+	state := c.ConnState()
+	r.Success = true
+	r.Security = string(state.Security)
+	r.Muxer = string(state.StreamMultiplexer)
+	if b, ok := builtInSecurity[state.Transport]; ok {
+		r.Security, r.Muxer = b.security, b.muxer
+	}
+	return r
+}
+
+// builtInSecurity is the security and the muxer of the transports that have
+// them built in, and do not report them in their connection state.
+var builtInSecurity = map[string]struct{ security, muxer string }{
+	"quic":          {"tls/1.3 (built-in)", "quic (built-in)"},
+	"quic-v1":       {"tls/1.3 (built-in)", "quic (built-in)"},
+	"webtransport":  {"tls/1.3 (built-in)", "quic (built-in)"},
+	"webrtc-direct": {"dtls (built-in)", "sctp (built-in)"},
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	swarmProtectTagOptionName    = "tag"
+	swarmProtectWeightOptionName = "weight"
+)
+
+var errNoPeerProtector = errors.New("peer protection requires the basic connection manager, see Swarm.ConnMgr.Type")
+
+var swarmProtectCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Protect connections to peers from trimming.",
+		ShortDescription: `
+'ipfs swarm protect' keeps the connections to peers when the connection
+manager trims connections above Swarm.ConnMgr.HighWater. Protections are
+identified by a tag: a peer stays protected until every tag is removed with
+'ipfs swarm unprotect'.
+
+With --weight, the peers are not protected, but their connections are
+trimmed after those of peers with a lower total weight.
+
+Protections set with this command are lost when the daemon restarts. Use
+Swarm.ConnMgr.ProtectedPeers for permanent protections.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peer", true, true, "ID of the peer to protect.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(swarmProtectTagOptionName, "Tag of the protection.").WithDefault(libp2p.UserProtectionTag),
+		cmds.IntOption(swarmProtectWeightOptionName, "Weigh the peers instead of protecting them."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		peers, err := protectArgs(n, req.Arguments)
+		if err != nil {
+			return err
+		}
+
+		tag, _ := req.Options[swarmProtectTagOptionName].(string)
+		weight, weighed := req.Options[swarmProtectWeightOptionName].(int)
+
+		output := make([]string, 0, len(peers))
+		for _, p := range peers {
+			if weighed {
+				n.PeerProtector.SetWeight(p, tag, weight)
+				output = append(output, fmt.Sprintf("weigh %s at %d with tag %q", p, weight, tag))
+			} else {
+				n.PeerProtector.Protect(p, tag)
+				output = append(output, fmt.Sprintf("protect %s with tag %q", p, tag))
+			}
+		}
+		return cmds.EmitOnce(res, &stringList{output})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(safeTextListEncoder),
+	},
+	Type: stringList{},
+}
+
+var swarmUnprotectCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove the protection of peers.",
+		ShortDescription: `
+'ipfs swarm unprotect' removes the protection or the weight set with a tag by
+'ipfs swarm protect' or Swarm.ConnMgr.ProtectedPeers. Peers protected by
+other tags, for example by peering, stay protected.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("peer", true, true, "ID of the peer to unprotect.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(swarmProtectTagOptionName, "Tag of the protection.").WithDefault(libp2p.UserProtectionTag),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		peers, err := protectArgs(n, req.Arguments)
+		if err != nil {
+			return err
+		}
+
+		tag, _ := req.Options[swarmProtectTagOptionName].(string)
+
+		output := make([]string, 0, len(peers))
+		for _, p := range peers {
+			msg := fmt.Sprintf("unprotect %s with tag %q", p, tag)
+			n.PeerProtector.RemoveWeight(p, tag)
+			if n.PeerProtector.Unprotect(p, tag) {
+				msg += ": still protected by other tags"
+			}
+			output = append(output, msg)
+		}
+		return cmds.EmitOnce(res, &stringList{output})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(safeTextListEncoder),
+	},
+	Type: stringList{},
+}
+
+func protectArgs(n *core.IpfsNode, args []string) ([]peer.ID, error) {
+	if !n.IsOnline {
+		return nil, ErrNotOnline
+	}
+	if n.PeerProtector == nil {
+		return nil, errNoPeerProtector
+	}
+
+	peers := make([]peer.ID, 0, len(args))
+	for _, arg := range args {
+		p, err := peer.Decode(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %q: %w", arg, err)
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
+)
+
+var swarmReachabilityCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Diagnose whether other peers can connect to this node.",
+		ShortDescription: `
+'ipfs swarm reachability' reports what makes this node reachable, or not:
+
+  - the AutoNAT verdict for the node
+  - for each address family and transport the node listens on, the public
+    addresses it announces and the NAT device type
+  - the UPnP / NAT-PMP port mappings
+  - the reservations on relays
+  - the recent DCUtR hole punches
+  - the addresses of this node observed by peers with identify
+
+AutoNAT tests the node as a whole, it has no verdict per address family or
+transport. A transport with public addresses is not necessarily reachable
+over them.
+
+This interface is not stable and may change from release to release.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+		return cmds.EmitOnce(res, n.Reachability.Status())
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, s *libp2p.ReachabilityStatus) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer tw.Flush()
+
+			fmt.Fprintf(tw, "AutoNAT (node-wide):\t%s\n", s.Reachability)
+
+			fmt.Fprintln(tw, "\nTransports:")
+			for _, t := range s.Transports {
+				addrs := strings.Join(t.PublicAddrs, " ")
+				if addrs == "" {
+					addrs = "no public address"
+				}
+				fmt.Fprintf(tw, "  %s\t%s\tNAT: %s\t%s\n", t.Family, t.Transport, t.NATDeviceType, addrs)
+			}
+
+			fmt.Fprint(tw, "\nPort mapping:\t")
+			switch {
+			case !s.PortMapping.Enabled:
+				fmt.Fprintln(tw, "disabled")
+			case !s.PortMapping.NATDiscovered:
+				fmt.Fprintln(tw, "no UPnP or NAT-PMP device found")
+			default:
+				fmt.Fprintf(tw, "%d mappings\n", len(s.PortMapping.Mappings))
+			}
+			for _, m := range s.PortMapping.Mappings {
+				fmt.Fprintf(tw, "  %s\t-> %s\n", m.Internal, m.External)
+			}
+
+			fmt.Fprintf(tw, "\nRelay reservations:\t%d\n", len(s.Relays))
+			for _, r := range s.Relays {
+				fmt.Fprintf(tw, "  %s\t%s\n", r.Relay, strings.Join(r.Addrs, " "))
+			}
+
+			fmt.Fprintf(tw, "\nHole punches:\t%d\n", len(s.HolePunches))
+			for _, hp := range s.HolePunches {
+				outcome := "success"
+				if !hp.Success {
+					outcome = "failure: " + hp.Error
+				}
+				fmt.Fprintf(tw, "  %s\t%s\t%d attempts\t%s\t%s\n", hp.Time.Format(time.RFC3339), hp.Peer, hp.Attempts, hp.Duration.Round(time.Millisecond), outcome)
+			}
+
+			fmt.Fprintf(tw, "\nObserved addresses:\t%d\n", len(s.ObservedAddrs))
+			for _, addr := range s.ObservedAddrs {
+				fmt.Fprintf(tw, "  %s\n", addr)
+			}
+			return nil
+		}),
+	},
+	Type: libp2p.ReachabilityStatus{},
+}
@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/kubo/core/commands/cmdenv"
+	"github.com/ipfs/kubo/core/node/libp2p"
+)
+
+var swarmRelayCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the relay service of the node.",
+		ShortDescription: `
+'ipfs swarm relay' is a set of commands to inspect the circuit relay v2
+service, enabled with Swarm.RelayService.Enabled.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"stats": swarmRelayStatsCmd,
+	},
+}
+
+var swarmRelayStatsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the reservations on the relay service of the node.",
+		ShortDescription: `
+'ipfs swarm relay stats' lists the peers with a reservation on the relay
+service of the node, with the time the reservation was made and when it
+expires, how many times it was renewed, the circuits relayed to the peer and
+the bytes relayed. It also reports how many reservations were refused by
+Swarm.RelayService.ReservationAllowList.
+
+The reservations are only accounted for with Swarm.RelayService.Accounting
+enabled. The relayed bytes are only counted when the bandwidth metrics are enabled,
+see Swarm.DisableBandwidthMetrics.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		if !n.IsOnline {
+			return ErrNotOnline
+		}
+		if n.RelayStats == nil || !n.RelayStats.Accounting() {
+			return errors.New("the accounting of the relay service is disabled, see Swarm.RelayService.Enabled and Swarm.RelayService.Accounting")
+		}
+
+		status := n.RelayStats.Status(n.PeerHost)
+		return cmds.EmitOnce(res, &status)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *libp2p.RelayServiceStatus) error {
+			tw := tabwriter.NewWriter(w, 1, 2, 1, ' ', 0)
+			defer tw.Flush()
+
+			fmt.Fprintf(tw, "Reservations: %d\n", len(out.Reservations))
+			fmt.Fprintf(tw, "Denied: %d\n", out.Denied)
+			if len(out.Reservations) == 0 {
+				return nil
+			}
+			fmt.Fprintln(tw)
+			fmt.Fprintln(tw, "Peer\tSince\tExpires\tRenewals\tCircuits\tActive\tDuration\tIn\tOut")
+			for _, r := range out.Reservations {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", r.Peer,
+					r.Since.Format(time.RFC3339), r.Expires.Format(time.RFC3339), r.Renewals,
+					r.Circuits, r.ActiveCircuits, r.CircuitsDuration.Round(time.Second),
+					humanize.Bytes(uint64(r.BytesIn)), humanize.Bytes(uint64(r.BytesOut)))
+			}
+			return nil
+		}),
+	},
+	Type: libp2p.RelayServiceStatus{},
+}
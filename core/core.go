@@ -53,8 +53,10 @@ import (
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/node"
 	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/core/node/schedule"
 	"github.com/ipfs/kubo/fuse/mount"
 	"github.com/ipfs/kubo/p2p"
+	"github.com/ipfs/kubo/pinsync"
 	"github.com/ipfs/kubo/repo"
 	irouting "github.com/ipfs/kubo/routing"
 )
@@ -95,6 +97,8 @@ type IpfsNode struct {
 	PeerHost                  p2phost.Host               `optional:"true"` // the network host (server+client)
 	Peering                   *peering.PeeringService    `optional:"true"`
 	Filters                   *ma.Filters                `optional:"true"`
+	Bans                      *libp2p.BanList            `optional:"true"`
+	ConnectionGater           connmgr.ConnectionGater    `optional:"true"` // the connection gater of the swarm
 	Bootstrapper              io.Closer                  `optional:"true"` // the periodic bootstrapper
 	Routing                   irouting.ProvideManyRouter `optional:"true"` // the routing system. recommend ipfs-dht
 	DNSResolver               *madns.Resolver            // the DNS resolver
@@ -107,6 +111,8 @@ type IpfsNode struct {
 	Provider                  provider.System            // the value provider system
 	IpnsRepub                 *ipnsrp.Republisher        `optional:"true"`
 	ResourceManager           network.ResourceManager    `optional:"true"`
+	BandwidthThrottler        *libp2p.BandwidthThrottler `optional:"true"`
+	PeerProtector             *libp2p.PeerProtector      `optional:"true"`
 
 	PubSub   *pubsub.PubSub             `optional:"true"`
 	PSRouter *psrouter.PubsubValueStore `optional:"true"`
@@ -116,6 +122,19 @@ type IpfsNode struct {
 
 	P2P *p2p.P2P `optional:"true"`
 
+	Reachability *libp2p.ReachabilityMonitor `optional:"true"`
+	ConnHistory  *libp2p.ConnHistory         `optional:"true"`
+	RelayStats   *libp2p.RelayServiceStats   `optional:"true"`
+
+	PinSync *pinsync.Service `optional:"true"`
+
+	BitswapTracer *node.BitswapTracer `optional:"true"`
+	BitswapLedger *node.BitswapLedger `optional:"true"`
+
+	Scheduler *schedule.Scheduler `optional:"true"`
+
+	ProvideTracker *irouting.ProvideTracker `optional:"true"`
+
 	Process goprocess.Process
 	ctx     context.Context
 
@@ -0,0 +1,294 @@
+package corerepo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	bserv "github.com/ipfs/boxo/blockservice"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	"github.com/ipfs/kubo/core"
+)
+
+// pinSizesKey is where the last computed pin sizes are stored in the repo
+// datastore.
+var pinSizesKey = datastore.NewKey("/local/pinsize")
+
+// PinSize is the disk usage of a pin.
+type PinSize struct {
+	// Size is the cumulative size of the deduplicated blocks of the pin.
+	Size   uint64
+	Blocks uint64
+	// UniqueSize is the size of the blocks that are not referenced by any
+	// other pin, and would be freed by a garbage collection if the pin was
+	// removed.
+	UniqueSize   uint64
+	UniqueBlocks uint64
+	// Missing is the number of blocks of the pin not found locally.
+	Missing uint64 `json:",omitempty"`
+}
+
+// pinSizes is the cached result of a pin size accounting. It is valid as long
+// as the pinset matches its fingerprint.
+type pinSizes struct {
+	Fingerprint []byte
+	Pins        map[string]PinSize
+}
+
+// pinRoots are the roots kept by a garbage collection: recursive pins, whose
+// whole DAG is kept, direct pins and the MFS root.
+type pinRoots struct {
+	recursive []cid.Cid
+	direct    []cid.Cid
+	mfs       []cid.Cid
+}
+
+func listPinRoots(ctx context.Context, n *core.IpfsNode) (*pinRoots, error) {
+	var roots pinRoots
+	for p := range n.Pinning.RecursiveKeys(ctx, false) {
+		if p.Err != nil {
+			return nil, p.Err
+		}
+		roots.recursive = append(roots.recursive, p.Pin.Key)
+	}
+	for p := range n.Pinning.DirectKeys(ctx, false) {
+		if p.Err != nil {
+			return nil, p.Err
+		}
+		roots.direct = append(roots.direct, p.Pin.Key)
+	}
+	if n.FilesRoot != nil {
+		mfsRoots, err := BestEffortRoots(n.FilesRoot)
+		if err != nil {
+			return nil, err
+		}
+		roots.mfs = mfsRoots
+	}
+	return &roots, nil
+}
+
+// fingerprint identifies the pinset, so cached sizes can be invalidated when
+// it changes.
+func (r *pinRoots) fingerprint() []byte {
+	h := sha256.New()
+	for _, set := range []struct {
+		prefix string
+		keys   []cid.Cid
+	}{{"r", r.recursive}, {"d", r.direct}, {"m", r.mfs}} {
+		keys := make([]string, len(set.keys))
+		for i, c := range set.keys {
+			keys[i] = c.KeyString()
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte(set.prefix))
+			h.Write([]byte(k))
+		}
+	}
+	return h.Sum(nil)
+}
+
+// PinSizes returns the disk usage of every recursive and direct pin, keyed by
+// CID. Blocks shared by several pins, or with the MFS root, are counted in the
+// size of each of them but in the unique size of none.
+//
+// The result is cached in the repo datastore until the pinset changes.
+func PinSizes(ctx context.Context, n *core.IpfsNode) (map[cid.Cid]PinSize, error) {
+	roots, err := listPinRoots(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	fp := roots.fingerprint()
+
+	cached, err := loadPinSizes(ctx, n)
+	if err != nil {
+		log.Errorf("loading cached pin sizes: %s", err)
+	}
+	if cached != nil && string(cached.Fingerprint) == string(fp) {
+		sizes := make(map[cid.Cid]PinSize, len(cached.Pins))
+		for k, s := range cached.Pins {
+			c, err := cid.Decode(k)
+			if err != nil {
+				return nil, err
+			}
+			sizes[c] = s
+		}
+		return sizes, nil
+	}
+
+	sizes, err := computePinSizes(ctx, n, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	result := pinSizes{Fingerprint: fp, Pins: make(map[string]PinSize, len(sizes))}
+	for c, s := range sizes {
+		if s.Missing > 0 {
+			// the sizes will change once the missing blocks are fetched
+			return sizes, nil
+		}
+		result.Pins[c.String()] = s
+	}
+	if err := savePinSizes(ctx, n, &result); err != nil {
+		log.Errorf("saving pin sizes: %s", err)
+	}
+	return sizes, nil
+}
+
+// PinSizeOf returns the disk usage of a single recursive or direct pin.
+func PinSizeOf(ctx context.Context, n *core.IpfsNode, c cid.Cid) (PinSize, error) {
+	sizes, err := PinSizes(ctx, n)
+	if err != nil {
+		return PinSize{}, err
+	}
+	size, ok := sizes[c]
+	if !ok {
+		return PinSize{}, errors.New("not pinned recursively or directly")
+	}
+	return size, nil
+}
+
+// blockInfo is what the accounting knows about a block.
+type blockInfo struct {
+	size  uint64
+	links []cid.Cid
+	// owner is the index of the only root referencing the block, or
+	// sharedBlock if it is referenced by several roots.
+	owner int
+}
+
+const (
+	unvisitedBlock = -2
+	sharedBlock    = -1
+)
+
+func computePinSizes(ctx context.Context, n *core.IpfsNode, roots *pinRoots) (map[cid.Cid]PinSize, error) {
+	// only blocks present locally are accounted for
+	bs := n.Blockstore
+	dagServ := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+
+	type root struct {
+		cid       cid.Cid
+		recursive bool
+	}
+	var all []root
+	for _, c := range roots.recursive {
+		all = append(all, root{c, true})
+	}
+	for _, c := range roots.direct {
+		all = append(all, root{c, false})
+	}
+	for _, c := range roots.mfs {
+		all = append(all, root{c, true})
+	}
+
+	infos := make(map[cid.Cid]*blockInfo)
+	missing := make([]uint64, len(all))
+
+	// getInfo reads a block the first time it is seen
+	getInfo := func(c cid.Cid) (*blockInfo, error) {
+		if info, ok := infos[c]; ok {
+			return info, nil
+		}
+		var info *blockInfo
+		if c.Prefix().Codec == cid.Raw {
+			size, err := bs.GetSize(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			info = &blockInfo{size: uint64(size), owner: unvisitedBlock}
+		} else {
+			nd, err := dagServ.Get(ctx, c)
+			if err != nil {
+				return nil, err
+			}
+			info = &blockInfo{size: uint64(len(nd.RawData())), owner: unvisitedBlock}
+			for _, l := range nd.Links() {
+				info.links = append(info.links, l.Cid)
+			}
+		}
+		infos[c] = info
+		return info, nil
+	}
+
+	blocks := make([][]cid.Cid, len(all))
+	for i, r := range all {
+		visited := cid.NewSet()
+		stack := []cid.Cid{r.cid}
+		for len(stack) > 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !visited.Visit(c) {
+				continue
+			}
+			info, err := getInfo(c)
+			if ipld.IsNotFound(err) {
+				missing[i]++
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch info.owner {
+			case unvisitedBlock:
+				info.owner = i
+			case i:
+			default:
+				info.owner = sharedBlock
+			}
+			blocks[i] = append(blocks[i], c)
+			if r.recursive {
+				stack = append(stack, info.links...)
+			}
+		}
+	}
+
+	sizes := make(map[cid.Cid]PinSize, len(roots.recursive)+len(roots.direct))
+	for i, r := range all[:len(roots.recursive)+len(roots.direct)] {
+		s := PinSize{Missing: missing[i]}
+		for _, c := range blocks[i] {
+			info := infos[c]
+			s.Size += info.size
+			s.Blocks++
+			if info.owner == i {
+				s.UniqueSize += info.size
+				s.UniqueBlocks++
+			}
+		}
+		sizes[r.cid] = s
+	}
+	return sizes, nil
+}
+
+func loadPinSizes(ctx context.Context, n *core.IpfsNode) (*pinSizes, error) {
+	b, err := n.Repo.Datastore().Get(ctx, pinSizesKey)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sizes pinSizes
+	if err := json.Unmarshal(b, &sizes); err != nil {
+		return nil, err
+	}
+	return &sizes, nil
+}
+
+func savePinSizes(ctx context.Context, n *core.IpfsNode, sizes *pinSizes) error {
+	b, err := json.Marshal(sizes)
+	if err != nil {
+		return err
+	}
+	return n.Repo.Datastore().Put(ctx, pinSizesKey, b)
+}
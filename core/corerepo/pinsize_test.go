@@ -0,0 +1,83 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	coremock "github.com/ipfs/kubo/core/mock"
+)
+
+func TestPinSizes(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	shared := merkledag.NewRawNode([]byte("shared"))
+	own := merkledag.NewRawNode([]byte("own"))
+	a := new(merkledag.ProtoNode)
+	if err := a.AddNodeLink("shared", shared); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddNodeLink("own", own); err != nil {
+		t.Fatal(err)
+	}
+	// the same block linked twice is only counted once
+	if err := a.AddNodeLink("own-again", own); err != nil {
+		t.Fatal(err)
+	}
+	b := new(merkledag.ProtoNode)
+	if err := b.AddNodeLink("shared", shared); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.DAG.AddMany(ctx, []ipld.Node{shared, own, a, b}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, a, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, b, true, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := PinSizeOf(ctx, n, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aSize := uint64(len(a.RawData()))
+	expected := PinSize{
+		Size:         aSize + uint64(len(shared.RawData())+len(own.RawData())),
+		Blocks:       3,
+		UniqueSize:   aSize + uint64(len(own.RawData())),
+		UniqueBlocks: 2,
+	}
+	if size != expected {
+		t.Fatalf("expected %+v, got %+v", expected, size)
+	}
+
+	cached, err := loadPinSizes(ctx, n)
+	if err != nil || cached == nil {
+		t.Fatalf("expected sizes to be cached, got %v", err)
+	}
+
+	// unpinning b makes the shared block unique to a
+	if err := n.Pinning.Unpin(ctx, b.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	size, err = PinSizeOf(ctx, n, a.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.UniqueSize != size.Size || size.UniqueBlocks != 3 {
+		t.Fatalf("expected every block to be unique after unpinning, got %+v", size)
+	}
+
+	if _, err := PinSizeOf(ctx, n, b.Cid()); err == nil {
+		t.Fatal("expected error for unpinned CID")
+	}
+}
@@ -0,0 +1,342 @@
+package corerepo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	bserv "github.com/ipfs/boxo/blockservice"
+	blockstore "github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	dag "github.com/ipfs/boxo/ipld/merkledag"
+	verifcid "github.com/ipfs/boxo/verifcid"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core"
+)
+
+// pinRepairTimeout limits the time spent fetching the blocks of a single
+// broken pin during background verification.
+const pinRepairTimeout = 10 * time.Minute
+
+// pinVerifyReportKey is where the report of the last background pin
+// verification is stored in the repo datastore.
+var pinVerifyReportKey = datastore.NewKey("/local/pinverify/report")
+
+var (
+	pinVerifyCheckedMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipfs_pin_verify_checked_total",
+		Help: "Number of recursive pins checked by background pin verification.",
+	})
+	pinVerifyBrokenMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipfs_pin_verify_broken_total",
+		Help: "Number of broken recursive pins found by background pin verification.",
+	})
+	pinVerifyRepairedMetric = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ipfs_pin_verify_repaired_total",
+		Help: "Number of broken recursive pins repaired by background pin verification.",
+	})
+	pinVerifyLastRunMetric = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ipfs_pin_verify_last_run_timestamp_seconds",
+		Help: "Time of the end of the last background pin verification.",
+	})
+)
+
+// BadBlock is a missing or corrupt block found under a pin.
+type BadBlock struct {
+	Cid cid.Cid
+	Err string
+}
+
+// PinVerifyStatus is the result of verifying a single recursive pin.
+type PinVerifyStatus struct {
+	Ok        bool
+	Repaired  bool       `json:",omitempty"`
+	BadBlocks []BadBlock `json:",omitempty"`
+}
+
+// PinVerifyResult is the status of a single recursive pin in a report.
+type PinVerifyResult struct {
+	Cid cid.Cid
+	PinVerifyStatus
+}
+
+// PinVerifyReport summarizes a background pin verification run.
+type PinVerifyReport struct {
+	Started  time.Time
+	Finished time.Time
+	Checked  int
+	Broken   int
+	Repaired int
+	// Pins lists the broken pins.
+	Pins []PinVerifyResult `json:",omitempty"`
+}
+
+// verifyingBlockstore returns an error for blocks whose data does not match
+// their CID.
+type verifyingBlockstore struct {
+	blockstore.Blockstore
+}
+
+func (bs verifyingBlockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	blk, err := bs.Blockstore.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	chk, err := c.Prefix().Sum(blk.RawData())
+	if err != nil {
+		return nil, err
+	}
+	if !chk.Equals(c) {
+		return nil, blockstore.ErrHashMismatch
+	}
+	return blk, nil
+}
+
+// PinVerifier checks that recursive pins are complete and, optionally, that
+// their blocks match their CIDs, using only the local blockstore.
+type PinVerifier struct {
+	node    *core.IpfsNode
+	bs      blockstore.Blockstore
+	dag     ipld.DAGService
+	rehash  bool
+	visited map[cid.Cid]PinVerifyStatus
+}
+
+// NewPinVerifier creates a pin verifier. When rehash is set, every block is
+// read and hashed again, otherwise raw leaves are only looked up.
+func NewPinVerifier(n *core.IpfsNode, rehash bool) *PinVerifier {
+	bs := n.Blocks.Blockstore()
+	if rehash {
+		bs = verifyingBlockstore{bs}
+	}
+	return &PinVerifier{
+		node:    n,
+		bs:      bs,
+		dag:     dag.NewDAGService(bserv.New(bs, offline.Exchange(bs))),
+		rehash:  rehash,
+		visited: make(map[cid.Cid]PinVerifyStatus),
+	}
+}
+
+// Check verifies the DAG under root. Results are cached, so blocks shared by
+// several pins are only checked once.
+func (v *PinVerifier) Check(ctx context.Context, root cid.Cid) PinVerifyStatus {
+	if status, ok := v.visited[root]; ok {
+		return status
+	}
+
+	if err := verifcid.ValidateCid(verifcid.DefaultAllowlist, root); err != nil {
+		status := PinVerifyStatus{Ok: false, BadBlocks: []BadBlock{{Cid: root, Err: err.Error()}}}
+		v.visited[root] = status
+		return status
+	}
+
+	// raw leaves have no links, they only need to be read to be rehashed
+	if root.Type() == cid.Raw && !v.rehash {
+		status := PinVerifyStatus{Ok: true}
+		if has, err := v.bs.Has(ctx, root); err != nil || !has {
+			if err == nil {
+				err = ipld.ErrNotFound{Cid: root}
+			}
+			status = PinVerifyStatus{Ok: false, BadBlocks: []BadBlock{{Cid: root, Err: err.Error()}}}
+		}
+		v.visited[root] = status
+		return status
+	}
+
+	nd, err := v.dag.Get(ctx, root)
+	if err != nil {
+		status := PinVerifyStatus{Ok: false, BadBlocks: []BadBlock{{Cid: root, Err: err.Error()}}}
+		v.visited[root] = status
+		return status
+	}
+
+	status := PinVerifyStatus{Ok: true}
+	for _, lnk := range nd.Links() {
+		res := v.Check(ctx, lnk.Cid)
+		if !res.Ok {
+			status.Ok = false
+			status.BadBlocks = append(status.BadBlocks, res.BadBlocks...)
+		}
+	}
+
+	v.visited[root] = status
+	return status
+}
+
+// Repair removes the corrupt blocks under a broken pin, fetches every missing
+// block of its DAG from the network, and checks it again.
+func (v *PinVerifier) Repair(ctx context.Context, root cid.Cid, status PinVerifyStatus) (PinVerifyStatus, error) {
+	if !v.node.IsOnline {
+		return status, errors.New("cannot repair pins in offline mode")
+	}
+
+	// bad blocks that are present locally are corrupt, remove them so they
+	// are fetched again
+	for _, bad := range status.BadBlocks {
+		has, err := v.node.Blockstore.Has(ctx, bad.Cid)
+		if err != nil {
+			return status, err
+		}
+		if !has {
+			continue
+		}
+		if err := v.node.Blockstore.DeleteBlock(ctx, bad.Cid); err != nil {
+			return status, fmt.Errorf("removing corrupt block %s: %w", bad.Cid, err)
+		}
+	}
+
+	if err := dag.FetchGraph(ctx, root, v.node.DAG); err != nil {
+		return status, fmt.Errorf("fetching %s: %w", root, err)
+	}
+
+	v.forget(status.BadBlocks)
+	repaired := v.Check(ctx, root)
+	repaired.Repaired = repaired.Ok
+	return repaired, nil
+}
+
+// forget removes the cached results that include the given bad blocks, which
+// are stale once they were fetched again. The results of the healthy
+// subgraphs still hold.
+func (v *PinVerifier) forget(badBlocks []BadBlock) {
+	bad := make(map[cid.Cid]struct{}, len(badBlocks))
+	for _, b := range badBlocks {
+		bad[b.Cid] = struct{}{}
+	}
+	for c, st := range v.visited {
+		for _, b := range st.BadBlocks {
+			if _, ok := bad[b.Cid]; ok {
+				delete(v.visited, c)
+				break
+			}
+		}
+	}
+}
+
+// VerifyPinSample checks up to sampleSize recursive pins picked at random,
+// or all of them if sampleSize is not positive, and optionally repairs the
+// broken ones.
+func VerifyPinSample(ctx context.Context, n *core.IpfsNode, sampleSize int, repair bool) (*PinVerifyReport, error) {
+	report := &PinVerifyReport{Started: time.Now()}
+
+	// reservoir sampling over the recursive pins
+	var sample []cid.Cid
+	seen := 0
+	for p := range n.Pinning.RecursiveKeys(ctx, false) {
+		if p.Err != nil {
+			return nil, p.Err
+		}
+		seen++
+		if sampleSize <= 0 || len(sample) < sampleSize {
+			sample = append(sample, p.Pin.Key)
+		} else if i := rand.Intn(seen); i < sampleSize {
+			sample[i] = p.Pin.Key
+		}
+	}
+
+	v := NewPinVerifier(n, true)
+	for _, c := range sample {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		status := v.Check(ctx, c)
+		report.Checked++
+		if status.Ok {
+			continue
+		}
+		report.Broken++
+		if repair {
+			rctx, cancel := context.WithTimeout(ctx, pinRepairTimeout)
+			repaired, err := v.Repair(rctx, c, status)
+			cancel()
+			if err != nil {
+				log.Errorf("repairing pin %s: %s", c, err)
+			}
+			status = repaired
+			if status.Repaired {
+				report.Repaired++
+			}
+		}
+		report.Pins = append(report.Pins, PinVerifyResult{Cid: c, PinVerifyStatus: status})
+	}
+	report.Finished = time.Now()
+	return report, nil
+}
+
+// LastPinVerifyReport returns the report of the last background pin
+// verification, or nil if none was run yet.
+func LastPinVerifyReport(ctx context.Context, n *core.IpfsNode) (*PinVerifyReport, error) {
+	b, err := n.Repo.Datastore().Get(ctx, pinVerifyReportKey)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report PinVerifyReport
+	if err := json.Unmarshal(b, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func savePinVerifyReport(ctx context.Context, n *core.IpfsNode, report *PinVerifyReport) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return n.Repo.Datastore().Put(ctx, pinVerifyReportKey, b)
+}
+
+// PeriodicPinVerify checks a sample of the recursive pins every
+// Pinning.Verify.Interval, and records the results.
+func PeriodicPinVerify(ctx context.Context, node *core.IpfsNode) error {
+	cfg, err := node.Repo.Config()
+	if err != nil {
+		return err
+	}
+
+	period := cfg.Pinning.Verify.Interval.WithDefault(config.DefaultPinVerifyInterval)
+	if period == 0 {
+		// if duration is 0, it means background verification is disabled.
+		return nil
+	}
+	sampleSize := int(cfg.Pinning.Verify.SampleSize.WithDefault(config.DefaultPinVerifySampleSize))
+	repair := cfg.Pinning.Verify.Repair.WithDefault(config.DefaultPinVerifyRepair)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(period):
+			report, err := VerifyPinSample(ctx, node, sampleSize, repair)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("background pin verification: %s", err)
+				}
+				continue
+			}
+			pinVerifyCheckedMetric.Add(float64(report.Checked))
+			pinVerifyBrokenMetric.Add(float64(report.Broken))
+			pinVerifyRepairedMetric.Add(float64(report.Repaired))
+			pinVerifyLastRunMetric.Set(float64(report.Finished.Unix()))
+			if report.Broken > report.Repaired {
+				log.Warnf("background pin verification found %d broken pins, see 'ipfs pin verify --last-report'", report.Broken-report.Repaired)
+			}
+			if err := savePinVerifyReport(ctx, node, report); err != nil {
+				log.Errorf("saving pin verification report: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package corerepo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+
+	coremock "github.com/ipfs/kubo/core/mock"
+)
+
+func TestVerifyPinSample(t *testing.T) {
+	ctx := context.Background()
+	n, err := coremock.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	root := new(merkledag.ProtoNode)
+	if err := root.AddNodeLink("leaf", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.DAG.AddMany(ctx, []ipld.Node{leaf, root}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, root, true, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := VerifyPinSample(ctx, n, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 || report.Broken != 0 {
+		t.Fatalf("expected one healthy pin, got %+v", report)
+	}
+
+	// overwrite the leaf with data that does not match its CID
+	corrupt, err := blocks.NewBlockWithCid([]byte("corrupt"), leaf.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Blockstore.DeleteBlock(ctx, leaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.BaseBlocks.Put(ctx, corrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks are only rehashed when asked for
+	if status := NewPinVerifier(n, false).Check(ctx, root.Cid()); !status.Ok {
+		t.Fatalf("expected the corrupt leaf to go unnoticed without rehashing, got %+v", status)
+	}
+
+	report, err = VerifyPinSample(ctx, n, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 1 || report.Broken != 1 || len(report.Pins) != 1 {
+		t.Fatalf("expected one broken pin, got %+v", report)
+	}
+	bad := report.Pins[0].BadBlocks
+	if len(bad) != 1 || !bad[0].Cid.Equals(leaf.Cid()) {
+		t.Fatalf("expected corrupt leaf to be reported, got %+v", bad)
+	}
+
+	// the corrupt block is removed, but cannot be fetched again as the node
+	// has no peers
+	tctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	v := NewPinVerifier(n, true)
+	if _, err := v.Repair(tctx, root.Cid(), report.Pins[0].PinVerifyStatus); err == nil {
+		t.Fatal("expected repair to fail without peers")
+	}
+	if has, _ := n.Blockstore.Has(ctx, leaf.Cid()); has {
+		t.Fatal("expected corrupt block to be removed")
+	}
+
+	if err := savePinVerifyReport(ctx, n, report); err != nil {
+		t.Fatal(err)
+	}
+	last, err := LastPinVerifyReport(ctx, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.Broken != 1 || !last.Pins[0].Cid.Equals(root.Cid()) {
+		t.Fatalf("unexpected last report: %+v", last)
+	}
+}
+
+func TestPinVerifierForget(t *testing.T) {
+	c := func(s string) cid.Cid { return merkledag.NewRawNode([]byte(s)).Cid() }
+	healthy, broken, other, leaf, otherLeaf := c("healthy"), c("broken"), c("other"), c("leaf"), c("other leaf")
+
+	v := &PinVerifier{visited: map[cid.Cid]PinVerifyStatus{
+		healthy:   {Ok: true},
+		broken:    {BadBlocks: []BadBlock{{Cid: leaf}}},
+		leaf:      {BadBlocks: []BadBlock{{Cid: leaf}}},
+		other:     {BadBlocks: []BadBlock{{Cid: otherLeaf}}},
+		otherLeaf: {BadBlocks: []BadBlock{{Cid: otherLeaf}}},
+	}}
+	v.forget([]BadBlock{{Cid: leaf}})
+
+	for _, k := range []cid.Cid{healthy, other, otherLeaf} {
+		if _, ok := v.visited[k]; !ok {
+			t.Errorf("expected the result of %s to be kept", k)
+		}
+	}
+	for _, k := range []cid.Cid{broken, leaf} {
+		if _, ok := v.visited[k]; ok {
+			t.Errorf("expected the result of %s to be forgotten", k)
+		}
+	}
+}
@@ -2,15 +2,29 @@ package node
 
 import (
 	"context"
+	"fmt"
+	gopath "path"
+	"sync"
 	"time"
 
 	"github.com/ipfs/boxo/bitswap"
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
 	"github.com/ipfs/boxo/bitswap/network"
+	"github.com/ipfs/boxo/bitswap/tracer"
+	"github.com/ipfs/boxo/blockservice"
 	blockstore "github.com/ipfs/boxo/blockstore"
 	exchange "github.com/ipfs/boxo/exchange"
+	offline "github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/kubo/config"
 	irouting "github.com/ipfs/kubo/routing"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
 	"go.uber.org/fx"
 
 	"github.com/ipfs/kubo/core/node/helpers"
@@ -61,16 +75,49 @@ type onlineExchangeIn struct {
 	Rt          irouting.ProvideManyRouter
 	Bs          blockstore.GCBlockstore
 	BitswapOpts []bitswap.Option `group:"bitswap-options"`
+	// RequestFilters are combined, as bitswap takes a single one.
+	RequestFilters []bitswap.PeerBlockRequestFilter `group:"bitswap-request-filters"`
+	// MessageTracers are combined, as bitswap takes a single one.
+	MessageTracers []tracer.Tracer `group:"bitswap-tracers"`
+	Tracer         *BitswapTracer  `optional:"true"`
+	ServePolicy    *ServePolicy    `optional:"true"`
 }
 
 // OnlineExchange creates new LibP2P backed block exchange (BitSwap).
 // Additional options to bitswap.New can be provided via the "bitswap-options"
-// group.
+// group, filters of the blocks served to peers via the
+// "bitswap-request-filters" group, and tracers of the messages via the
+// "bitswap-tracers" group.
 func OnlineExchange() interface{} {
 	return func(in onlineExchangeIn, lc fx.Lifecycle) exchange.Interface {
-		bitswapNetwork := network.NewFromIpfsHost(in.Host, in.Rt)
+		var rt routing.ContentRouting = in.Rt
+		if in.ServePolicy != nil {
+			// bitswap announces the blocks it receives or that are added
+			rt = in.ServePolicy.WrapContentRouting(rt)
+		}
+		bitswapNetwork := network.NewFromIpfsHost(in.Host, rt)
+		if in.Tracer != nil {
+			bitswapNetwork = in.Tracer.WrapNetwork(bitswapNetwork)
+		}
 
-		exch := bitswap.New(helpers.LifecycleCtx(in.Mctx, lc), bitswapNetwork, in.Bs, in.BitswapOpts...)
+		opts := in.BitswapOpts
+		if len(in.RequestFilters) > 0 {
+			filters := in.RequestFilters
+			opts = append(opts, bitswap.WithPeerBlockRequestFilter(func(p peer.ID, c cid.Cid) bool {
+				for _, allow := range filters {
+					if !allow(p, c) {
+						return false
+					}
+				}
+				return true
+			}))
+		}
+
+		if len(in.MessageTracers) > 0 {
+			opts = append(opts, bitswap.WithTracer(messageTracers(in.MessageTracers)))
+		}
+
+		exch := bitswap.New(helpers.LifecycleCtx(in.Mctx, lc), bitswapNetwork, in.Bs, opts...)
 		lc.Append(fx.Hook{
 			OnStop: func(ctx context.Context) error {
 				return exch.Close()
@@ -79,3 +126,458 @@ func OnlineExchange() interface{} {
 		return exch
 	}
 }
+
+// messageTracers passes the bitswap messages to several tracers.
+type messageTracers []tracer.Tracer
+
+func (ts messageTracers) MessageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, t := range ts {
+		t.MessageReceived(p, msg)
+	}
+}
+
+func (ts messageTracers) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	for _, t := range ts {
+		t.MessageSent(p, msg)
+	}
+}
+
+type bitswapRequestFilterOut struct {
+	fx.Out
+
+	Filter bitswap.PeerBlockRequestFilter `group:"bitswap-request-filters"`
+}
+
+// ServePolicy restricts the blocks of some pins to some peers, as configured
+// by Bitswap.ServePolicies. The blocks are keyed by multihash, so that a peer
+// cannot get around the policy by asking for another CID of the same block.
+//
+// The blocks of a pin are restricted as soon as the pinner wrapped by
+// WrapPinner makes it, and released when it removes or renames it. The
+// restricted pins including a block are counted per policy, so that the
+// blocks shared with other pins stay restricted.
+//
+// No block is served until the pins are loaded.
+type ServePolicy struct {
+	policies []config.BitswapServePolicy
+	allowed  []map[peer.ID]struct{}
+
+	mu         sync.RWMutex
+	restricted map[string]map[int]int      // multihash -> policy index -> number of pins
+	roots      map[cid.Cid]servePolicyRoot // restricted pin -> policies and blocks
+	// changes are the pins restricted or released during the load, which
+	// may have listed the pins before them
+	loading bool
+	changes []servePolicyChange
+}
+
+// servePolicyRoot is a restricted pin, with the indexes of the policies
+// matching it and the multihashes of its blocks.
+type servePolicyRoot struct {
+	idx  []int
+	keys []string
+}
+
+// servePolicyChange restricts the blocks of a pin, or releases them when no
+// policy matches it.
+type servePolicyChange struct {
+	root cid.Cid
+	servePolicyRoot
+}
+
+func (c servePolicyChange) apply(restricted map[string]map[int]int, roots map[cid.Cid]servePolicyRoot) {
+	if old, ok := roots[c.root]; ok {
+		for _, k := range old.keys {
+			counts := restricted[k]
+			for _, i := range old.idx {
+				if counts[i]--; counts[i] <= 0 {
+					delete(counts, i)
+				}
+			}
+			if len(counts) == 0 {
+				delete(restricted, k)
+			}
+		}
+		delete(roots, c.root)
+	}
+	if len(c.idx) == 0 {
+		return
+	}
+	roots[c.root] = c.servePolicyRoot
+	for _, k := range c.keys {
+		counts := restricted[k]
+		if counts == nil {
+			counts = make(map[int]int, len(c.idx))
+			restricted[k] = counts
+		}
+		for _, i := range c.idx {
+			counts[i]++
+		}
+	}
+}
+
+// NewServePolicy creates a serve policy, to be loaded with Load.
+func NewServePolicy(policies []config.BitswapServePolicy) (*ServePolicy, error) {
+	p := &ServePolicy{
+		policies: policies,
+		allowed:  make([]map[peer.ID]struct{}, len(policies)),
+	}
+	for i, policy := range policies {
+		for _, pattern := range policy.PinNames {
+			if _, err := gopath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pin name pattern %q in Bitswap.ServePolicies: %w", pattern, err)
+			}
+		}
+		p.allowed[i] = make(map[peer.ID]struct{}, len(policy.AllowedPeers))
+		for _, id := range policy.AllowedPeers {
+			p.allowed[i][id] = struct{}{}
+		}
+	}
+	return p, nil
+}
+
+// ServePolicies groups the units restricting the blocks served over bitswap
+// according to Bitswap.ServePolicies.
+func ServePolicies(policies []config.BitswapServePolicy) fx.Option {
+	if len(policies) == 0 {
+		return fx.Options()
+	}
+	return fx.Options(
+		fx.Provide(func() (*ServePolicy, error) { return NewServePolicy(policies) }),
+		fx.Decorate(servePolicyPinner),
+		fx.Provide(servePolicyRequestFilter),
+		fx.Invoke(loadServePolicy),
+	)
+}
+
+// offlineDAG returns a DAG service reading the blocks of bs, without
+// fetching them from the network.
+func offlineDAG(bs blockstore.GCBlockstore) format.DAGService {
+	return merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+}
+
+// loadServePolicy loads the pins in the serve policy in the background when
+// the node starts, as walking them may take a while. The pinner depends on
+// bitswap, through the DAG service, so it cannot be given to the constructor
+// of the policy.
+func loadServePolicy(lc fx.Lifecycle, p *ServePolicy, pinner pin.Pinner, bs blockstore.GCBlockstore) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				if err := p.Load(ctx, pinner, offlineDAG(bs)); err != nil && ctx.Err() == nil {
+					logger.Errorf("loading the bitswap serve policy, restricted blocks will not be served: %s", err)
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			<-done
+			return nil
+		},
+	})
+}
+
+// Load computes the restricted blocks from the pins of pinner, walking their
+// DAGs with dag, which should not fetch blocks from the network.
+func (p *ServePolicy) Load(ctx context.Context, pinner pin.Pinner, dag format.DAGService) error {
+	p.mu.Lock()
+	p.loading = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.loading = false
+		p.changes = nil
+		p.mu.Unlock()
+	}()
+
+	var pins []pin.Pinned
+	for _, ch := range []<-chan pin.StreamedPin{pinner.RecursiveKeys(ctx, true), pinner.DirectKeys(ctx, true)} {
+		for sp := range ch {
+			if sp.Err != nil {
+				return sp.Err
+			}
+			pins = append(pins, sp.Pin)
+		}
+	}
+
+	restricted := make(map[string]map[int]int)
+	roots := make(map[cid.Cid]servePolicyRoot)
+	for _, pinned := range pins {
+		idx := p.match(pinned.Name)
+		if len(idx) == 0 {
+			continue
+		}
+		change, err := pinChange(ctx, dag, pinned.Key, pinned.Mode != pin.Direct, idx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// the blocks visited so far are still restricted
+			logger.Errorf("walking pin %q (%s) for the bitswap serve policy: %s", pinned.Name, pinned.Key, err)
+		}
+		change.apply(restricted, roots)
+	}
+
+	p.mu.Lock()
+	for _, c := range p.changes {
+		c.apply(restricted, roots)
+	}
+	p.restricted = restricted
+	p.roots = roots
+	p.mu.Unlock()
+	return nil
+}
+
+// pinChange restricts the blocks of a pin to the policies idx, walking the
+// DAG of recursive pins with dag. The blocks visited so far are returned on
+// errors.
+func pinChange(ctx context.Context, dag format.DAGService, c cid.Cid, recursive bool, idx []int) (servePolicyChange, error) {
+	change := servePolicyChange{root: c, servePolicyRoot: servePolicyRoot{idx: idx}}
+	if !recursive {
+		change.keys = []string{string(c.Hash())}
+		return change, nil
+	}
+	seen := cid.NewSet()
+	err := merkledag.Walk(ctx, merkledag.GetLinksWithDAG(dag), c, func(c cid.Cid) bool {
+		if !seen.Visit(c) {
+			return false
+		}
+		change.keys = append(change.keys, string(c.Hash()))
+		return true
+	})
+	return change, err
+}
+
+func matchPinName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := gopath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the indexes of the policies matching a pin name.
+func (p *ServePolicy) match(name string) []int {
+	var idx []int
+	for i, policy := range p.policies {
+		if matchPinName(policy.PinNames, name) {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// rootPolicies returns the indexes of the policies restricting a pin.
+func (p *ServePolicy) rootPolicies(c cid.Cid) []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.roots[c].idx
+}
+
+// update applies the change to the restricted blocks, and keeps it for the
+// load in progress.
+func (p *ServePolicy) update(c servePolicyChange) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.loading {
+		p.changes = append(p.changes, c)
+	}
+	if p.restricted != nil {
+		c.apply(p.restricted, p.roots)
+	}
+}
+
+// restrict restricts the blocks of a new pin to the policies idx, walking
+// its DAG with dag when recursive.
+func (p *ServePolicy) restrict(ctx context.Context, dag format.DAGService, c cid.Cid, recursive bool, idx []int) error {
+	change, err := pinChange(ctx, dag, c, recursive, idx)
+	// the blocks visited so far are restricted even on errors
+	p.update(change)
+	return err
+}
+
+// release releases the blocks of a removed pin, unless other restricted
+// pins include them.
+func (p *ServePolicy) release(c cid.Cid) {
+	p.update(servePolicyChange{root: c})
+}
+
+// Restricted returns whether the block is restricted to some peers. All
+// blocks are until the policy is loaded.
+func (p *ServePolicy) Restricted(c cid.Cid) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.restricted == nil {
+		return true
+	}
+	_, ok := p.restricted[string(c.Hash())]
+	return ok
+}
+
+// Allowed returns whether the block can be served to the peer.
+func (p *ServePolicy) Allowed(id peer.ID, c cid.Cid) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.restricted == nil {
+		return false
+	}
+	counts, ok := p.restricted[string(c.Hash())]
+	if !ok {
+		return true
+	}
+	for i := range counts {
+		if _, ok := p.allowed[i][id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// WrapPinner returns a pinner updating the restricted blocks with the pins it
+// makes, removes or renames. Its DAGs are walked with dag, which should not
+// fetch blocks from the network.
+func (p *ServePolicy) WrapPinner(pinner pin.Pinner, dag format.DAGService) pin.Pinner {
+	return &servePolicyPinnerWrapper{Pinner: pinner, policy: p, dag: dag}
+}
+
+// servePolicyPinner decorates the pinner of the node, so that the pins are
+// restricted as soon as they are made, whatever makes them.
+func servePolicyPinner(p *ServePolicy, pinner pin.Pinner, bs blockstore.GCBlockstore) pin.Pinner {
+	return p.WrapPinner(pinner, offlineDAG(bs))
+}
+
+type servePolicyPinnerWrapper struct {
+	pin.Pinner
+	policy *ServePolicy
+	dag    format.DAGService
+}
+
+func (sp *servePolicyPinnerWrapper) Pin(ctx context.Context, node format.Node, recursive bool, name string) error {
+	if err := sp.Pinner.Pin(ctx, node, recursive, name); err != nil {
+		return err
+	}
+	return sp.pinned(ctx, node.Cid(), recursive, name)
+}
+
+func (sp *servePolicyPinnerWrapper) PinWithMode(ctx context.Context, c cid.Cid, mode pin.Mode, name string) error {
+	if err := sp.Pinner.PinWithMode(ctx, c, mode, name); err != nil {
+		return err
+	}
+	return sp.pinned(ctx, c, mode == pin.Recursive, name)
+}
+
+// pinned restricts the blocks of a new pin, and releases them when the pin
+// was renamed away from the policies.
+func (sp *servePolicyPinnerWrapper) pinned(ctx context.Context, c cid.Cid, recursive bool, name string) error {
+	idx := sp.policy.match(name)
+	if len(idx) == 0 {
+		sp.policy.release(c)
+		return nil
+	}
+	if err := sp.policy.restrict(ctx, sp.dag, c, recursive, idx); err != nil {
+		logger.Errorf("walking pin %q (%s) for the bitswap serve policy: %s", name, c, err)
+	}
+	return nil
+}
+
+func (sp *servePolicyPinnerWrapper) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	if err := sp.Pinner.Unpin(ctx, c, recursive); err != nil {
+		return err
+	}
+	sp.policy.release(c)
+	return nil
+}
+
+// Update keeps the name of the pin, so the new pin is restricted by the
+// policies of the old one.
+func (sp *servePolicyPinnerWrapper) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if err := sp.Pinner.Update(ctx, from, to, unpin); err != nil {
+		return err
+	}
+	idx := sp.policy.rootPolicies(from)
+	if len(idx) == 0 {
+		return nil
+	}
+	if err := sp.policy.restrict(ctx, sp.dag, to, true, idx); err != nil {
+		logger.Errorf("walking pin %s for the bitswap serve policy: %s", to, err)
+	}
+	if unpin {
+		sp.policy.release(from)
+	}
+	return nil
+}
+
+// FilterKeyProvider returns a key provider skipping the restricted blocks,
+// so that the reprovider does not announce them.
+func (p *ServePolicy) FilterKeyProvider(keyProvider provider.KeyChanFunc) provider.KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		keys, err := keyProvider(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out := make(chan cid.Cid)
+		go func() {
+			defer close(out)
+			for c := range keys {
+				if p.Restricted(c) {
+					continue
+				}
+				select {
+				case out <- c:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out, nil
+	}
+}
+
+// WrapProvider returns a provider system that does not announce the
+// restricted blocks. The content is announced after being pinned, so the
+// pins made through the pinner of the node are restricted by then.
+func (p *ServePolicy) WrapProvider(sys provider.System) provider.System {
+	return &servePolicyProvider{System: sys, policy: p}
+}
+
+type servePolicyProvider struct {
+	provider.System
+	policy *ServePolicy
+}
+
+func (sp *servePolicyProvider) Provide(c cid.Cid) error {
+	if sp.policy.Restricted(c) {
+		return nil
+	}
+	return sp.System.Provide(c)
+}
+
+// WrapContentRouting returns a content router that does not announce the
+// restricted blocks. Bitswap announces the blocks as they are received or
+// added, so only the blocks of the pins restricted by then are skipped.
+func (p *ServePolicy) WrapContentRouting(r routing.ContentRouting) routing.ContentRouting {
+	return &servePolicyContentRouting{ContentRouting: r, policy: p}
+}
+
+type servePolicyContentRouting struct {
+	routing.ContentRouting
+	policy *ServePolicy
+}
+
+func (sr *servePolicyContentRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if announce && sr.policy.Restricted(c) {
+		return nil
+	}
+	return sr.ContentRouting.Provide(ctx, c, announce)
+}
+
+// servePolicyRequestFilter refuses to serve the restricted blocks to the
+// peers that are not allowed to fetch them.
+func servePolicyRequestFilter(p *ServePolicy) bitswapRequestFilterOut {
+	return bitswapRequestFilterOut{Filter: p.Allowed}
+}
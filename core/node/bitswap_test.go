@@ -0,0 +1,106 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	"github.com/ipfs/boxo/pinning/pinner/dspinner"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServePolicy returns a loaded serve policy restricting the pins
+// named "private-*", and the pinner restricting them.
+func newTestServePolicy(t *testing.T) (*ServePolicy, pin.Pinner, blockstore.GCBlockstore) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	bs := blockstore.NewGCBlockstore(blockstore.NewBlockstore(ds), blockstore.NewGCLocker())
+	pinner, err := dspinner.New(ctx, ds, offlineDAG(bs))
+	require.NoError(t, err)
+
+	p, err := NewServePolicy([]config.BitswapServePolicy{{PinNames: []string{"private-*"}}})
+	require.NoError(t, err)
+	require.NoError(t, p.Load(ctx, pinner, offlineDAG(bs)))
+	return p, p.WrapPinner(pinner, offlineDAG(bs)), bs
+}
+
+type providedRouting struct {
+	routing.ContentRouting
+	provided []cid.Cid
+}
+
+func (r *providedRouting) Provide(_ context.Context, c cid.Cid, _ bool) error {
+	r.provided = append(r.provided, c)
+	return nil
+}
+
+func TestServePolicyContentRouting(t *testing.T) {
+	ctx := context.Background()
+	p, pinner, bs := newTestServePolicy(t)
+
+	private := merkledag.NewRawNode([]byte("private"))
+	public := merkledag.NewRawNode([]byte("public"))
+	require.NoError(t, bs.PutMany(ctx, []blocks.Block{private, public}))
+	require.NoError(t, pinner.Pin(ctx, private, true, "private-data"))
+
+	rt := &providedRouting{}
+	wrapped := p.WrapContentRouting(rt)
+	require.NoError(t, wrapped.Provide(ctx, private.Cid(), true))
+	require.NoError(t, wrapped.Provide(ctx, public.Cid(), true))
+	require.Equal(t, []cid.Cid{public.Cid()}, rt.provided)
+}
+
+func TestServePolicyUnpin(t *testing.T) {
+	ctx := context.Background()
+	p, pinner, bs := newTestServePolicy(t)
+
+	shared := merkledag.NewRawNode([]byte("shared"))
+	own := merkledag.NewRawNode([]byte("own"))
+	a := new(merkledag.ProtoNode)
+	require.NoError(t, a.AddNodeLink("shared", shared))
+	require.NoError(t, a.AddNodeLink("own", own))
+	b := new(merkledag.ProtoNode)
+	require.NoError(t, b.AddNodeLink("shared", shared))
+	require.NoError(t, bs.PutMany(ctx, []blocks.Block{shared, own, a, b}))
+
+	require.NoError(t, pinner.Pin(ctx, a, true, "private-a"))
+	require.NoError(t, pinner.Pin(ctx, b, true, "private-b"))
+	for _, c := range []cid.Cid{a.Cid(), b.Cid(), shared.Cid(), own.Cid()} {
+		require.True(t, p.Restricted(c))
+	}
+
+	// the blocks of b stay restricted
+	require.NoError(t, pinner.Unpin(ctx, a.Cid(), true))
+	require.False(t, p.Restricted(a.Cid()))
+	require.False(t, p.Restricted(own.Cid()))
+	require.True(t, p.Restricted(shared.Cid()))
+	require.True(t, p.Restricted(b.Cid()))
+
+	// renamed away from the policy
+	require.NoError(t, pinner.Pin(ctx, b, true, "public-b"))
+	require.False(t, p.Restricted(b.Cid()))
+	require.False(t, p.Restricted(shared.Cid()))
+	require.Empty(t, p.restricted)
+}
+
+func TestServePolicyNotLoaded(t *testing.T) {
+	p, err := NewServePolicy([]config.BitswapServePolicy{{PinNames: []string{"private-*"}}})
+	require.NoError(t, err)
+
+	c := merkledag.NewRawNode([]byte("public")).Cid()
+	require.True(t, p.Restricted(c))
+	require.False(t, p.Allowed(peer.ID("peer"), c))
+
+	// removals before the load are tolerated
+	p.release(c)
+	require.True(t, p.Restricted(c))
+}
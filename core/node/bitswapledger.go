@@ -0,0 +1,523 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/tracer"
+	exchange "github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/repo"
+)
+
+// ledgerPrefix is where the daily bitswap ledgers are stored in the repo
+// datastore, one key per day and peer.
+var ledgerPrefix = datastore.NewKey("/local/bitswap/ledger")
+
+// ledgerFlushInterval is how often the daily ledgers are written to the
+// datastore.
+const ledgerFlushInterval = time.Minute
+
+// ledgerDay is the layout of the days of the ledger history, in UTC.
+const ledgerDay = "2006-01-02"
+
+// ledgerOtherPeers is the peer label of the metrics of the peers without
+// their own metrics.
+const ledgerOtherPeers = "other"
+
+// minLedgerPeers is the smallest number of peers whose totals are kept
+// individually since the node started.
+const minLedgerPeers = 1024
+
+var (
+	peerSentBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "bitswap", "peer_sent_bytes_total"),
+		"Bytes of blocks sent to the peers exchanging the most with the node",
+		[]string{"peer"}, nil,
+	)
+	peerReceivedBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName("ipfs", "bitswap", "peer_received_bytes_total"),
+		"Bytes of blocks received from the peers exchanging the most with the node",
+		[]string{"peer"}, nil,
+	)
+)
+
+// LedgerEntry is what the node exchanged with a peer over bitswap.
+type LedgerEntry struct {
+	Day            string  `json:",omitempty"`
+	Peer           peer.ID `json:",omitempty"`
+	Sent           uint64
+	Received       uint64
+	BlocksSent     uint64
+	BlocksReceived uint64
+}
+
+func (e *LedgerEntry) add(o LedgerEntry) {
+	e.Sent += o.Sent
+	e.Received += o.Received
+	e.BlocksSent += o.BlocksSent
+	e.BlocksReceived += o.BlocksReceived
+}
+
+// BitswapLedger accounts for the blocks exchanged with each peer over
+// bitswap, since the node started and, with a datastore, per day over the
+// retention period. It exposes the peers exchanging the most, and the
+// durations of the bitswap sessions, as Prometheus metrics.
+//
+// Only the totals of the peers exchanging the most are kept individually,
+// those of the other peers are folded into a single entry.
+type BitswapLedger struct {
+	ds          datastore.Datastore
+	retention   time.Duration
+	peerMetrics int
+	maxPeers    int
+	now         func() time.Time
+
+	sessionDuration prometheus.Histogram
+
+	mu      sync.Mutex
+	totals  map[peer.ID]*LedgerEntry
+	other   LedgerEntry                         // folded totals
+	pending map[string]map[peer.ID]*LedgerEntry // day -> peer -> not stored yet
+
+	// metricsMu guards the peers exposed individually in the metrics, with
+	// their totals when they got their own series: the bytes exchanged
+	// before stay in the other peers, so that every series only grows.
+	metricsMu sync.Mutex
+	exported  map[peer.ID]LedgerEntry
+}
+
+// NewBitswapLedger creates a bitswap ledger exposing peerMetrics peers in the
+// metrics. The daily ledgers are stored in ds when it is not nil.
+func NewBitswapLedger(ds datastore.Datastore, retention time.Duration, peerMetrics int) *BitswapLedger {
+	return &BitswapLedger{
+		ds:          ds,
+		retention:   retention,
+		peerMetrics: peerMetrics,
+		maxPeers:    max(peerMetrics, minLedgerPeers),
+		now:         time.Now,
+		sessionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "ipfs",
+			Subsystem: "bitswap",
+			Name:      "session_fetch_duration_seconds",
+			Help:      "Time bitswap sessions spent fetching blocks, from the first request to the last block received",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+		}),
+		totals:  make(map[peer.ID]*LedgerEntry),
+		pending: make(map[string]map[peer.ID]*LedgerEntry),
+	}
+}
+
+type bitswapLedgerOut struct {
+	fx.Out
+
+	Ledger *BitswapLedger
+	Tracer tracer.Tracer `group:"bitswap-tracers"`
+}
+
+// BitswapLedgers constructs the bitswap ledger configured by cfg, fed by the
+// messages of bitswap.
+func BitswapLedgers(cfg config.Bitswap) interface{} {
+	return func(lc fx.Lifecycle, r repo.Repo) bitswapLedgerOut {
+		var ds datastore.Datastore
+		if cfg.LedgerHistory.Enabled.WithDefault(false) {
+			ds = r.Datastore()
+		}
+		retention := cfg.LedgerHistory.Retention.WithDefault(config.DefaultBitswapLedgerHistoryRetention)
+		l := NewBitswapLedger(ds, retention, int(cfg.PeerMetrics.WithDefault(config.DefaultBitswapPeerMetrics)))
+
+		if ds != nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			lc.Append(fx.Hook{
+				OnStart: func(context.Context) error {
+					go func() {
+						defer close(done)
+						ticker := time.NewTicker(ledgerFlushInterval)
+						defer ticker.Stop()
+						for {
+							select {
+							case <-ctx.Done():
+								return
+							case <-ticker.C:
+							}
+							if err := l.Flush(ctx); err != nil && ctx.Err() == nil {
+								logger.Errorf("storing the bitswap ledger history: %s", err)
+							}
+						}
+					}()
+					return nil
+				},
+				OnStop: func(stopCtx context.Context) error {
+					cancel()
+					<-done
+					return l.Flush(stopCtx)
+				},
+			})
+		}
+		return bitswapLedgerOut{Ledger: l, Tracer: l}
+	}
+}
+
+// MessageReceived implements bitswap.Tracer.
+func (l *BitswapLedger) MessageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	if blks := msg.Blocks(); len(blks) > 0 {
+		l.add(p, LedgerEntry{Received: blocksSize(blks), BlocksReceived: uint64(len(blks))})
+	}
+}
+
+// MessageSent implements bitswap.Tracer.
+func (l *BitswapLedger) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	if blks := msg.Blocks(); len(blks) > 0 {
+		l.add(p, LedgerEntry{Sent: blocksSize(blks), BlocksSent: uint64(len(blks))})
+	}
+}
+
+func blocksSize(blks []blocks.Block) uint64 {
+	var size uint64
+	for _, b := range blks {
+		size += uint64(len(b.RawData()))
+	}
+	return size
+}
+
+func (l *BitswapLedger) add(p peer.ID, e LedgerEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	total, ok := l.totals[p]
+	if !ok {
+		total = &LedgerEntry{Peer: p}
+		l.totals[p] = total
+	}
+	total.add(e)
+	if len(l.totals) >= 2*l.maxPeers {
+		l.fold()
+	}
+
+	if l.ds == nil {
+		return
+	}
+	day := l.now().UTC().Format(ledgerDay)
+	peers, ok := l.pending[day]
+	if !ok {
+		peers = make(map[peer.ID]*LedgerEntry)
+		l.pending[day] = peers
+	}
+	pending, ok := peers[p]
+	if !ok {
+		pending = &LedgerEntry{Day: day, Peer: p}
+		peers[p] = pending
+	}
+	pending.add(e)
+}
+
+// fold keeps the totals of the maxPeers peers exchanging the most, and adds
+// those of the other peers to the folded totals.
+func (l *BitswapLedger) fold() {
+	entries := make([]LedgerEntry, 0, len(l.totals))
+	for _, e := range l.totals {
+		entries = append(entries, *e)
+	}
+	sortLedgerEntries(entries)
+	for _, e := range entries[l.maxPeers:] {
+		l.other.add(e)
+		delete(l.totals, e.Peer)
+	}
+}
+
+// Peer returns what the node exchanged with p since it started, or since
+// its totals were last folded.
+func (l *BitswapLedger) Peer(p peer.ID) LedgerEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.totals[p]; ok {
+		return *e
+	}
+	return LedgerEntry{Peer: p}
+}
+
+// Top returns the n peers that exchanged the most bytes since the node
+// started, and the sum of the other peers.
+func (l *BitswapLedger) Top(n int) ([]LedgerEntry, LedgerEntry) {
+	l.mu.Lock()
+	entries := make([]LedgerEntry, 0, len(l.totals))
+	for _, e := range l.totals {
+		entries = append(entries, *e)
+	}
+	other := l.other
+	l.mu.Unlock()
+
+	sortLedgerEntries(entries)
+	if len(entries) > n {
+		for _, e := range entries[n:] {
+			other.add(e)
+		}
+		entries = entries[:n]
+	}
+	return entries, other
+}
+
+// sortLedgerEntries sorts entries by decreasing bytes exchanged.
+func sortLedgerEntries(entries []LedgerEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		ti, tj := entries[i].Sent+entries[i].Received, entries[j].Sent+entries[j].Received
+		if ti != tj {
+			return ti > tj
+		}
+		return entries[i].Peer < entries[j].Peer
+	})
+}
+
+// Flush adds the ledgers of the day not stored yet to the datastore, and
+// removes the days past the retention period.
+func (l *BitswapLedger) Flush(ctx context.Context) error {
+	if l.ds == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = make(map[string]map[peer.ID]*LedgerEntry)
+	l.mu.Unlock()
+
+	for day, peers := range pending {
+		for p, e := range peers {
+			key := ledgerPrefix.ChildString(day).ChildString(p.String())
+			stored, err := l.get(ctx, key)
+			if err != nil {
+				return err
+			}
+			stored.add(*e)
+			data, err := json.Marshal(stored)
+			if err != nil {
+				return err
+			}
+			if err := l.ds.Put(ctx, key, data); err != nil {
+				return err
+			}
+		}
+	}
+	return l.prune(ctx)
+}
+
+func (l *BitswapLedger) get(ctx context.Context, key datastore.Key) (LedgerEntry, error) {
+	var e LedgerEntry
+	data, err := l.ds.Get(ctx, key)
+	switch {
+	case err == datastore.ErrNotFound:
+		return e, nil
+	case err != nil:
+		return e, err
+	}
+	return e, json.Unmarshal(data, &e)
+}
+
+// prune removes the days past the retention period.
+func (l *BitswapLedger) prune(ctx context.Context) error {
+	oldest := l.now().Add(-l.retention).UTC().Format(ledgerDay)
+	results, err := l.ds.Query(ctx, query.Query{Prefix: ledgerPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for res := range results.Next() {
+		if res.Error != nil {
+			return res.Error
+		}
+		key := datastore.NewKey(res.Key)
+		if day := key.Parent().BaseNamespace(); day < oldest {
+			if err := l.ds.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// History returns the daily ledgers since the given day, of the given peer
+// or of all the peers when it is empty, most recent day first and then by
+// decreasing bytes exchanged.
+func (l *BitswapLedger) History(ctx context.Context, p peer.ID, since time.Time) ([]LedgerEntry, error) {
+	if l.ds == nil {
+		return nil, nil
+	}
+	from := since.UTC().Format(ledgerDay)
+
+	byKey := make(map[string]*LedgerEntry)
+	results, err := l.ds.Query(ctx, query.Query{Prefix: ledgerPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for res := range results.Next() {
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		var e LedgerEntry
+		if err := json.Unmarshal(res.Value, &e); err != nil {
+			logger.Errorf("ignoring bitswap ledger %s: %s", res.Key, err)
+			continue
+		}
+		key := datastore.NewKey(res.Key)
+		e.Day = key.Parent().BaseNamespace()
+		e.Peer, err = peer.Decode(key.BaseNamespace())
+		if err != nil {
+			logger.Errorf("ignoring bitswap ledger %s: %s", res.Key, err)
+			continue
+		}
+		byKey[e.Day+"/"+e.Peer.String()] = &e
+	}
+
+	l.mu.Lock()
+	for day, peers := range l.pending {
+		for id, pending := range peers {
+			k := day + "/" + id.String()
+			if e, ok := byKey[k]; ok {
+				e.add(*pending)
+			} else {
+				e := *pending
+				byKey[k] = &e
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	entries := []LedgerEntry{}
+	for _, e := range byKey {
+		if e.Day < from || (p != "" && e.Peer != p) {
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sortLedgerEntries(entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Day > entries[j].Day
+	})
+	return entries, nil
+}
+
+// Describe implements prometheus.Collector.
+func (l *BitswapLedger) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peerSentBytesDesc
+	ch <- peerReceivedBytesDesc
+	l.sessionDuration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector. A peer entering the top peers
+// gets its own series from zero, and its bytes are added back to the other
+// peers when it leaves them, so that the counters never decrease.
+func (l *BitswapLedger) Collect(ch chan<- prometheus.Metric) {
+	top, other := l.Top(l.peerMetrics)
+
+	l.metricsMu.Lock()
+	exported := make(map[peer.ID]LedgerEntry, len(top))
+	for _, e := range top {
+		base, ok := l.exported[e.Peer]
+		if !ok || e.Sent < base.Sent || e.Received < base.Received {
+			// new in the top peers, or folded since
+			base = e
+		}
+		exported[e.Peer] = base
+		other.Sent += base.Sent
+		other.Received += base.Received
+		ch <- prometheus.MustNewConstMetric(peerSentBytesDesc, prometheus.CounterValue, float64(e.Sent-base.Sent), e.Peer.String())
+		ch <- prometheus.MustNewConstMetric(peerReceivedBytesDesc, prometheus.CounterValue, float64(e.Received-base.Received), e.Peer.String())
+	}
+	l.exported = exported
+	l.metricsMu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(peerSentBytesDesc, prometheus.CounterValue, float64(other.Sent), ledgerOtherPeers)
+	ch <- prometheus.MustNewConstMetric(peerReceivedBytesDesc, prometheus.CounterValue, float64(other.Received), ledgerOtherPeers)
+	l.sessionDuration.Collect(ch)
+}
+
+// WrapExchange returns an exchange recording the time its sessions spend
+// fetching blocks.
+func (l *BitswapLedger) WrapExchange(ex exchange.Interface) exchange.Interface {
+	sx, ok := ex.(exchange.SessionExchange)
+	if !ok {
+		return ex
+	}
+	return &ledgerExchange{SessionExchange: sx, ledger: l}
+}
+
+type ledgerExchange struct {
+	exchange.SessionExchange
+	ledger *BitswapLedger
+}
+
+func (e *ledgerExchange) NewSession(ctx context.Context) exchange.Fetcher {
+	s := &ledgerSession{Fetcher: e.SessionExchange.NewSession(ctx), ledger: e.ledger}
+	context.AfterFunc(ctx, s.done)
+	return s
+}
+
+// ledgerSession records the time from the first request of a session that
+// fetched a block to the last block received, when the session ends.
+type ledgerSession struct {
+	exchange.Fetcher
+	ledger *BitswapLedger
+
+	mu          sync.Mutex
+	first, last time.Time
+}
+
+func (s *ledgerSession) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	start := time.Now()
+	b, err := s.Fetcher.GetBlock(ctx, c)
+	if err == nil {
+		s.fetched(start)
+	}
+	return b, err
+}
+
+func (s *ledgerSession) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	start := time.Now()
+	blks, err := s.Fetcher.GetBlocks(ctx, ks)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		for b := range blks {
+			s.fetched(start)
+			select {
+			case out <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *ledgerSession) fetched(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.first.IsZero() || start.Before(s.first) {
+		s.first = start
+	}
+	s.last = time.Now()
+}
+
+func (s *ledgerSession) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.last.IsZero() {
+		s.ledger.sessionDuration.Observe(s.last.Sub(s.first).Seconds())
+	}
+}
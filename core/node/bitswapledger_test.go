@@ -0,0 +1,69 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitswapLedgerBounded(t *testing.T) {
+	l := NewBitswapLedger(nil, 0, 2)
+
+	// one large peer, and more small peers than the ledger keeps
+	big := peer.ID("big")
+	l.add(big, LedgerEntry{Sent: 1 << 20, BlocksSent: 1})
+	const small = 3 * minLedgerPeers
+	for i := 0; i < small; i++ {
+		l.add(peer.ID(fmt.Sprint("small-", i)), LedgerEntry{Received: 1, BlocksReceived: 1})
+	}
+	require.Less(t, len(l.totals), 2*minLedgerPeers)
+
+	top, other := l.Top(2)
+	require.Len(t, top, 2)
+	require.Equal(t, big, top[0].Peer)
+	require.Equal(t, uint64(1<<20), l.Peer(big).Sent)
+	// the folded peers are still counted in the other peers
+	require.EqualValues(t, small, top[1].Received+other.Received)
+	require.EqualValues(t, small, top[1].BlocksReceived+other.BlocksReceived)
+}
+
+// collectLedgerSent returns the bytes sent by peer label in the metrics.
+func collectLedgerSent(t *testing.T, l *BitswapLedger) map[string]float64 {
+	ch := make(chan prometheus.Metric, 16)
+	l.Collect(ch)
+	close(ch)
+	sent := make(map[string]float64)
+	for m := range ch {
+		if m.Desc() != peerSentBytesDesc {
+			continue
+		}
+		var out dto.Metric
+		require.NoError(t, m.Write(&out))
+		sent[out.GetLabel()[0].GetValue()] = out.GetCounter().GetValue()
+	}
+	return sent
+}
+
+func TestBitswapLedgerMetricsMonotonic(t *testing.T) {
+	l := NewBitswapLedger(nil, 0, 1)
+	a, b := peer.ID("a"), peer.ID("b")
+
+	l.add(a, LedgerEntry{Sent: 10})
+	l.add(b, LedgerEntry{Sent: 5})
+	require.Equal(t, map[string]float64{a.String(): 0, ledgerOtherPeers: 15}, collectLedgerSent(t, l))
+
+	l.add(a, LedgerEntry{Sent: 2})
+	require.Equal(t, map[string]float64{a.String(): 2, ledgerOtherPeers: 15}, collectLedgerSent(t, l))
+
+	// b enters the top peers, a leaves them: the other peers do not decrease
+	l.add(b, LedgerEntry{Sent: 20})
+	require.Equal(t, map[string]float64{b.String(): 0, ledgerOtherPeers: 37}, collectLedgerSent(t, l))
+
+	l.add(b, LedgerEntry{Sent: 1})
+	l.add(a, LedgerEntry{Sent: 1})
+	require.Equal(t, map[string]float64{b.String(): 1, ledgerOtherPeers: 38}, collectLedgerSent(t, l))
+}
@@ -0,0 +1,403 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/bitswap"
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	"github.com/ipfs/boxo/bitswap/server"
+	"github.com/ipfs/boxo/bitswap/tracer"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+)
+
+// Names of the built-in policies of Bitswap.Prioritization.Policies.
+const (
+	BitswapPrioritizePeering     = "peering"
+	BitswapPrioritizeLedgerRatio = "ledger-ratio"
+	BitswapPrioritizePeerShare   = "peer-share"
+)
+
+// peerShareWindow is the period over which the bytes sent to each peer are
+// counted, for the share of the bytes recently sent they got.
+const peerShareWindow = time.Minute
+
+// BitswapPrioritizer is a policy ordering the requests the bitswap server
+// answers. It returns a negative number when a should be served before b, a
+// positive one when b should be served first, and 0 when it ranks them
+// equally.
+//
+// The bitswap server keeps the requests in heaps, which are only reordered
+// when the requests of a peer change, so a policy must rank two requests the
+// same way while they are queued: it should compare their snapshots rather
+// than the current state of the node.
+type BitswapPrioritizer func(a, b *server.TaskInfo) int
+
+// BitswapPrioritizerEnv is what the prioritization policies are constructed
+// with.
+type BitswapPrioritizerEnv struct {
+	Config *config.Config
+	// Ledger has the bytes exchanged with each peer since the node started.
+	Ledger *BitswapLedger
+	// Shares has the bytes recently sent to each peer.
+	Shares *BitswapPeerShares
+	// Snapshot returns the snapshot of a request.
+	Snapshot func(t *server.TaskInfo) BitswapTaskSnapshot
+}
+
+// BitswapTaskSnapshot is what the node knew of a peer when it received a
+// request. It is the zero value for the requests that are not known.
+type BitswapTaskSnapshot struct {
+	// Sent is the bytes recently sent to the peer, and SentTotal those sent
+	// to all the peers.
+	Sent, SentTotal uint64
+	// Ledger is what the node exchanged with the peer since it started.
+	Ledger LedgerEntry
+}
+
+// BitswapPrioritizerConstructor constructs a prioritization policy.
+type BitswapPrioritizerConstructor func(env BitswapPrioritizerEnv) (BitswapPrioritizer, error)
+
+var bitswapPrioritizers = map[string]BitswapPrioritizerConstructor{
+	BitswapPrioritizePeering:     peeringPrioritizer,
+	BitswapPrioritizeLedgerRatio: ledgerRatioPrioritizer,
+	BitswapPrioritizePeerShare:   peerSharePrioritizer,
+}
+
+// AddBitswapPrioritizer adds a prioritization policy, which can then be
+// selected by its name in Bitswap.Prioritization.Policies.
+func AddBitswapPrioritizer(name string, c BitswapPrioritizerConstructor) error {
+	if _, ok := bitswapPrioritizers[name]; ok {
+		return fmt.Errorf("already have a bitswap prioritization policy named %q", name)
+	}
+	bitswapPrioritizers[name] = c
+	return nil
+}
+
+type bitswapPrioritizationOut struct {
+	fx.Out
+
+	BitswapOpts []bitswap.Option `group:"bitswap-options,flatten"`
+	Tracers     []tracer.Tracer  `group:"bitswap-tracers,flatten"`
+}
+
+// BitswapPrioritization orders the requests the bitswap server answers with
+// the policies of Bitswap.Prioritization.Policies. The requests they rank
+// equally go to the peers that were sent the fewest bytes recently, and then
+// in the order of the priorities of the wants, like without policies.
+//
+// The shares and ledger ratios are those of the peers when their requests
+// were received, see BitswapTaskSnapshot.
+func BitswapPrioritization(cfg *config.Config) interface{} {
+	return func(lc fx.Lifecycle, h host.Host, ledger *BitswapLedger) (bitswapPrioritizationOut, error) {
+		shares := NewBitswapPeerShares()
+		wants := newBitswapWantPriorities(shares, ledger)
+		env := BitswapPrioritizerEnv{
+			Config:   cfg,
+			Ledger:   ledger,
+			Shares:   shares,
+			Snapshot: wants.snapshot,
+		}
+
+		var policies []BitswapPrioritizer
+		for _, name := range cfg.Bitswap.Prioritization.Policies {
+			c, ok := bitswapPrioritizers[name]
+			if !ok {
+				return bitswapPrioritizationOut{}, fmt.Errorf("unknown bitswap prioritization policy %q", name)
+			}
+			p, err := c(env)
+			if err != nil {
+				return bitswapPrioritizationOut{}, fmt.Errorf("bitswap prioritization policy %q: %w", name, err)
+			}
+			policies = append(policies, p)
+		}
+
+		h.Network().Notify(wants)
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				h.Network().StopNotify(wants)
+				return nil
+			},
+		})
+
+		return bitswapPrioritizationOut{
+			BitswapOpts: []bitswap.Option{bitswap.WithTaskComparator(bitswapTaskComparator(policies, wants))},
+			Tracers:     []tracer.Tracer{shares, wants},
+		}, nil
+	}
+}
+
+// bitswapTaskComparator returns whether the request a should be served before
+// b. The bitswap server uses it for the requests of each peer, and for the
+// next request of the peers.
+func bitswapTaskComparator(policies []BitswapPrioritizer, wants *bitswapWantPriorities) server.TaskComparator {
+	return func(a, b *server.TaskInfo) bool {
+		for _, p := range policies {
+			if c := p(a, b); c != 0 {
+				return c < 0
+			}
+		}
+		if a.Peer != b.Peer {
+			sentA, sentB := wants.snapshot(a).Sent, wants.snapshot(b).Sent
+			if sentA != sentB {
+				return sentA < sentB
+			}
+		}
+		return wants.less(a, b)
+	}
+}
+
+// peeringPrioritizer serves the peers of Peering.Peers first.
+func peeringPrioritizer(env BitswapPrioritizerEnv) (BitswapPrioritizer, error) {
+	peering := make(map[peer.ID]struct{}, len(env.Config.Peering.Peers))
+	for _, p := range env.Config.Peering.Peers {
+		peering[p.ID] = struct{}{}
+	}
+	return func(a, b *server.TaskInfo) int {
+		_, peeringA := peering[a.Peer]
+		_, peeringB := peering[b.Peer]
+		return compareBools(peeringA, peeringB)
+	}, nil
+}
+
+// ledgerRatioPrioritizer serves first the peers that sent the node the most
+// bytes for each byte it sent them.
+func ledgerRatioPrioritizer(env BitswapPrioritizerEnv) (BitswapPrioritizer, error) {
+	ratio := func(t *server.TaskInfo) float64 {
+		e := env.Snapshot(t).Ledger
+		return float64(e.Received+1) / float64(e.Sent+1)
+	}
+	return func(a, b *server.TaskInfo) int {
+		ra, rb := ratio(a), ratio(b)
+		switch {
+		case ra > rb:
+			return -1
+		case ra < rb:
+			return 1
+		default:
+			return 0
+		}
+	}, nil
+}
+
+// peerSharePrioritizer serves last the peers that got more than
+// Bitswap.Prioritization.MaxPeerShare of the bytes recently sent. They are
+// not throttled: they are still served when the other peers want nothing.
+func peerSharePrioritizer(env BitswapPrioritizerEnv) (BitswapPrioritizer, error) {
+	maxShare := env.Config.Bitswap.Prioritization.MaxPeerShare.WithDefault(config.DefaultBitswapMaxPeerShare)
+	if maxShare < 1 || maxShare > 100 {
+		return nil, fmt.Errorf("the Bitswap.Prioritization.MaxPeerShare percentage must be between 1 and 100, got %d", maxShare)
+	}
+	over := func(t *server.TaskInfo) bool {
+		s := env.Snapshot(t)
+		return s.Sent*100 > s.SentTotal*uint64(maxShare)
+	}
+	return func(a, b *server.TaskInfo) int {
+		return compareBools(over(b), over(a))
+	}, nil
+}
+
+// compareBools ranks true before false.
+func compareBools(a, b bool) int {
+	switch {
+	case a && !b:
+		return -1
+	case b && !a:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// BitswapPeerShares counts the bytes of the blocks bitswap sends to each
+// peer over the last one to two minutes.
+type BitswapPeerShares struct {
+	now func() time.Time
+
+	mu                  sync.Mutex
+	start               time.Time // of the current window
+	current, previous   map[peer.ID]uint64
+	currentTotal, total uint64 // total is over both windows
+}
+
+// NewBitswapPeerShares creates a counter of the bytes sent to each peer.
+func NewBitswapPeerShares() *BitswapPeerShares {
+	return &BitswapPeerShares{
+		now:      time.Now,
+		start:    time.Now(),
+		current:  make(map[peer.ID]uint64),
+		previous: make(map[peer.ID]uint64),
+	}
+}
+
+// rotate starts a new window when the current one is over. Called with mu
+// held.
+func (s *BitswapPeerShares) rotate() {
+	now := s.now()
+	elapsed := now.Sub(s.start)
+	switch {
+	case elapsed < peerShareWindow:
+		return
+	case elapsed < 2*peerShareWindow:
+		s.previous, s.total = s.current, s.currentTotal
+	default:
+		s.previous, s.total = make(map[peer.ID]uint64), 0
+	}
+	s.current, s.currentTotal = make(map[peer.ID]uint64), 0
+	s.start = now
+}
+
+// Sent returns the bytes recently sent to p, and to all the peers.
+func (s *BitswapPeerShares) Sent(p peer.ID) (uint64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate()
+	return s.current[p] + s.previous[p], s.total
+}
+
+// MessageReceived implements bitswap.Tracer.
+func (s *BitswapPeerShares) MessageReceived(peer.ID, bsmsg.BitSwapMessage) {}
+
+// MessageSent implements bitswap.Tracer.
+func (s *BitswapPeerShares) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	size := blocksSize(msg.Blocks())
+	if size == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate()
+	s.current[p] += size
+	s.currentTotal += size
+	s.total += size
+}
+
+// bitswapWantPriorities records the priorities of the wants of the peers,
+// which the bitswap server does not give to the task comparator, and their
+// snapshots, until they are answered or cancelled. The tracers see the
+// wants before the bitswap server queues them.
+type bitswapWantPriorities struct {
+	shares *BitswapPeerShares
+	ledger *BitswapLedger
+
+	mu    sync.Mutex
+	seq   uint64
+	wants map[peer.ID]map[cid.Cid]bitswapWantPriority
+}
+
+type bitswapWantPriority struct {
+	priority int32
+	seq      uint64 // order of arrival
+	snapshot BitswapTaskSnapshot
+}
+
+func newBitswapWantPriorities(shares *BitswapPeerShares, ledger *BitswapLedger) *bitswapWantPriorities {
+	return &bitswapWantPriorities{
+		shares: shares,
+		ledger: ledger,
+		wants:  make(map[peer.ID]map[cid.Cid]bitswapWantPriority),
+	}
+}
+
+// snapshot returns the snapshot of a request, taken when it was received.
+func (w *bitswapWantPriorities) snapshot(t *server.TaskInfo) BitswapTaskSnapshot {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.wants[t.Peer][t.Cid].snapshot
+}
+
+// less ranks the wants with the highest priority first, and then the first
+// ones received. The unknown wants come last.
+func (w *bitswapWantPriorities) less(a, b *server.TaskInfo) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wa, okA := w.wants[a.Peer][a.Cid]
+	wb, okB := w.wants[b.Peer][b.Cid]
+	switch {
+	case !okA:
+		return false
+	case !okB:
+		return true
+	case wa.priority != wb.priority:
+		return wa.priority > wb.priority
+	default:
+		return wa.seq < wb.seq
+	}
+}
+
+// MessageReceived implements bitswap.Tracer.
+func (w *bitswapWantPriorities) MessageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	entries := msg.Wantlist()
+	if len(entries) == 0 && !msg.Full() {
+		return
+	}
+	var snapshot BitswapTaskSnapshot
+	snapshot.Sent, snapshot.SentTotal = w.shares.Sent(p)
+	snapshot.Ledger = w.ledger.Peer(p)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wants := w.wants[p]
+	if wants == nil || msg.Full() {
+		wants = make(map[cid.Cid]bitswapWantPriority, len(entries))
+		w.wants[p] = wants
+	}
+	for _, e := range entries {
+		if e.Cancel {
+			delete(wants, e.Cid)
+			continue
+		}
+		w.seq++
+		wants[e.Cid] = bitswapWantPriority{priority: e.Priority, seq: w.seq, snapshot: snapshot}
+	}
+	if len(wants) == 0 {
+		delete(w.wants, p)
+	}
+}
+
+// MessageSent implements bitswap.Tracer.
+func (w *bitswapWantPriorities) MessageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wants, ok := w.wants[p]
+	if !ok {
+		return
+	}
+	for _, b := range msg.Blocks() {
+		delete(wants, b.Cid())
+	}
+	for _, c := range msg.Haves() {
+		delete(wants, c)
+	}
+	for _, c := range msg.DontHaves() {
+		delete(wants, c)
+	}
+	if len(wants) == 0 {
+		delete(w.wants, p)
+	}
+}
+
+func (w *bitswapWantPriorities) Listen(network.Network, ma.Multiaddr)      {}
+func (w *bitswapWantPriorities) ListenClose(network.Network, ma.Multiaddr) {}
+func (w *bitswapWantPriorities) Connected(network.Network, network.Conn)   {}
+
+// Disconnected forgets the wants of the peers the node is no longer
+// connected to, as the bitswap server drops them.
+func (w *bitswapWantPriorities) Disconnected(n network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	if n.Connectedness(p) == network.Connected {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.wants, p)
+}
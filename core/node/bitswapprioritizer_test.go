@@ -0,0 +1,153 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	pb "github.com/ipfs/boxo/bitswap/message/pb"
+	"github.com/ipfs/boxo/bitswap/server"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitswapTaskComparator(t *testing.T) {
+	alice, bob, carol := peer.ID("alice"), peer.ID("bob"), peer.ID("carol")
+	blks := make([]blocks.Block, 4)
+	for i := range blks {
+		blks[i] = blocks.NewBlock([]byte{byte(i)})
+	}
+	task := func(p peer.ID, b blocks.Block) *server.TaskInfo {
+		return &server.TaskInfo{Peer: p, Cid: b.Cid()}
+	}
+	want := func(p peer.ID, priorities map[cid.Cid]int32, order ...cid.Cid) bsmsg.BitSwapMessage {
+		msg := bsmsg.New(false)
+		for _, c := range order {
+			msg.AddEntry(c, priorities[c], pb.Message_Wantlist_Block, false)
+		}
+		return msg
+	}
+
+	shares := NewBitswapPeerShares()
+	wants := newBitswapWantPriorities(shares, NewBitswapLedger(nil, 0, 1))
+	cfg := &config.Config{}
+	cfg.Peering.Peers = []peer.AddrInfo{{ID: carol}}
+	peering, err := peeringPrioritizer(BitswapPrioritizerEnv{Config: cfg})
+	require.NoError(t, err)
+	less := bitswapTaskComparator([]BitswapPrioritizer{peering}, wants)
+
+	c0, c1, c2 := blks[0].Cid(), blks[1].Cid(), blks[2].Cid()
+	// the order of the wants of a message is lost, as it is a map
+	wants.MessageReceived(alice, want(alice, map[cid.Cid]int32{c0: 1, c1: 5}, c0, c1))
+	wants.MessageReceived(alice, want(alice, map[cid.Cid]int32{c2: 1}, c2))
+	wants.MessageReceived(bob, want(bob, map[cid.Cid]int32{c0: 3}, c0))
+
+	// the wants of a peer are served by priority, and then as they came
+	assert.True(t, less(task(alice, blks[1]), task(alice, blks[0])))
+	assert.True(t, less(task(alice, blks[0]), task(alice, blks[2])))
+	assert.False(t, less(task(alice, blks[2]), task(alice, blks[0])))
+	// unknown wants come last
+	assert.True(t, less(task(alice, blks[2]), task(alice, blks[3])))
+	assert.False(t, less(task(alice, blks[3]), task(alice, blks[2])))
+
+	// the policies rank the peers first
+	assert.True(t, less(task(carol, blks[3]), task(alice, blks[1])))
+	assert.False(t, less(task(alice, blks[1]), task(carol, blks[3])))
+
+	// then the peers that were sent the fewest bytes when their wants were
+	// received, as the queued wants are not reordered
+	shares.MessageSent(alice, blockMessage(blks[3]))
+	assert.True(t, less(task(alice, blks[1]), task(bob, blks[0])))
+	wants.MessageReceived(alice, want(alice, map[cid.Cid]int32{c1: 5}, c1))
+	assert.True(t, less(task(bob, blks[0]), task(alice, blks[1])))
+	assert.False(t, less(task(alice, blks[1]), task(bob, blks[0])))
+
+	// and the priorities of the wants when they were sent as many bytes
+	shares.MessageSent(bob, blockMessage(blks[3]))
+	wants.MessageReceived(bob, want(bob, map[cid.Cid]int32{c0: 3}, c0))
+	assert.True(t, less(task(alice, blks[1]), task(bob, blks[0])))
+
+	// the wants are forgotten once answered, cancelled or disconnected
+	wants.MessageSent(alice, blockMessage(blks[1]))
+	cancel := bsmsg.New(false)
+	cancel.Cancel(c0)
+	wants.MessageReceived(alice, cancel)
+	assert.Len(t, wants.wants[alice], 1)
+	full := bsmsg.New(true)
+	wants.MessageReceived(alice, full)
+	assert.NotContains(t, wants.wants, alice)
+}
+
+func TestBitswapPeerShares(t *testing.T) {
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	now := time.Now()
+	s := NewBitswapPeerShares()
+	s.now = func() time.Time { return now }
+	s.start = now
+
+	s.MessageSent(alice, blockMessage(blocks.NewBlock(make([]byte, 100))))
+	s.MessageSent(bob, blockMessage(blocks.NewBlock(make([]byte, 50))))
+	// messages without blocks are not counted
+	s.MessageSent(bob, bsmsg.New(false))
+	sent, total := s.Sent(alice)
+	assert.Equal(t, uint64(100), sent)
+	assert.Equal(t, uint64(150), total)
+
+	// the bytes of the previous window are still counted
+	now = now.Add(peerShareWindow)
+	s.MessageSent(bob, blockMessage(blocks.NewBlock(make([]byte, 50))))
+	sent, total = s.Sent(bob)
+	assert.Equal(t, uint64(100), sent)
+	assert.Equal(t, uint64(200), total)
+	sent, _ = s.Sent(alice)
+	assert.Equal(t, uint64(100), sent)
+
+	// but not those of the windows before
+	now = now.Add(peerShareWindow)
+	sent, total = s.Sent(alice)
+	assert.Zero(t, sent)
+	assert.Equal(t, uint64(50), total)
+
+	now = now.Add(2 * peerShareWindow)
+	sent, total = s.Sent(bob)
+	assert.Zero(t, sent)
+	assert.Zero(t, total)
+}
+
+func blockMessage(b blocks.Block) bsmsg.BitSwapMessage {
+	msg := bsmsg.New(false)
+	msg.AddBlock(b)
+	return msg
+}
+
+func TestPeerSharePrioritizer(t *testing.T) {
+	alice, bob := peer.ID("alice"), peer.ID("bob")
+	blk := blocks.NewBlock([]byte("block"))
+	shares := NewBitswapPeerShares()
+	wants := newBitswapWantPriorities(shares, NewBitswapLedger(nil, 0, 1))
+	cfg := &config.Config{}
+	cfg.Bitswap.Prioritization.MaxPeerShare = config.NewOptionalInteger(50)
+	peerShare, err := peerSharePrioritizer(BitswapPrioritizerEnv{Config: cfg, Shares: shares, Snapshot: wants.snapshot})
+	require.NoError(t, err)
+
+	want := bsmsg.New(false)
+	want.AddEntry(blk.Cid(), 1, pb.Message_Wantlist_Block, false)
+	aliceTask := &server.TaskInfo{Peer: alice, Cid: blk.Cid()}
+	bobTask := &server.TaskInfo{Peer: bob, Cid: blk.Cid()}
+
+	shares.MessageSent(alice, blockMessage(blk))
+	wants.MessageReceived(alice, want)
+	wants.MessageReceived(bob, want)
+	assert.Equal(t, 1, peerShare(aliceTask, bobTask))
+
+	// the queued wants keep their rank however the shares change
+	shares.MessageSent(bob, blockMessage(blocks.NewBlock(make([]byte, 100))))
+	assert.Equal(t, 1, peerShare(aliceTask, bobTask))
+	wants.MessageReceived(alice, want)
+	wants.MessageReceived(bob, want)
+	assert.Equal(t, -1, peerShare(aliceTask, bobTask))
+}
@@ -0,0 +1,294 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	bsmsg "github.com/ipfs/boxo/bitswap/message"
+	pb "github.com/ipfs/boxo/bitswap/message/pb"
+	"github.com/ipfs/boxo/bitswap/network"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ipldlegacy "github.com/ipfs/go-ipld-legacy"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Types of the events of a bitswap trace.
+const (
+	BitswapTraceWantSent         = "want-sent"
+	BitswapTraceCancelSent       = "cancel-sent"
+	BitswapTraceHaveReceived     = "have-received"
+	BitswapTraceDontHaveReceived = "dont-have-received"
+	BitswapTraceBlockReceived    = "block-received"
+	BitswapTraceProviderSearch   = "provider-search"
+	BitswapTraceProviderFound    = "provider-found"
+)
+
+// BitswapTraceEvent is an event of the fetch of a block over bitswap.
+type BitswapTraceEvent struct {
+	Time time.Time
+	Type string
+	Cid  string
+	Peer peer.ID `json:",omitempty"`
+	// WantType is "block" or "have", for the wants sent.
+	WantType string `json:",omitempty"`
+	// Latency is, for the blocks received, the time since the block was
+	// first wanted and, for the providers found, since the search started.
+	Latency time.Duration `json:",omitempty"`
+	// Size is the size of the blocks received.
+	Size int `json:",omitempty"`
+	// Duplicate is set on the blocks received while not wanted, such as the
+	// copies received after the first one.
+	Duplicate bool `json:",omitempty"`
+}
+
+// BitswapTracer records the messages bitswap exchanges to fetch blocks, and
+// the provider searches it triggers, for the subscribers of the trace. It
+// does nothing while there are none.
+type BitswapTracer struct {
+	decoder *ipldlegacy.Decoder
+
+	mu   sync.Mutex
+	subs map[*bitswapTraceSub]struct{}
+	// wants are the times of the first want of the CIDs, until the block is
+	// received or the want cancelled, while there are subscribers.
+	wants map[string]time.Time
+}
+
+type bitswapTraceSub struct {
+	// cids are the traced CIDs, all when nil.
+	cids map[string]struct{}
+	ch   chan BitswapTraceEvent
+}
+
+// NewBitswapTracer creates a bitswap tracer.
+func NewBitswapTracer() *BitswapTracer {
+	return &BitswapTracer{
+		decoder: ipldlegacy.NewDecoder(),
+		subs:    make(map[*bitswapTraceSub]struct{}),
+		wants:   make(map[string]time.Time),
+	}
+}
+
+// Subscribe returns the events of the given CIDs and of the blocks they link
+// to, recursively, so that the fetch of a whole DAG is traced. Without CIDs,
+// all the events are returned. Events are dropped when the subscriber does
+// not keep up. The returned function ends the subscription.
+func (t *BitswapTracer) Subscribe(cids []cid.Cid, buffer int) (<-chan BitswapTraceEvent, func()) {
+	sub := &bitswapTraceSub{ch: make(chan BitswapTraceEvent, buffer)}
+	if len(cids) > 0 {
+		sub.cids = make(map[string]struct{}, len(cids))
+		for _, c := range cids {
+			sub.cids[c.KeyString()] = struct{}{}
+		}
+	}
+
+	t.mu.Lock()
+	t.subs[sub] = struct{}{}
+	t.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.subs, sub)
+			if len(t.subs) == 0 {
+				t.wants = make(map[string]time.Time)
+			}
+			close(sub.ch)
+		})
+	}
+}
+
+// WrapNetwork returns a bitswap network reporting its messages and provider
+// searches to the tracer.
+func (t *BitswapTracer) WrapNetwork(n network.BitSwapNetwork) network.BitSwapNetwork {
+	return &tracingNetwork{BitSwapNetwork: n, tracer: t}
+}
+
+// emit sends an event to the subscribers tracing its CID. Called with mu
+// held.
+func (t *BitswapTracer) emit(c cid.Cid, ev BitswapTraceEvent) {
+	k := c.KeyString()
+	for sub := range t.subs {
+		if sub.cids != nil {
+			if _, ok := sub.cids[k]; !ok {
+				continue
+			}
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			logger.Debugf("bitswap trace subscriber too slow, dropped %s event for %s", ev.Type, ev.Cid)
+		}
+	}
+}
+
+func (t *BitswapTracer) messageSent(p peer.ID, msg bsmsg.BitSwapMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.subs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, e := range msg.Wantlist() {
+		ev := BitswapTraceEvent{Time: now, Type: BitswapTraceWantSent, Cid: e.Cid.String(), Peer: p}
+		switch {
+		case e.Cancel:
+			ev.Type = BitswapTraceCancelSent
+		case e.WantType == pb.Message_Wantlist_Have:
+			ev.WantType = "have"
+		default:
+			ev.WantType = "block"
+		}
+		if e.Cancel {
+			delete(t.wants, e.Cid.KeyString())
+		} else if _, ok := t.wants[e.Cid.KeyString()]; !ok {
+			t.wants[e.Cid.KeyString()] = now
+		}
+		t.emit(e.Cid, ev)
+	}
+}
+
+func (t *BitswapTracer) messageReceived(p peer.ID, msg bsmsg.BitSwapMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.subs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, c := range msg.Haves() {
+		t.emit(c, BitswapTraceEvent{Time: now, Type: BitswapTraceHaveReceived, Cid: c.String(), Peer: p})
+	}
+	for _, c := range msg.DontHaves() {
+		t.emit(c, BitswapTraceEvent{Time: now, Type: BitswapTraceDontHaveReceived, Cid: c.String(), Peer: p})
+	}
+	for _, b := range msg.Blocks() {
+		c := b.Cid()
+		ev := BitswapTraceEvent{Time: now, Type: BitswapTraceBlockReceived, Cid: c.String(), Peer: p, Size: len(b.RawData())}
+		if wanted, ok := t.wants[c.KeyString()]; ok {
+			ev.Latency = now.Sub(wanted)
+			delete(t.wants, c.KeyString())
+		} else {
+			ev.Duplicate = true
+		}
+		t.emit(c, ev)
+		t.follow(b)
+	}
+}
+
+// follow adds the links of a traced block to the CIDs traced with it.
+// Called with mu held.
+func (t *BitswapTracer) follow(b blocks.Block) {
+	var links []cid.Cid
+	k := b.Cid().KeyString()
+	for sub := range t.subs {
+		if sub.cids == nil {
+			continue
+		}
+		if _, ok := sub.cids[k]; !ok {
+			continue
+		}
+		if links == nil {
+			nd, err := t.decoder.DecodeNode(context.Background(), b)
+			if err != nil {
+				return
+			}
+			links = make([]cid.Cid, 0, len(nd.Links()))
+			for _, l := range nd.Links() {
+				links = append(links, l.Cid)
+			}
+		}
+		for _, l := range links {
+			sub.cids[l.KeyString()] = struct{}{}
+		}
+	}
+}
+
+func (t *BitswapTracer) providerSearch(c cid.Cid) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.subs) == 0 {
+		return
+	}
+	t.emit(c, BitswapTraceEvent{Time: time.Now(), Type: BitswapTraceProviderSearch, Cid: c.String()})
+}
+
+func (t *BitswapTracer) providerFound(c cid.Cid, p peer.ID, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.subs) == 0 {
+		return
+	}
+	t.emit(c, BitswapTraceEvent{Time: time.Now(), Type: BitswapTraceProviderFound, Cid: c.String(), Peer: p, Latency: latency})
+}
+
+type tracingNetwork struct {
+	network.BitSwapNetwork
+	tracer *BitswapTracer
+}
+
+func (n *tracingNetwork) SendMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) error {
+	n.tracer.messageSent(p, msg)
+	return n.BitSwapNetwork.SendMessage(ctx, p, msg)
+}
+
+func (n *tracingNetwork) NewMessageSender(ctx context.Context, p peer.ID, opts *network.MessageSenderOpts) (network.MessageSender, error) {
+	s, err := n.BitSwapNetwork.NewMessageSender(ctx, p, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tracingMessageSender{MessageSender: s, peer: p, tracer: n.tracer}, nil
+}
+
+func (n *tracingNetwork) Start(receivers ...network.Receiver) {
+	wrapped := make([]network.Receiver, len(receivers))
+	for i, r := range receivers {
+		wrapped[i] = &tracingReceiver{Receiver: r, tracer: n.tracer}
+	}
+	n.BitSwapNetwork.Start(wrapped...)
+}
+
+func (n *tracingNetwork) FindProvidersAsync(ctx context.Context, c cid.Cid, max int) <-chan peer.ID {
+	n.tracer.providerSearch(c)
+	start := time.Now()
+	providers := n.BitSwapNetwork.FindProvidersAsync(ctx, c, max)
+	out := make(chan peer.ID)
+	go func() {
+		defer close(out)
+		for p := range providers {
+			n.tracer.providerFound(c, p, time.Since(start))
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+type tracingMessageSender struct {
+	network.MessageSender
+	peer   peer.ID
+	tracer *BitswapTracer
+}
+
+func (s *tracingMessageSender) SendMsg(ctx context.Context, msg bsmsg.BitSwapMessage) error {
+	s.tracer.messageSent(s.peer, msg)
+	return s.MessageSender.SendMsg(ctx, msg)
+}
+
+type tracingReceiver struct {
+	network.Receiver
+	tracer *BitswapTracer
+}
+
+func (r *tracingReceiver) ReceiveMessage(ctx context.Context, p peer.ID, msg bsmsg.BitSwapMessage) {
+	r.tracer.messageReceived(p, msg)
+	r.Receiver.ReceiveMessage(ctx, p, msg)
+}
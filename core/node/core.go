@@ -28,8 +28,21 @@ import (
 	"github.com/ipfs/kubo/repo"
 )
 
+type blockServiceIn struct {
+	fx.In
+
+	Ledger *BitswapLedger `optional:"true"`
+	HTTP   *HTTPRetriever `optional:"true"`
+}
+
 // BlockService creates new blockservice which provides an interface to fetch content-addressable blocks
-func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interface) blockservice.BlockService {
+func BlockService(lc fx.Lifecycle, bs blockstore.Blockstore, rem exchange.Interface, in blockServiceIn) blockservice.BlockService {
+	if in.HTTP != nil {
+		rem = in.HTTP.WrapExchange(rem)
+	}
+	if in.Ledger != nil {
+		rem = in.Ledger.WrapExchange(rem)
+	}
 	bsvc := blockservice.New(bs, rem)
 
 	lc.Append(fx.Hook{
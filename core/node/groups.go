@@ -15,6 +15,7 @@ import (
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/node/libp2p"
 	"github.com/ipfs/kubo/p2p"
+	irouting "github.com/ipfs/kubo/routing"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p-pubsub/timecache"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -47,7 +48,12 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config, userResourceOverrides rcmgr.Part
 		grace := cfg.Swarm.ConnMgr.GracePeriod.WithDefault(config.DefaultConnMgrGracePeriod)
 		low := int(cfg.Swarm.ConnMgr.LowWater.WithDefault(config.DefaultConnMgrLowWater))
 		high := int(cfg.Swarm.ConnMgr.HighWater.WithDefault(config.DefaultConnMgrHighWater))
-		connmgr = fx.Provide(libp2p.ConnectionManager(low, high, grace))
+		// schedules can only lower the watermarks at runtime
+		low, high = scheduleConnMgrWatermarks(cfg, low, high)
+		connmgr = fx.Options(
+			fx.Provide(libp2p.ConnectionManager(low, high, grace)),
+			fx.Provide(libp2p.PeerProtection(cfg.Swarm.ConnMgr.ProtectedPeers)),
+		)
 	default:
 		return fx.Error(fmt.Errorf("unrecognized Swarm.ConnMgr.Type: %q", connMgrType))
 	}
@@ -111,6 +117,10 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config, userResourceOverrides rcmgr.Part
 	enableRelayTransport := cfg.Swarm.Transports.Network.Relay.WithDefault(true) // nolint
 	enableRelayService := cfg.Swarm.RelayService.Enabled.WithDefault(enableRelayTransport)
 	enableRelayClient := cfg.Swarm.RelayClient.Enabled.WithDefault(enableRelayTransport)
+	// the relay service is only wrapped when the reservations are accounted
+	// for or restricted
+	relayService := cfg.Swarm.RelayService
+	enableRelayStats := enableRelayService && (relayService.Accounting.WithDefault(false) || len(relayService.ReservationAllowList) > 0)
 
 	// Log error when relay subsystem could not be initialized due to missing dependency
 	if !enableRelayTransport {
@@ -122,6 +132,16 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config, userResourceOverrides rcmgr.Part
 		}
 	}
 
+	// streams are only throttled when some limit is configured
+	bwLimits := cfg.Swarm.BandwidthLimits
+	enableBandwidthLimits := bwLimits.RateIn != nil || bwLimits.RateOut != nil || len(bwLimits.Protocols) > 0
+	for _, s := range cfg.Schedules {
+		enableBandwidthLimits = enableBandwidthLimits || s.BandwidthLimits != nil
+	}
+
+	allowList := cfg.Swarm.PeerAllowList
+	enableAllowList := allowList.Enabled.WithDefault(false)
+
 	// Gather all the options
 	opts := fx.Options(
 		BaseLibP2P,
@@ -132,25 +152,35 @@ func LibP2P(bcfg *BuildCfg, cfg *config.Config, userResourceOverrides rcmgr.Part
 		// Services (resource management)
 		fx.Provide(libp2p.ResourceManager(cfg.Swarm, userResourceOverrides)),
 		fx.Provide(libp2p.AddrFilters(cfg.Swarm.AddrFilters)),
+		fx.Provide(libp2p.Bans),
+		maybeProvide(libp2p.PeerAllowList(allowList), enableAllowList),
+		maybeInvoke(libp2p.WatchAllowList, enableAllowList && allowList.File.WithDefault("") != ""),
+		maybeProvide(libp2p.ConnectionHistory(cfg.Swarm.ConnectionHistory), cfg.Swarm.ConnectionHistory.Enabled.WithDefault(false)),
+		fx.Provide(libp2p.ConnectionGater),
 		fx.Provide(libp2p.AddrsFactory(cfg.Addresses.Announce, cfg.Addresses.AppendAnnounce, cfg.Addresses.NoAnnounce)),
 		fx.Provide(libp2p.SmuxTransport(cfg.Swarm.Transports)),
 		fx.Provide(libp2p.RelayTransport(enableRelayTransport)),
+		maybeProvide(libp2p.RelayStats(cfg.Swarm.RelayService), enableRelayStats),
 		fx.Provide(libp2p.RelayService(enableRelayService, cfg.Swarm.RelayService)),
 		fx.Provide(libp2p.Transports(cfg.Swarm.Transports)),
 		fx.Provide(libp2p.ListenOn(cfg.Addresses.Swarm)),
 		fx.Invoke(libp2p.SetupDiscovery(cfg.Discovery.MDNS.Enabled)),
 		fx.Provide(libp2p.ForceReachability(cfg.Internal.Libp2pForceReachability)),
+		fx.Provide(libp2p.NewReachabilityMonitor),
+		fx.Invoke(libp2p.MonitorReachability),
 		fx.Provide(libp2p.HolePunching(cfg.Swarm.EnableHolePunching, enableRelayClient)),
 
 		fx.Provide(libp2p.Security(!bcfg.DisableEncryptedConnections, cfg.Swarm.Transports)),
 
 		fx.Provide(libp2p.Routing),
 		fx.Provide(libp2p.ContentRouting),
+		maybeProvide(irouting.NewProvideTracker, cfg.Routing.TrackProvides.WithDefault(false)),
 
 		fx.Provide(libp2p.BaseRouting(cfg)),
 		maybeProvide(libp2p.PubsubRouter, bcfg.getOpt("ipnsps")),
 
 		maybeProvide(libp2p.BandwidthCounter, !cfg.Swarm.DisableBandwidthMetrics),
+		maybeProvide(libp2p.BandwidthLimits(bwLimits), enableBandwidthLimits),
 		maybeProvide(libp2p.NatPortMap, !cfg.Swarm.DisableNatPortMap),
 		libp2p.MaybeAutoRelay(cfg.Swarm.RelayClient.StaticRelays, cfg.Peering, enableRelayClient),
 		autonat,
@@ -200,12 +230,19 @@ func Identity(cfg *config.Config) fx.Option {
 		return fx.Error(fmt.Errorf("peer ID invalid: %s", err))
 	}
 
+	pstore := fx.Provide(libp2p.Peerstore)
+	if cfg.Swarm.Peerstore.Persist.WithDefault(false) {
+		gcInterval := cfg.Swarm.Peerstore.GCInterval.WithDefault(config.DefaultPeerstoreGCInterval)
+		addrTTL := cfg.Swarm.Peerstore.DisconnectedAddrTTL.WithDefault(config.DefaultPeerstoreDisconnectedAddrTTL)
+		pstore = fx.Provide(libp2p.PersistentPeerstore(gcInterval, addrTTL))
+	}
+
 	// Private Key
 
 	if cfg.Identity.PrivKey == "" {
 		return fx.Options( // No PK (usually in tests)
 			fx.Provide(PeerID(id)),
-			fx.Provide(libp2p.Peerstore),
+			pstore,
 		)
 	}
 
@@ -217,7 +254,7 @@ func Identity(cfg *config.Config) fx.Option {
 	return fx.Options( // Full identity
 		fx.Provide(PeerID(id)),
 		fx.Provide(PrivateKey(sk)),
-		fx.Provide(libp2p.Peerstore),
+		pstore,
 
 		fx.Invoke(libp2p.PstoreAddSelfKeys),
 	)
@@ -271,11 +308,17 @@ func Online(bcfg *BuildCfg, cfg *config.Config, userResourceOverrides rcmgr.Part
 
 	return fx.Options(
 		fx.Provide(BitswapOptions(cfg, shouldBitswapProvide)),
+		fx.Provide(NewBitswapTracer),
+		fx.Provide(BitswapLedgers(cfg.Bitswap)),
+		maybeProvide(BitswapPrioritization(cfg), len(cfg.Bitswap.Prioritization.Policies) > 0),
 		fx.Provide(OnlineExchange()),
+		maybeProvide(HTTPRetrieval(cfg.HTTPRetrieval), cfg.HTTPRetrieval.Enabled.WithDefault(false)),
+		ServePolicies(cfg.Bitswap.ServePolicies),
 		fx.Provide(DNSResolver),
 		fx.Provide(Namesys(ipnsCacheSize, cfg.Ipns.MaxCacheTTL.WithDefault(config.DefaultIpnsMaxCacheTTL))),
 		fx.Provide(Peering),
 		PeerWith(cfg.Peering.Peers...),
+		maybeProvide(PinSync(cfg.Pinning.Sync, cfg.Peering), cfg.Pinning.Sync.Enabled.WithDefault(false)),
 
 		fx.Invoke(IpnsRepublisher(repubPeriod, recordLifetime)),
 
@@ -288,6 +331,7 @@ func Online(bcfg *BuildCfg, cfg *config.Config, userResourceOverrides rcmgr.Part
 			cfg.Reprovider.Interval.WithDefault(config.DefaultReproviderInterval),
 			cfg.Routing.AcceleratedDHTClient.WithDefault(config.DefaultAcceleratedDHTClient),
 		),
+		Schedules(cfg),
 	)
 }
 
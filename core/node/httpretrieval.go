@@ -0,0 +1,686 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	exchange "github.com/ipfs/boxo/exchange"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/libp2p/go-libp2p/core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+
+	version "github.com/ipfs/kubo"
+	"github.com/ipfs/kubo/config"
+	irouting "github.com/ipfs/kubo/routing"
+)
+
+const (
+	// httpRetrievalConcurrency is the number of requests to the gateways
+	// at once.
+	httpRetrievalConcurrency = 16
+	// httpRetrievalMaxBlockSize is the size above which a block from a
+	// gateway is rejected.
+	httpRetrievalMaxBlockSize = 4 << 20
+	// httpRetrievalMaxCARSize is the size of the blocks of a CAR kept by a
+	// session until they are asked for. The rest of the CAR is not fetched.
+	httpRetrievalMaxCARSize = 64 << 20
+	// httpRetrievalMaxCacheSize is the size of the blocks of the CARs kept
+	// by all the sessions. The CARs are not fetched further above it.
+	httpRetrievalMaxCacheSize = 256 << 20
+)
+
+var errNoGateway = errors.New("no trustless gateway to fetch from")
+
+// HTTPRetriever fetches blocks from HTTP trustless gateways, configured or
+// found by the routing system, in parallel with bitswap. Every block is
+// checked against its CID.
+type HTTPRetriever struct {
+	gateways   []string
+	router     routing.ContentRouting // nil when the routing system is not used
+	maxRouting int
+	timeout    time.Duration
+	client     *http.Client
+	slots      chan struct{}
+	cached     atomic.Int64 // size of the blocks of the CARs of the sessions
+}
+
+// NewHTTPRetriever creates a retriever fetching from the given gateways and,
+// when router is not nil, from up to maxRouting providers with HTTP addresses
+// for each DAG.
+func NewHTTPRetriever(gateways []string, router routing.ContentRouting, maxRouting int, timeout time.Duration) *HTTPRetriever {
+	trimmed := make([]string, len(gateways))
+	for i, g := range gateways {
+		trimmed[i] = strings.TrimSuffix(g, "/")
+	}
+	return &HTTPRetriever{
+		gateways:   trimmed,
+		router:     router,
+		maxRouting: maxRouting,
+		timeout:    timeout,
+		client:     &http.Client{},
+		slots:      make(chan struct{}, httpRetrievalConcurrency),
+	}
+}
+
+// HTTPRetrieval constructs the retriever configured by cfg.
+func HTTPRetrieval(cfg config.HTTPRetrieval) interface{} {
+	return func(rt irouting.ProvideManyRouter) (*HTTPRetriever, error) {
+		for _, g := range cfg.Gateways {
+			u, err := url.Parse(g)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("invalid trustless gateway URL %q in HTTPRetrieval.Gateways", g)
+			}
+		}
+		useRouting := cfg.UseRouting.WithDefault(false)
+		if len(cfg.Gateways) == 0 && !useRouting {
+			return nil, errors.New("HTTPRetrieval is enabled without Gateways nor UseRouting")
+		}
+
+		var router routing.ContentRouting
+		if useRouting {
+			router = rt
+		}
+		return NewHTTPRetriever(
+			cfg.Gateways,
+			router,
+			int(cfg.MaxRoutingGateways.WithDefault(config.DefaultHTTPRetrievalMaxRoutingGateways)),
+			cfg.Timeout.WithDefault(config.DefaultHTTPRetrievalTimeout),
+		), nil
+	}
+}
+
+// WrapExchange returns an exchange fetching the blocks from the gateways as
+// well as with ex, returning the first copy received.
+func (r *HTTPRetriever) WrapExchange(ex exchange.Interface) exchange.Interface {
+	hx := &httpExchange{Interface: ex, retriever: r}
+	if sx, ok := ex.(exchange.SessionExchange); ok {
+		return &httpSessionExchange{httpExchange: hx, sessions: sx}
+	}
+	return hx
+}
+
+// reserve takes room for n bytes of CAR blocks from the budget of all the
+// sessions, and returns false when there is not enough.
+func (r *HTTPRetriever) reserve(n int) bool {
+	for {
+		cached := r.cached.Load()
+		if cached+int64(n) > httpRetrievalMaxCacheSize {
+			return false
+		}
+		if r.cached.CompareAndSwap(cached, cached+int64(n)) {
+			return true
+		}
+	}
+}
+
+// release gives back n bytes reserved with reserve.
+func (r *HTTPRetriever) release(n int) {
+	r.cached.Add(-int64(n))
+}
+
+// acquire waits for a free request slot, and returns the function releasing
+// it.
+func (r *HTTPRetriever) acquire(ctx context.Context) (func(), error) {
+	select {
+	case r.slots <- struct{}{}:
+		return func() { <-r.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (r *HTTPRetriever) get(ctx context.Context, u, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", version.GetUserAgentVersion())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return resp, nil
+}
+
+// FetchBlock fetches the block c from gateway, and checks its hash.
+func (r *HTTPRetriever) FetchBlock(ctx context.Context, gateway string, c cid.Cid) (blocks.Block, error) {
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	resp, err := r.get(ctx, gateway+"/ipfs/"+c.String()+"?format=raw", "application/vnd.ipld.raw")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, httpRetrievalMaxBlockSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > httpRetrievalMaxBlockSize {
+		return nil, fmt.Errorf("block %s from %s is larger than %d bytes", c, gateway, httpRetrievalMaxBlockSize)
+	}
+	sum, err := c.Prefix().Sum(data)
+	if err != nil {
+		return nil, err
+	}
+	if !sum.Equals(c) {
+		return nil, fmt.Errorf("block %s from %s: %w", c, gateway, blocks.ErrWrongHash)
+	}
+	return blocks.NewBlockWithCid(data, c)
+}
+
+// FetchCAR fetches the DAG of root from gateway, calling fn with each of its
+// blocks once its hash is checked, until fn returns false.
+func (r *HTTPRetriever) FetchCAR(ctx context.Context, gateway string, root cid.Cid, fn func(blocks.Block) bool) error {
+	release, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	resp, err := r.get(ctx, gateway+"/ipfs/"+root.String()+"?format=car&dag-scope=all", "application/vnd.ipld.car;version=1;order=dfs;dups=n")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The block reader checks the hash of each block, the CAR being untrusted.
+	br, err := carv2.NewBlockReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	for {
+		b, err := br.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(b) {
+			return nil
+		}
+	}
+}
+
+// fetcher returns a function fetching blocks from the configured gateways,
+// and then from the gateways of the providers of root found by the routing
+// system. They are looked up once for all the blocks, the first time one is
+// missing from the configured gateways.
+func (r *HTTPRetriever) fetcher(root cid.Cid) func(context.Context, cid.Cid) (blocks.Block, error) {
+	var lookup sync.Once
+	var found []string
+	return func(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+		if b, err := r.fetchFrom(ctx, r.gateways, c); err == nil {
+			return b, nil
+		}
+		lookup.Do(func() {
+			found = r.findGateways(ctx, root)
+		})
+		return r.fetchFrom(ctx, found, c)
+	}
+}
+
+// fetchFrom fetches c from the first of the gateways that has it.
+func (r *HTTPRetriever) fetchFrom(ctx context.Context, gateways []string, c cid.Cid) (blocks.Block, error) {
+	err := errNoGateway
+	for _, g := range gateways {
+		var b blocks.Block
+		b, err = r.FetchBlock(ctx, g, c)
+		if err == nil {
+			return b, nil
+		}
+		logger.Debugf("fetching %s over HTTP: %s", c, err)
+	}
+	return nil, err
+}
+
+// findGateways returns the trustless gateways of the providers of c found by
+// the routing system, other than the configured ones.
+func (r *HTTPRetriever) findGateways(ctx context.Context, c cid.Cid) []string {
+	if r.router == nil || r.maxRouting <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	seen := make(map[string]struct{}, len(r.gateways))
+	for _, g := range r.gateways {
+		seen[g] = struct{}{}
+	}
+	var found []string
+	for ai := range r.router.FindProvidersAsync(ctx, c, 0) {
+		for _, a := range ai.Addrs {
+			u, ok := gatewayURL(a)
+			if !ok {
+				continue
+			}
+			if _, ok := seen[u]; !ok {
+				seen[u] = struct{}{}
+				found = append(found, u)
+			}
+			break
+		}
+		if len(found) >= r.maxRouting {
+			break
+		}
+	}
+	return found
+}
+
+// gatewayURL returns the URL of an HTTP multiaddr, such as
+// /dns4/example.com/tcp/443/https.
+func gatewayURL(addr ma.Multiaddr) (string, bool) {
+	var host, port, scheme string
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_DNS, ma.P_DNS4, ma.P_DNS6:
+			host = c.Value()
+		case ma.P_IP6:
+			host = "[" + c.Value() + "]"
+		case ma.P_TCP:
+			port = c.Value()
+		case ma.P_TLS, ma.P_HTTPS:
+			scheme = "https"
+		case ma.P_HTTP:
+			if scheme == "" {
+				scheme = "http"
+			}
+		}
+		return true
+	})
+	if host == "" || scheme == "" {
+		return "", false
+	}
+	if port != "" && !(scheme == "https" && port == "443") && !(scheme == "http" && port == "80") {
+		host += ":" + port
+	}
+	return scheme + "://" + host, true
+}
+
+// firstBlock returns the block of the first fetch succeeding, cancelling the
+// others. When they all fail, it returns the error of the first one.
+func firstBlock(ctx context.Context, fetches ...func(context.Context) (blocks.Block, error)) (blocks.Block, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		i   int
+		b   blocks.Block
+		err error
+	}
+	results := make(chan result, len(fetches))
+	for i, fetch := range fetches {
+		go func() {
+			b, err := fetch(ctx)
+			results <- result{i, b, err}
+		}()
+	}
+	errs := make([]error, len(fetches))
+	for range fetches {
+		res := <-results
+		if res.err == nil {
+			return res.b, nil
+		}
+		errs[res.i] = res.err
+	}
+	return nil, errs[0]
+}
+
+// raceBlocks fetches the blocks ks with getBlocks and, one by one, with
+// fetchHTTP, returning the first copy of each block. The HTTP fetches are
+// made by up to httpRetrievalConcurrency workers, which skip the blocks
+// already received.
+func raceBlocks(ctx context.Context, ks []cid.Cid, getBlocks func(context.Context, []cid.Cid) (<-chan blocks.Block, error), fetchHTTP func(context.Context, cid.Cid) (blocks.Block, error)) (<-chan blocks.Block, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	fetched, err := getBlocks(ctx, ks)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	wanted := make(map[cid.Cid]struct{}, len(ks))
+	for _, c := range ks {
+		wanted[c] = struct{}{}
+	}
+	isWanted := func(c cid.Cid) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		_, ok := wanted[c]
+		return ok
+	}
+
+	pending := make(chan cid.Cid, len(wanted))
+	for c := range wanted {
+		pending <- c
+	}
+	close(pending)
+	fromHTTP := make(chan blocks.Block)
+	var wg sync.WaitGroup
+	for i := 0; i < min(len(wanted), httpRetrievalConcurrency); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range pending {
+				if !isWanted(c) {
+					continue
+				}
+				b, err := fetchHTTP(ctx, c)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					continue
+				}
+				select {
+				case fromHTTP <- b:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(fromHTTP)
+	}()
+
+	out := make(chan blocks.Block)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for fetched != nil || fromHTTP != nil {
+			var b blocks.Block
+			var ok bool
+			select {
+			case b, ok = <-fetched:
+				if !ok {
+					fetched = nil
+					continue
+				}
+			case b, ok = <-fromHTTP:
+				if !ok {
+					fromHTTP = nil
+					continue
+				}
+			}
+			mu.Lock()
+			_, first := wanted[b.Cid()]
+			delete(wanted, b.Cid())
+			left := len(wanted)
+			mu.Unlock()
+			if !first {
+				continue
+			}
+			select {
+			case out <- b:
+			case <-ctx.Done():
+				return
+			}
+			if left == 0 {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+type httpExchange struct {
+	exchange.Interface
+	retriever *HTTPRetriever
+}
+
+func (e *httpExchange) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	fetch := e.retriever.fetcher(c)
+	return firstBlock(ctx,
+		func(ctx context.Context) (blocks.Block, error) {
+			return e.Interface.GetBlock(ctx, c)
+		},
+		func(ctx context.Context) (blocks.Block, error) {
+			return fetch(ctx, c)
+		},
+	)
+}
+
+// GetBlocks takes the first block as the root of the others, and only looks
+// up the gateways of its providers.
+func (e *httpExchange) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	if len(ks) == 0 {
+		return e.Interface.GetBlocks(ctx, ks)
+	}
+	return raceBlocks(ctx, ks, e.Interface.GetBlocks, e.retriever.fetcher(ks[0]))
+}
+
+type httpSessionExchange struct {
+	*httpExchange
+	sessions exchange.SessionExchange
+}
+
+func (e *httpSessionExchange) NewSession(ctx context.Context) exchange.Fetcher {
+	s := &httpSession{
+		Fetcher:    e.sessions.NewSession(ctx),
+		retriever:  e.retriever,
+		ctx:        ctx,
+		gateways:   e.retriever.gateways,
+		cache:      make(map[cid.Cid]blocks.Block),
+		changed:    make(chan struct{}),
+		carDone:    true,
+		discovered: true,
+	}
+	context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.retriever.release(s.cacheSize)
+		s.cache, s.cacheSize = nil, 0
+	})
+	return s
+}
+
+// httpSession fetches the blocks of a session from the gateways in parallel
+// with bitswap. The first block asked for is taken as the root of the DAG
+// of the session: the gateways of its providers are looked up, and its DAG
+// is fetched as a CAR, from which the blocks are taken while it lasts.
+type httpSession struct {
+	exchange.Fetcher
+	retriever *HTTPRetriever
+	ctx       context.Context
+	start     sync.Once
+
+	mu        sync.Mutex
+	gateways  []string
+	cache     map[cid.Cid]blocks.Block // blocks of the CAR not asked for yet
+	cacheSize int
+	// changed is closed, and replaced, when the gateways, the cache or
+	// the states below change.
+	changed    chan struct{}
+	carDone    bool
+	discovered bool
+}
+
+// notify wakes up the fetches waiting for a change. Called with mu held.
+func (s *httpSession) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// begin starts the lookup of the gateways and the fetch of the CAR of root.
+func (s *httpSession) begin(root cid.Cid) {
+	s.start.Do(func() {
+		s.mu.Lock()
+		s.carDone = false
+		s.discovered = s.retriever.router == nil
+		s.mu.Unlock()
+		if !s.discovered {
+			go s.discover(root)
+		}
+		go s.prefetch(root)
+	})
+}
+
+func (s *httpSession) discover(root cid.Cid) {
+	found := s.retriever.findGateways(s.ctx, root)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gateways = append(s.gateways[:len(s.gateways):len(s.gateways)], found...)
+	s.discovered = true
+	s.notify()
+}
+
+// prefetch fetches the CAR of root from the first gateway that has it.
+func (s *httpSession) prefetch(root cid.Cid) {
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.carDone = true
+		s.notify()
+	}()
+
+	tried := make(map[string]struct{})
+	for {
+		s.mu.Lock()
+		next := s.untried(tried)
+		changed, discovered := s.changed, s.discovered
+		s.mu.Unlock()
+
+		if next == "" {
+			if discovered {
+				return
+			}
+			select {
+			case <-changed:
+				continue
+			case <-s.ctx.Done():
+				return
+			}
+		}
+
+		tried[next] = struct{}{}
+		var received bool
+		err := s.retriever.FetchCAR(s.ctx, next, root, func(b blocks.Block) bool {
+			received = true
+			return s.add(b)
+		})
+		if err == nil || received {
+			if err != nil {
+				logger.Debugf("fetching the CAR of %s from %s: %s", root, next, err)
+			}
+			return
+		}
+		logger.Debugf("fetching the CAR of %s from %s: %s", root, next, err)
+	}
+}
+
+// untried returns the first gateway not tried yet. Called with mu held.
+func (s *httpSession) untried(tried map[string]struct{}) string {
+	for _, g := range s.gateways {
+		if _, ok := tried[g]; !ok {
+			return g
+		}
+	}
+	return ""
+}
+
+// add caches a block of the CAR, and returns whether there is room for more,
+// in the session and in the budget of all the sessions.
+func (s *httpSession) add(b blocks.Block) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cache == nil {
+		return false
+	}
+	if _, ok := s.cache[b.Cid()]; !ok {
+		size := len(b.RawData())
+		if !s.retriever.reserve(size) {
+			return false
+		}
+		s.cache[b.Cid()] = b
+		s.cacheSize += size
+		s.notify()
+	}
+	return s.cacheSize < httpRetrievalMaxCARSize
+}
+
+// fetchHTTP takes c from the CAR of the session while it is fetched, and
+// then fetches it from the gateways one after the other.
+func (s *httpSession) fetchHTTP(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	tried := make(map[string]struct{})
+	err := errNoGateway
+	for {
+		s.mu.Lock()
+		if b, ok := s.cache[c]; ok {
+			delete(s.cache, c)
+			s.cacheSize -= len(b.RawData())
+			s.retriever.release(len(b.RawData()))
+			s.mu.Unlock()
+			return b, nil
+		}
+		var next string
+		if s.carDone {
+			next = s.untried(tried)
+		}
+		changed, done := s.changed, s.carDone && s.discovered
+		s.mu.Unlock()
+
+		if next != "" {
+			tried[next] = struct{}{}
+			var b blocks.Block
+			b, err = s.retriever.FetchBlock(ctx, next, c)
+			if err == nil {
+				return b, nil
+			}
+			logger.Debugf("fetching %s over HTTP: %s", c, err)
+			continue
+		}
+		if done {
+			return nil, err
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *httpSession) GetBlock(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+	s.begin(c)
+	return firstBlock(ctx,
+		func(ctx context.Context) (blocks.Block, error) {
+			return s.Fetcher.GetBlock(ctx, c)
+		},
+		func(ctx context.Context) (blocks.Block, error) {
+			return s.fetchHTTP(ctx, c)
+		},
+	)
+}
+
+func (s *httpSession) GetBlocks(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+	if len(ks) > 0 {
+		s.begin(ks[0])
+	}
+	return raceBlocks(ctx, ks, s.Fetcher.GetBlocks, s.fetchHTTP)
+}
@@ -0,0 +1,107 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRaceBlocks(t *testing.T) {
+	var ks []cid.Cid
+	byCid := make(map[cid.Cid]blocks.Block)
+	for i := 0; i < 40; i++ {
+		b := blocks.NewBlock([]byte{byte(i)})
+		ks = append(ks, b.Cid())
+		byCid[b.Cid()] = b
+	}
+
+	// the wrapped fetcher has the first half of the blocks, and the gateways
+	// all but the last one
+	getBlocks := func(ctx context.Context, ks []cid.Cid) (<-chan blocks.Block, error) {
+		out := make(chan blocks.Block)
+		go func() {
+			defer close(out)
+			for _, c := range ks[:len(ks)/2] {
+				select {
+				case out <- byCid[c]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			<-ctx.Done()
+		}()
+		return out, nil
+	}
+	fetchHTTP := func(ctx context.Context, c cid.Cid) (blocks.Block, error) {
+		if c == ks[len(ks)-1] {
+			return nil, errNoGateway
+		}
+		return byCid[c], nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out, err := raceBlocks(ctx, ks, getBlocks, fetchHTTP)
+	require.NoError(t, err)
+	received := make(map[cid.Cid]int)
+	for b := range out {
+		received[b.Cid()]++
+		if len(received) == len(ks)-1 {
+			cancel()
+		}
+	}
+	assert.Len(t, received, len(ks)-1)
+	for c, n := range received {
+		assert.Equal(t, 1, n, "block %s received more than once", c)
+	}
+
+	_, err = raceBlocks(context.Background(), ks, func(context.Context, []cid.Cid) (<-chan blocks.Block, error) {
+		return nil, errors.New("offline")
+	}, fetchHTTP)
+	assert.Error(t, err)
+}
+
+func TestHTTPRetrieverReserve(t *testing.T) {
+	r := NewHTTPRetriever(nil, nil, 0, 0)
+	assert.True(t, r.reserve(httpRetrievalMaxCacheSize))
+	assert.False(t, r.reserve(1))
+	r.release(1)
+	assert.True(t, r.reserve(1))
+}
+
+type countingRouter struct {
+	routing.ContentRouting
+	lookups atomic.Int32
+}
+
+func (r *countingRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, n int) <-chan peer.AddrInfo {
+	r.lookups.Add(1)
+	ch := make(chan peer.AddrInfo)
+	close(ch)
+	return ch
+}
+
+func TestHTTPRetrieverLooksUpOnce(t *testing.T) {
+	router := new(countingRouter)
+	r := NewHTTPRetriever(nil, router, 4, time.Second)
+
+	var ks []cid.Cid
+	for i := 0; i < 10; i++ {
+		ks = append(ks, blocks.NewBlock([]byte{byte(i)}).Cid())
+	}
+	fetch := r.fetcher(ks[0])
+	for _, c := range ks {
+		_, err := fetch(context.Background(), c)
+		assert.ErrorIs(t, err, errNoGateway)
+	}
+	assert.EqualValues(t, 1, router.lookups.Load())
+}
@@ -0,0 +1,295 @@
+package libp2p
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+)
+
+// allowListPollInterval is how often the allow-list file is checked for
+// changes.
+const allowListPollInterval = 5 * time.Second
+
+// dhtOnlyProtocols are the protocols allowed with the peers not in the
+// allow-list when Swarm.PeerAllowList.AllowDHT is set.
+var dhtOnlyProtocols = append([]protocol.ID{"/ipfs/id/", "/ipfs/ping/"}, bandwidthProtocols["dht"]...)
+
+// AllowList refuses connections to and from the peers not in
+// Swarm.PeerAllowList, or restricts them to the DHT.
+type AllowList struct {
+	static   map[peer.ID]struct{}
+	file     string
+	allowDHT bool
+
+	mu      sync.RWMutex
+	peers   map[peer.ID]struct{}
+	modTime time.Time
+}
+
+var _ connmgr.ConnectionGater = (*AllowList)(nil)
+
+// NewAllowList creates the allow-list of cfg. A relative file path is
+// resolved against repoPath.
+func NewAllowList(cfg config.PeerAllowList, repoPath string) (*AllowList, error) {
+	a := &AllowList{
+		static:   make(map[peer.ID]struct{}, len(cfg.Peers)),
+		allowDHT: cfg.AllowDHT.WithDefault(false),
+	}
+	for _, p := range cfg.Peers {
+		a.static[p] = struct{}{}
+	}
+	if file := cfg.File.WithDefault(""); file != "" {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(repoPath, file)
+		}
+		a.file = file
+	}
+	if _, err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// PeerAllowList constructs the allow-list of the swarm.
+func PeerAllowList(cfg config.PeerAllowList) interface{} {
+	return func() (*AllowList, error) {
+		repoPath, err := config.PathRoot()
+		if err != nil {
+			return nil, err
+		}
+		a, err := NewAllowList(cfg, repoPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(a.Peers()) == 0 {
+			log.Warn("Swarm.PeerAllowList is enabled, but no peer is allowed")
+		}
+		return a, nil
+	}
+}
+
+// WatchAllowList reloads the allow-list file when it changes.
+func WatchAllowList(lc fx.Lifecycle, a *AllowList, h host.Host) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				defer close(done)
+				a.watch(ctx, h)
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			cancel()
+			<-done
+			return nil
+		},
+	})
+}
+
+// readAllowListFile reads one peer ID per line, ignoring empty lines and
+// comments starting with #.
+func readAllowListFile(path string) (map[peer.ID]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	peers := make(map[peer.ID]struct{})
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s, _, _ := strings.Cut(scanner.Text(), "#")
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid peer ID %q", path, line, s)
+		}
+		peers[p] = struct{}{}
+	}
+	return peers, scanner.Err()
+}
+
+// reload reads the allow-list file if it changed since the last reload, and
+// reports whether the list changed.
+func (a *AllowList) reload() (bool, error) {
+	peers := make(map[peer.ID]struct{}, len(a.static))
+	for p := range a.static {
+		peers[p] = struct{}{}
+	}
+
+	var modTime time.Time
+	if a.file != "" {
+		fi, err := os.Stat(a.file)
+		if err != nil {
+			return false, fmt.Errorf("reading Swarm.PeerAllowList.File: %w", err)
+		}
+		modTime = fi.ModTime()
+
+		a.mu.RLock()
+		unchanged := a.peers != nil && modTime.Equal(a.modTime)
+		a.mu.RUnlock()
+		if unchanged {
+			return false, nil
+		}
+
+		filePeers, err := readAllowListFile(a.file)
+		if err != nil {
+			return false, fmt.Errorf("reading Swarm.PeerAllowList.File: %w", err)
+		}
+		for p := range filePeers {
+			peers[p] = struct{}{}
+		}
+	}
+
+	a.mu.Lock()
+	a.peers = peers
+	a.modTime = modTime
+	a.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads the allow-list file when it changes, and closes the
+// connections to the peers no longer allowed.
+func (a *AllowList) watch(ctx context.Context, h host.Host) {
+	ticker := time.NewTicker(allowListPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := a.reload()
+		if err != nil {
+			log.Errorf("keeping the previous allow-list: %s", err)
+			continue
+		}
+		if !changed || a.allowDHT {
+			continue
+		}
+		for _, p := range h.Network().Peers() {
+			if !a.Allowed(p) {
+				_ = h.Network().ClosePeer(p)
+			}
+		}
+	}
+}
+
+// Allowed reports whether a peer is in the allow-list.
+func (a *AllowList) Allowed(p peer.ID) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.peers[p]
+	return ok
+}
+
+// Peers returns the allowed peers.
+func (a *AllowList) Peers() []peer.ID {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	peers := make([]peer.ID, 0, len(a.peers))
+	for p := range a.peers {
+		peers = append(peers, p)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i] < peers[j]
+	})
+	return peers
+}
+
+// ProtocolAllowed reports whether streams of a protocol are allowed with a
+// peer.
+func (a *AllowList) ProtocolAllowed(p peer.ID, proto protocol.ID) bool {
+	if a.Allowed(p) {
+		return true
+	}
+	if !a.allowDHT {
+		return false
+	}
+	for _, prefix := range dhtOnlyProtocols {
+		if strings.HasPrefix(string(proto), string(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AllowList) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) (allow bool) {
+	return a.allowDHT || a.Allowed(p)
+}
+
+func (a *AllowList) InterceptPeerDial(p peer.ID) (allow bool) {
+	return a.allowDHT || a.Allowed(p)
+}
+
+func (a *AllowList) InterceptAccept(_ network.ConnMultiaddrs) (allow bool) {
+	return true
+}
+
+func (a *AllowList) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) (allow bool) {
+	return a.allowDHT || a.Allowed(p)
+}
+
+func (a *AllowList) InterceptUpgraded(_ network.Conn) (allow bool, reason control.DisconnectReason) {
+	return true, 0
+}
+
+// WrapResourceManager returns a resource manager refusing the streams of the
+// protocols not allowed with their peer.
+func (a *AllowList) WrapResourceManager(rm network.ResourceManager) network.ResourceManager {
+	if !a.allowDHT {
+		// the connections to the peers not in the list are refused
+		return rm
+	}
+	return &allowListResourceManager{ResourceManager: rm, allowList: a}
+}
+
+type allowListResourceManager struct {
+	network.ResourceManager
+	allowList *AllowList
+}
+
+func (rm *allowListResourceManager) OpenStream(p peer.ID, dir network.Direction) (network.StreamManagementScope, error) {
+	scope, err := rm.ResourceManager.OpenStream(p, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &allowListStreamScope{StreamManagementScope: scope, peer: p, allowList: rm.allowList}, nil
+}
+
+// allowListStreamScope refuses the protocol of a stream, which resets the
+// stream, when it is not allowed with its peer.
+type allowListStreamScope struct {
+	network.StreamManagementScope
+	peer      peer.ID
+	allowList *AllowList
+}
+
+func (s *allowListStreamScope) SetProtocol(proto protocol.ID) error {
+	if !s.allowList.ProtocolAllowed(s.peer, proto) {
+		return fmt.Errorf("protocol %s is not allowed with peer %s: not in Swarm.PeerAllowList", proto, s.peer)
+	}
+	return s.StreamManagementScope.SetProtocol(proto)
+}
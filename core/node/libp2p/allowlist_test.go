@@ -0,0 +1,74 @@
+package libp2p
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowList(t *testing.T) {
+	p1, err := peer.Decode("12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5")
+	require.NoError(t, err)
+	p2, err := peer.Decode("12D3KooWJ7Dkj1Mzy3dhsXmw5wGbT9D9cZGNgdskYgU6Jdwd8ZUu")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "allowed")
+	require.NoError(t, os.WriteFile(file, []byte("# trusted peers\n\n"+p2.String()+" # storage\n"), 0o600))
+
+	t.Run("static peers and file", func(t *testing.T) {
+		a, err := NewAllowList(config.PeerAllowList{
+			Peers: []peer.ID{p1},
+			File:  config.NewOptionalString("allowed"),
+		}, dir)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []peer.ID{p1, p2}, a.Peers())
+		assert.True(t, a.InterceptPeerDial(p1))
+		assert.True(t, a.InterceptSecured(0, p2, nil))
+		assert.False(t, a.InterceptPeerDial("other"))
+		assert.False(t, a.ProtocolAllowed("other", "/ipfs/kad/1.0.0"))
+
+		// the file is reloaded when it changes
+		require.NoError(t, os.WriteFile(file, []byte(p1.String()+"\n"), 0o600))
+		require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+		changed, err := a.reload()
+		require.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, []peer.ID{p1}, a.Peers())
+		changed, err = a.reload()
+		require.NoError(t, err)
+		assert.False(t, changed)
+
+		// an invalid file keeps the previous list
+		require.NoError(t, os.WriteFile(file, []byte("not a peer\n"), 0o600))
+		require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(2*time.Minute)))
+		_, err = a.reload()
+		assert.Error(t, err)
+		assert.Equal(t, []peer.ID{p1}, a.Peers())
+	})
+
+	t.Run("DHT only", func(t *testing.T) {
+		a, err := NewAllowList(config.PeerAllowList{
+			Peers:    []peer.ID{p1},
+			AllowDHT: config.True,
+		}, dir)
+		require.NoError(t, err)
+		assert.True(t, a.InterceptPeerDial(p2))
+		assert.True(t, a.InterceptSecured(0, p2, nil))
+		assert.True(t, a.ProtocolAllowed(p1, "/ipfs/bitswap/1.2.0"))
+		assert.False(t, a.ProtocolAllowed(p2, "/ipfs/bitswap/1.2.0"))
+		assert.True(t, a.ProtocolAllowed(p2, "/ipfs/kad/1.0.0"))
+		assert.True(t, a.ProtocolAllowed(p2, "/ipfs/id/1.0.0"))
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := NewAllowList(config.PeerAllowList{File: config.NewOptionalString("missing")}, dir)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,477 @@
+package libp2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"go.uber.org/fx"
+	"golang.org/x/time/rate"
+
+	"github.com/ipfs/kubo/config"
+)
+
+// bandwidthProtocols are the protocols that can be named in
+// Swarm.BandwidthLimits.Protocols, with the protocol ID prefixes they match.
+var bandwidthProtocols = map[string][]protocol.ID{
+	"bitswap": {"/ipfs/bitswap"},
+	"dht":     {"/ipfs/kad/", "/ipfs/lan/kad/"},
+	"relay":   {"/libp2p/circuit/relay/"},
+}
+
+const (
+	// minBandwidthBurst is the smallest number of bytes a stream can send or
+	// receive at once, to avoid splitting writes too finely at low rates.
+	minBandwidthBurst = 4 << 10
+
+	// throttledRecently is how long a direction is reported as throttled
+	// after a stream last had to wait for its limit.
+	throttledRecently = 5 * time.Second
+)
+
+// errThrottleClosed is returned by the reads and writes of a throttled stream
+// that was closed, or whose host stopped, while waiting for its limit.
+var errThrottleClosed = errors.New("stream closed while waiting for the bandwidth limit")
+
+// bandwidthLimiter limits the throughput of a direction.
+type bandwidthLimiter struct {
+	limit   uint64
+	limiter *rate.Limiter
+	// lastThrottled is the time, in nanoseconds since the epoch, a stream
+	// last had to wait for the limiter.
+	lastThrottled atomic.Int64
+}
+
+func newBandwidthLimiter(limit uint64) *bandwidthLimiter {
+	if limit == 0 {
+		return nil
+	}
+	burst := limit
+	if burst < minBandwidthBurst {
+		burst = minBandwidthBurst
+	}
+	return &bandwidthLimiter{
+		limit:   limit,
+		limiter: rate.NewLimiter(rate.Limit(limit), int(burst)),
+	}
+}
+
+// burst returns the maximum number of bytes that can be transferred at once.
+func (l *bandwidthLimiter) burst() int {
+	if l == nil {
+		return 0
+	}
+	return l.limiter.Burst()
+}
+
+// wait blocks until n bytes can be transferred. n must not exceed the burst.
+// It gives up, and returns the bandwidth it reserved, when the wait would
+// outlast the deadline or when one of the done channels is closed.
+func (l *bandwidthLimiter) wait(n int, deadline time.Time, closed, stopped <-chan struct{}) error {
+	if l == nil || n == 0 {
+		return nil
+	}
+	now := time.Now()
+	r := l.limiter.ReserveN(now, n)
+	d := r.DelayFrom(now)
+	if d == 0 {
+		return nil
+	}
+	l.lastThrottled.Store(now.UnixNano())
+	if !deadline.IsZero() && now.Add(d).After(deadline) {
+		r.CancelAt(now)
+		return os.ErrDeadlineExceeded
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-closed:
+	case <-stopped:
+	}
+	r.Cancel()
+	return errThrottleClosed
+}
+
+// waitAll is like wait, but n can exceed the burst.
+func (l *bandwidthLimiter) waitAll(n int, deadline time.Time, closed, stopped <-chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+	for burst := l.burst(); n > 0; n -= burst {
+		if err := l.wait(min(n, burst), deadline, closed, stopped); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *bandwidthLimiter) status() BandwidthLimitStatus {
+	if l == nil {
+		return BandwidthLimitStatus{}
+	}
+	last := l.lastThrottled.Load()
+	return BandwidthLimitStatus{
+		Limit:     l.limit,
+		Throttled: last != 0 && time.Since(time.Unix(0, last)) < throttledRecently,
+	}
+}
+
+// bandwidthBucket limits the throughput of the streams of some protocols.
+type bandwidthBucket struct {
+	name     string
+	prefixes []protocol.ID
+	in, out  *bandwidthLimiter
+}
+
+// BandwidthLimitStatus is the state of the bandwidth limit of a direction.
+type BandwidthLimitStatus struct {
+	// Limit is the maximum throughput in bytes per second, 0 if unlimited.
+	Limit uint64
+	// Throttled is set when streams recently had to wait for the limit.
+	Throttled bool
+}
+
+// BandwidthThrottleStatus is the state of the bandwidth limits of a set of
+// streams.
+type BandwidthThrottleStatus struct {
+	// Protocol is the protocol whose limits apply, empty for the global
+	// limits.
+	Protocol string `json:",omitempty"`
+	In       BandwidthLimitStatus
+	Out      BandwidthLimitStatus
+}
+
+// BandwidthThrottler enforces Swarm.BandwidthLimits on the streams of a host.
+type BandwidthThrottler struct {
+	mu        sync.RWMutex
+	global    *bandwidthBucket
+	protocols []*bandwidthBucket
+
+	// stopped is closed when the throttler is closed, to release the
+	// streams waiting for a limit.
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// NewBandwidthThrottler creates a throttler with the given limits.
+func NewBandwidthThrottler(cfg config.BandwidthLimits) (*BandwidthThrottler, error) {
+	t := &BandwidthThrottler{stopped: make(chan struct{})}
+	if err := t.SetLimits(cfg); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// BandwidthLimits constructs the bandwidth throttler of the host.
+func BandwidthLimits(cfg config.BandwidthLimits) interface{} {
+	return func(lc fx.Lifecycle) (*BandwidthThrottler, error) {
+		t, err := NewBandwidthThrottler(cfg)
+		if err != nil {
+			return nil, err
+		}
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				t.Close()
+				return nil
+			},
+		})
+		return t, nil
+	}
+}
+
+// Close releases the streams waiting for a limit. Their pending reads and
+// writes fail.
+func (t *BandwidthThrottler) Close() {
+	t.closeOnce.Do(func() { close(t.stopped) })
+}
+
+func parseBandwidthRate(s *config.OptionalString) (uint64, error) {
+	v := strings.TrimSuffix(strings.TrimSpace(s.WithDefault("")), "/s")
+	if v == "" {
+		return 0, nil
+	}
+	rate, err := humanize.ParseBytes(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth rate %q: %w", v, err)
+	}
+	return rate, nil
+}
+
+func newBandwidthBucket(name string, prefixes []protocol.ID, rateIn, rateOut *config.OptionalString) (*bandwidthBucket, error) {
+	in, err := parseBandwidthRate(rateIn)
+	if err != nil {
+		return nil, err
+	}
+	out, err := parseBandwidthRate(rateOut)
+	if err != nil {
+		return nil, err
+	}
+	return &bandwidthBucket{
+		name:     name,
+		prefixes: prefixes,
+		in:       newBandwidthLimiter(in),
+		out:      newBandwidthLimiter(out),
+	}, nil
+}
+
+// SetLimits replaces the limits of the throttler. New limits apply right away
+// to the open streams.
+func (t *BandwidthThrottler) SetLimits(cfg config.BandwidthLimits) error {
+	global, err := newBandwidthBucket("", nil, cfg.RateIn, cfg.RateOut)
+	if err != nil {
+		return err
+	}
+
+	protocols := make([]*bandwidthBucket, 0, len(cfg.Protocols))
+	for name, limit := range cfg.Protocols {
+		prefixes, ok := bandwidthProtocols[name]
+		if !ok {
+			if !strings.HasPrefix(name, "/") {
+				return fmt.Errorf("invalid protocol %q in Swarm.BandwidthLimits.Protocols: must be one of bitswap, dht, relay or a protocol ID prefix", name)
+			}
+			prefixes = []protocol.ID{protocol.ID(name)}
+		}
+		b, err := newBandwidthBucket(name, prefixes, limit.RateIn, limit.RateOut)
+		if err != nil {
+			return fmt.Errorf("Swarm.BandwidthLimits.Protocols[%q]: %w", name, err)
+		}
+		// a direction without override shares the global limit
+		if limit.RateIn == nil {
+			b.in = global.in
+		}
+		if limit.RateOut == nil {
+			b.out = global.out
+		}
+		protocols = append(protocols, b)
+	}
+	sort.Slice(protocols, func(i, j int) bool {
+		return protocols[i].name < protocols[j].name
+	})
+
+	t.mu.Lock()
+	t.global = global
+	t.protocols = protocols
+	t.mu.Unlock()
+	return nil
+}
+
+// bucket returns the limits that apply to the streams of a protocol: the
+// protocol with the longest matching prefix, or the global limits.
+func (t *BandwidthThrottler) bucket(p protocol.ID) *bandwidthBucket {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	match, matchLen := t.global, -1
+	for _, b := range t.protocols {
+		for _, prefix := range b.prefixes {
+			if len(prefix) > matchLen && strings.HasPrefix(string(p), string(prefix)) {
+				match, matchLen = b, len(prefix)
+			}
+		}
+	}
+	return match
+}
+
+// Status returns the state of the limits that apply to the streams of a
+// protocol, or the global limits if p is empty.
+func (t *BandwidthThrottler) Status(p protocol.ID) BandwidthThrottleStatus {
+	b := t.bucket(p)
+	return BandwidthThrottleStatus{Protocol: b.name, In: b.in.status(), Out: b.out.status()}
+}
+
+// Statuses returns the state of the global limits followed by the state of
+// the limits of each protocol.
+func (t *BandwidthThrottler) Statuses() []BandwidthThrottleStatus {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	statuses := make([]BandwidthThrottleStatus, 0, len(t.protocols)+1)
+	for _, b := range append([]*bandwidthBucket{t.global}, t.protocols...) {
+		statuses = append(statuses, BandwidthThrottleStatus{Protocol: b.name, In: b.in.status(), Out: b.out.status()})
+	}
+	return statuses
+}
+
+// Attach throttles the inbound streams of h, and returns a host whose
+// outbound streams are throttled.
+func (t *BandwidthThrottler) Attach(h host.Host) host.Host {
+	h.Network().SetStreamHandler(t.inboundStreamHandler(h))
+	return t.WrapHost(h)
+}
+
+// WrapHost returns a host whose outbound streams are throttled.
+func (t *BandwidthThrottler) WrapHost(h host.Host) host.Host {
+	return &throttledHost{Host: h, throttler: t}
+}
+
+// inboundStreamHandler negotiates the protocol of inbound streams like the
+// basic host, and hands throttled streams to the protocol handlers.
+func (t *BandwidthThrottler) inboundStreamHandler(h host.Host) network.StreamHandler {
+	return func(s network.Stream) {
+		if err := s.SetDeadline(time.Now().Add(basichost.DefaultNegotiationTimeout)); err != nil {
+			log.Debug("setting stream deadline: ", err)
+			_ = s.Reset()
+			return
+		}
+
+		protoID, handle, err := h.Mux().Negotiate(s)
+		if err != nil {
+			if err != io.EOF {
+				log.Debugf("protocol mux failed: %s", err)
+			}
+			_ = s.Reset()
+			return
+		}
+
+		if err := s.SetDeadline(time.Time{}); err != nil {
+			log.Debugf("resetting stream deadline: %s", err)
+			_ = s.Reset()
+			return
+		}
+		if err := s.SetProtocol(protoID); err != nil {
+			log.Debugf("error setting stream protocol: %s", err)
+			_ = s.Reset()
+			return
+		}
+
+		handle(protoID, newThrottledStream(s, t))
+	}
+}
+
+type throttledHost struct {
+	host.Host
+	throttler *BandwidthThrottler
+}
+
+func (h *throttledHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	return newThrottledStream(s, h.throttler), nil
+}
+
+// throttledStream waits for the bandwidth limits of its protocol on every
+// read and write. The limits are looked up on each call, so that they follow
+// protocol negotiation and configuration changes. Waits end early at the
+// deadlines of the stream and when it is closed or reset.
+type throttledStream struct {
+	network.Stream
+	throttler *BandwidthThrottler
+
+	// readDeadline and writeDeadline are the deadlines of the stream, in
+	// nanoseconds since the epoch, 0 for none.
+	readDeadline  atomic.Int64
+	writeDeadline atomic.Int64
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newThrottledStream(s network.Stream, t *BandwidthThrottler) *throttledStream {
+	return &throttledStream{Stream: s, throttler: t, closed: make(chan struct{})}
+}
+
+func storeDeadline(v *atomic.Int64, t time.Time) {
+	if t.IsZero() {
+		v.Store(0)
+	} else {
+		v.Store(t.UnixNano())
+	}
+}
+
+func loadDeadline(v *atomic.Int64) time.Time {
+	if d := v.Load(); d != 0 {
+		return time.Unix(0, d)
+	}
+	return time.Time{}
+}
+
+func (s *throttledStream) markClosed() {
+	s.closeOnce.Do(func() { close(s.closed) })
+}
+
+func (s *throttledStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	if n > 0 {
+		in := s.throttler.bucket(s.Protocol()).in
+		if werr := in.waitAll(n, loadDeadline(&s.readDeadline), s.closed, s.throttler.stopped); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (s *throttledStream) Write(p []byte) (int, error) {
+	out := s.throttler.bucket(s.Protocol()).out
+	burst := out.burst()
+	if burst == 0 {
+		return s.Stream.Write(p)
+	}
+
+	var written int
+	for len(p) > 0 {
+		chunk := min(len(p), burst)
+		if err := out.wait(chunk, loadDeadline(&s.writeDeadline), s.closed, s.throttler.stopped); err != nil {
+			return written, err
+		}
+		n, err := s.Stream.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
+	}
+	return written, nil
+}
+
+func (s *throttledStream) SetDeadline(t time.Time) error {
+	if err := s.Stream.SetDeadline(t); err != nil {
+		return err
+	}
+	storeDeadline(&s.readDeadline, t)
+	storeDeadline(&s.writeDeadline, t)
+	return nil
+}
+
+func (s *throttledStream) SetReadDeadline(t time.Time) error {
+	if err := s.Stream.SetReadDeadline(t); err != nil {
+		return err
+	}
+	storeDeadline(&s.readDeadline, t)
+	return nil
+}
+
+func (s *throttledStream) SetWriteDeadline(t time.Time) error {
+	if err := s.Stream.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	storeDeadline(&s.writeDeadline, t)
+	return nil
+}
+
+func (s *throttledStream) Close() error {
+	s.markClosed()
+	return s.Stream.Close()
+}
+
+func (s *throttledStream) Reset() error {
+	s.markClosed()
+	return s.Stream.Reset()
+}
@@ -0,0 +1,139 @@
+package libp2p
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ipfs/kubo/config"
+)
+
+func TestBandwidthThrottlerBuckets(t *testing.T) {
+	throttler, err := NewBandwidthThrottler(config.BandwidthLimits{
+		RateIn: config.NewOptionalString("1MB"),
+		Protocols: map[string]config.BandwidthLimit{
+			"bitswap":      {RateOut: config.NewOptionalString("512KB/s")},
+			"/ipfs/kad/":   {RateIn: config.NewOptionalString("2MB")},
+			"/ipfs/kad/1.": {RateIn: config.NewOptionalString("3MB")},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, BandwidthThrottleStatus{In: BandwidthLimitStatus{Limit: 1000000}}, throttler.Status("/ipfs/id/1.0.0"))
+	// directions without override share the global limits
+	require.Equal(t, BandwidthThrottleStatus{
+		Protocol: "bitswap",
+		In:       BandwidthLimitStatus{Limit: 1000000},
+		Out:      BandwidthLimitStatus{Limit: 512000},
+	}, throttler.Status("/ipfs/bitswap/1.2.0"))
+	// the longest matching prefix wins
+	require.Equal(t, "/ipfs/kad/1.", throttler.Status("/ipfs/kad/1.0.0").Protocol)
+	require.Len(t, throttler.Statuses(), 4)
+
+	_, err = NewBandwidthThrottler(config.BandwidthLimits{
+		Protocols: map[string]config.BandwidthLimit{"unknown": {}},
+	})
+	require.Error(t, err)
+	_, err = NewBandwidthThrottler(config.BandwidthLimits{RateOut: config.NewOptionalString("fast")})
+	require.Error(t, err)
+}
+
+func TestBandwidthThrottlerStreams(t *testing.T) {
+	const proto = "/test/throttle"
+	const limit = 32 << 10
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	throttler, err := NewBandwidthThrottler(config.BandwidthLimits{
+		Protocols: map[string]config.BandwidthLimit{
+			proto: {RateOut: config.NewOptionalString("32KiB")},
+		},
+	})
+	require.NoError(t, err)
+	h1 := throttler.Attach(mn.Hosts()[0])
+	h2 := mn.Hosts()[1]
+	require.NoError(t, mn.ConnectAllButSelf())
+
+	received := make(chan int64)
+	h2.SetStreamHandler(proto, func(s network.Stream) {
+		n, _ := io.Copy(io.Discard, s)
+		received <- n
+	})
+
+	s, err := h1.NewStream(context.Background(), h2.ID(), proto)
+	require.NoError(t, err)
+
+	// the first burst goes through right away, the second one waits for
+	// the limit
+	start := time.Now()
+	_, err = s.Write(make([]byte, 2*limit))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	require.GreaterOrEqual(t, time.Since(start), 900*time.Millisecond)
+	require.True(t, throttler.Status(proto).Out.Throttled)
+	require.Equal(t, int64(2*limit), <-received)
+
+	// removing the limit applies to open streams
+	require.NoError(t, throttler.SetLimits(config.BandwidthLimits{}))
+	require.Equal(t, BandwidthThrottleStatus{}, throttler.Status(proto))
+}
+
+func TestBandwidthThrottlerStreamWaits(t *testing.T) {
+	const proto = "/test/throttle"
+	const limit = 4 << 10
+
+	mn, err := mocknet.FullMeshLinked(2)
+	require.NoError(t, err)
+	throttler, err := NewBandwidthThrottler(config.BandwidthLimits{
+		Protocols: map[string]config.BandwidthLimit{
+			proto: {RateOut: config.NewOptionalString("4KiB")},
+		},
+	})
+	require.NoError(t, err)
+	h1 := throttler.Attach(mn.Hosts()[0])
+	h2 := mn.Hosts()[1]
+	require.NoError(t, mn.ConnectAllButSelf())
+	h2.SetStreamHandler(proto, func(s network.Stream) {
+		_, _ = io.Copy(io.Discard, s)
+	})
+
+	// a wait that would outlast the deadline fails right away
+	out := throttler.Status(proto).Out
+	require.Equal(t, uint64(limit), out.Limit)
+	l := throttler.bucket(proto).out
+	require.NoError(t, l.wait(limit, time.Time{}, nil, nil))
+	start := time.Now()
+	err = l.waitAll(10*limit, time.Now().Add(500*time.Millisecond), nil, nil)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Less(t, time.Since(start), 250*time.Millisecond)
+
+	// resetting the stream releases a pending write
+	s, err := h1.NewStream(context.Background(), h2.ID(), proto)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = s.Reset()
+	}()
+	start = time.Now()
+	_, err = s.Write(make([]byte, 10*limit))
+	require.ErrorIs(t, err, errThrottleClosed)
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	// closing the throttler releases the pending writes of all streams
+	s, err = h1.NewStream(context.Background(), h2.ID(), proto)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		throttler.Close()
+	}()
+	start = time.Now()
+	_, err = s.Write(make([]byte, 10*limit))
+	require.ErrorIs(t, err, errThrottleClosed)
+	require.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,255 @@
+package libp2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// bansKey is where the ban list is stored in the repo datastore.
+var bansKey = datastore.NewKey("/local/swarm/bans")
+
+// PeerBan is an entry of the ban list.
+type PeerBan struct {
+	// Target is the banned peer ID or multiaddr.
+	Target string
+	// Expires is when the ban is lifted, nil if it never is.
+	Expires *time.Time `json:",omitempty"`
+}
+
+type ban struct {
+	target  string
+	peer    peer.ID
+	addr    ma.Multiaddr
+	expires time.Time
+}
+
+func (b *ban) expired(now time.Time) bool {
+	return !b.expires.IsZero() && !now.Before(b.expires)
+}
+
+func (b *ban) matchesAddr(a ma.Multiaddr) bool {
+	return b.addr != nil && a != nil && bytes.HasPrefix(a.Bytes(), b.addr.Bytes())
+}
+
+func (b *ban) toPeerBan() PeerBan {
+	pb := PeerBan{Target: b.target}
+	if !b.expires.IsZero() {
+		expires := b.expires
+		pb.Expires = &expires
+	}
+	return pb
+}
+
+// parseBan parses a ban target: a peer ID, a /p2p/<peer-id> multiaddr, or a
+// multiaddr matching the addresses starting with it.
+func parseBan(target string, expires time.Time) (*ban, error) {
+	if p, err := peer.Decode(target); err == nil {
+		return &ban{target: p.String(), peer: p, expires: expires}, nil
+	}
+	addr, err := ma.NewMultiaddr(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ban target %q: not a peer ID or a multiaddr", target)
+	}
+	transport, p := peer.SplitAddr(addr)
+	switch {
+	case transport == nil && p != "":
+		return &ban{target: p.String(), peer: p, expires: expires}, nil
+	case p != "":
+		return nil, fmt.Errorf("invalid ban target %q: ban the peer ID %s or the address %s", target, p, transport)
+	}
+	return &ban{target: addr.String(), addr: addr, expires: expires}, nil
+}
+
+// BanList refuses connections to and from banned peers and addresses. It is
+// persisted in the repo datastore.
+type BanList struct {
+	ds  datastore.Datastore
+	now func() time.Time
+
+	mu   sync.RWMutex
+	bans map[string]*ban
+}
+
+var _ connmgr.ConnectionGater = (*BanList)(nil)
+
+// NewBanList loads the ban list stored in ds.
+func NewBanList(ds datastore.Datastore) (*BanList, error) {
+	b := &BanList{ds: ds, now: time.Now, bans: make(map[string]*ban)}
+
+	data, err := ds.Get(context.Background(), bansKey)
+	if errors.Is(err, datastore.ErrNotFound) {
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []PeerBan
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("loading the ban list: %w", err)
+	}
+	for _, pb := range stored {
+		var expires time.Time
+		if pb.Expires != nil {
+			expires = *pb.Expires
+		}
+		entry, err := parseBan(pb.Target, expires)
+		if err != nil {
+			log.Errorf("ignoring stored ban: %s", err)
+			continue
+		}
+		b.bans[entry.target] = entry
+	}
+	return b, nil
+}
+
+// Bans constructs the ban list of the node.
+func Bans(ds datastore.Datastore) (*BanList, error) {
+	return NewBanList(ds)
+}
+
+// save stores the ban list, minus the expired bans. The lock must be held.
+func (b *BanList) save(ctx context.Context) error {
+	now := b.now()
+	stored := make([]PeerBan, 0, len(b.bans))
+	for target, entry := range b.bans {
+		if entry.expired(now) {
+			delete(b.bans, target)
+			continue
+		}
+		stored = append(stored, entry.toPeerBan())
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].Target < stored[j].Target
+	})
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return b.ds.Put(ctx, bansKey, data)
+}
+
+// Ban bans a peer ID or a multiaddr, for ttl or forever if ttl is 0. Banning
+// an already banned target replaces its expiry.
+func (b *BanList) Ban(ctx context.Context, target string, ttl time.Duration) (PeerBan, error) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = b.now().Add(ttl).UTC()
+	}
+	entry, err := parseBan(target, expires)
+	if err != nil {
+		return PeerBan{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bans[entry.target] = entry
+	return entry.toPeerBan(), b.save(ctx)
+}
+
+// Unban lifts the ban of a peer ID or a multiaddr. It returns false if the
+// target was not banned.
+func (b *BanList) Unban(ctx context.Context, target string) (bool, error) {
+	entry, err := parseBan(target, time.Time{})
+	if err != nil {
+		return false, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	existing, ok := b.bans[entry.target]
+	if !ok {
+		return false, nil
+	}
+	delete(b.bans, entry.target)
+	return !existing.expired(b.now()), b.save(ctx)
+}
+
+// List returns the bans in effect, sorted by target.
+func (b *BanList) List() []PeerBan {
+	now := b.now()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	list := make([]PeerBan, 0, len(b.bans))
+	for _, entry := range b.bans {
+		if !entry.expired(now) {
+			list = append(list, entry.toPeerBan())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Target < list[j].Target
+	})
+	return list
+}
+
+// PeerBanned reports whether a peer is banned.
+func (b *BanList) PeerBanned(p peer.ID) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	entry, ok := b.bans[p.String()]
+	return ok && !entry.expired(b.now())
+}
+
+// AddrBanned reports whether an address is banned.
+func (b *BanList) AddrBanned(a ma.Multiaddr) bool {
+	now := b.now()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, entry := range b.bans {
+		if entry.matchesAddr(a) && !entry.expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseBanned closes the connections to banned peers and addresses, and
+// returns how many were closed.
+func (b *BanList) CloseBanned(n network.Network) int {
+	closed := 0
+	for _, c := range n.Conns() {
+		if !b.PeerBanned(c.RemotePeer()) && !b.AddrBanned(c.RemoteMultiaddr()) {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			log.Debugf("closing connection to banned peer %s: %s", c.RemotePeer(), err)
+			continue
+		}
+		closed++
+	}
+	return closed
+}
+
+func (b *BanList) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) (allow bool) {
+	return !b.PeerBanned(p) && !b.AddrBanned(addr)
+}
+
+func (b *BanList) InterceptPeerDial(p peer.ID) (allow bool) {
+	return !b.PeerBanned(p)
+}
+
+func (b *BanList) InterceptAccept(connAddr network.ConnMultiaddrs) (allow bool) {
+	return !b.AddrBanned(connAddr.RemoteMultiaddr())
+}
+
+func (b *BanList) InterceptSecured(_ network.Direction, p peer.ID, connAddr network.ConnMultiaddrs) (allow bool) {
+	return !b.PeerBanned(p) && !b.AddrBanned(connAddr.RemoteMultiaddr())
+}
+
+func (b *BanList) InterceptUpgraded(_ network.Conn) (allow bool, reason control.DisconnectReason) {
+	return true, 0
+}
@@ -0,0 +1,73 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanList(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	p1, err := peer.Decode("12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5")
+	require.NoError(t, err)
+	p2, err := peer.Decode("12D3KooWJ7Dkj1Mzy3dhsXmw5wGbT9D9cZGNgdskYgU6Jdwd8ZUu")
+	require.NoError(t, err)
+
+	bans, err := NewBanList(ds)
+	require.NoError(t, err)
+	now := time.Now()
+	bans.now = func() time.Time { return now }
+
+	_, err = bans.Ban(ctx, "/p2p/"+p1.String(), 0)
+	require.NoError(t, err)
+	_, err = bans.Ban(ctx, "/ip4/1.2.3.4", 0)
+	require.NoError(t, err)
+	b, err := bans.Ban(ctx, p2.String(), time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, b.Expires)
+
+	_, err = bans.Ban(ctx, "/ip4/1.2.3.4/tcp/4001/p2p/"+p1.String(), 0)
+	assert.Error(t, err)
+	_, err = bans.Ban(ctx, "not a target", 0)
+	assert.Error(t, err)
+
+	assert.True(t, bans.PeerBanned(p1))
+	assert.True(t, bans.PeerBanned(p2))
+	assert.True(t, bans.AddrBanned(ma.StringCast("/ip4/1.2.3.4/tcp/4001")))
+	assert.False(t, bans.AddrBanned(ma.StringCast("/ip4/1.2.3.5/tcp/4001")))
+	assert.False(t, bans.InterceptAddrDial(p1, ma.StringCast("/ip4/5.6.7.8/tcp/4001")))
+	assert.False(t, bans.InterceptAddrDial("", ma.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1")))
+
+	// bans are persisted
+	loaded, err := NewBanList(ds)
+	require.NoError(t, err)
+	loaded.now = bans.now
+	assert.Equal(t, bans.List(), loaded.List())
+	assert.Len(t, loaded.List(), 3)
+
+	// bans expire
+	now = now.Add(2 * time.Hour)
+	assert.False(t, bans.PeerBanned(p2))
+	assert.Len(t, bans.List(), 2)
+
+	ok, err := bans.Unban(ctx, p1.String())
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, bans.PeerBanned(p1))
+	ok, err = bans.Unban(ctx, p1.String())
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	loaded, err = NewBanList(ds)
+	require.NoError(t, err)
+	assert.Equal(t, []PeerBan{{Target: "/ip4/1.2.3.4"}}, loaded.List())
+}
@@ -0,0 +1,465 @@
+package libp2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+)
+
+// historyPrefix is where the connection history is stored in the repo
+// datastore, one key per connection.
+var historyPrefix = datastore.NewKey("/local/swarm/history")
+
+// Reasons of the end of a connection in the connection history.
+const (
+	CloseReasonClosed     = "closed"
+	CloseReasonBanned     = "banned"
+	CloseReasonNotAllowed = "not allowed"
+	CloseReasonShutdown   = "shutdown"
+)
+
+// ConnRecord is a closed connection in the connection history.
+type ConnRecord struct {
+	Peer      peer.ID
+	Addr      string
+	Direction string
+	Opened    time.Time
+	Closed    time.Time
+	// BytesIn and BytesOut are the bytes exchanged with the peer while the
+	// connection was open. With several connections to the peer at once,
+	// the bytes are counted in the record of the first of them to close,
+	// and then in the next ones, so that they are never counted twice.
+	BytesIn  int64
+	BytesOut int64
+	// Reason is why the connection was closed, as far as the node knows:
+	// "banned", "not allowed", "shutdown", or "closed" otherwise.
+	Reason       string
+	AgentVersion string `json:",omitempty"`
+}
+
+// Duration is how long the connection was open.
+func (r ConnRecord) Duration() time.Duration {
+	return r.Closed.Sub(r.Opened)
+}
+
+// ConnHistoryFilter selects records of the connection history. Zero fields
+// match every record.
+type ConnHistoryFilter struct {
+	Peer      peer.ID
+	Since     time.Time
+	Direction string
+	// Agent matches the records whose agent version contains it.
+	Agent string
+	// Limit is the maximum number of records, the most recent ones.
+	Limit int
+}
+
+func (f ConnHistoryFilter) matches(r ConnRecord) bool {
+	return (f.Peer == "" || r.Peer == f.Peer) &&
+		(f.Since.IsZero() || !r.Closed.Before(f.Since)) &&
+		(f.Direction == "" || r.Direction == f.Direction) &&
+		(f.Agent == "" || strings.Contains(r.AgentVersion, f.Agent))
+}
+
+// PeerConnStats aggregates the connection history of a peer.
+type PeerConnStats struct {
+	Peer         peer.ID
+	Connections  int
+	Duration     time.Duration
+	BytesIn      int64
+	BytesOut     int64
+	LastClosed   time.Time
+	AgentVersion string `json:",omitempty"`
+}
+
+type historyEntry struct {
+	key    datastore.Key
+	record ConnRecord
+}
+
+type openConn struct {
+	opened time.Time
+}
+
+// historyWrite is a change of the history to write to the datastore.
+type historyWrite struct {
+	key     datastore.Key
+	data    []byte
+	deleted []datastore.Key
+}
+
+// ConnHistory keeps a bounded history of the closed connections of the
+// node in the repo datastore.
+type ConnHistory struct {
+	ds         datastore.Datastore
+	maxEntries int
+
+	reporter  *metrics.BandwidthCounter
+	peerstore peerstore.Peerstore
+	bans      *BanList
+	allowList *AllowList
+
+	mu      sync.Mutex
+	seq     uint64
+	entries []historyEntry // oldest first
+	open    map[network.Conn]openConn
+	// peerConns is the number of open connections of each peer.
+	peerConns map[peer.ID]int
+	// counted is, for the peers with open connections, the bandwidth of
+	// the peer already counted in the records of closed connections, or
+	// when the first of the open connections was opened.
+	counted map[peer.ID]metrics.Stats
+	closing bool
+
+	// the writes are done in the background, not to hold up the swarm
+	// while it closes connections
+	writeMu     sync.Mutex
+	writes      []historyWrite
+	writesDone  bool
+	writeSignal chan struct{}
+	writerOnce  sync.Once
+	writerDone  chan struct{}
+}
+
+// NewConnHistory loads the connection history stored in ds.
+func NewConnHistory(ds datastore.Datastore, maxEntries int) (*ConnHistory, error) {
+	h := &ConnHistory{
+		ds:          ds,
+		maxEntries:  maxEntries,
+		open:        make(map[network.Conn]openConn),
+		peerConns:   make(map[peer.ID]int),
+		counted:     make(map[peer.ID]metrics.Stats),
+		writeSignal: make(chan struct{}, 1),
+		writerDone:  make(chan struct{}),
+	}
+
+	results, err := ds.Query(context.Background(), query.Query{
+		Prefix: historyPrefix.String(),
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for res := range results.Next() {
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		key := datastore.NewKey(res.Key)
+		var seq uint64
+		if _, err := fmt.Sscanf(key.BaseNamespace(), "%d", &seq); err != nil {
+			log.Errorf("ignoring connection history entry %s: %s", key, err)
+			continue
+		}
+		var r ConnRecord
+		if err := json.Unmarshal(res.Value, &r); err != nil {
+			log.Errorf("ignoring connection history entry %s: %s", key, err)
+			continue
+		}
+		h.entries = append(h.entries, historyEntry{key: key, record: r})
+		h.seq = seq
+	}
+
+	for _, key := range h.trim() {
+		if err := ds.Delete(context.Background(), key); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+type connHistoryIn struct {
+	fx.In
+
+	Reporter  *metrics.BandwidthCounter `optional:"true"`
+	Bans      *BanList                  `optional:"true"`
+	AllowList *AllowList                `optional:"true"`
+}
+
+// ConnectionHistory constructs the connection history of the host,
+// configured by Swarm.ConnectionHistory.
+func ConnectionHistory(cfg config.ConnectionHistory) interface{} {
+	return func(lc fx.Lifecycle, ds datastore.Datastore, h host.Host, in connHistoryIn) (*ConnHistory, error) {
+		maxEntries := int(cfg.MaxEntries.WithDefault(config.DefaultConnectionHistoryMaxEntries))
+		history, err := NewConnHistory(ds, maxEntries)
+		if err != nil {
+			return nil, err
+		}
+		history.reporter = in.Reporter
+		history.peerstore = h.Peerstore()
+		history.bans = in.Bans
+		history.allowList = in.AllowList
+
+		h.Network().Notify(history)
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				h.Network().StopNotify(history)
+				return history.close(ctx)
+			},
+		})
+		return history, nil
+	}
+}
+
+// Query returns the records matching the filter, most recent first.
+func (h *ConnHistory) Query(f ConnHistoryFilter) []ConnRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := []ConnRecord{}
+	for i := len(h.entries) - 1; i >= 0; i-- {
+		if f.Limit > 0 && len(records) == f.Limit {
+			break
+		}
+		if r := h.entries[i].record; f.matches(r) {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// AggregateConnRecords aggregates records per peer, sorted by decreasing
+// total duration of the connections.
+func AggregateConnRecords(records []ConnRecord) []PeerConnStats {
+	byPeer := make(map[peer.ID]*PeerConnStats)
+	for _, r := range records {
+		s, ok := byPeer[r.Peer]
+		if !ok {
+			s = &PeerConnStats{Peer: r.Peer}
+			byPeer[r.Peer] = s
+		}
+		s.Connections++
+		s.Duration += r.Duration()
+		s.BytesIn += r.BytesIn
+		s.BytesOut += r.BytesOut
+		if r.Closed.After(s.LastClosed) {
+			s.LastClosed = r.Closed
+			if r.AgentVersion != "" {
+				s.AgentVersion = r.AgentVersion
+			}
+		}
+	}
+
+	stats := make([]PeerConnStats, 0, len(byPeer))
+	for _, s := range byPeer {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Duration != stats[j].Duration {
+			return stats[i].Duration > stats[j].Duration
+		}
+		return stats[i].Peer < stats[j].Peer
+	})
+	return stats
+}
+
+func (h *ConnHistory) bandwidth(p peer.ID) metrics.Stats {
+	if h.reporter == nil {
+		return metrics.Stats{}
+	}
+	return h.reporter.GetBandwidthForPeer(p)
+}
+
+func (h *ConnHistory) Connected(_ network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	bw := h.bandwidth(p)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.open[c] = openConn{opened: time.Now()}
+	h.peerConns[p]++
+	if _, ok := h.counted[p]; !ok {
+		h.counted[p] = bw
+	}
+}
+
+func (h *ConnHistory) Disconnected(_ network.Network, c network.Conn) {
+	h.mu.Lock()
+	closing := h.closing
+	h.mu.Unlock()
+	if closing {
+		return
+	}
+
+	p := c.RemotePeer()
+	reason := CloseReasonClosed
+	switch {
+	case h.bans != nil && (h.bans.PeerBanned(p) || h.bans.AddrBanned(c.RemoteMultiaddr())):
+		reason = CloseReasonBanned
+	case h.allowList != nil && !h.allowList.Allowed(p):
+		reason = CloseReasonNotAllowed
+	}
+	if err := h.record(c, time.Now(), reason); err != nil {
+		log.Errorf("recording closed connection: %s", err)
+	}
+}
+
+func (h *ConnHistory) Listen(network.Network, ma.Multiaddr)      {}
+func (h *ConnHistory) ListenClose(network.Network, ma.Multiaddr) {}
+
+// record adds a closed connection to the history. It is written to the
+// datastore in the background.
+func (h *ConnHistory) record(c network.Conn, closed time.Time, reason string) error {
+	p := c.RemotePeer()
+	bw := h.bandwidth(p)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	oc, ok := h.open[c]
+	if ok {
+		delete(h.open, c)
+		h.peerConns[p]--
+	} else {
+		// the connection was opened before the history was started
+		oc = openConn{opened: c.Stat().Opened}
+	}
+	counted, ok := h.counted[p]
+	if !ok {
+		counted = bw
+	}
+	if h.peerConns[p] > 0 {
+		h.counted[p] = bw
+	} else {
+		delete(h.peerConns, p)
+		delete(h.counted, p)
+	}
+
+	r := ConnRecord{
+		Peer:      p,
+		Addr:      c.RemoteMultiaddr().String(),
+		Direction: strings.ToLower(c.Stat().Direction.String()),
+		Opened:    oc.opened,
+		Closed:    closed,
+		BytesIn:   bw.TotalIn - counted.TotalIn,
+		BytesOut:  bw.TotalOut - counted.TotalOut,
+		Reason:    reason,
+	}
+	if h.peerstore != nil {
+		if agent, err := h.peerstore.Get(p, "AgentVersion"); err == nil {
+			r.AgentVersion, _ = agent.(string)
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	h.seq++
+	key := historyPrefix.ChildString(fmt.Sprintf("%020d", h.seq))
+	h.entries = append(h.entries, historyEntry{key: key, record: r})
+	h.write(historyWrite{key: key, data: data, deleted: h.trim()})
+	return nil
+}
+
+// trim removes the oldest entries above the maximum number of entries, and
+// returns their keys.
+func (h *ConnHistory) trim() []datastore.Key {
+	var deleted []datastore.Key
+	for len(h.entries) > h.maxEntries {
+		deleted = append(deleted, h.entries[0].key)
+		h.entries = h.entries[1:]
+	}
+	return deleted
+}
+
+// write queues w for the background writer.
+func (h *ConnHistory) write(w historyWrite) {
+	h.writerOnce.Do(func() {
+		go h.writer()
+	})
+
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if h.writesDone {
+		// a connection closed while the history was being closed
+		return
+	}
+	h.writes = append(h.writes, w)
+	select {
+	case h.writeSignal <- struct{}{}:
+	default:
+	}
+}
+
+// writer writes the queued changes to the datastore until the history is
+// closed.
+func (h *ConnHistory) writer() {
+	defer close(h.writerDone)
+	for range h.writeSignal {
+		h.flush(context.Background())
+	}
+	h.flush(context.Background())
+}
+
+func (h *ConnHistory) flush(ctx context.Context) {
+	h.writeMu.Lock()
+	writes := h.writes
+	h.writes = nil
+	h.writeMu.Unlock()
+
+	for _, w := range writes {
+		if err := h.ds.Put(ctx, w.key, w.data); err != nil {
+			log.Errorf("writing the connection history: %s", err)
+		}
+		for _, key := range w.deleted {
+			if err := h.ds.Delete(ctx, key); err != nil {
+				log.Errorf("trimming the connection history: %s", err)
+			}
+		}
+	}
+}
+
+// close records the connections that are still open as closed by the
+// shutdown of the node.
+func (h *ConnHistory) close(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	conns := make([]network.Conn, 0, len(h.open))
+	for c := range h.open {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range conns {
+		if err := h.record(c, now, CloseReasonShutdown); err != nil {
+			return err
+		}
+	}
+
+	// wait for the writes, if any
+	started := true
+	h.writerOnce.Do(func() {
+		started = false
+	})
+	if !started {
+		return nil
+	}
+	h.writeMu.Lock()
+	h.writesDone = true
+	close(h.writeSignal)
+	h.writeMu.Unlock()
+	select {
+	case <-h.writerDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
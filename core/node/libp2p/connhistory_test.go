@@ -0,0 +1,76 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnHistory(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h.Close()
+
+	history, err := NewConnHistory(ds, 2)
+	require.NoError(t, err)
+	history.peerstore = h.Peerstore()
+	h.Network().Notify(history)
+	defer h.Network().StopNotify(history)
+
+	var others []peer.ID
+	for i := 0; i < 3; i++ {
+		other, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+		require.NoError(t, err)
+		defer other.Close()
+		require.NoError(t, h.Connect(ctx, peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()}))
+		require.NoError(t, h.Network().ClosePeer(other.ID()))
+		others = append(others, other.ID())
+	}
+
+	var records []ConnRecord
+	require.Eventually(t, func() bool {
+		records = history.Query(ConnHistoryFilter{})
+		return len(records) == 2 && records[0].Peer == others[2]
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the oldest connection was removed
+	assert.Equal(t, others[1], records[1].Peer)
+	r := records[0]
+	assert.Equal(t, "outbound", r.Direction)
+	assert.Equal(t, CloseReasonClosed, r.Reason)
+	assert.False(t, r.Closed.Before(r.Opened))
+	assert.NotEmpty(t, r.Addr)
+
+	assert.Len(t, history.Query(ConnHistoryFilter{Peer: others[1]}), 1)
+	assert.Len(t, history.Query(ConnHistoryFilter{Limit: 1}), 1)
+	assert.Empty(t, history.Query(ConnHistoryFilter{Direction: "inbound"}))
+	assert.Empty(t, history.Query(ConnHistoryFilter{Since: time.Now().Add(time.Hour)}))
+
+	stats := AggregateConnRecords(records)
+	require.Len(t, stats, 2)
+	assert.Equal(t, 1, stats[0].Connections)
+
+	// the history is persisted
+	require.NoError(t, history.close(ctx))
+	loaded, err := NewConnHistory(ds, 2)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Query(ConnHistoryFilter{}), 2)
+
+	// and trimmed when the maximum decreases
+	loaded, err = NewConnHistory(ds, 1)
+	require.NoError(t, err)
+	assert.Equal(t, others[2], loaded.Query(ConnHistoryFilter{})[0].Peer)
+	loaded, err = NewConnHistory(ds, 2)
+	require.NoError(t, err)
+	assert.Len(t, loaded.Query(ConnHistoryFilter{}), 1)
+}
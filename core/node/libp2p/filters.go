@@ -1,14 +1,98 @@
 package libp2p
 
 import (
+	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/control"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
 )
 
+// connectionGaters combines connection gaters: a connection is allowed only
+// if every gater allows it.
+type connectionGaters []connmgr.ConnectionGater
+
+var _ connmgr.ConnectionGater = connectionGaters(nil)
+
+func (gs connectionGaters) InterceptAddrDial(p peer.ID, addr ma.Multiaddr) (allow bool) {
+	for _, g := range gs {
+		if !g.InterceptAddrDial(p, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gs connectionGaters) InterceptPeerDial(p peer.ID) (allow bool) {
+	for _, g := range gs {
+		if !g.InterceptPeerDial(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gs connectionGaters) InterceptAccept(connAddr network.ConnMultiaddrs) (allow bool) {
+	for _, g := range gs {
+		if !g.InterceptAccept(connAddr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gs connectionGaters) InterceptSecured(dir network.Direction, p peer.ID, connAddr network.ConnMultiaddrs) (allow bool) {
+	for _, g := range gs {
+		if !g.InterceptSecured(dir, p, connAddr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (gs connectionGaters) InterceptUpgraded(c network.Conn) (allow bool, reason control.DisconnectReason) {
+	for _, g := range gs {
+		if allow, reason := g.InterceptUpgraded(c); !allow {
+			return false, reason
+		}
+	}
+	return true, 0
+}
+
+type connectionGaterIn struct {
+	fx.In
+
+	Filters   *ma.Filters
+	Bans      *BanList   `optional:"true"`
+	AllowList *AllowList `optional:"true"`
+}
+
+type connectionGaterOut struct {
+	fx.Out
+
+	Opts  []libp2p.Option `group:"libp2p"`
+	Gater connmgr.ConnectionGater
+}
+
+// ConnectionGater installs the connection gater of the host, enforcing the
+// address filters, the ban list and the peer allow-list. The gater is also
+// provided for the dials that bypass the swarm.
+func ConnectionGater(in connectionGaterIn) (out connectionGaterOut) {
+	gaters := connectionGaters{(*filtersConnectionGater)(in.Filters)}
+	if in.Bans != nil {
+		gaters = append(gaters, in.Bans)
+	}
+	if in.AllowList != nil {
+		gaters = append(gaters, in.AllowList)
+	}
+	out.Opts = append(out.Opts, libp2p.ConnectionGater(gaters))
+	out.Gater = gaters
+	return out
+}
+
 // filtersConnectionGater is an adapter that turns multiaddr.Filter into a
 // connmgr.ConnectionGater.
 type filtersConnectionGater ma.Filters
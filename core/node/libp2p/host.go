@@ -14,6 +14,7 @@ import (
 	"github.com/ipfs/kubo/config"
 	"github.com/ipfs/kubo/core/node/helpers"
 	"github.com/ipfs/kubo/repo"
+	irouting "github.com/ipfs/kubo/routing"
 
 	"go.uber.org/fx"
 )
@@ -27,6 +28,10 @@ type P2PHostIn struct {
 	RoutingOption RoutingOption
 	ID            peer.ID
 	Peerstore     peerstore.Peerstore
+	Throttler     *BandwidthThrottler  `optional:"true"`
+	Reachability  *ReachabilityMonitor `optional:"true"`
+
+	ProvideTracker *irouting.ProvideTracker `optional:"true"`
 
 	Opts [][]libp2p.Option `group:"libp2p"`
 }
@@ -62,10 +67,24 @@ func Host(mctx helpers.MetricsCtx, lc fx.Lifecycle, params P2PHostIn) (out P2PHo
 		OptimisticProvide:             cfg.Experimental.OptimisticProvide,
 		OptimisticProvideJobsPoolSize: cfg.Experimental.OptimisticProvideJobsPoolSize,
 		LoopbackAddressesOnLanDHT:     cfg.Routing.LoopbackAddressesOnLanDHT.WithDefault(config.DefaultLoopbackAddressesOnLanDHT),
+		ProvideTracker:                params.ProvideTracker,
 	}
 	opts = append(opts, libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 		args := routingOptArgs
 		args.Host = h
+		if params.Reachability != nil {
+			// h is the host built by libp2p, before it gets wrapped
+			params.Reachability.setBasicHost(h)
+		}
+		if params.Throttler != nil {
+			// the routing is built along with the host, throttle its
+			// outbound streams too
+			args.Host = params.Throttler.WrapHost(h)
+		}
+		if params.ProvideTracker != nil {
+			// record the provider records the DHT sends
+			args.Host = params.ProvideTracker.WrapHost(args.Host)
+		}
 		r, err := params.RoutingOption(args)
 		out.Routing = r
 		return r, err
@@ -89,6 +108,10 @@ func Host(mctx helpers.MetricsCtx, lc fx.Lifecycle, params P2PHostIn) (out P2PHo
 		out.Host = routedhost.Wrap(out.Host, out.Routing)
 	}
 
+	if params.Throttler != nil {
+		out.Host = params.Throttler.Attach(out.Host)
+	}
+
 	lc.Append(fx.Hook{
 		OnStop: func(ctx context.Context) error {
 			return out.Host.Close()
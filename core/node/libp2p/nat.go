@@ -7,7 +7,9 @@ import (
 	"github.com/libp2p/go-libp2p"
 )
 
-var NatPortMap = simpleOpt(libp2p.NATPortMap())
+func NatPortMap(m *ReachabilityMonitor) Libp2pOpts {
+	return Libp2pOpts{Opts: []libp2p.Option{libp2p.NATManager(m.natManager)}}
+}
 
 func AutoNATService(throttle *config.AutoNATThrottleConfig) func() Libp2pOpts {
 	return func() (opts Libp2pOpts) {
@@ -2,12 +2,23 @@ package libp2p
 
 import (
 	"context"
+	"time"
 
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/kubo/repo"
+	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	ma "github.com/multiformats/go-multiaddr"
 	"go.uber.org/fx"
 )
 
+// peerstorePrefix is the datastore namespace of the persistent peerstore.
+var peerstorePrefix = datastore.NewKey("/local/peerstore")
+
 func Peerstore(lc fx.Lifecycle) (peerstore.Peerstore, error) {
 	pstore, err := pstoremem.NewPeerstore()
 	if err != nil {
@@ -21,3 +32,100 @@ func Peerstore(lc fx.Lifecycle) (peerstore.Peerstore, error) {
 
 	return pstore, nil
 }
+
+// PersistentPeerstore constructs a peerstore backed by the repo datastore, so
+// that the addresses, protocols and public keys of known peers survive
+// restarts. The addresses of the peers the node disconnected from are kept
+// for disconnectedAddrTTL instead of the 30 minutes of
+// peerstore.RecentlyConnectedAddrTTL, which would not outlast most restarts.
+// Expired addresses are removed from the datastore every gcInterval.
+func PersistentPeerstore(gcInterval, disconnectedAddrTTL time.Duration) interface{} {
+	return func(lc fx.Lifecycle, r repo.Repo) (peerstore.Peerstore, error) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		opts := pstoreds.DefaultOpts()
+		opts.GCPurgeInterval = gcInterval
+		ds := namespace.Wrap(r.Datastore(), peerstorePrefix)
+		pstore, err := pstoreds.NewPeerstore(ctx, ds, opts)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		persistent := &persistentPeerstore{
+			Peerstore:         pstore,
+			CertifiedAddrBook: pstore,
+			privKeys:          pstoremem.NewKeyBook(),
+			disconnectedTTL:   disconnectedAddrTTL,
+		}
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				defer cancel()
+				return persistent.Close()
+			},
+		})
+		return persistent, nil
+	}
+}
+
+// persistentPeerstore keeps private keys in memory, so that the identity key
+// stays in the config and is not copied to the datastore. The addresses given
+// the TTL of recently connected peers are kept for disconnectedTTL instead.
+type persistentPeerstore struct {
+	peerstore.Peerstore
+	peerstore.CertifiedAddrBook
+	privKeys        peerstore.KeyBook
+	disconnectedTTL time.Duration
+}
+
+func (ps *persistentPeerstore) ttl(ttl time.Duration) time.Duration {
+	if ttl == peerstore.RecentlyConnectedAddrTTL {
+		return ps.disconnectedTTL
+	}
+	return ttl
+}
+
+func (ps *persistentPeerstore) AddAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddr(p, addr, ps.ttl(ttl))
+}
+
+func (ps *persistentPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddrs(p, addrs, ps.ttl(ttl))
+}
+
+func (ps *persistentPeerstore) SetAddr(p peer.ID, addr ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddr(p, addr, ps.ttl(ttl))
+}
+
+func (ps *persistentPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddrs(p, addrs, ps.ttl(ttl))
+}
+
+func (ps *persistentPeerstore) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
+	ps.Peerstore.UpdateAddrs(p, ps.ttl(oldTTL), ps.ttl(newTTL))
+}
+
+func (ps *persistentPeerstore) PrivKey(p peer.ID) ic.PrivKey {
+	return ps.privKeys.PrivKey(p)
+}
+
+func (ps *persistentPeerstore) AddPrivKey(p peer.ID, sk ic.PrivKey) error {
+	return ps.privKeys.AddPrivKey(p, sk)
+}
+
+func (ps *persistentPeerstore) PeersWithKeys() peer.IDSlice {
+	seen := make(map[peer.ID]struct{})
+	var peers peer.IDSlice
+	for _, p := range append(ps.Peerstore.PeersWithKeys(), ps.privKeys.PeersWithKeys()...) {
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+func (ps *persistentPeerstore) RemovePeer(p peer.ID) {
+	ps.Peerstore.RemovePeer(p)
+	ps.privKeys.RemovePeer(p)
+}
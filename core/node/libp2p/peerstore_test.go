@@ -0,0 +1,73 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/repo"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+func TestPersistentPeerstore(t *testing.T) {
+	ctx := context.Background()
+	r := &repo.Mock{D: dssync.MutexWrap(datastore.NewMapDatastore())}
+	newPeerstore := PersistentPeerstore(time.Hour, 24*time.Hour).(func(fx.Lifecycle, repo.Repo) (peerstore.Peerstore, error))
+
+	sk, pk, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	self, err := peer.IDFromPublicKey(pk)
+	require.NoError(t, err)
+	_, otherKey, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+	other, err := peer.IDFromPublicKey(otherKey)
+	require.NoError(t, err)
+	addr := ma.StringCast("/ip4/192.0.2.1/tcp/4001")
+
+	lc := fxtest.NewLifecycle(t)
+	ps, err := newPeerstore(lc, r)
+	require.NoError(t, err)
+	lc.RequireStart()
+	require.NoError(t, ps.AddPrivKey(self, sk))
+	require.NoError(t, ps.AddPubKey(self, pk))
+	require.NoError(t, ps.AddPubKey(other, otherKey))
+	ps.AddAddr(other, addr, peerstore.PermanentAddrTTL)
+	// the addresses of a peer the node disconnected from outlast restarts
+	connected := ma.StringCast("/ip4/192.0.2.2/tcp/4001")
+	ps.AddAddr(other, connected, peerstore.ConnectedAddrTTL)
+	ps.UpdateAddrs(other, peerstore.ConnectedAddrTTL, peerstore.RecentlyConnectedAddrTTL)
+	require.NoError(t, ps.AddProtocols(other, "/ipfs/bitswap/1.2.0"))
+	assert.ElementsMatch(t, peer.IDSlice{self, other}, ps.PeersWithKeys())
+	lc.RequireStop()
+
+	// private keys are not persisted
+	res, err := r.D.Query(ctx, query.Query{KeysOnly: true})
+	require.NoError(t, err)
+	entries, err := res.Rest()
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Key, "/priv")
+	}
+
+	lc = fxtest.NewLifecycle(t)
+	ps, err = newPeerstore(lc, r)
+	require.NoError(t, err)
+	lc.RequireStart()
+	defer lc.RequireStop()
+	assert.ElementsMatch(t, []ma.Multiaddr{addr, connected}, ps.Addrs(other))
+	protos, err := ps.GetProtocols(other)
+	require.NoError(t, err)
+	assert.Len(t, protos, 1)
+	assert.True(t, otherKey.Equals(ps.PubKey(other)))
+	assert.Nil(t, ps.PrivKey(self))
+}
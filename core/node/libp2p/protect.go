@@ -0,0 +1,122 @@
+package libp2p
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+)
+
+const (
+	// ConfigProtectionTag is the default tag of Swarm.ConnMgr.ProtectedPeers.
+	ConfigProtectionTag = "config"
+	// UserProtectionTag is the default tag of 'ipfs swarm protect'.
+	UserProtectionTag = "user"
+)
+
+// PeerProtector protects peers from connection trimming, or weighs them so
+// that their connections are trimmed last.
+//
+// The connection manager forgets the tags of peers that are not connected,
+// so weights are applied again every time a weighed peer connects.
+type PeerProtector struct {
+	cm connmgr.ConnManager
+
+	mu      sync.Mutex
+	weights map[peer.ID]map[string]int
+}
+
+// NewPeerProtector creates a peer protector for the connection manager of h.
+// It must be closed to stop tracking connections.
+func NewPeerProtector(h host.Host) *PeerProtector {
+	pp := &PeerProtector{
+		cm:      h.ConnManager(),
+		weights: make(map[peer.ID]map[string]int),
+	}
+	h.Network().Notify(pp)
+	return pp
+}
+
+// PeerProtection constructs the peer protector of the host, and applies
+// Swarm.ConnMgr.ProtectedPeers.
+func PeerProtection(protected []config.ConnMgrProtectedPeer) interface{} {
+	return func(lc fx.Lifecycle, h host.Host) *PeerProtector {
+		pp := NewPeerProtector(h)
+		for _, p := range protected {
+			tag := p.Tag.WithDefault(ConfigProtectionTag)
+			if p.Weight != nil {
+				pp.SetWeight(p.ID, tag, int(p.Weight.WithDefault(0)))
+			} else {
+				pp.Protect(p.ID, tag)
+			}
+		}
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				h.Network().StopNotify(pp)
+				return nil
+			},
+		})
+		return pp
+	}
+}
+
+// Protect keeps the connections to a peer when trimming connections.
+func (pp *PeerProtector) Protect(p peer.ID, tag string) {
+	pp.cm.Protect(p, tag)
+}
+
+// Unprotect removes a protection of a peer, and reports whether the peer is
+// still protected by other tags.
+func (pp *PeerProtector) Unprotect(p peer.ID, tag string) bool {
+	return pp.cm.Unprotect(p, tag)
+}
+
+// SetWeight tags a peer with a weight, so that its connections are trimmed
+// after those of peers with a lower total weight.
+func (pp *PeerProtector) SetWeight(p peer.ID, tag string, weight int) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	tags, ok := pp.weights[p]
+	if !ok {
+		tags = make(map[string]int)
+		pp.weights[p] = tags
+	}
+	tags[tag] = weight
+	pp.cm.TagPeer(p, tag, weight)
+}
+
+// RemoveWeight removes a weight set with SetWeight, and reports whether it
+// existed.
+func (pp *PeerProtector) RemoveWeight(p peer.ID, tag string) bool {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if _, ok := pp.weights[p][tag]; !ok {
+		return false
+	}
+	delete(pp.weights[p], tag)
+	if len(pp.weights[p]) == 0 {
+		delete(pp.weights, p)
+	}
+	pp.cm.UntagPeer(p, tag)
+	return true
+}
+
+func (pp *PeerProtector) Connected(_ network.Network, c network.Conn) {
+	p := c.RemotePeer()
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	for tag, weight := range pp.weights[p] {
+		pp.cm.TagPeer(p, tag, weight)
+	}
+}
+
+func (pp *PeerProtector) Disconnected(network.Network, network.Conn) {}
+func (pp *PeerProtector) Listen(network.Network, ma.Multiaddr)       {}
+func (pp *PeerProtector) ListenClose(network.Network, ma.Multiaddr)  {}
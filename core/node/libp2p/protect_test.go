@@ -0,0 +1,46 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerProtector(t *testing.T) {
+	cm, err := connmgr.NewConnManager(1, 10)
+	require.NoError(t, err)
+	h, err := libp2p.New(libp2p.ConnectionManager(cm), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h.Close()
+	other, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer other.Close()
+
+	pp := NewPeerProtector(h)
+	defer h.Network().StopNotify(pp)
+
+	// weights set before connecting are applied on connection
+	pp.SetWeight(other.ID(), "app", 42)
+	require.NoError(t, h.Connect(context.Background(), peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()}))
+	assert.Eventually(t, func() bool {
+		info := cm.GetTagInfo(other.ID())
+		return info != nil && info.Tags["app"] == 42
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.True(t, pp.RemoveWeight(other.ID(), "app"))
+	assert.False(t, pp.RemoveWeight(other.ID(), "app"))
+	assert.NotContains(t, cm.GetTagInfo(other.ID()).Tags, "app")
+
+	pp.Protect(other.ID(), "a")
+	pp.Protect(other.ID(), "b")
+	assert.True(t, cm.IsProtected(other.ID(), ""))
+	assert.True(t, pp.Unprotect(other.ID(), "a"))
+	assert.False(t, pp.Unprotect(other.ID(), "b"))
+	assert.False(t, cm.IsProtected(other.ID(), ""))
+}
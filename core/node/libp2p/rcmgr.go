@@ -28,8 +28,15 @@ const NetLimitTraceFilename = "rcmgr.json.gz"
 
 var ErrNoResourceMgr = fmt.Errorf("missing ResourceMgr: make sure the daemon is running with Swarm.ResourceMgr.Enabled")
 
+type resourceManagerIn struct {
+	fx.In
+
+	AllowList  *AllowList         `optional:"true"`
+	RelayStats *RelayServiceStats `optional:"true"`
+}
+
 func ResourceManager(cfg config.SwarmConfig, userResourceOverrides rcmgr.PartialLimitConfig) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo) (network.ResourceManager, Libp2pOpts, error) {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, repo repo.Repo, in resourceManagerIn) (network.ResourceManager, Libp2pOpts, error) {
 		var manager network.ResourceManager
 		var opts Libp2pOpts
 
@@ -113,7 +120,14 @@ filled in with autocomputed defaults.`)
 			manager = &network.NullResourceManager{}
 		}
 
-		opts.Opts = append(opts.Opts, libp2p.ResourceManager(manager))
+		wrapped := manager
+		if in.AllowList != nil {
+			wrapped = in.AllowList.WrapResourceManager(wrapped)
+		}
+		if in.RelayStats != nil {
+			wrapped = in.RelayStats.WrapResourceManager(wrapped)
+		}
+		opts.Opts = append(opts.Opts, libp2p.ResourceManager(wrapped))
 
 		lc.Append(fx.Hook{
 			OnStop: func(_ context.Context) error {
@@ -0,0 +1,325 @@
+package libp2p
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autonat"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"go.uber.org/fx"
+)
+
+// maxHolePunches is the number of hole punches kept by ReachabilityMonitor.
+const maxHolePunches = 32
+
+// ReachabilityMonitor collects what makes the node reachable, or not: the
+// AutoNAT verdict, the NAT port mappings, the relay reservations, the recent
+// hole punches and the addresses observed by peers.
+type ReachabilityMonitor struct {
+	mu          sync.Mutex
+	host        host.Host
+	basic       host.Host
+	natmgr      basichost.NATManager
+	natTypes    map[network.NATTransportProtocol]network.NATDeviceType
+	attempts    map[peer.ID]int
+	holePunches []HolePunch
+}
+
+// ReachabilityStatus is a snapshot of the reachability of the node.
+type ReachabilityStatus struct {
+	// Reachability is the AutoNAT verdict for the node as a whole. AutoNAT
+	// does not test transports separately.
+	Reachability  string
+	Transports    []TransportReachability
+	PortMapping   PortMappingStatus
+	Relays        []RelayReservation
+	HolePunches   []HolePunch
+	ObservedAddrs []string
+}
+
+// TransportReachability describes an address family and a transport the node
+// listens on. It has no reachability verdict of its own, see
+// ReachabilityStatus.Reachability.
+type TransportReachability struct {
+	Family        string
+	Transport     string
+	NATDeviceType string
+	// PublicAddrs are the public addresses announced for the transport.
+	PublicAddrs []string
+}
+
+// PortMappingStatus is the state of the UPnP / NAT-PMP port mappings.
+type PortMappingStatus struct {
+	Enabled       bool
+	NATDiscovered bool
+	Mappings      []PortMapping
+}
+
+// PortMapping maps a listen address to the external address of the NAT.
+type PortMapping struct {
+	Internal string
+	External string
+}
+
+// RelayReservation is a reservation of the node on a relay.
+type RelayReservation struct {
+	Relay peer.ID
+	Addrs []string
+}
+
+// HolePunch is the outcome of a DCUtR hole punch with a peer.
+type HolePunch struct {
+	Peer     peer.ID
+	Time     time.Time
+	Attempts int
+	Success  bool
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+}
+
+// NewReachabilityMonitor creates a reachability monitor. It collects data
+// once it has been attached to the host with MonitorReachability.
+func NewReachabilityMonitor() *ReachabilityMonitor {
+	return &ReachabilityMonitor{
+		natTypes: make(map[network.NATTransportProtocol]network.NATDeviceType),
+		attempts: make(map[peer.ID]int),
+	}
+}
+
+// MonitorReachability attaches the reachability monitor to the host.
+func MonitorReachability(lc fx.Lifecycle, m *ReachabilityMonitor, h host.Host) error {
+	sub, err := h.EventBus().Subscribe(new(event.EvtNATDeviceTypeChanged))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.host = h
+	m.mu.Unlock()
+
+	go func() {
+		for e := range sub.Out() {
+			evt := e.(event.EvtNATDeviceTypeChanged)
+			m.mu.Lock()
+			m.natTypes[evt.TransportProtocol] = evt.NatDeviceType
+			m.mu.Unlock()
+		}
+	}()
+
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return sub.Close()
+		},
+	})
+	return nil
+}
+
+// setBasicHost sets the host built by libp2p, before it gets wrapped. It
+// gives access to the AutoNAT and identify services.
+func (m *ReachabilityMonitor) setBasicHost(h host.Host) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.basic = h
+}
+
+// natManager wraps the constructor of the NAT manager of libp2p to keep a
+// reference to it.
+func (m *ReachabilityMonitor) natManager(n network.Network) basichost.NATManager {
+	nm := basichost.NewNATManager(n)
+	m.mu.Lock()
+	m.natmgr = nm
+	m.mu.Unlock()
+	return nm
+}
+
+// Trace implements holepunch.EventTracer.
+func (m *ReachabilityMonitor) Trace(evt *holepunch.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch e := evt.Evt.(type) {
+	case *holepunch.HolePunchAttemptEvt:
+		m.attempts[evt.Remote] = e.Attempt
+	case *holepunch.EndHolePunchEvt:
+		m.addHolePunch(HolePunch{
+			Peer:     evt.Remote,
+			Time:     time.Unix(0, evt.Timestamp),
+			Attempts: m.attempts[evt.Remote],
+			Success:  e.Success,
+			Duration: e.EllapsedTime,
+			Error:    e.Error,
+		})
+	case *holepunch.ProtocolErrorEvt:
+		m.addHolePunch(HolePunch{
+			Peer:     evt.Remote,
+			Time:     time.Unix(0, evt.Timestamp),
+			Attempts: m.attempts[evt.Remote],
+			Error:    e.Error,
+		})
+	}
+}
+
+func (m *ReachabilityMonitor) addHolePunch(hp HolePunch) {
+	delete(m.attempts, hp.Peer)
+	if len(m.holePunches) == maxHolePunches {
+		m.holePunches = append(m.holePunches[:0], m.holePunches[1:]...)
+	}
+	m.holePunches = append(m.holePunches, hp)
+}
+
+// Status returns the current reachability of the node.
+func (m *ReachabilityMonitor) Status() ReachabilityStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := ReachabilityStatus{
+		Reachability:  network.ReachabilityUnknown.String(),
+		Transports:    []TransportReachability{},
+		PortMapping:   PortMappingStatus{Mappings: []PortMapping{}},
+		Relays:        []RelayReservation{},
+		HolePunches:   append([]HolePunch{}, m.holePunches...),
+		ObservedAddrs: []string{},
+	}
+	if m.host == nil {
+		return status
+	}
+
+	if an, ok := m.basic.(interface{ GetAutoNat() autonat.AutoNAT }); ok && an.GetAutoNat() != nil {
+		status.Reachability = an.GetAutoNat().Status().String()
+	}
+
+	if ids, ok := m.basic.(interface{ IDService() identify.IDService }); ok {
+		for _, addr := range ids.IDService().OwnObservedAddrs() {
+			status.ObservedAddrs = append(status.ObservedAddrs, addr.String())
+		}
+		sort.Strings(status.ObservedAddrs)
+	}
+
+	listenAddrs := m.host.Network().ListenAddresses()
+	if m.natmgr != nil {
+		status.PortMapping.Enabled = true
+		status.PortMapping.NATDiscovered = m.natmgr.HasDiscoveredNAT()
+		for _, addr := range listenAddrs {
+			if ext := m.natmgr.GetMapping(addr); ext != nil {
+				status.PortMapping.Mappings = append(status.PortMapping.Mappings, PortMapping{
+					Internal: addr.String(),
+					External: ext.String(),
+				})
+			}
+		}
+	}
+
+	publicAddrs := make(map[[2]string][]string)
+	relays := make(map[peer.ID][]string)
+	for _, addr := range m.host.Addrs() {
+		if relay, ok := relayOf(addr); ok {
+			relays[relay] = append(relays[relay], addr.String())
+			continue
+		}
+		if manet.IsPublicAddr(addr) {
+			key := [2]string{addrFamily(addr), AddrTransport(addr)}
+			publicAddrs[key] = append(publicAddrs[key], addr.String())
+		}
+	}
+	for relay, addrs := range relays {
+		status.Relays = append(status.Relays, RelayReservation{Relay: relay, Addrs: addrs})
+	}
+	sort.Slice(status.Relays, func(i, j int) bool {
+		return status.Relays[i].Relay < status.Relays[j].Relay
+	})
+
+	seen := make(map[[2]string]bool)
+	for _, addr := range listenAddrs {
+		if _, ok := relayOf(addr); ok {
+			continue
+		}
+		key := [2]string{addrFamily(addr), AddrTransport(addr)}
+		if key[0] == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		tr := TransportReachability{
+			Family:        key[0],
+			Transport:     key[1],
+			NATDeviceType: m.natTypes[natTransportProtocol(addr)].String(),
+			PublicAddrs:   publicAddrs[key],
+		}
+		if tr.PublicAddrs == nil {
+			tr.PublicAddrs = []string{}
+		}
+		status.Transports = append(status.Transports, tr)
+	}
+	sort.Slice(status.Transports, func(i, j int) bool {
+		a, b := status.Transports[i], status.Transports[j]
+		if a.Family != b.Family {
+			return a.Family < b.Family
+		}
+		return a.Transport < b.Transport
+	})
+
+	return status
+}
+
+// relayOf returns the relay of a circuit address.
+func relayOf(addr ma.Multiaddr) (peer.ID, bool) {
+	relayAddr, circuit := ma.SplitFunc(addr, func(c ma.Component) bool {
+		return c.Protocol().Code == ma.P_CIRCUIT
+	})
+	if circuit == nil {
+		return "", false
+	}
+	info, err := peer.AddrInfoFromP2pAddr(relayAddr)
+	if err != nil {
+		return "", false
+	}
+	return info.ID, true
+}
+
+// addrFamily returns ip4 or ip6, or an empty string for other addresses.
+func addrFamily(addr ma.Multiaddr) string {
+	first, _ := ma.SplitFirst(addr)
+	if first == nil {
+		return ""
+	}
+	switch first.Protocol().Code {
+	case ma.P_IP4:
+		return "ip4"
+	case ma.P_IP6:
+		return "ip6"
+	}
+	return ""
+}
+
+// AddrTransport returns the name of the outermost transport of an address,
+// for example tcp, ws, quic-v1 or webtransport.
+func AddrTransport(addr ma.Multiaddr) string {
+	var name string
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6, ma.P_IP6ZONE, ma.P_DNS, ma.P_DNS4, ma.P_DNS6,
+			ma.P_CERTHASH, ma.P_SNI, ma.P_P2P:
+		default:
+			name = c.Protocol().Name
+		}
+		return true
+	})
+	return name
+}
+
+func natTransportProtocol(addr ma.Multiaddr) network.NATTransportProtocol {
+	if _, err := addr.ValueForProtocol(ma.P_UDP); err == nil {
+		return network.NATTransportUDP
+	}
+	return network.NATTransportTCP
+}
@@ -0,0 +1,57 @@
+package libp2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddrTransport(t *testing.T) {
+	for addr, expected := range map[string][2]string{
+		"/ip4/1.2.3.4/tcp/4001":         {"ip4", "tcp"},
+		"/ip6/::1/tcp/4001/ws":          {"ip6", "ws"},
+		"/ip4/1.2.3.4/udp/4001/quic-v1": {"ip4", "quic-v1"},
+		"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport/certhash/uEiAkH5a4DPGKUuOBjYw0CgwjvcJCJMD2K_1aluKR_tpevQ/p2p/12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5": {"ip4", "webtransport"},
+		"/dns4/example.com/tcp/443/wss": {"", "wss"},
+	} {
+		a := ma.StringCast(addr)
+		assert.Equal(t, expected[0], addrFamily(a), addr)
+		assert.Equal(t, expected[1], AddrTransport(a), addr)
+	}
+}
+
+func TestRelayOf(t *testing.T) {
+	const relay = "12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5"
+	id, ok := relayOf(ma.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + relay + "/p2p-circuit"))
+	require.True(t, ok)
+	assert.Equal(t, relay, id.String())
+
+	_, ok = relayOf(ma.StringCast("/ip4/1.2.3.4/tcp/4001/p2p/" + relay))
+	assert.False(t, ok)
+}
+
+func TestReachabilityMonitorHolePunches(t *testing.T) {
+	m := NewReachabilityMonitor()
+	remote := peer.ID("remote")
+	now := time.Now()
+
+	m.Trace(&holepunch.Event{Remote: remote, Timestamp: now.UnixNano(), Evt: &holepunch.HolePunchAttemptEvt{Attempt: 1}})
+	m.Trace(&holepunch.Event{Remote: remote, Timestamp: now.UnixNano(), Evt: &holepunch.HolePunchAttemptEvt{Attempt: 2}})
+	m.Trace(&holepunch.Event{Remote: remote, Timestamp: now.UnixNano(), Evt: &holepunch.EndHolePunchEvt{Success: true, EllapsedTime: time.Second}})
+
+	status := m.Status()
+	require.Len(t, status.HolePunches, 1)
+	assert.Equal(t, HolePunch{Peer: remote, Time: time.Unix(0, now.UnixNano()), Attempts: 2, Success: true, Duration: time.Second}, status.HolePunches[0])
+
+	for i := 0; i < maxHolePunches; i++ {
+		m.Trace(&holepunch.Event{Remote: remote, Evt: &holepunch.ProtocolErrorEvt{Error: "failed"}})
+	}
+	status = m.Status()
+	assert.Len(t, status.HolePunches, maxHolePunches)
+	assert.Equal(t, "failed", status.HolePunches[0].Error)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
 	"go.uber.org/fx"
 )
 
@@ -22,13 +23,19 @@ func RelayTransport(enableRelay bool) func() (opts Libp2pOpts, err error) {
 	}
 }
 
-func RelayService(enable bool, relayOpts config.RelayService) func() (opts Libp2pOpts, err error) {
-	return func() (opts Libp2pOpts, err error) {
+type relayServiceIn struct {
+	fx.In
+
+	Stats *RelayServiceStats `optional:"true"`
+}
+
+func RelayService(enable bool, relayOpts config.RelayService) func(in relayServiceIn) (opts Libp2pOpts, err error) {
+	return func(in relayServiceIn) (opts Libp2pOpts, err error) {
 		if enable {
 			def := relay.DefaultResources()
 			// Real defaults live in go-libp2p.
 			// Here we apply any overrides from user config.
-			opts.Opts = append(opts.Opts, libp2p.EnableRelayService(relay.WithResources(relay.Resources{
+			relayOptions := []relay.Option{relay.WithResources(relay.Resources{
 				Limit: &relay.RelayLimit{
 					Data:     relayOpts.ConnectionDataLimit.WithDefault(def.Limit.Data),
 					Duration: relayOpts.ConnectionDurationLimit.WithDefault(def.Limit.Duration),
@@ -40,7 +47,12 @@ func RelayService(enable bool, relayOpts config.RelayService) func() (opts Libp2
 				MaxReservationsPerIP:   int(relayOpts.MaxReservationsPerIP.WithDefault(int64(def.MaxReservationsPerIP))),
 				MaxReservationsPerPeer: int(relayOpts.MaxReservationsPerPeer.WithDefault(int64(def.MaxReservationsPerPeer))),
 				MaxReservationsPerASN:  int(relayOpts.MaxReservationsPerASN.WithDefault(int64(def.MaxReservationsPerASN))),
-			})))
+			})}
+			if in.Stats != nil {
+				// restricts and accounts for the reservations
+				relayOptions = append(relayOptions, relay.WithACL(in.Stats))
+			}
+			opts.Opts = append(opts.Opts, libp2p.EnableRelayService(relayOptions...))
 		}
 		return
 	}
@@ -106,8 +118,8 @@ func MaybeAutoRelay(staticRelays []string, cfgPeering config.Peering, enabled bo
 	)
 }
 
-func HolePunching(flag config.Flag, hasRelayClient bool) func() (opts Libp2pOpts, err error) {
-	return func() (opts Libp2pOpts, err error) {
+func HolePunching(flag config.Flag, hasRelayClient bool) func(m *ReachabilityMonitor) (opts Libp2pOpts, err error) {
+	return func(m *ReachabilityMonitor) (opts Libp2pOpts, err error) {
 		if flag.WithDefault(true) {
 			if !hasRelayClient {
 				// If hole punching is explicitly enabled but the relay client is disabled then panic,
@@ -119,7 +131,9 @@ func HolePunching(flag config.Flag, hasRelayClient bool) func() (opts Libp2pOpts
 				}
 				return
 			}
-			opts.Opts = append(opts.Opts, libp2p.EnableHolePunching())
+			// keep the metrics tracer libp2p sets up by default
+			tracer := holepunch.WithMetricsAndEventTracer(holepunch.NewMetricsTracer(), m)
+			opts.Opts = append(opts.Opts, libp2p.EnableHolePunching(tracer))
 		}
 		return
 	}
@@ -0,0 +1,291 @@
+package libp2p
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	ma "github.com/multiformats/go-multiaddr"
+
+	"github.com/ipfs/kubo/config"
+)
+
+// relayReservationTag is the connection manager tag of the peers with a
+// reservation on the relay service of go-libp2p.
+const relayReservationTag = "relay-reservation"
+
+// stopProtocol is the protocol of the streams the relay opens to the
+// destination of a circuit.
+const stopProtocol = protocol.ID(proto.ProtoIDv2Stop)
+
+// relayStatsPruneInterval is the minimum interval between two removals of the
+// expired reservations.
+const relayStatsPruneInterval = time.Minute
+
+// RelayReservationStats is the accounting of a reservation on the relay
+// service of the node.
+type RelayReservationStats struct {
+	Peer    peer.ID
+	Addr    string
+	Since   time.Time
+	Expires time.Time
+	// Renewals is the number of times the reservation was refreshed.
+	Renewals int
+	// Circuits is the number of relayed connections to the peer, and
+	// ActiveCircuits the number of those still open.
+	Circuits       int
+	ActiveCircuits int
+	// CircuitsDuration is the total duration of the closed circuits.
+	CircuitsDuration time.Duration
+	// BytesIn and BytesOut are the bytes relayed from and to the peer.
+	BytesIn  int64
+	BytesOut int64
+}
+
+// RelayServiceStatus is the state of the relay service of the node.
+type RelayServiceStatus struct {
+	Reservations []RelayReservationStats
+	// Denied is the number of reservations refused by
+	// Swarm.RelayService.ReservationAllowList.
+	Denied int
+}
+
+// RelayServiceStats restricts the reservations on the relay service to an
+// allow-list, and accounts for the reservations.
+//
+// Circuits are counted from the resource manager, and bytes from the
+// bandwidth reporter of libp2p, which is only set when the bandwidth metrics
+// are enabled.
+//
+// The reservations are also accounted for when the relay refuses them
+// because of its limits, as it checks the allow-list first. They are removed
+// once expired, on the next reservation or status.
+type RelayServiceStats struct {
+	allowed    map[peer.ID]struct{}
+	accounting bool
+	ttl        time.Duration
+	now        func() time.Time
+
+	mu           sync.Mutex
+	reservations map[peer.ID]*RelayReservationStats
+	denied       int
+	pruned       time.Time
+}
+
+// NewRelayServiceStats creates the allow-list of the relay service, which
+// also accounts for the reservations when accounting is set. Empty allowed
+// peers allow all peers.
+func NewRelayServiceStats(allowed []peer.ID, accounting bool, ttl time.Duration) *RelayServiceStats {
+	s := &RelayServiceStats{
+		accounting:   accounting,
+		ttl:          ttl,
+		now:          time.Now,
+		reservations: make(map[peer.ID]*RelayReservationStats),
+	}
+	if len(allowed) > 0 {
+		s.allowed = make(map[peer.ID]struct{}, len(allowed))
+		for _, p := range allowed {
+			s.allowed[p] = struct{}{}
+		}
+	}
+	return s
+}
+
+// RelayStats constructs the accounting of the relay service configured by
+// Swarm.RelayService.
+func RelayStats(cfg config.RelayService) func() *RelayServiceStats {
+	return func() *RelayServiceStats {
+		ttl := cfg.ReservationTTL.WithDefault(relay.DefaultResources().ReservationTTL)
+		return NewRelayServiceStats(cfg.ReservationAllowList, cfg.Accounting.WithDefault(false), ttl)
+	}
+}
+
+// Accounting reports whether the reservations are accounted for.
+func (s *RelayServiceStats) Accounting() bool {
+	return s.accounting
+}
+
+// AllowReserve implements relay.ACLFilter.
+func (s *RelayServiceStats) AllowReserve(p peer.ID, a ma.Multiaddr) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.allowed != nil {
+		if _, ok := s.allowed[p]; !ok {
+			s.denied++
+			return false
+		}
+	}
+	if !s.accounting {
+		return true
+	}
+
+	now := s.now()
+	if now.Sub(s.pruned) >= relayStatsPruneInterval {
+		s.prune(now)
+	}
+	r, ok := s.reservations[p]
+	switch {
+	case !ok || (!r.Expires.After(now) && r.ActiveCircuits == 0):
+		r = &RelayReservationStats{Peer: p, Since: now}
+		s.reservations[p] = r
+	case r.Expires.After(now):
+		r.Renewals++
+	}
+	r.Addr = a.String()
+	r.Expires = now.Add(s.ttl)
+	return true
+}
+
+// AllowConnect implements relay.ACLFilter.
+func (s *RelayServiceStats) AllowConnect(peer.ID, ma.Multiaddr, peer.ID) bool {
+	return true
+}
+
+// prune removes the expired reservations without open circuits.
+func (s *RelayServiceStats) prune(now time.Time) {
+	for p, r := range s.reservations {
+		if !r.Expires.After(now) && r.ActiveCircuits == 0 {
+			delete(s.reservations, p)
+		}
+	}
+	s.pruned = now
+}
+
+func (s *RelayServiceStats) circuitOpened(p peer.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.reservations[p]; ok {
+		r.Circuits++
+		r.ActiveCircuits++
+	}
+}
+
+func (s *RelayServiceStats) circuitClosed(p peer.ID, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.reservations[p]; ok && r.ActiveCircuits > 0 {
+		r.ActiveCircuits--
+		r.CircuitsDuration += d
+	}
+}
+
+func (s *RelayServiceStats) relayed(p peer.ID, in, out int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.reservations[p]; ok {
+		r.BytesIn += in
+		r.BytesOut += out
+	}
+}
+
+// Status returns the accounting of the active reservations, and of the
+// expired ones with circuits still open. The relay drops the reservations
+// of the peers that disconnect, and those it refuses because of its limits.
+func (s *RelayServiceStats) Status(h host.Host) RelayServiceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	status := RelayServiceStatus{Reservations: []RelayReservationStats{}, Denied: s.denied}
+	for p, r := range s.reservations {
+		active := r.Expires.After(now) && h.Network().Connectedness(p) == network.Connected
+		if info := h.ConnManager().GetTagInfo(p); active && info != nil {
+			_, active = info.Tags[relayReservationTag]
+		}
+		if !active && r.ActiveCircuits == 0 {
+			delete(s.reservations, p)
+			continue
+		}
+		status.Reservations = append(status.Reservations, *r)
+	}
+	sort.Slice(status.Reservations, func(i, j int) bool {
+		return status.Reservations[i].Since.Before(status.Reservations[j].Since)
+	})
+	return status
+}
+
+// WrapResourceManager returns a resource manager counting the circuits
+// relayed to the peers with a reservation.
+func (s *RelayServiceStats) WrapResourceManager(rm network.ResourceManager) network.ResourceManager {
+	if !s.accounting {
+		return rm
+	}
+	return &relayStatsResourceManager{ResourceManager: rm, stats: s}
+}
+
+// WrapReporter returns a bandwidth reporter counting the bytes relayed to
+// the peers with a reservation.
+func (s *RelayServiceStats) WrapReporter(rep metrics.Reporter) metrics.Reporter {
+	if !s.accounting {
+		return rep
+	}
+	return &relayStatsReporter{Reporter: rep, stats: s}
+}
+
+type relayStatsResourceManager struct {
+	network.ResourceManager
+	stats *RelayServiceStats
+}
+
+func (rm *relayStatsResourceManager) OpenStream(p peer.ID, dir network.Direction) (network.StreamManagementScope, error) {
+	scope, err := rm.ResourceManager.OpenStream(p, dir)
+	if err != nil || dir != network.DirOutbound {
+		return scope, err
+	}
+	return &relayStatsStreamScope{StreamManagementScope: scope, peer: p, stats: rm.stats}, nil
+}
+
+// relayStatsStreamScope tracks the stop streams the relay opens to the
+// destination of circuits.
+type relayStatsStreamScope struct {
+	network.StreamManagementScope
+	peer   peer.ID
+	stats  *RelayServiceStats
+	opened time.Time
+}
+
+func (s *relayStatsStreamScope) SetProtocol(p protocol.ID) error {
+	if err := s.StreamManagementScope.SetProtocol(p); err != nil {
+		return err
+	}
+	if p == stopProtocol {
+		s.opened = time.Now()
+		s.stats.circuitOpened(s.peer)
+	}
+	return nil
+}
+
+func (s *relayStatsStreamScope) Done() {
+	if !s.opened.IsZero() {
+		s.stats.circuitClosed(s.peer, time.Since(s.opened))
+		s.opened = time.Time{}
+	}
+	s.StreamManagementScope.Done()
+}
+
+type relayStatsReporter struct {
+	metrics.Reporter
+	stats *RelayServiceStats
+}
+
+func (r *relayStatsReporter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	r.Reporter.LogSentMessageStream(size, proto, p)
+	if proto == stopProtocol {
+		r.stats.relayed(p, 0, size)
+	}
+}
+
+func (r *relayStatsReporter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	r.Reporter.LogRecvMessageStream(size, proto, p)
+	if proto == stopProtocol {
+		r.stats.relayed(p, size, 0)
+	}
+}
@@ -0,0 +1,111 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelayServiceStats(t *testing.T) {
+	cm, err := connmgr.NewConnManager(1, 10)
+	require.NoError(t, err)
+	h, err := libp2p.New(libp2p.ConnectionManager(cm), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer h.Close()
+	other, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer other.Close()
+	require.NoError(t, h.Connect(context.Background(), peer.AddrInfo{ID: other.ID(), Addrs: other.Addrs()}))
+
+	s := NewRelayServiceStats([]peer.ID{other.ID()}, true, time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	addr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+
+	// peers outside of the allow-list are refused
+	assert.False(t, s.AllowReserve(peer.ID("stranger"), addr))
+	assert.True(t, s.AllowReserve(other.ID(), addr))
+	cm.TagPeer(other.ID(), relayReservationTag, 10)
+
+	now = now.Add(time.Minute)
+	assert.True(t, s.AllowReserve(other.ID(), addr))
+	s.circuitOpened(other.ID())
+	s.relayed(other.ID(), 10, 20)
+	s.circuitClosed(other.ID(), time.Second)
+	s.circuitOpened(other.ID())
+
+	status := s.Status(h)
+	assert.Equal(t, 1, status.Denied)
+	require.Len(t, status.Reservations, 1)
+	r := status.Reservations[0]
+	assert.Equal(t, other.ID(), r.Peer)
+	assert.Equal(t, addr.String(), r.Addr)
+	assert.Equal(t, now.Add(-time.Minute), r.Since)
+	assert.Equal(t, now.Add(time.Hour), r.Expires)
+	assert.Equal(t, 1, r.Renewals)
+	assert.Equal(t, 2, r.Circuits)
+	assert.Equal(t, 1, r.ActiveCircuits)
+	assert.Equal(t, time.Second, r.CircuitsDuration)
+	assert.Equal(t, int64(10), r.BytesIn)
+	assert.Equal(t, int64(20), r.BytesOut)
+
+	// expired reservations are kept while circuits are open
+	now = now.Add(2 * time.Hour)
+	assert.Len(t, s.Status(h).Reservations, 1)
+	s.circuitClosed(other.ID(), time.Second)
+	assert.Empty(t, s.Status(h).Reservations)
+
+	// a new reservation after the expiration starts over
+	assert.True(t, s.AllowReserve(other.ID(), addr))
+	r = s.Status(h).Reservations[0]
+	assert.Equal(t, now, r.Since)
+	assert.Zero(t, r.Renewals)
+	assert.Zero(t, r.Circuits)
+}
+
+func TestRelayServiceStatsAllowAll(t *testing.T) {
+	s := NewRelayServiceStats(nil, true, time.Hour)
+	assert.True(t, s.AllowReserve(peer.ID("anyone"), ma.StringCast("/ip4/127.0.0.1/tcp/4001")))
+	assert.True(t, s.AllowConnect(peer.ID("a"), nil, peer.ID("b")))
+}
+
+func TestRelayServiceStatsPrune(t *testing.T) {
+	s := NewRelayServiceStats(nil, true, time.Hour)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	addr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+
+	// reservations refused by the limits of the relay are accounted for too
+	assert.True(t, s.AllowReserve(peer.ID("a"), addr))
+	assert.True(t, s.AllowReserve(peer.ID("b"), addr))
+	s.circuitOpened(peer.ID("b"))
+	require.Len(t, s.reservations, 2)
+
+	// and removed once expired by the next reservation, unless circuits are
+	// still open
+	now = now.Add(2 * time.Hour)
+	assert.True(t, s.AllowReserve(peer.ID("c"), addr))
+	assert.NotContains(t, s.reservations, peer.ID("a"))
+	assert.Contains(t, s.reservations, peer.ID("b"))
+	assert.Contains(t, s.reservations, peer.ID("c"))
+}
+
+func TestRelayServiceStatsNoAccounting(t *testing.T) {
+	s := NewRelayServiceStats([]peer.ID{peer.ID("allowed")}, false, time.Hour)
+	addr := ma.StringCast("/ip4/127.0.0.1/tcp/4001")
+	assert.True(t, s.AllowReserve(peer.ID("allowed"), addr))
+	assert.False(t, s.AllowReserve(peer.ID("stranger"), addr))
+	assert.False(t, s.Accounting())
+	assert.Empty(t, s.reservations)
+
+	rm := &network.NullResourceManager{}
+	assert.Same(t, rm, s.WrapResourceManager(rm))
+}
@@ -49,6 +49,8 @@ type processInitialRoutingIn struct {
 	Host      host.Host
 	Repo      repo.Repo
 	Validator record.Validator
+
+	ProvideTracker *irouting.ProvideTracker `optional:"true"`
 }
 
 type processInitialRoutingOut struct {
@@ -78,6 +80,9 @@ func BaseRouting(cfg *config.Config) interface{} {
 
 		if cr, ok := in.Router.(routinghelpers.ComposableRouter); ok {
 			for _, r := range cr.Routers() {
+				if u, ok := r.(interface{ Unwrap() routing.Routing }); ok {
+					r = u.Unwrap()
+				}
 				if dht, ok := r.(*ddht.DHT); ok {
 					dualDHT = dht
 					lc.Append(fx.Hook{
@@ -100,7 +105,11 @@ func BaseRouting(cfg *config.Config) interface{} {
 				return out, err
 			}
 
-			fullRTClient, err := fullrt.NewFullRT(in.Host,
+			h := in.Host
+			if in.ProvideTracker != nil {
+				h = in.ProvideTracker.WrapHost(h)
+			}
+			fullRTClient, err := fullrt.NewFullRT(h,
 				dht.DefaultPrefix,
 				fullrt.DHTOption(
 					dht.Validator(in.Validator),
@@ -126,7 +135,7 @@ func BaseRouting(cfg *config.Config) interface{} {
 				return out, err
 			}
 			routers := []*routinghelpers.ParallelRouter{
-				{Router: fullRTClient, DoNotWaitForSearchValue: true},
+				{Router: irouting.MeasureRouter("dht", fullRTClient), DoNotWaitForSearchValue: true},
 			}
 			routers = append(routers, httpRouters...)
 			router := routinghelpers.NewComposableParallel(routers)
@@ -182,6 +191,8 @@ type p2pOnlineRoutingIn struct {
 
 	Routers   []Router `group:"routers"`
 	Validator record.Validator
+
+	ProvideTracker *irouting.ProvideTracker `optional:"true"`
 }
 
 // Routing will get all routers obtained from different methods
@@ -203,7 +214,11 @@ func Routing(in p2pOnlineRoutingIn) irouting.ProvideManyRouter {
 		})
 	}
 
-	return routinghelpers.NewComposableParallel(cRouters)
+	var r irouting.ProvideManyRouter = routinghelpers.NewComposableParallel(cRouters)
+	if in.ProvideTracker != nil {
+		r = in.ProvideTracker.TrackProvides(r)
+	}
+	return r
 }
 
 // OfflineRouting provides a special Router to the routers list when we are creating a offline node.
@@ -27,6 +27,8 @@ type RoutingOptionArgs struct {
 	OptimisticProvide             bool
 	OptimisticProvideJobsPoolSize int
 	LoopbackAddressesOnLanDHT     bool
+	// ProvideTracker records the announcements of the routers, if set.
+	ProvideTracker *irouting.ProvideTracker
 }
 
 type RoutingOption func(args RoutingOptionArgs) (routing.Routing, error)
@@ -62,7 +64,7 @@ func constructDefaultHTTPRouters(cfg *config.Config) ([]*routinghelpers.Parallel
 		}
 
 		routers = append(routers, &routinghelpers.ParallelRouter{
-			Router:                  r,
+			Router:                  irouting.MeasureRouter(endpoint, r),
 			IgnoreError:             true,             // https://github.com/ipfs/kubo/pull/9475#discussion_r1042507387
 			Timeout:                 15 * time.Second, // 5x server value from https://github.com/ipfs/kubo/pull/9475#discussion_r1042428529
 			DoNotWaitForSearchValue: true,
@@ -84,7 +86,7 @@ func ConstructDefaultRouting(cfg *config.Config, routingOpt RoutingOption) Routi
 			return nil, err
 		}
 		routers = append(routers, &routinghelpers.ParallelRouter{
-			Router:                  dhtRouting,
+			Router:                  irouting.MeasureRouter("dht", dhtRouting),
 			IgnoreError:             false,
 			DoNotWaitForSearchValue: true,
 			ExecuteAfter:            0,
@@ -136,7 +138,7 @@ func constructDHTRouting(mode dht.ModeOpt) RoutingOption {
 // ConstructDelegatedRouting is used when Routing.Type = "custom"
 func ConstructDelegatedRouting(routers config.Routers, methods config.Methods, peerID string, addrs config.Addresses, privKey string) RoutingOption {
 	return func(args RoutingOptionArgs) (routing.Routing, error) {
-		return irouting.Parse(routers, methods,
+		return irouting.ParseWithCache(routers, methods,
 			&irouting.ExtraDHTParams{
 				BootstrapPeers: args.BootstrapPeers,
 				Host:           args.Host,
@@ -145,9 +147,14 @@ func ConstructDelegatedRouting(routers config.Routers, methods config.Methods, p
 				Context:        args.Ctx,
 			},
 			&irouting.ExtraHTTPParams{
-				PeerID:     peerID,
-				Addrs:      httpAddrsFromConfig(addrs),
-				PrivKeyB64: privKey,
+				PeerID:         peerID,
+				Addrs:          httpAddrsFromConfig(addrs),
+				PrivKeyB64:     privKey,
+				ProvideTracker: args.ProvideTracker,
+			},
+			&irouting.ExtraCacheParams{
+				Datastore: args.Datastore,
+				Context:   args.Ctx,
 			},
 		)
 	}
@@ -63,8 +63,18 @@ func Transports(tptConfig config.Transports) interface{} {
 	}
 }
 
-func BandwidthCounter() (opts Libp2pOpts, reporter *metrics.BandwidthCounter) {
+type bandwidthCounterIn struct {
+	fx.In
+
+	RelayStats *RelayServiceStats `optional:"true"`
+}
+
+func BandwidthCounter(in bandwidthCounterIn) (opts Libp2pOpts, reporter *metrics.BandwidthCounter) {
 	reporter = metrics.NewBandwidthCounter()
-	opts.Opts = append(opts.Opts, libp2p.BandwidthReporter(reporter))
+	var rep metrics.Reporter = reporter
+	if in.RelayStats != nil {
+		rep = in.RelayStats.WrapReporter(rep)
+	}
+	opts.Opts = append(opts.Opts, libp2p.BandwidthReporter(rep))
 	return opts, reporter
 }
@@ -0,0 +1,114 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	provider "github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/pinsync"
+)
+
+// PinSync constructs the pinset sync service and hooks it into fx's lifetime
+// management system.
+func PinSync(cfg config.PinSync, peering config.Peering) interface{} {
+	peers := cfg.Peers
+	if len(peers) == 0 {
+		for _, ai := range peering.Peers {
+			peers = append(peers, ai.ID)
+		}
+	}
+	syncCfg := pinsync.Config{
+		Peers:      peers,
+		Interval:   cfg.Interval.WithDefault(config.DefaultPinSyncInterval),
+		PinTimeout: cfg.PinTimeout.WithDefault(config.DefaultPinSyncPinTimeout),
+	}
+
+	return func(lc fx.Lifecycle, h host.Host, sk crypto.PrivKey, ds datastore.Datastore, pinning pin.Pinner, dag format.DAGService, gcLocker blockstore.GCLocker, prov provider.System) (*pinsync.Service, error) {
+		if syncCfg.Interval <= 0 {
+			return nil, fmt.Errorf("Pinning.Sync.Interval must be positive, got %s", syncCfg.Interval)
+		}
+		if syncCfg.PinTimeout <= 0 {
+			return nil, fmt.Errorf("Pinning.Sync.PinTimeout must be positive, got %s", syncCfg.PinTimeout)
+		}
+		if len(syncCfg.Peers) == 0 {
+			logger.Warn("Pinning.Sync is enabled, but there are no peers to sync with: set Pinning.Sync.Peers or Peering.Peers")
+		}
+
+		ps := &pinsyncPinset{pinning: pinning, dag: dag, gcLocker: gcLocker, provider: prov}
+		svc, err := pinsync.New(h, sk, ds, ps, syncCfg)
+		if err != nil {
+			return nil, err
+		}
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				return svc.Start()
+			},
+			OnStop: func(context.Context) error {
+				return svc.Stop()
+			},
+		})
+		return svc, nil
+	}
+}
+
+// pinsyncPinset exposes the recursive pins of the node to the pinset sync
+// service.
+type pinsyncPinset struct {
+	pinning  pin.Pinner
+	dag      format.DAGService
+	gcLocker blockstore.GCLocker
+	provider provider.System
+}
+
+func (p *pinsyncPinset) Ls(ctx context.Context) (map[cid.Cid]string, error) {
+	pins := map[cid.Cid]string{}
+	for sp := range p.pinning.RecursiveKeys(ctx, true) {
+		if sp.Err != nil {
+			return nil, sp.Err
+		}
+		pins[sp.Pin.Key] = sp.Pin.Name
+	}
+	return pins, nil
+}
+
+func (p *pinsyncPinset) Pin(ctx context.Context, c cid.Cid, name string) error {
+	nd, err := p.dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	defer p.gcLocker.PinLock(ctx).Unlock(ctx)
+
+	if err := p.pinning.Pin(ctx, nd, true, name); err != nil {
+		return err
+	}
+	if err := p.provider.Provide(c); err != nil {
+		return err
+	}
+	return p.pinning.Flush(ctx)
+}
+
+func (p *pinsyncPinset) Unpin(ctx context.Context, c cid.Cid) error {
+	defer p.gcLocker.PinLock(ctx).Unlock(ctx)
+
+	if err := p.pinning.Unpin(ctx, c, true); err != nil {
+		if errors.Is(err, pin.ErrNotPinned) {
+			return nil
+		}
+		return err
+	}
+	return p.pinning.Flush(ctx)
+}
+
+var _ pinsync.Pinset = (*pinsyncPinset)(nil)
@@ -0,0 +1,31 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	blockstore "github.com/ipfs/boxo/blockstore"
+	pin "github.com/ipfs/boxo/pinning/pinner"
+	provider "github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-datastore"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/pinsync"
+)
+
+func TestPinSyncConfig(t *testing.T) {
+	for _, cfg := range []config.PinSync{
+		{Interval: config.NewOptionalDuration(0)},
+		{Interval: config.NewOptionalDuration(-time.Minute)},
+		{PinTimeout: config.NewOptionalDuration(0)},
+	} {
+		newPinSync := PinSync(cfg, config.Peering{}).(func(fx.Lifecycle, host.Host, crypto.PrivKey, datastore.Datastore, pin.Pinner, format.DAGService, blockstore.GCLocker, provider.System) (*pinsync.Service, error))
+		_, err := newPinSync(nil, nil, nil, nil, nil, nil, nil, nil)
+		require.ErrorContains(t, err, "must be positive")
+	}
+}
@@ -3,20 +3,36 @@ package node
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/ipfs/boxo/blockservice"
 	"github.com/ipfs/boxo/blockstore"
+	offline "github.com/ipfs/boxo/exchange/offline"
 	"github.com/ipfs/boxo/fetcher"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/mfs"
 	pin "github.com/ipfs/boxo/pinning/pinner"
 	provider "github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/kubo/repo"
 	irouting "github.com/ipfs/kubo/routing"
 	"go.uber.org/fx"
 )
 
+type providerSysIn struct {
+	fx.In
+
+	ServePolicy *ServePolicy `optional:"true"`
+}
+
 func ProviderSys(reprovideInterval time.Duration, acceleratedDHTClient bool) fx.Option {
 	const magicThroughputReportCount = 128
-	return fx.Provide(func(lc fx.Lifecycle, cr irouting.ProvideManyRouter, keyProvider provider.KeyChanFunc, repo repo.Repo, bs blockstore.Blockstore) (provider.System, error) {
+	return fx.Provide(func(lc fx.Lifecycle, cr irouting.ProvideManyRouter, keyProvider provider.KeyChanFunc, repo repo.Repo, bs blockstore.Blockstore, in providerSysIn) (provider.System, error) {
+		if in.ServePolicy != nil {
+			keyProvider = in.ServePolicy.FilterKeyProvider(keyProvider)
+		}
 		opts := []provider.Option{
 			provider.Online(cr),
 			provider.ReproviderInterval(reprovideInterval),
@@ -111,6 +127,10 @@ https://github.com/ipfs/kubo/blob/master/docs/config.md#routingaccelerateddhtcli
 		if err != nil {
 			return nil, err
 		}
+		if in.ServePolicy != nil {
+			// the content restricted by Bitswap.ServePolicies is not announced
+			sys = in.ServePolicy.WrapProvider(sys)
+		}
 
 		lc.Append(fx.Hook{
 			OnStop: func(ctx context.Context) error {
@@ -133,15 +153,22 @@ func OnlineProviders(useStrategicProviding bool, reprovideStrategy string, repro
 	var keyProvider fx.Option
 	switch reprovideStrategy {
 	case "all", "":
-		keyProvider = fx.Provide(newProvidingStrategy(false, false))
-	case "roots":
-		keyProvider = fx.Provide(newProvidingStrategy(true, true))
-	case "pinned":
-		keyProvider = fx.Provide(newProvidingStrategy(true, false))
+		keyProvider = fx.Provide(newProvidingStrategy())
 	case "flat":
 		keyProvider = fx.Provide(provider.NewBlockstoreProvider)
 	default:
-		return fx.Error(fmt.Errorf("unknown reprovider strategy %q", reprovideStrategy))
+		// the other strategies can be combined, e.g. "pinned+mfs"
+		strategies := strings.Split(reprovideStrategy, "+")
+		for _, strategy := range strategies {
+			switch strategy {
+			case "roots", "pinned", "mfs":
+			case "all", "flat":
+				return fx.Error(fmt.Errorf("reprovider strategy %q cannot be combined with other strategies", strategy))
+			default:
+				return fx.Error(fmt.Errorf("unknown reprovider strategy %q", reprovideStrategy))
+			}
+		}
+		keyProvider = fx.Provide(newCombinedProvidingStrategy(strategies))
 	}
 
 	return fx.Options(
@@ -155,22 +182,112 @@ func OfflineProviders() fx.Option {
 	return fx.Provide(provider.NewNoopProvider)
 }
 
-func newProvidingStrategy(onlyPinned, onlyRoots bool) interface{} {
+func newCombinedProvidingStrategy(strategies []string) interface{} {
 	type input struct {
 		fx.In
 		Pinner      pin.Pinner
 		Blockstore  blockstore.Blockstore
 		IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
+		MFSRoot     *mfs.Root
 	}
 	return func(in input) provider.KeyChanFunc {
-		if onlyRoots {
-			return provider.NewPinnedProvider(true, in.Pinner, in.IPLDFetcher)
+		providers := make([]provider.KeyChanFunc, len(strategies))
+		for i, strategy := range strategies {
+			switch strategy {
+			case "roots":
+				providers[i] = provider.NewPinnedProvider(true, in.Pinner, in.IPLDFetcher)
+			case "pinned":
+				providers[i] = provider.NewPinnedProvider(false, in.Pinner, in.IPLDFetcher)
+			case "mfs":
+				providers[i] = newMFSProvider(in.MFSRoot, in.Blockstore)
+			}
+		}
+		if len(providers) == 1 {
+			return providers[0]
 		}
+		return concatProviders(providers...)
+	}
+}
+
+// concatProviders announces the keys of the providers one after the other,
+// each key once.
+func concatProviders(providers ...provider.KeyChanFunc) provider.KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		outCh := make(chan cid.Cid)
+		go func() {
+			defer close(outCh)
+			visited := cid.NewSet()
+			for _, p := range providers {
+				ch, err := p(ctx)
+				if err != nil {
+					logger.Errorf("reprovide: %s", err)
+					continue
+				}
+				for c := range ch {
+					if !visited.Visit(c) {
+						continue
+					}
+					select {
+					case outCh <- c:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+		return outCh, nil
+	}
+}
 
-		if onlyPinned {
-			return provider.NewPinnedProvider(false, in.Pinner, in.IPLDFetcher)
+// newMFSProvider announces the blocks of the current MFS tree that are in the
+// blockstore.
+func newMFSProvider(root *mfs.Root, bs blockstore.Blockstore) provider.KeyChanFunc {
+	dag := merkledag.NewDAGService(blockservice.New(bs, offline.Exchange(bs)))
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		nd, err := root.GetDirectory().GetNode()
+		if err != nil {
+			return nil, err
 		}
 
+		outCh := make(chan cid.Cid)
+		go func() {
+			defer close(outCh)
+			getLinks := func(ctx context.Context, c cid.Cid) ([]*format.Link, error) {
+				links, err := merkledag.GetLinksDirect(dag)(ctx, c)
+				if format.IsNotFound(err) {
+					// content copied to MFS from /ipfs is not fetched
+					// until read, it is not ours to announce
+					return nil, nil
+				}
+				if err != nil {
+					return nil, err
+				}
+				select {
+				case outCh <- c:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				return links, nil
+			}
+			err := merkledag.Walk(ctx, getLinks, nd.Cid(), cid.NewSet().Visit)
+			if err != nil && ctx.Err() == nil {
+				logger.Errorf("reprovide mfs: %s", err)
+			}
+		}()
+		return outCh, nil
+	}
+}
+
+// newProvidingStrategy is the "all" strategy: the roots of the pins first,
+// and then the whole blockstore.
+func newProvidingStrategy() interface{} {
+	type input struct {
+		fx.In
+		Pinner      pin.Pinner
+		Blockstore  blockstore.Blockstore
+		IPLDFetcher fetcher.Factory `name:"ipldFetcher"`
+	}
+	return func(in input) provider.KeyChanFunc {
 		return provider.NewPrioritizedProvider(
 			provider.NewPinnedProvider(true, in.Pinner, in.IPLDFetcher),
 			provider.NewBlockstoreProvider(in.Blockstore),
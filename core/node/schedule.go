@@ -0,0 +1,184 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/boxo/provider"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/fx"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/core/node/schedule"
+)
+
+// connTrimInterval is how often connections are trimmed to the watermarks of
+// the active schedule.
+const connTrimInterval = time.Minute
+
+// Schedules groups the units switching settings according to cfg.Schedules.
+func Schedules(cfg *config.Config) fx.Option {
+	if len(cfg.Schedules) == 0 {
+		return fx.Options()
+	}
+
+	opts := []fx.Option{
+		fx.Provide(Scheduler(cfg)),
+		fx.Invoke(scheduleBandwidthLimits),
+		fx.Provide(scheduleBitswapServer),
+	}
+	if !cfg.Experimental.StrategicProviding {
+		opts = append(opts,
+			fx.Decorate(scheduleKeyProvider),
+			fx.Invoke(scheduleReprovide),
+		)
+	}
+	switch cfg.Swarm.ConnMgr.Type.WithDefault(config.DefaultConnMgrType) {
+	case "", "basic":
+		grace := cfg.Swarm.ConnMgr.GracePeriod.WithDefault(config.DefaultConnMgrGracePeriod)
+		opts = append(opts, fx.Invoke(scheduleConnMgr(grace)))
+	}
+	return fx.Options(opts...)
+}
+
+// Scheduler constructs the scheduler of cfg.Schedules.
+func Scheduler(cfg *config.Config) interface{} {
+	return func(lc fx.Lifecycle) (*schedule.Scheduler, error) {
+		base := schedule.Settings{
+			BandwidthLimits:  cfg.Swarm.BandwidthLimits,
+			ConnMgrLowWater:  int(cfg.Swarm.ConnMgr.LowWater.WithDefault(config.DefaultConnMgrLowWater)),
+			ConnMgrHighWater: int(cfg.Swarm.ConnMgr.HighWater.WithDefault(config.DefaultConnMgrHighWater)),
+			Reprovide:        true,
+			BitswapServer:    true,
+		}
+		s, err := schedule.New(base, cfg.Schedules)
+		if err != nil {
+			return nil, err
+		}
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				s.Start()
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				s.Stop()
+				return nil
+			},
+		})
+		return s, nil
+	}
+}
+
+// scheduleConnMgrWatermarks returns the highest watermarks of Swarm.ConnMgr
+// and the schedules, to which the connection manager is configured.
+func scheduleConnMgrWatermarks(cfg *config.Config, low, high int) (int, int) {
+	for _, s := range cfg.Schedules {
+		if s.ConnMgr == nil {
+			continue
+		}
+		low = max(low, int(s.ConnMgr.LowWater.WithDefault(0)))
+		high = max(high, int(s.ConnMgr.HighWater.WithDefault(0)))
+	}
+	return low, high
+}
+
+type scheduleBandwidthLimitsIn struct {
+	fx.In
+
+	Scheduler *schedule.Scheduler
+	Throttler *libp2p.BandwidthThrottler `optional:"true"`
+}
+
+// scheduleBandwidthLimits makes the bandwidth limits follow the schedules.
+func scheduleBandwidthLimits(in scheduleBandwidthLimitsIn) {
+	if in.Throttler == nil {
+		return
+	}
+	in.Scheduler.OnChange(func(_, settings schedule.Settings) {
+		if err := in.Throttler.SetLimits(settings.BandwidthLimits); err != nil {
+			logger.Errorf("applying scheduled bandwidth limits: %s", err)
+		}
+	})
+}
+
+// scheduleConnMgr trims connections to the watermarks of the active
+// schedule.
+func scheduleConnMgr(grace time.Duration) interface{} {
+	return func(lc fx.Lifecycle, s *schedule.Scheduler, h host.Host) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				go func() {
+					defer close(done)
+					ticker := time.NewTicker(connTrimInterval)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-ctx.Done():
+							return
+						case <-ticker.C:
+						}
+						settings := s.Settings()
+						if n := schedule.TrimConnections(h, h.ConnManager(), settings.ConnMgrLowWater, settings.ConnMgrHighWater, grace); n > 0 {
+							logger.Debugf("closed %d connections above the scheduled watermarks", n)
+						}
+					}
+				}()
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				cancel()
+				<-done
+				return nil
+			},
+		})
+	}
+}
+
+// scheduleKeyProvider pauses the reprovides while the active schedule
+// disables them.
+func scheduleKeyProvider(s *schedule.Scheduler, keyProvider provider.KeyChanFunc) provider.KeyChanFunc {
+	return func(ctx context.Context) (<-chan cid.Cid, error) {
+		if !s.Settings().Reprovide {
+			logger.Info("reprovide skipped: paused by the active schedule")
+			ch := make(chan cid.Cid)
+			close(ch)
+			return ch, nil
+		}
+		return keyProvider(ctx)
+	}
+}
+
+// scheduleReprovide triggers a reprovide when the active schedule resumes the
+// reprovides, to catch up with the ones skipped while paused.
+func scheduleReprovide(lc fx.Lifecycle, s *schedule.Scheduler, prov provider.System) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+	s.OnChange(func(old, settings schedule.Settings) {
+		if old.Reprovide || !settings.Reprovide {
+			return
+		}
+		go func() {
+			if err := prov.Reprovide(ctx); err != nil {
+				logger.Errorf("reprovide after schedule change: %s", err)
+			}
+		}()
+	})
+}
+
+// scheduleBitswapServer refuses to serve blocks while the active schedule
+// disables the bitswap server.
+func scheduleBitswapServer(s *schedule.Scheduler) bitswapRequestFilterOut {
+	return bitswapRequestFilterOut{Filter: func(peer.ID, cid.Cid) bool {
+		return s.Settings().BitswapServer
+	}}
+}
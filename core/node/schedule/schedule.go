@@ -0,0 +1,244 @@
+// Package schedule switches node settings according to time windows
+// configured in Schedules.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+
+	"github.com/ipfs/kubo/config"
+)
+
+var log = logging.Logger("schedule")
+
+const (
+	// checkInterval is how often the active schedule is evaluated.
+	checkInterval = 15 * time.Second
+
+	// lookahead bounds the search of the next schedule change.
+	lookahead = 8 * 24 * time.Hour
+)
+
+// Settings are the settings switched by schedules.
+type Settings struct {
+	BandwidthLimits  config.BandwidthLimits
+	ConnMgrLowWater  int
+	ConnMgrHighWater int
+	Reprovide        bool
+	BitswapServer    bool
+}
+
+// Status is the state of the scheduler.
+type Status struct {
+	// Active is the name of the active schedule, empty when none is active.
+	Active string
+	// Since is when the current settings were applied.
+	Since time.Time
+	// NextChange is when the active schedule will change next, zero if it
+	// does not change in the coming week.
+	NextChange time.Time
+	Settings   Settings
+}
+
+type entry struct {
+	name     string
+	cron     *Cron
+	loc      *time.Location
+	settings Settings
+}
+
+// Scheduler applies the settings of the active schedule, or the base
+// settings when no schedule is active.
+type Scheduler struct {
+	base    Settings
+	entries []entry
+	now     func() time.Time
+
+	mu        sync.Mutex
+	active    int
+	since     time.Time
+	listeners []func(old, new Settings)
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a scheduler for the given schedules. base are the settings
+// that apply when no schedule is active.
+func New(base Settings, schedules []config.Schedule) (*Scheduler, error) {
+	s := &Scheduler{
+		base:   base,
+		now:    time.Now,
+		active: -1,
+	}
+	for i, sc := range schedules {
+		name := sc.Name
+		if name == "" {
+			name = fmt.Sprintf("schedule-%d", i)
+		}
+		cron, err := ParseCron(sc.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("Schedules[%d]: %w", i, err)
+		}
+		loc := time.Local
+		if tz := sc.Location.WithDefault(""); tz != "" {
+			loc, err = time.LoadLocation(tz)
+			if err != nil {
+				return nil, fmt.Errorf("Schedules[%d]: invalid location: %w", i, err)
+			}
+		}
+		settings, err := base.override(sc)
+		if err != nil {
+			return nil, fmt.Errorf("Schedules[%d]: %w", i, err)
+		}
+		s.entries = append(s.entries, entry{name: name, cron: cron, loc: loc, settings: settings})
+	}
+	s.active = s.activeAt(s.now())
+	s.since = s.now()
+	return s, nil
+}
+
+// override returns the settings with the overrides of a schedule applied.
+func (base Settings) override(sc config.Schedule) (Settings, error) {
+	s := base
+	if sc.BandwidthLimits != nil {
+		s.BandwidthLimits = *sc.BandwidthLimits
+	}
+	if sc.ConnMgr != nil {
+		s.ConnMgrLowWater = int(sc.ConnMgr.LowWater.WithDefault(int64(base.ConnMgrLowWater)))
+		s.ConnMgrHighWater = int(sc.ConnMgr.HighWater.WithDefault(int64(base.ConnMgrHighWater)))
+		if s.ConnMgrLowWater > s.ConnMgrHighWater {
+			return s, fmt.Errorf("ConnMgr.LowWater (%d) is greater than ConnMgr.HighWater (%d)", s.ConnMgrLowWater, s.ConnMgrHighWater)
+		}
+	}
+	s.Reprovide = sc.Reprovide.WithDefault(base.Reprovide)
+	s.BitswapServer = sc.BitswapServer.WithDefault(base.BitswapServer)
+	return s, nil
+}
+
+// activeAt returns the index of the schedule active at t, or -1.
+func (s *Scheduler) activeAt(t time.Time) int {
+	for i, e := range s.entries {
+		if e.cron.Matches(t.In(e.loc)) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Scheduler) settingsOf(active int) Settings {
+	if active < 0 {
+		return s.base
+	}
+	return s.entries[active].settings
+}
+
+func (s *Scheduler) nameOf(active int) string {
+	if active < 0 {
+		return ""
+	}
+	return s.entries[active].name
+}
+
+// OnChange registers a function called with the previous and the new
+// settings when the active schedule changes. It is also called on Start with
+// the base settings and the current ones. It must be called before Start.
+func (s *Scheduler) OnChange(f func(old, new Settings)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, f)
+}
+
+// Settings returns the current settings.
+func (s *Scheduler) Settings() Settings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settingsOf(s.active)
+}
+
+// Status returns the active schedule and the current settings.
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	active, since := s.active, s.since
+	s.mu.Unlock()
+
+	st := Status{
+		Active:   s.nameOf(active),
+		Since:    since,
+		Settings: s.settingsOf(active),
+	}
+	start := s.now().Truncate(time.Minute)
+	for t := start.Add(time.Minute); t.Sub(start) <= lookahead; t = t.Add(time.Minute) {
+		if s.activeAt(t) != active {
+			st.NextChange = t
+			break
+		}
+	}
+	return st
+}
+
+// update switches to the schedule active at t.
+func (s *Scheduler) update(t time.Time) {
+	s.mu.Lock()
+	prev := s.active
+	next := s.activeAt(t)
+	if next == prev {
+		s.mu.Unlock()
+		return
+	}
+	s.active = next
+	s.since = t
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	if next < 0 {
+		log.Infof("schedule %q ended, restoring the configured settings", s.nameOf(prev))
+	} else {
+		log.Infof("switching to schedule %q", s.nameOf(next))
+	}
+	for _, f := range listeners {
+		f(s.settingsOf(prev), s.settingsOf(next))
+	}
+}
+
+// Start applies the current settings and switches them as schedules become
+// active.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	current := s.settingsOf(s.active)
+	listeners := s.listeners
+	s.mu.Unlock()
+	for _, f := range listeners {
+		f(s.base, current)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.update(s.now())
+			}
+		}
+	}()
+}
+
+// Stop stops switching settings.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	<-s.done
+}
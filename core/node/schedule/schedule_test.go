@@ -0,0 +1,123 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCron(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		require.NoError(t, err)
+		return tm
+	}
+
+	// 2024-04-15 is a Monday
+	cases := []struct {
+		expr    string
+		match   []string
+		noMatch []string
+	}{
+		{"* * * * *", []string{"2024-04-15 00:00", "2024-12-31 23:59"}, nil},
+		{"*/15 9-17 * * 1-5", []string{"2024-04-15 09:00", "2024-04-19 17:45"}, []string{"2024-04-15 09:10", "2024-04-15 18:00", "2024-04-20 10:00"}},
+		{"0 0 1,15 * *", []string{"2024-04-01 00:00", "2024-04-15 00:00"}, []string{"2024-04-02 00:00"}},
+		{"* * * * 0", []string{"2024-04-14 12:00"}, []string{"2024-04-15 12:00"}},
+		{"* * * * 7", []string{"2024-04-14 12:00"}, []string{"2024-04-15 12:00"}},
+		// day of month or day of week when both are restricted
+		{"0 12 1 * 1", []string{"2024-04-01 12:00", "2024-04-15 12:00"}, []string{"2024-04-16 12:00"}},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr)
+		require.NoError(t, err, c.expr)
+		for _, m := range c.match {
+			assert.True(t, cron.Matches(at(m)), "%q should match %s", c.expr, m)
+		}
+		for _, m := range c.noMatch {
+			assert.False(t, cron.Matches(at(m)), "%q should not match %s", c.expr, m)
+		}
+	}
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, "%q should not parse", expr)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	base := Settings{ConnMgrLowWater: 32, ConnMgrHighWater: 96, Reprovide: true, BitswapServer: true}
+	rate := config.NewOptionalString("1MB")
+	s, err := New(base, []config.Schedule{
+		{
+			Name:            "night",
+			Cron:            "* 0-5 * * *",
+			Location:        config.NewOptionalString("UTC"),
+			BandwidthLimits: &config.BandwidthLimits{RateOut: rate},
+			Reprovide:       config.False,
+		},
+		{
+			Name:          "evening",
+			Cron:          "* 18-23 * * *",
+			Location:      config.NewOptionalString("UTC"),
+			ConnMgr:       &config.ScheduleConnMgr{HighWater: config.NewOptionalInteger(50)},
+			BitswapServer: config.False,
+		},
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 4, 15, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	var changes [][2]Settings
+	s.OnChange(func(old, new Settings) {
+		changes = append(changes, [2]Settings{old, new})
+	})
+
+	s.update(now)
+	assert.Empty(t, changes)
+	st := s.Status()
+	assert.Equal(t, "", st.Active)
+	assert.Equal(t, base, st.Settings)
+	assert.Equal(t, time.Date(2024, 4, 15, 18, 0, 0, 0, time.UTC), st.NextChange)
+
+	now = time.Date(2024, 4, 15, 19, 0, 0, 0, time.UTC)
+	s.update(now)
+	require.Len(t, changes, 1)
+	st = s.Status()
+	assert.Equal(t, "evening", st.Active)
+	assert.Equal(t, 32, st.Settings.ConnMgrLowWater)
+	assert.Equal(t, 50, st.Settings.ConnMgrHighWater)
+	assert.False(t, st.Settings.BitswapServer)
+	assert.True(t, st.Settings.Reprovide)
+	assert.Equal(t, time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC), st.NextChange)
+
+	now = time.Date(2024, 4, 16, 1, 0, 0, 0, time.UTC)
+	s.update(now)
+	require.Len(t, changes, 2)
+	assert.Equal(t, changes[0][1], changes[1][0])
+	settings := s.Settings()
+	assert.Equal(t, "1MB", settings.BandwidthLimits.RateOut.WithDefault(""))
+	assert.False(t, settings.Reprovide)
+	assert.True(t, settings.BitswapServer)
+	assert.Equal(t, 96, settings.ConnMgrHighWater)
+
+	now = time.Date(2024, 4, 16, 6, 0, 0, 0, time.UTC)
+	s.update(now)
+	require.Len(t, changes, 3)
+	assert.Equal(t, base, s.Settings())
+}
+
+func TestSchedulerInvalid(t *testing.T) {
+	base := Settings{ConnMgrLowWater: 32, ConnMgrHighWater: 96}
+	for _, sc := range []config.Schedule{
+		{Cron: "* * *"},
+		{Cron: "* * * * *", Location: config.NewOptionalString("Nowhere/Nothing")},
+		{Cron: "* * * * *", ConnMgr: &config.ScheduleConnMgr{HighWater: config.NewOptionalInteger(10)}},
+	} {
+		_, err := New(base, []config.Schedule{sc})
+		assert.Error(t, err)
+	}
+}
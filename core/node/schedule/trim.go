@@ -0,0 +1,77 @@
+package schedule
+
+import (
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TrimConnections closes connections to the least valuable peers, as tagged
+// in the connection manager, until there are at most low connections. It
+// only does so when there are more than high connections. Protected peers
+// and connections younger than grace are kept.
+//
+// The connection manager cannot change its watermarks at runtime. It is
+// created with the highest watermarks of all schedules, and this enforces
+// the lower watermarks of the active schedule.
+func TrimConnections(h host.Host, cm connmgr.ConnManager, low, high int, grace time.Duration) int {
+	conns := h.Network().Conns()
+	if high <= 0 || len(conns) <= high {
+		return 0
+	}
+
+	type candidate struct {
+		p     peer.ID
+		value int
+		conns int
+	}
+	byPeer := make(map[peer.ID]*candidate)
+	// excluded are the peers with a connection that must be kept, closing
+	// the peer would close it too
+	excluded := make(map[peer.ID]struct{})
+	for _, c := range conns {
+		p := c.RemotePeer()
+		if _, ok := excluded[p]; ok {
+			continue
+		}
+		if cm.IsProtected(p, "") || time.Since(c.Stat().Opened) < grace {
+			excluded[p] = struct{}{}
+			delete(byPeer, p)
+			continue
+		}
+		if cand, ok := byPeer[p]; ok {
+			cand.conns++
+			continue
+		}
+		value := 0
+		if info := cm.GetTagInfo(p); info != nil {
+			value = info.Value
+		}
+		byPeer[p] = &candidate{p: p, value: value, conns: 1}
+	}
+
+	candidates := make([]*candidate, 0, len(byPeer))
+	for _, c := range byPeer {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].value < candidates[j].value
+	})
+
+	open, closed := len(conns), 0
+	for _, c := range candidates {
+		if open <= low {
+			break
+		}
+		if err := h.Network().ClosePeer(c.p); err != nil {
+			log.Debugf("closing connection to %s: %s", c.p, err)
+			continue
+		}
+		open -= c.conns
+		closed += c.conns
+	}
+	return closed
+}
@@ -0,0 +1,61 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type trimConn struct {
+	network.Conn
+	p      peer.ID
+	opened time.Time
+}
+
+func (c *trimConn) RemotePeer() peer.ID { return c.p }
+
+func (c *trimConn) Stat() network.ConnStats {
+	return network.ConnStats{Stats: network.Stats{Opened: c.opened}}
+}
+
+type trimNetwork struct {
+	network.Network
+	conns  []network.Conn
+	closed []peer.ID
+}
+
+func (n *trimNetwork) Conns() []network.Conn { return n.conns }
+
+func (n *trimNetwork) ClosePeer(p peer.ID) error {
+	n.closed = append(n.closed, p)
+	return nil
+}
+
+type trimHost struct {
+	host.Host
+	net *trimNetwork
+}
+
+func (h *trimHost) Network() network.Network { return h.net }
+
+func TestTrimConnectionsKeepsPeersInGrace(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	young := time.Now()
+
+	net := &trimNetwork{conns: []network.Conn{
+		// a peer with a young connection followed by an old one
+		&trimConn{p: "young", opened: young},
+		&trimConn{p: "young", opened: old},
+		&trimConn{p: "old1", opened: old},
+		&trimConn{p: "old2", opened: old},
+	}}
+	closed := TrimConnections(&trimHost{net: net}, &connmgr.NullConnMgr{}, 0, 1, time.Minute)
+
+	require.Equal(t, 2, closed)
+	require.ElementsMatch(t, []peer.ID{"old1", "old2"}, net.closed)
+}
@@ -0,0 +1,140 @@
+package pinsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/record"
+)
+
+const (
+	// deltaDomain is the signature domain of sealed deltas.
+	deltaDomain = "kubo-pinsync-delta"
+
+	maxDeltaEntries = 1024
+	maxMessageSize  = 4 << 20
+	streamTimeout   = time.Minute
+)
+
+var deltaCodec = []byte("/kubo/pinsync/delta")
+
+// Delta is a batch of entries with a sequence number greater than the one
+// requested. It is sealed in a signed envelope by the sending peer.
+type Delta struct {
+	// Seq is the latest sequence number of the sender.
+	Seq uint64
+	// More is set when entries were left out to keep the delta small.
+	More    bool
+	Entries []Entry
+}
+
+var _ record.Record = (*Delta)(nil)
+
+func (*Delta) Domain() string {
+	return deltaDomain
+}
+
+func (*Delta) Codec() []byte {
+	return deltaCodec
+}
+
+func (d *Delta) MarshalRecord() ([]byte, error) {
+	return json.Marshal(d)
+}
+
+func (d *Delta) UnmarshalRecord(data []byte) error {
+	return json.Unmarshal(data, d)
+}
+
+type deltaRequest struct {
+	Since uint64
+}
+
+type deltaResponse struct {
+	Envelope []byte
+}
+
+// handleStream serves entries newer than the requested sequence number to
+// members of the group.
+func (s *Service) handleStream(str network.Stream) {
+	remote := str.Conn().RemotePeer()
+	if !s.isMember(remote) {
+		log.Debugf("rejecting pinsync stream from %s: not a member of the group", remote)
+		_ = str.Reset()
+		return
+	}
+	defer str.Close()
+	_ = str.SetDeadline(time.Now().Add(streamTimeout))
+
+	var req deltaRequest
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&req); err != nil {
+		log.Debugf("reading pinsync request from %s: %s", remote, err)
+		_ = str.Reset()
+		return
+	}
+
+	env, err := record.Seal(s.deltaSince(req.Since), s.key)
+	if err != nil {
+		log.Errorf("sealing pinsync delta: %s", err)
+		_ = str.Reset()
+		return
+	}
+	b, err := env.Marshal()
+	if err != nil {
+		log.Errorf("marshaling pinsync delta: %s", err)
+		_ = str.Reset()
+		return
+	}
+	if err := json.NewEncoder(str).Encode(deltaResponse{Envelope: b}); err != nil {
+		log.Debugf("writing pinsync delta to %s: %s", remote, err)
+		_ = str.Reset()
+	}
+}
+
+// fetchDelta requests entries newer than since from p, and verifies the
+// delta was signed by p.
+func (s *Service) fetchDelta(ctx context.Context, p peer.ID, since uint64) (*Delta, error) {
+	ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+	defer cancel()
+
+	str, err := s.host.NewStream(ctx, p, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer str.Close()
+	_ = str.SetDeadline(time.Now().Add(streamTimeout))
+
+	if err := json.NewEncoder(str).Encode(deltaRequest{Since: since}); err != nil {
+		_ = str.Reset()
+		return nil, err
+	}
+	if err := str.CloseWrite(); err != nil {
+		_ = str.Reset()
+		return nil, err
+	}
+
+	var resp deltaResponse
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&resp); err != nil {
+		_ = str.Reset()
+		return nil, err
+	}
+
+	delta := new(Delta)
+	env, err := record.ConsumeTypedEnvelope(resp.Envelope, delta)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta: %w", err)
+	}
+	signer, err := peer.IDFromPublicKey(env.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta: %w", err)
+	}
+	if signer != p {
+		return nil, fmt.Errorf("invalid delta: signed by %s instead of %s", signer, p)
+	}
+	return delta, nil
+}
@@ -0,0 +1,561 @@
+// Package pinsync keeps the recursive pins of a group of peers in sync.
+//
+// Every member records changes to its local pinset as entries of a
+// last-writer-wins set keyed by CID. Entries are numbered with a local
+// sequence number, and members periodically pull the entries they have not
+// seen yet from each other as signed deltas. Entries that win over the local
+// state are applied by pinning or unpinning the CID.
+package pinsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var log = logging.Logger("pinsync")
+
+// ID is the protocol ID of the pinset sync protocol.
+const ID protocol.ID = "/kubo/pinsync/1.0.0"
+
+var (
+	seqKey        = datastore.NewKey("/seq")
+	entriesPrefix = datastore.NewKey("/entries")
+	peersPrefix   = datastore.NewKey("/peers")
+	failedPrefix  = datastore.NewKey("/failed")
+)
+
+// maxRetryBackoff is the longest delay between two attempts to apply a remote
+// entry that failed.
+const maxRetryBackoff = 6 * time.Hour
+
+// Pinset is the local pinset kept in sync with the group.
+type Pinset interface {
+	// Ls returns the recursive pins mapped to their names.
+	Ls(ctx context.Context) (map[cid.Cid]string, error)
+	// Pin recursively pins c under the given name, fetching it if needed.
+	Pin(ctx context.Context, c cid.Cid, name string) error
+	// Unpin removes the recursive pin for c. It is not an error if c is
+	// not pinned.
+	Unpin(ctx context.Context, c cid.Cid) error
+}
+
+// Entry is the replicated state of a single CID.
+type Entry struct {
+	Cid     cid.Cid
+	Name    string `json:",omitempty"`
+	Removed bool   `json:",omitempty"`
+	// Timestamp is the time of the change in nanoseconds since the epoch,
+	// the most recent change wins.
+	Timestamp int64
+	// Origin is the peer where the change was made, it breaks ties between
+	// changes with the same timestamp.
+	Origin peer.ID
+	// Seq is the sequence number assigned by the peer serving the entry.
+	Seq uint64
+}
+
+// newer reports whether e wins over other.
+func (e Entry) newer(other Entry) bool {
+	if e.Timestamp != other.Timestamp {
+		return e.Timestamp > other.Timestamp
+	}
+	return e.Origin > other.Origin
+}
+
+// conflicts reports whether e and other are different changes to the same
+// CID made on different peers.
+func (e Entry) conflicts(other Entry) bool {
+	return e.Origin != other.Origin && (e.Removed != other.Removed || e.Name != other.Name)
+}
+
+// Config configures the pinset sync service.
+type Config struct {
+	// Peers are the other members of the group.
+	Peers []peer.ID
+	// Interval is the delay between two sync rounds.
+	Interval time.Duration
+	// PinTimeout limits the time spent fetching a single pin.
+	PinTimeout time.Duration
+}
+
+// PeerStatus is the sync state with a single member of the group.
+type PeerStatus struct {
+	ID       peer.ID
+	LastSeq  uint64
+	LastSync time.Time `json:",omitempty"`
+	Error    string    `json:",omitempty"`
+}
+
+// Failure is a remote entry that could not be applied. It is retried with
+// exponential backoff, without holding back the sync of the later entries.
+type Failure struct {
+	Entry Entry
+	// Peer is the member the entry was pulled from.
+	Peer      peer.ID
+	Error     string
+	Retries   int
+	NextRetry time.Time
+}
+
+// Status is a snapshot of the sync state.
+type Status struct {
+	Seq       uint64
+	Pins      int
+	Conflicts uint64
+	Peers     []PeerStatus
+	Failures  []Failure
+}
+
+// Service keeps the local pinset in sync with the group.
+type Service struct {
+	host   host.Host
+	key    crypto.PrivKey
+	ds     datastore.Datastore
+	pinset Pinset
+	cfg    Config
+
+	members map[peer.ID]struct{}
+
+	mu        sync.Mutex
+	seq       uint64
+	entries   map[cid.Cid]Entry
+	peers     map[peer.ID]*PeerStatus
+	failures  map[cid.Cid]*Failure
+	conflicts uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a pinset sync service, restoring its state from ds.
+func New(h host.Host, key crypto.PrivKey, ds datastore.Datastore, pinset Pinset, cfg Config) (*Service, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		host:     h,
+		key:      key,
+		ds:       namespace.Wrap(ds, datastore.NewKey("/pinsync")),
+		pinset:   pinset,
+		cfg:      cfg,
+		members:  make(map[peer.ID]struct{}, len(cfg.Peers)),
+		entries:  map[cid.Cid]Entry{},
+		peers:    map[peer.ID]*PeerStatus{},
+		failures: map[cid.Cid]*Failure{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, p := range cfg.Peers {
+		if p == h.ID() {
+			continue
+		}
+		s.members[p] = struct{}{}
+		s.peers[p] = &PeerStatus{ID: p}
+	}
+	if err := s.load(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Service) load(ctx context.Context) error {
+	b, err := s.ds.Get(ctx, seqKey)
+	switch {
+	case errors.Is(err, datastore.ErrNotFound):
+	case err != nil:
+		return err
+	default:
+		if s.seq, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.ds.Query(ctx, query.Query{Prefix: entriesPrefix.String()})
+	if err != nil {
+		return err
+	}
+	for r := range res.Next() {
+		if r.Error != nil {
+			res.Close()
+			return r.Error
+		}
+		var e Entry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			res.Close()
+			return err
+		}
+		s.entries[e.Cid] = e
+	}
+	res.Close()
+
+	res, err = s.ds.Query(ctx, query.Query{Prefix: failedPrefix.String()})
+	if err != nil {
+		return err
+	}
+	for r := range res.Next() {
+		if r.Error != nil {
+			res.Close()
+			return r.Error
+		}
+		var f Failure
+		if err := json.Unmarshal(r.Value, &f); err != nil {
+			res.Close()
+			return err
+		}
+		s.failures[f.Entry.Cid] = &f
+	}
+	res.Close()
+
+	for p, ps := range s.peers {
+		b, err := s.ds.Get(ctx, peersPrefix.ChildString(p.String()))
+		switch {
+		case errors.Is(err, datastore.ErrNotFound):
+		case err != nil:
+			return err
+		default:
+			if ps.LastSeq, err = strconv.ParseUint(string(b), 10, 64); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Start registers the protocol handler and starts syncing in the background.
+func (s *Service) Start() error {
+	s.host.SetStreamHandler(ID, s.handleStream)
+	s.wg.Add(1)
+	go s.loop()
+	return nil
+}
+
+// Stop stops syncing and removes the protocol handler.
+func (s *Service) Stop() error {
+	s.host.RemoveStreamHandler(ID)
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Service) loop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		s.Sync(s.ctx)
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Sync runs a single sync round: it records local changes, retries the
+// failed entries that are due, then pulls and applies new entries from every
+// member of the group.
+func (s *Service) Sync(ctx context.Context) {
+	if err := s.scan(ctx); err != nil {
+		log.Errorf("scanning local pins: %s", err)
+		return
+	}
+	s.retryFailures(ctx)
+	for p := range s.members {
+		if err := s.pull(ctx, p); err != nil {
+			log.Debugf("syncing pins with %s: %s", p, err)
+		}
+	}
+}
+
+// scan records the changes made to the local pinset since the last scan.
+func (s *Service) scan(ctx context.Context) error {
+	pins, err := s.pinset.Ls(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	self := s.host.ID()
+	for c, name := range pins {
+		if e, ok := s.entries[c]; ok && !e.Removed && e.Name == name {
+			continue
+		}
+		s.putEntry(ctx, Entry{Cid: c, Name: name, Timestamp: now, Origin: self})
+	}
+	for c, e := range s.entries {
+		if _, ok := pins[c]; ok || e.Removed {
+			continue
+		}
+		s.putEntry(ctx, Entry{Cid: c, Removed: true, Timestamp: now, Origin: self})
+	}
+	return nil
+}
+
+// putEntry assigns the next sequence number to e and persists it. Must be
+// called with s.mu held.
+func (s *Service) putEntry(ctx context.Context, e Entry) {
+	s.seq++
+	e.Seq = s.seq
+	s.entries[e.Cid] = e
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("marshaling pinsync entry: %s", err)
+		return
+	}
+	if err := s.ds.Put(ctx, entriesPrefix.ChildString(e.Cid.String()), b); err != nil {
+		log.Errorf("persisting pinsync entry: %s", err)
+	}
+	if err := s.ds.Put(ctx, seqKey, []byte(strconv.FormatUint(s.seq, 10))); err != nil {
+		log.Errorf("persisting pinsync sequence number: %s", err)
+	}
+}
+
+// deltaSince returns the entries with a sequence number greater than since.
+func (s *Service) deltaSince(since uint64) *Delta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := &Delta{Seq: s.seq}
+	for _, e := range s.entries {
+		if e.Seq > since {
+			d.Entries = append(d.Entries, e)
+		}
+	}
+	sort.Slice(d.Entries, func(i, j int) bool { return d.Entries[i].Seq < d.Entries[j].Seq })
+	if len(d.Entries) > maxDeltaEntries {
+		d.Entries = d.Entries[:maxDeltaEntries]
+		d.More = true
+	}
+	return d
+}
+
+// pull fetches and applies the entries of p that were not seen yet.
+func (s *Service) pull(ctx context.Context, p peer.ID) error {
+	err := s.pullDeltas(ctx, p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ps := s.peers[p]
+	if err != nil {
+		ps.Error = err.Error()
+		return err
+	}
+	ps.Error = ""
+	ps.LastSync = time.Now()
+	return nil
+}
+
+func (s *Service) pullDeltas(ctx context.Context, p peer.ID) error {
+	for {
+		s.mu.Lock()
+		since := s.peers[p].LastSeq
+		s.mu.Unlock()
+
+		delta, err := s.fetchDelta(ctx, p, since)
+		if err != nil {
+			return err
+		}
+		for _, e := range delta.Entries {
+			if e.Seq <= since {
+				continue
+			}
+			if err := s.merge(ctx, e); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				log.Debugf("applying the entry of %s from %s: %s", e.Cid, p, err)
+				s.addFailure(ctx, p, e, err)
+			} else {
+				s.clearFailure(ctx, e)
+			}
+			s.setLastSeq(ctx, p, e.Seq)
+		}
+		if !delta.More || len(delta.Entries) == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Service) setLastSeq(ctx context.Context, p peer.ID, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers[p].LastSeq = seq
+	if err := s.ds.Put(ctx, peersPrefix.ChildString(p.String()), []byte(strconv.FormatUint(seq, 10))); err != nil {
+		log.Errorf("persisting pinsync state of %s: %s", p, err)
+	}
+}
+
+// retryBackoff returns the delay before the next attempt to apply an entry
+// that failed retries times.
+func (s *Service) retryBackoff(retries int) time.Duration {
+	backoff := s.cfg.Interval
+	for i := 0; i < retries && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
+
+// addFailure records that the remote entry e pulled from p could not be
+// applied, unless a newer entry for the same CID already failed.
+func (s *Service) addFailure(ctx context.Context, p peer.ID, e Entry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[e.Cid]
+	switch {
+	case !ok:
+		f = &Failure{}
+		s.failures[e.Cid] = f
+	case f.Entry.newer(e):
+		return
+	case e.newer(f.Entry):
+		f.Retries = 0
+	default:
+		f.Retries++
+	}
+	f.Entry, f.Peer, f.Error = e, p, err.Error()
+	f.NextRetry = time.Now().Add(s.retryBackoff(f.Retries))
+	s.putFailure(ctx, f)
+}
+
+// clearFailure forgets the failure of the entries for the CID of e that are
+// not newer than e, which was applied.
+func (s *Service) clearFailure(ctx context.Context, e Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[e.Cid]
+	if !ok || f.Entry.newer(e) {
+		return
+	}
+	delete(s.failures, e.Cid)
+	if err := s.ds.Delete(ctx, failedPrefix.ChildString(e.Cid.String())); err != nil {
+		log.Errorf("removing pinsync failure: %s", err)
+	}
+}
+
+// putFailure persists f. Must be called with s.mu held.
+func (s *Service) putFailure(ctx context.Context, f *Failure) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		log.Errorf("marshaling pinsync failure: %s", err)
+		return
+	}
+	if err := s.ds.Put(ctx, failedPrefix.ChildString(f.Entry.Cid.String()), b); err != nil {
+		log.Errorf("persisting pinsync failure: %s", err)
+	}
+}
+
+// retryFailures applies again the failed entries that are due.
+func (s *Service) retryFailures(ctx context.Context) {
+	now := time.Now()
+	s.mu.Lock()
+	var due []Failure
+	for _, f := range s.failures {
+		if !f.NextRetry.After(now) {
+			due = append(due, *f)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, f := range due {
+		if err := s.merge(ctx, f.Entry); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Debugf("retrying the entry of %s from %s: %s", f.Entry.Cid, f.Peer, err)
+			s.addFailure(ctx, f.Peer, f.Entry, err)
+			continue
+		}
+		s.clearFailure(ctx, f.Entry)
+	}
+}
+
+// merge applies a remote entry to the local pinset if it wins over the local
+// state.
+func (s *Service) merge(ctx context.Context, remote Entry) error {
+	s.mu.Lock()
+	local, ok := s.entries[remote.Cid]
+	s.mu.Unlock()
+
+	if ok && !remote.newer(local) {
+		if remote.conflicts(local) {
+			log.Debugf("keeping local change for %s over the one from %s", remote.Cid, remote.Origin)
+			s.mu.Lock()
+			s.conflicts++
+			s.mu.Unlock()
+		}
+		return nil
+	}
+
+	if remote.Removed {
+		if err := s.pinset.Unpin(ctx, remote.Cid); err != nil {
+			return err
+		}
+	} else {
+		pctx, cancel := context.WithTimeout(ctx, s.cfg.PinTimeout)
+		err := s.pinset.Pin(pctx, remote.Cid, remote.Name)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ok && remote.conflicts(local) {
+		log.Debugf("replacing local change for %s with the one from %s", remote.Cid, remote.Origin)
+		s.conflicts++
+	}
+	s.putEntry(ctx, remote)
+	return nil
+}
+
+func (s *Service) isMember(p peer.ID) bool {
+	_, ok := s.members[p]
+	return ok
+}
+
+// Status returns a snapshot of the sync state.
+func (s *Service) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Status{Seq: s.seq, Conflicts: s.conflicts}
+	for _, e := range s.entries {
+		if !e.Removed {
+			st.Pins++
+		}
+	}
+	for _, ps := range s.peers {
+		st.Peers = append(st.Peers, *ps)
+	}
+	sort.Slice(st.Peers, func(i, j int) bool { return st.Peers[i].ID < st.Peers[j].ID })
+	for _, f := range s.failures {
+		st.Failures = append(st.Failures, *f)
+	}
+	sort.Slice(st.Failures, func(i, j int) bool {
+		return st.Failures[i].Entry.Cid.KeyString() < st.Failures[j].Entry.Cid.KeyString()
+	})
+	return st
+}
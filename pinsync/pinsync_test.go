@@ -0,0 +1,245 @@
+package pinsync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type testPinset struct {
+	mu          sync.Mutex
+	pins        map[cid.Cid]string
+	unfetchable map[cid.Cid]bool
+}
+
+func newTestPinset() *testPinset {
+	return &testPinset{pins: map[cid.Cid]string{}, unfetchable: map[cid.Cid]bool{}}
+}
+
+func (p *testPinset) Ls(ctx context.Context) (map[cid.Cid]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pins := make(map[cid.Cid]string, len(p.pins))
+	for c, name := range p.pins {
+		pins[c] = name
+	}
+	return pins, nil
+}
+
+func (p *testPinset) Pin(ctx context.Context, c cid.Cid, name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unfetchable[c] {
+		return errors.New("block not found")
+	}
+	p.pins[c] = name
+	return nil
+}
+
+func (p *testPinset) Unpin(ctx context.Context, c cid.Cid) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.pins, c)
+	return nil
+}
+
+func (p *testPinset) has(c cid.Cid) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.pins[c]
+	return ok
+}
+
+func testCid(t *testing.T, s string) cid.Cid {
+	h, err := mh.Sum([]byte(s), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func newTestService(t *testing.T, h host.Host, ds datastore.Datastore, pinset Pinset, peers ...peer.ID) *Service {
+	s, err := New(h, h.Peerstore().PrivKey(h.ID()), ds, pinset, Config{
+		Peers:      peers,
+		Interval:   time.Hour,
+		PinTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.host.SetStreamHandler(ID, s.handleStream)
+	t.Cleanup(func() { _ = s.Stop() })
+	return s
+}
+
+func TestSyncAddAndRemove(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := mn.Hosts()[0], mn.Hosts()[1]
+	p1, p2 := newTestPinset(), newTestPinset()
+	s1 := newTestService(t, h1, dssync.MutexWrap(datastore.NewMapDatastore()), p1, h2.ID())
+	s2 := newTestService(t, h2, dssync.MutexWrap(datastore.NewMapDatastore()), p2, h1.ID())
+
+	c := testCid(t, "a")
+	_ = p1.Pin(ctx, c, "shared")
+	s1.Sync(ctx)
+	s2.Sync(ctx)
+	if name := p2.pins[c]; name != "shared" {
+		t.Fatalf("expected pin to be synced with its name, got %q", name)
+	}
+
+	// syncing back must not produce new changes
+	seq := s2.Status().Seq
+	s1.Sync(ctx)
+	s2.Sync(ctx)
+	if s2.Status().Seq != seq {
+		t.Fatal("synced pin was recorded as a local change")
+	}
+
+	_ = p2.Unpin(ctx, c)
+	s2.Sync(ctx)
+	s1.Sync(ctx)
+	if p1.has(c) {
+		t.Fatal("expected pin removal to be synced")
+	}
+
+	st := s1.Status()
+	if st.Pins != 0 || len(st.Peers) != 1 || st.Peers[0].ID != h2.ID() || st.Peers[0].LastSync.IsZero() {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestSyncConflict(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := mn.Hosts()[0], mn.Hosts()[1]
+	p1, p2 := newTestPinset(), newTestPinset()
+	s1 := newTestService(t, h1, dssync.MutexWrap(datastore.NewMapDatastore()), p1, h2.ID())
+	s2 := newTestService(t, h2, dssync.MutexWrap(datastore.NewMapDatastore()), p2, h1.ID())
+
+	// both peers pin the same CID under a different name, the most recent
+	// change wins on both sides
+	c := testCid(t, "a")
+	_ = p1.Pin(ctx, c, "older")
+	s1.Sync(ctx)
+	_ = p2.Pin(ctx, c, "newer")
+	s2.Sync(ctx)
+	s1.Sync(ctx)
+
+	if p1.pins[c] != "newer" || p2.pins[c] != "newer" {
+		t.Fatalf("expected most recent change to win, got %q and %q", p1.pins[c], p2.pins[c])
+	}
+	if s1.Status().Conflicts != 1 || s2.Status().Conflicts != 1 {
+		t.Fatalf("expected one conflict on each side, got %d and %d", s1.Status().Conflicts, s2.Status().Conflicts)
+	}
+}
+
+func TestSyncSkipsFailedEntries(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := mn.Hosts()[0], mn.Hosts()[1]
+	p1, p2 := newTestPinset(), newTestPinset()
+	s1 := newTestService(t, h1, dssync.MutexWrap(datastore.NewMapDatastore()), p1, h2.ID())
+	s2 := newTestService(t, h2, dssync.MutexWrap(datastore.NewMapDatastore()), p2, h1.ID())
+
+	// the first entry cannot be pinned, the sync goes on with the next one
+	a, b := testCid(t, "a"), testCid(t, "b")
+	_ = p1.Pin(ctx, a, "")
+	s1.Sync(ctx)
+	_ = p1.Pin(ctx, b, "")
+	s1.Sync(ctx)
+	p2.unfetchable[a] = true
+	s2.Sync(ctx)
+
+	st := s2.Status()
+	if !p2.has(b) || st.Peers[0].LastSeq != 2 || st.Peers[0].Error != "" {
+		t.Fatalf("expected the sync to go past the failed entry, got %+v", st)
+	}
+	if len(st.Failures) != 1 || st.Failures[0].Entry.Cid != a || st.Failures[0].Peer != h1.ID() || st.Failures[0].Error == "" {
+		t.Fatalf("expected the failed entry to be recorded, got %+v", st.Failures)
+	}
+
+	// failures are retried with backoff
+	first := st.Failures[0].NextRetry
+	s2.failures[a].NextRetry = time.Time{}
+	s2.Sync(ctx)
+	st = s2.Status()
+	if len(st.Failures) != 1 || st.Failures[0].Retries != 1 || !st.Failures[0].NextRetry.After(first) {
+		t.Fatalf("expected the failure to be retried later, got %+v", st.Failures)
+	}
+
+	p2.unfetchable[a] = false
+	s2.failures[a].NextRetry = time.Time{}
+	s2.Sync(ctx)
+	if st := s2.Status(); !p2.has(a) || len(st.Failures) != 0 {
+		t.Fatalf("expected the failed entry to be applied, got %+v", st.Failures)
+	}
+}
+
+func TestSyncRejectsNonMembers(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := mn.Hosts()[0], mn.Hosts()[1]
+	p1 := newTestPinset()
+	_ = p1.Pin(ctx, testCid(t, "a"), "")
+	s1 := newTestService(t, h1, dssync.MutexWrap(datastore.NewMapDatastore()), p1)
+	s2 := newTestService(t, h2, dssync.MutexWrap(datastore.NewMapDatastore()), newTestPinset(), h1.ID())
+
+	s1.Sync(ctx)
+	if err := s2.pull(ctx, h1.ID()); err == nil {
+		t.Fatal("expected non-member to be rejected")
+	}
+	if st := s2.Status(); st.Peers[0].Error == "" {
+		t.Fatal("expected error to be recorded in status")
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := mn.Hosts()[0], mn.Hosts()[1]
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	p1 := newTestPinset()
+	c := testCid(t, "a")
+	_ = p1.Pin(ctx, c, "")
+
+	s1 := newTestService(t, h1, ds, p1, h2.ID())
+	s1.Sync(ctx)
+	_ = s1.Stop()
+
+	// the pin was removed while the service was not running
+	_ = p1.Unpin(ctx, c)
+	s1 = newTestService(t, h1, ds, p1, h2.ID())
+	if st := s1.Status(); st.Seq != 1 || st.Pins != 1 {
+		t.Fatalf("expected state to be restored, got %+v", st)
+	}
+	s1.Sync(ctx)
+	if st := s1.Status(); st.Seq != 2 || st.Pins != 0 {
+		t.Fatalf("expected removal to be recorded, got %+v", st)
+	}
+}
@@ -0,0 +1,15 @@
+package plugin
+
+import (
+	"github.com/ipfs/kubo/core/node"
+)
+
+// PluginBitswapPrioritizer is an interface that can be implemented to add a
+// policy ordering the requests the bitswap server answers, which can be
+// selected in Bitswap.Prioritization.Policies.
+type PluginBitswapPrioritizer interface {
+	Plugin
+
+	BitswapPrioritizerName() string
+	BitswapPrioritizer() node.BitswapPrioritizerConstructor
+}
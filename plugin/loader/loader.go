@@ -14,6 +14,7 @@ import (
 
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
+	"github.com/ipfs/kubo/core/node"
 	plugin "github.com/ipfs/kubo/plugin"
 	fsrepo "github.com/ipfs/kubo/repo/fsrepo"
 
@@ -299,6 +300,13 @@ func (loader *PluginLoader) Inject() error {
 				return err
 			}
 		}
+		if pl, ok := pl.(plugin.PluginBitswapPrioritizer); ok {
+			err := injectBitswapPrioritizerPlugin(pl)
+			if err != nil {
+				loader.state = loaderFailed
+				return err
+			}
+		}
 	}
 
 	return loader.transition(loaderInjecting, loaderInjected)
@@ -389,3 +397,7 @@ func injectFxPlugin(pl plugin.PluginFx) error {
 	core.RegisterFXOptionFunc(pl.Options)
 	return nil
 }
+
+func injectBitswapPrioritizerPlugin(pl plugin.PluginBitswapPrioritizer) error {
+	return node.AddBitswapPrioritizer(pl.BitswapPrioritizerName(), pl.BitswapPrioritizer())
+}
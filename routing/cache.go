@@ -0,0 +1,393 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ipfs/boxo/datastore/dshelp"
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// cacheRouterKey is where the routers of the cache type keep their results in
+// the repo datastore.
+var cacheRouterKey = datastore.NewKey("/routing/cache")
+
+var (
+	cacheRouterHitsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_routing_cache_hits_total",
+		Help: "Number of lookups answered by a cache router from its cache.",
+	}, []string{"router", "method"})
+	cacheRouterMissesMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_routing_cache_misses_total",
+		Help: "Number of lookups a cache router passed on to the router it caches.",
+	}, []string{"router", "method"})
+)
+
+var (
+	_ routing.Routing                  = &cacheRouter{}
+	_ routinghelpers.ProvideManyRouter = &cacheRouter{}
+)
+
+type ExtraCacheParams struct {
+	Datastore datastore.Batching
+	// Context ends the purge of the expired results.
+	Context context.Context
+}
+
+// cacheEntry is a result kept in the cache.
+type cacheEntry struct {
+	Expires   time.Time
+	Providers []peer.AddrInfo `json:",omitempty"`
+	// Count is how many providers the lookup of Providers was limited to,
+	// 0 when it found all of them.
+	Count int            `json:",omitempty"`
+	Peer  *peer.AddrInfo `json:",omitempty"`
+	Value []byte         `json:",omitempty"`
+}
+
+// cacheRouter keeps the providers, peers and values found by a router in the
+// datastore, and answers the lookups from there until they expire.
+type cacheRouter struct {
+	routing.Routing
+
+	name string
+	ttl  time.Duration
+	ds   datastore.Datastore
+}
+
+func cacheRoutingFromConfig(name string, conf config.Router, router routing.Routing, extra *ExtraCacheParams) (routing.Routing, error) {
+	params, ok := conf.Parameters.(*config.CacheRouterParams)
+	if !ok {
+		return nil, errors.New("incorrect params for cache router")
+	}
+	if extra == nil || extra.Datastore == nil {
+		return nil, errors.New("cache router needs a datastore")
+	}
+	ttl := params.TTL.WithDefault(config.DefaultCacheRouterTTL)
+	if ttl <= 0 {
+		return nil, errors.New("the TTL of a cache router must be positive")
+	}
+
+	r := &cacheRouter{
+		Routing: router,
+		name:    name,
+		ttl:     ttl,
+		ds:      extra.Datastore,
+	}
+	if extra.Context != nil {
+		go r.purge(extra.Context)
+	}
+	return r, nil
+}
+
+func (r *cacheRouter) key(kind string, k datastore.Key) datastore.Key {
+	return cacheRouterKey.ChildString(r.name).ChildString(kind).Child(k)
+}
+
+// get returns the entry at k, when it has not expired.
+func (r *cacheRouter) get(ctx context.Context, k datastore.Key) (*cacheEntry, bool) {
+	b, err := r.ds.Get(ctx, k)
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			log.Warnf("reading the cache of router %s: %s", r.name, err)
+		}
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		log.Warnf("invalid entry in the cache of router %s: %s", r.name, err)
+		return nil, false
+	}
+	if time.Now().After(e.Expires) {
+		return nil, false
+	}
+	return &e, true
+}
+
+// put keeps e at k for the TTL of the router, or until e.Expires when it is
+// set and sooner.
+func (r *cacheRouter) put(ctx context.Context, k datastore.Key, e *cacheEntry) {
+	if expires := time.Now().Add(r.ttl); e.Expires.IsZero() || e.Expires.After(expires) {
+		e.Expires = expires
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.Warnf("encoding an entry of the cache of router %s: %s", r.name, err)
+		return
+	}
+	if err := r.ds.Put(ctx, k, b); err != nil {
+		log.Warnf("writing the cache of router %s: %s", r.name, err)
+	}
+}
+
+// purge deletes the expired entries of the router periodically.
+func (r *cacheRouter) purge(ctx context.Context) {
+	t := time.NewTicker(r.ttl)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+
+		res, err := r.ds.Query(ctx, query.Query{Prefix: cacheRouterKey.ChildString(r.name).String()})
+		if err != nil {
+			log.Warnf("purging the cache of router %s: %s", r.name, err)
+			continue
+		}
+		now := time.Now()
+		var expired []datastore.Key
+		for entry := range res.Next() {
+			if entry.Error != nil {
+				break
+			}
+			var e cacheEntry
+			if err := json.Unmarshal(entry.Value, &e); err != nil || now.After(e.Expires) {
+				expired = append(expired, datastore.NewKey(entry.Key))
+			}
+		}
+		res.Close()
+		for _, k := range expired {
+			if err := r.ds.Delete(ctx, k); err != nil {
+				log.Warnf("purging the cache of router %s: %s", r.name, err)
+				break
+			}
+		}
+	}
+}
+
+// purgeRemovedCacheRouters deletes the entries of the cache routers that
+// are no longer in the config.
+func purgeRemovedCacheRouters(ctx context.Context, ds datastore.Datastore, routers config.Routers) {
+	res, err := ds.Query(ctx, query.Query{Prefix: cacheRouterKey.String(), KeysOnly: true})
+	if err != nil {
+		log.Warnf("purging the cache of the removed routers: %s", err)
+		return
+	}
+	var removed []datastore.Key
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			break
+		}
+		k := datastore.NewKey(entry.Key)
+		// /routing/cache/<router>/...
+		if parts := k.List(); len(parts) > 2 && routers[parts[2]].Type == config.RouterTypeCache {
+			continue
+		}
+		removed = append(removed, k)
+	}
+	res.Close()
+	for _, k := range removed {
+		if err := ds.Delete(ctx, k); err != nil {
+			log.Warnf("purging the cache of the removed routers: %s", err)
+			return
+		}
+	}
+}
+
+func (r *cacheRouter) hit(method config.MethodName) {
+	cacheRouterHitsMetric.WithLabelValues(r.name, string(method)).Inc()
+}
+
+func (r *cacheRouter) miss(method config.MethodName) {
+	cacheRouterMissesMetric.WithLabelValues(r.name, string(method)).Inc()
+}
+
+func (r *cacheRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	k := r.key("providers", dshelp.MultihashToDsKey(c.Hash()))
+	// the providers of a lookup limited to fewer providers than asked for
+	// are not enough
+	if e, ok := r.get(ctx, k); ok && len(e.Providers) > 0 && (e.Count == 0 || (count > 0 && count <= e.Count)) {
+		r.hit(config.MethodNameFindProviders)
+		providers := e.Providers
+		if count > 0 && len(providers) > count {
+			providers = providers[:count]
+		}
+		out := make(chan peer.AddrInfo, len(providers))
+		for _, p := range providers {
+			out <- p
+		}
+		close(out)
+		return out
+	}
+	r.miss(config.MethodNameFindProviders)
+
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		var found []peer.AddrInfo
+		for p := range in {
+			found = append(found, p)
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+		// only the lookups that were not cut short are kept
+		if len(found) == 0 || ctx.Err() != nil {
+			return
+		}
+		e := &cacheEntry{Providers: found}
+		if count > 0 && len(found) >= count {
+			e.Count = count
+		}
+		r.put(context.Background(), k, e)
+	}()
+	return out
+}
+
+func (r *cacheRouter) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	k := r.key("peers", datastore.NewKey(p.String()))
+	if e, ok := r.get(ctx, k); ok && e.Peer != nil {
+		r.hit(config.MethodNameFindPeers)
+		return *e.Peer, nil
+	}
+	r.miss(config.MethodNameFindPeers)
+
+	ai, err := r.Routing.FindPeer(ctx, p)
+	if err == nil && len(ai.Addrs) > 0 {
+		r.put(ctx, k, &cacheEntry{Peer: &ai})
+	}
+	return ai, err
+}
+
+// valueEntry returns the entry caching the value of key. The IPNS records
+// are not kept past their validity nor their own TTL, and invalid ones are
+// not kept at all.
+func valueEntry(key string, val []byte) (*cacheEntry, bool) {
+	e := &cacheEntry{Value: val}
+	if !strings.HasPrefix(key, ipns.NamespacePrefix) {
+		return e, true
+	}
+	rec, err := ipns.UnmarshalRecord(val)
+	if err != nil {
+		return nil, false
+	}
+	eol, err := rec.Validity()
+	if err != nil {
+		return nil, false
+	}
+	e.Expires = eol
+	if ttl, err := rec.TTL(); err == nil {
+		if expires := time.Now().Add(ttl); expires.Before(e.Expires) {
+			e.Expires = expires
+		}
+	}
+	return e, true
+}
+
+func (r *cacheRouter) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	k := r.key("values", dshelp.NewKeyFromBinary([]byte(key)))
+	if e, ok := r.get(ctx, k); ok {
+		r.hit(config.MethodNameGetIPNS)
+		return e.Value, nil
+	}
+	r.miss(config.MethodNameGetIPNS)
+
+	val, err := r.Routing.GetValue(ctx, key, opts...)
+	if err == nil {
+		if e, ok := valueEntry(key, val); ok {
+			r.put(ctx, k, e)
+		}
+	}
+	return val, err
+}
+
+// SearchValue always searches the router it caches, as the values found are
+// better and better, and starts with the cached value.
+func (r *cacheRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	k := r.key("values", dshelp.NewKeyFromBinary([]byte(key)))
+	var cached []byte
+	if e, ok := r.get(ctx, k); ok {
+		r.hit(config.MethodNameGetIPNS)
+		cached = e.Value
+	} else {
+		r.miss(config.MethodNameGetIPNS)
+	}
+
+	in, err := r.Routing.SearchValue(ctx, key, opts...)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		in = nil
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		if cached != nil {
+			select {
+			case out <- cached:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if in == nil {
+			return
+		}
+		var best []byte
+		for val := range in {
+			// the values found are better and better, the last one is kept
+			best = val
+			if bytes.Equal(val, cached) {
+				continue
+			}
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if best != nil && ctx.Err() == nil {
+			if e, ok := valueEntry(key, best); ok {
+				r.put(ctx, k, e)
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (r *cacheRouter) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	// the value put supersedes the one in the cache
+	k := r.key("values", dshelp.NewKeyFromBinary([]byte(key)))
+	if err := r.ds.Delete(ctx, k); err != nil {
+		log.Warnf("writing the cache of router %s: %s", r.name, err)
+	}
+	return r.Routing.PutValue(ctx, key, val, opts...)
+}
+
+func (r *cacheRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	if pmr, ok := r.Routing.(routinghelpers.ProvideManyRouter); ok {
+		return pmr.ProvideMany(ctx, keys)
+	}
+	for _, k := range keys {
+		if err := r.Routing.Provide(ctx, cid.NewCidV1(cid.Raw, k), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *cacheRouter) Ready() bool {
+	if rr, ok := r.Routing.(routinghelpers.ReadyAbleRouter); ok {
+		return rr.Ready()
+	}
+	return true
+}
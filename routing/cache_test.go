@@ -0,0 +1,275 @@
+package routing
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// countingRouter returns the same provider, peer and value to every lookup,
+// and counts them.
+type countingRouter struct {
+	routinghelpers.Null
+	ai      peer.AddrInfo
+	value   []byte
+	lookups int
+	// wait keeps the lookups of providers open until they are cancelled.
+	wait bool
+}
+
+func (r *countingRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	r.lookups++
+	out := make(chan peer.AddrInfo, 1)
+	out <- r.ai
+	if !r.wait {
+		close(out)
+		return out
+	}
+	go func() {
+		<-ctx.Done()
+		close(out)
+	}()
+	return out
+}
+
+func (r *countingRouter) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	r.lookups++
+	return r.ai, nil
+}
+
+func (r *countingRouter) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	r.lookups++
+	return r.value, nil
+}
+
+func (r *countingRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	r.lookups++
+	out := make(chan []byte, 1)
+	out <- r.value
+	close(out)
+	return out, nil
+}
+
+func (r *countingRouter) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	return nil
+}
+
+func TestCacheRouter(t *testing.T) {
+	require := require.New(t)
+
+	pid, _, err := generatePeerID()
+	require.NoError(err)
+	p, err := peer.Decode(pid)
+	require.NoError(err)
+	inner := &countingRouter{
+		ai: peer.AddrInfo{
+			ID:    p,
+			Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/4001")},
+		},
+		value: []byte("value"),
+	}
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	newRouter := func(ttl time.Duration) routing.Routing {
+		r, err := cacheRoutingFromConfig("cache", config.Router{
+			Type: config.RouterTypeCache,
+			Parameters: &config.CacheRouterParams{
+				RouterName: "inner",
+				TTL:        config.NewOptionalDuration(ttl),
+			},
+		}, inner, &ExtraCacheParams{Datastore: ds})
+		require.NoError(err)
+		return r
+	}
+	r := newRouter(time.Hour)
+
+	mh, err := multihash.Sum([]byte("foo"), multihash.SHA2_256, -1)
+	require.NoError(err)
+	c := cid.NewCidV1(cid.Raw, mh)
+
+	for i := 0; i < 2; i++ {
+		var providers []peer.AddrInfo
+		for ai := range r.FindProvidersAsync(context.Background(), c, 10) {
+			providers = append(providers, ai)
+		}
+		require.Len(providers, 1)
+		require.Equal(p, providers[0].ID)
+		require.Equal(inner.ai.Addrs, providers[0].Addrs)
+
+		ai, err := r.FindPeer(context.Background(), p)
+		require.NoError(err)
+		require.Equal(inner.ai.Addrs, ai.Addrs)
+
+		val, err := r.GetValue(context.Background(), "/v/foo")
+		require.NoError(err)
+		require.Equal(inner.value, val)
+	}
+	require.Equal(3, inner.lookups)
+
+	// the cache survives the router, as it does restarts
+	r = newRouter(time.Hour)
+	_, err = r.FindPeer(context.Background(), p)
+	require.NoError(err)
+	require.Equal(3, inner.lookups)
+
+	// putting a value invalidates its cached copy
+	require.NoError(r.PutValue(context.Background(), "/v/foo", []byte("new")))
+	_, err = r.GetValue(context.Background(), "/v/foo")
+	require.NoError(err)
+	require.Equal(4, inner.lookups)
+
+	// expired results are looked up again
+	ds = dssync.MutexWrap(datastore.NewMapDatastore())
+	r = newRouter(time.Nanosecond)
+	_, err = r.FindPeer(context.Background(), p)
+	require.NoError(err)
+	time.Sleep(time.Millisecond)
+	_, err = r.FindPeer(context.Background(), p)
+	require.NoError(err)
+	require.Equal(6, inner.lookups)
+
+	findProviders := func(ctx context.Context, count int) int {
+		var n int
+		for range r.FindProvidersAsync(ctx, c, count) {
+			n++
+		}
+		return n
+	}
+
+	// a lookup limited to fewer providers than asked for is not enough
+	ds = dssync.MutexWrap(datastore.NewMapDatastore())
+	r = newRouter(time.Hour)
+	require.Equal(1, findProviders(context.Background(), 1))
+	require.Equal(1, findProviders(context.Background(), 1))
+	require.Equal(7, inner.lookups)
+	require.Equal(1, findProviders(context.Background(), 10))
+	require.Equal(8, inner.lookups)
+	// but one that found all the providers is
+	require.Equal(1, findProviders(context.Background(), 0))
+	require.Equal(8, inner.lookups)
+
+	// cancelled lookups are not cached
+	ds = dssync.MutexWrap(datastore.NewMapDatastore())
+	r = newRouter(time.Hour)
+	inner.wait = true
+	ctx, cancel := context.WithCancel(context.Background())
+	out := r.FindProvidersAsync(ctx, c, 10)
+	<-out
+	cancel()
+	for range out {
+	}
+	inner.wait = false
+	require.Equal(1, findProviders(context.Background(), 10))
+	require.Equal(10, inner.lookups)
+}
+
+func TestCacheRouterValues(t *testing.T) {
+	require := require.New(t)
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(err)
+	p, err := peer.IDFromPrivateKey(sk)
+	require.NoError(err)
+	key := string(ipns.NameFromPeer(p).RoutingKey())
+	record := func(seq uint64, ttl time.Duration) []byte {
+		mh, err := multihash.Sum([]byte("foo"), multihash.SHA2_256, -1)
+		require.NoError(err)
+		rec, err := ipns.NewRecord(sk, path.FromCid(cid.NewCidV1(cid.Raw, mh)), seq, time.Now().Add(time.Hour), ttl)
+		require.NoError(err)
+		b, err := ipns.MarshalRecord(rec)
+		require.NoError(err)
+		return b
+	}
+
+	inner := &countingRouter{}
+	r, err := cacheRoutingFromConfig("cache-values", config.Router{
+		Type:       config.RouterTypeCache,
+		Parameters: &config.CacheRouterParams{RouterName: "inner"},
+	}, inner, &ExtraCacheParams{Datastore: dssync.MutexWrap(datastore.NewMapDatastore())})
+	require.NoError(err)
+	getValue := func() {
+		val, err := r.GetValue(context.Background(), key)
+		require.NoError(err)
+		require.Equal(inner.value, val)
+	}
+
+	// the records are not kept past their TTL
+	inner.value = record(1, time.Nanosecond)
+	getValue()
+	time.Sleep(time.Millisecond)
+	getValue()
+	require.Equal(2, inner.lookups)
+
+	inner.value = record(2, time.Hour)
+	getValue()
+	getValue()
+	require.Equal(3, inner.lookups)
+
+	// nor the invalid ones
+	inner.value = []byte("invalid")
+	_, err = r.GetValue(context.Background(), "/ipns/invalid")
+	require.NoError(err)
+	_, err = r.GetValue(context.Background(), "/ipns/invalid")
+	require.NoError(err)
+	require.Equal(5, inner.lookups)
+
+	// searches start with the cached value, and go on with the router
+	inner.value = record(3, time.Hour)
+	ch, err := r.SearchValue(context.Background(), key)
+	require.NoError(err)
+	var found [][]byte
+	for val := range ch {
+		found = append(found, val)
+	}
+	require.Equal(6, inner.lookups)
+	require.Len(found, 2)
+	require.Equal(inner.value, found[1])
+	rec, err := ipns.UnmarshalRecord(found[0])
+	require.NoError(err)
+	seq, err := rec.Sequence()
+	require.NoError(err)
+	require.EqualValues(2, seq)
+
+	// the best value found is cached
+	getValue()
+	require.Equal(6, inner.lookups)
+}
+
+func TestPurgeRemovedCacheRouters(t *testing.T) {
+	ctx := context.Background()
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	kept := cacheRouterKey.ChildString("kept").ChildString("peers").ChildString("foo")
+	removed := cacheRouterKey.ChildString("removed").ChildString("peers").ChildString("foo")
+	other := cacheRouterKey.ChildString("other").ChildString("peers").ChildString("foo")
+	for _, k := range []datastore.Key{kept, removed, other} {
+		require.NoError(t, ds.Put(ctx, k, []byte("{}")))
+	}
+
+	purgeRemovedCacheRouters(ctx, ds, config.Routers{
+		"kept":  {Router: config.Router{Type: config.RouterTypeCache}},
+		"other": {Router: config.Router{Type: config.RouterTypeHTTP}},
+	})
+	has := func(k datastore.Key) bool {
+		ok, err := ds.Has(ctx, k)
+		require.NoError(t, err)
+		return ok
+	}
+	require.True(t, has(kept))
+	require.False(t, has(removed))
+	require.False(t, has(other))
+}
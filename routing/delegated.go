@@ -29,6 +29,12 @@ import (
 var log = logging.Logger("routing/delegated")
 
 func Parse(routers config.Routers, methods config.Methods, extraDHT *ExtraDHTParams, extraHTTP *ExtraHTTPParams) (routing.Routing, error) {
+	return ParseWithCache(routers, methods, extraDHT, extraHTTP, nil)
+}
+
+// ParseWithCache is like Parse, but with the parameters the routers of type
+// "cache" need.
+func ParseWithCache(routers config.Routers, methods config.Methods, extraDHT *ExtraDHTParams, extraHTTP *ExtraHTTPParams, extraCache *ExtraCacheParams) (routing.Routing, error) {
 	if err := methods.Check(); err != nil {
 		return nil, err
 	}
@@ -38,7 +44,7 @@ func Parse(routers config.Routers, methods config.Methods, extraDHT *ExtraDHTPar
 
 	// Create all needed routers from method names
 	for mn, m := range methods {
-		router, err := parse(make(map[string]bool), createdRouters, m.RouterName, routers, extraDHT, extraHTTP)
+		router, err := parse(make(map[string]bool), createdRouters, m.RouterName, routers, extraDHT, extraHTTP, extraCache)
 		if err != nil {
 			return nil, err
 		}
@@ -59,6 +65,10 @@ func Parse(routers config.Routers, methods config.Methods, extraDHT *ExtraDHTPar
 		log.Info("using method ", mn, " with router ", m.RouterName)
 	}
 
+	if extraCache != nil && extraCache.Datastore != nil && extraCache.Context != nil {
+		go purgeRemovedCacheRouters(extraCache.Context, extraCache.Datastore, routers)
+	}
+
 	return finalRouter, nil
 }
 
@@ -68,6 +78,7 @@ func parse(visited map[string]bool,
 	routersCfg config.Routers,
 	extraDHT *ExtraDHTParams,
 	extraHTTP *ExtraHTTPParams,
+	extraCache *ExtraCacheParams,
 ) (routing.Routing, error) {
 	// check if we already created it
 	r, ok := createdRouters[routerName]
@@ -93,13 +104,20 @@ func parse(visited map[string]bool,
 	switch cfg.Type {
 	case config.RouterTypeHTTP:
 		router, err = httpRoutingFromConfig(cfg.Router, extraHTTP)
+		if err == nil && extraHTTP.ProvideTracker != nil {
+			router = &announcingRouter{
+				ProvideManyRouter: router.(ProvideManyRouter),
+				name:              routerName,
+				tracker:           extraHTTP.ProvideTracker,
+			}
+		}
 	case config.RouterTypeDHT:
 		router, err = dhtRoutingFromConfig(cfg.Router, extraDHT)
 	case config.RouterTypeParallel:
 		crp := cfg.Parameters.(*config.ComposableRouterParams)
 		var pr []*routinghelpers.ParallelRouter
 		for _, cr := range crp.Routers {
-			ri, err := parse(visited, createdRouters, cr.RouterName, routersCfg, extraDHT, extraHTTP)
+			ri, err := parse(visited, createdRouters, cr.RouterName, routersCfg, extraDHT, extraHTTP, extraCache)
 			if err != nil {
 				return nil, err
 			}
@@ -114,12 +132,16 @@ func parse(visited map[string]bool,
 
 		}
 
-		router = routinghelpers.NewComposableParallel(pr)
+		if crp.HedgePercentile != nil {
+			router, err = hedgedRoutingFromConfig(routerName, crp, pr)
+		} else {
+			router = routinghelpers.NewComposableParallel(pr)
+		}
 	case config.RouterTypeSequential:
 		crp := cfg.Parameters.(*config.ComposableRouterParams)
 		var sr []*routinghelpers.SequentialRouter
 		for _, cr := range crp.Routers {
-			ri, err := parse(visited, createdRouters, cr.RouterName, routersCfg, extraDHT, extraHTTP)
+			ri, err := parse(visited, createdRouters, cr.RouterName, routersCfg, extraDHT, extraHTTP, extraCache)
 			if err != nil {
 				return nil, err
 			}
@@ -133,6 +155,15 @@ func parse(visited map[string]bool,
 		}
 
 		router = routinghelpers.NewComposableSequential(sr)
+	case config.RouterTypeCache:
+		crp := cfg.Parameters.(*config.CacheRouterParams)
+		var ri routing.Routing
+		ri, err = parse(visited, createdRouters, crp.RouterName, routersCfg, extraDHT, extraHTTP, extraCache)
+		if err != nil {
+			return nil, err
+		}
+
+		router, err = cacheRoutingFromConfig(routerName, cfg.Router, ri, extraCache)
 	default:
 		return nil, fmt.Errorf("unknown router type %q", cfg.Type)
 	}
@@ -141,6 +172,7 @@ func parse(visited map[string]bool,
 		return nil, err
 	}
 
+	router = MeasureRouter(routerName, router)
 	createdRouters[routerName] = router
 
 	log.Info("created router ", routerName, " with params ", cfg.Parameters)
@@ -152,6 +184,8 @@ type ExtraHTTPParams struct {
 	PeerID     string
 	Addrs      []string
 	PrivKeyB64 string
+	// ProvideTracker records the keys the HTTP routers announce, if set.
+	ProvideTracker *ProvideTracker
 }
 
 func ConstructHTTPRouter(endpoint string, peerID string, addrs []string, privKey string) (routing.Routing, error) {
@@ -5,6 +5,8 @@ import (
 	"encoding/base64"
 	"testing"
 
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/ipfs/kubo/config"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -205,6 +207,57 @@ func TestParserRecursiveLoop(t *testing.T) {
 	require.ErrorContains(err, "dependency loop creating router with name \"composable2\"")
 }
 
+func TestParserCacheErrors(t *testing.T) {
+	require := require.New(t)
+
+	pid, sk, err := generatePeerID()
+	require.NoError(err)
+
+	routers := func(ttl *config.OptionalDuration) config.Routers {
+		return config.Routers{
+			"http": config.RouterParser{
+				Router: config.Router{
+					Type: config.RouterTypeHTTP,
+					Parameters: &config.HTTPRouterParams{
+						Endpoint: "testEndpoint",
+					},
+				},
+			},
+			"cache": config.RouterParser{
+				Router: config.Router{
+					Type: config.RouterTypeCache,
+					Parameters: &config.CacheRouterParams{
+						RouterName: "http",
+						TTL:        ttl,
+					},
+				},
+			},
+		}
+	}
+	methods := config.Methods{
+		config.MethodNameFindPeers:     config.Method{RouterName: "cache"},
+		config.MethodNameFindProviders: config.Method{RouterName: "cache"},
+		config.MethodNameGetIPNS:       config.Method{RouterName: "cache"},
+		config.MethodNamePutIPNS:       config.Method{RouterName: "cache"},
+		config.MethodNameProvide:       config.Method{RouterName: "cache"},
+	}
+	extraHTTP := &ExtraHTTPParams{
+		PeerID:     pid,
+		PrivKeyB64: sk,
+	}
+
+	// Parse has no datastore for the cache
+	_, err = Parse(routers(nil), methods, &ExtraDHTParams{}, extraHTTP)
+	require.ErrorContains(err, "cache router needs a datastore")
+
+	extraCache := &ExtraCacheParams{Datastore: dssync.MutexWrap(datastore.NewMapDatastore())}
+	_, err = ParseWithCache(routers(config.NewOptionalDuration(0)), methods, &ExtraDHTParams{}, extraHTTP, extraCache)
+	require.ErrorContains(err, "the TTL of a cache router must be positive")
+
+	_, err = ParseWithCache(routers(nil), methods, &ExtraDHTParams{}, extraHTTP, extraCache)
+	require.NoError(err)
+}
+
 func generatePeerID() (string, string, error) {
 	sk, pk, err := crypto.GenerateEd25519Key(rand.Reader)
 	if err != nil {
@@ -0,0 +1,311 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+)
+
+var (
+	_ routing.Routing                  = &hedgedRouter{}
+	_ routinghelpers.ProvideManyRouter = &hedgedRouter{}
+	_ routinghelpers.ComposableRouter  = &hedgedRouter{}
+)
+
+// errHedged is the cause of the cancellation of the lookups that another
+// router answered first.
+var errHedged = errors.New("answered by another router")
+
+// parallelRouter is what the parallel routers of go-libp2p-routing-helpers
+// implement.
+type parallelRouter interface {
+	routing.Routing
+	routinghelpers.ProvideManyRouter
+	routinghelpers.ReadyAbleRouter
+	routinghelpers.ComposableRouter
+}
+
+// hedgedRouter is a parallel router that sends the lookups to its first
+// router, and to the others only when the first has not answered within a
+// percentile of its latencies. Provides and puts go to all of them at once.
+type hedgedRouter struct {
+	// all the routers, in parallel
+	parallel parallelRouter
+
+	name        string
+	primary     routing.Routing
+	secondaries routing.Routing
+	// latencies are those of the primary router
+	latencies  *measuredRouter
+	percentile int
+}
+
+func hedgedRoutingFromConfig(name string, params *config.ComposableRouterParams, pr []*routinghelpers.ParallelRouter) (routing.Routing, error) {
+	percentile := params.HedgePercentile.WithDefault(0)
+	if percentile < 1 || percentile > 99 {
+		return nil, errors.New("the HedgePercentile of a parallel router must be between 1 and 99")
+	}
+	if len(pr) < 2 {
+		return nil, errors.New("hedging needs a parallel router of two routers or more")
+	}
+	latencies, ok := pr[0].Router.(*measuredRouter)
+	if !ok {
+		return nil, errors.New("the latencies of the first router of a hedging parallel router are not measured")
+	}
+
+	return &hedgedRouter{
+		parallel:    routinghelpers.NewComposableParallel(pr),
+		name:        name,
+		primary:     routinghelpers.NewComposableParallel(pr[:1]),
+		secondaries: routinghelpers.NewComposableParallel(pr[1:]),
+		latencies:   latencies,
+		percentile:  int(percentile),
+	}, nil
+}
+
+// delay returns how long the primary router is given before the lookups of
+// method are sent to the secondary ones. Until enough of its latencies are
+// known, they are sent to all routers at once, and false is returned: the
+// lookups of the primary router are then left to finish, to learn them.
+func (r *hedgedRouter) delay(method config.MethodName) (time.Duration, bool) {
+	return r.latencies.latency(method, r.percentile)
+}
+
+func (r *hedgedRouter) hedged(method config.MethodName) {
+	routerHedgedMetric.WithLabelValues(r.name, string(method)).Inc()
+}
+
+// hedge returns the first successful result of primary, or of secondary when
+// primary has failed or not answered within the delay of method.
+func hedge[T any](ctx context.Context, r *hedgedRouter, method config.MethodName, primary, secondary func(context.Context) (T, error)) (T, error) {
+	delay, known := r.delay(method)
+	pctx := ctx
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errHedged)
+	if known {
+		pctx = ctx
+	}
+
+	type result struct {
+		val T
+		err error
+	}
+	results := make(chan result, 2)
+	run := func(ctx context.Context, f func(context.Context) (T, error)) {
+		val, err := f(ctx)
+		results <- result{val, err}
+	}
+	go run(pctx, primary)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending, hedged := 1, false
+	startSecondary := func() {
+		hedged = true
+		pending++
+		r.hedged(method)
+		go run(ctx, secondary)
+	}
+
+	var errs error
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				startSecondary()
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				return res.val, nil
+			}
+			errs = errors.Join(errs, res.err)
+			if !hedged {
+				startSecondary()
+			} else if pending == 0 {
+				var zero T
+				return zero, errs
+			}
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// hedgeStream merges the results of primary and secondary, without the
+// duplicates, and starts secondary when primary has found nothing within the
+// delay of method. At most count results are returned when count is positive.
+func hedgeStream[T any](ctx context.Context, r *hedgedRouter, method config.MethodName, count int, key func(T) string, primary, secondary func(context.Context) (<-chan T, error)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		delay, known := r.delay(method)
+		pctx := ctx
+		ctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(errHedged)
+		if known {
+			pctx = ctx
+		}
+
+		pch := async(pctx, primary)
+		defer func() {
+			// the lookup of the primary router may still be running
+			if pch != nil && !known {
+				go func(pch <-chan T) {
+					for range pch {
+					}
+				}(pch)
+			}
+		}()
+		var sch <-chan T
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timerC := timer.C
+		hedged := false
+		startSecondary := func() {
+			hedged = true
+			timerC = nil
+			r.hedged(method)
+			sch = async(ctx, secondary)
+		}
+
+		seen := make(map[string]struct{})
+		emit := func(v T) bool {
+			k := key(v)
+			if _, ok := seen[k]; ok {
+				return true
+			}
+			seen[k] = struct{}{}
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return false
+			}
+			return count <= 0 || len(seen) < count
+		}
+
+		for pch != nil || sch != nil {
+			select {
+			case <-timerC:
+				startSecondary()
+			case v, ok := <-pch:
+				if !ok {
+					pch = nil
+					if !hedged && len(seen) == 0 {
+						startSecondary()
+					}
+					continue
+				}
+				// the primary router answered in time
+				timerC = nil
+				if !emit(v) {
+					return
+				}
+			case v, ok := <-sch:
+				if !ok {
+					sch = nil
+					continue
+				}
+				if !emit(v) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// async returns the results of f, without waiting for it to start them as the
+// parallel routers do. A failure of f ends them.
+func async[T any](ctx context.Context, f func(context.Context) (<-chan T, error)) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		in, err := f(ctx)
+		if err != nil {
+			return
+		}
+		for v := range in {
+			select {
+			case out <- v:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Routers returns the routers of the parallel router, so that they can be
+// found under it as under the other composable routers.
+func (r *hedgedRouter) Routers() []routing.Routing {
+	return r.parallel.Routers()
+}
+
+func (r *hedgedRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return r.parallel.Provide(ctx, c, announce)
+}
+
+func (r *hedgedRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	return r.parallel.ProvideMany(ctx, keys)
+}
+
+func (r *hedgedRouter) Ready() bool {
+	return r.parallel.Ready()
+}
+
+func (r *hedgedRouter) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	return r.parallel.PutValue(ctx, key, val, opts...)
+}
+
+func (r *hedgedRouter) Bootstrap(ctx context.Context) error {
+	return r.parallel.Bootstrap(ctx)
+}
+
+func (r *hedgedRouter) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	find := func(router routing.Routing) func(context.Context) (peer.AddrInfo, error) {
+		return func(ctx context.Context) (peer.AddrInfo, error) {
+			return router.FindPeer(ctx, p)
+		}
+	}
+	return hedge(ctx, r, config.MethodNameFindPeers, find(r.primary), find(r.secondaries))
+}
+
+func (r *hedgedRouter) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	get := func(router routing.Routing) func(context.Context) ([]byte, error) {
+		return func(ctx context.Context) ([]byte, error) {
+			return router.GetValue(ctx, key, opts...)
+		}
+	}
+	return hedge(ctx, r, config.MethodNameGetIPNS, get(r.primary), get(r.secondaries))
+}
+
+func (r *hedgedRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	find := func(router routing.Routing) func(context.Context) (<-chan peer.AddrInfo, error) {
+		return func(ctx context.Context) (<-chan peer.AddrInfo, error) {
+			return router.FindProvidersAsync(ctx, c, count), nil
+		}
+	}
+	key := func(ai peer.AddrInfo) string { return string(ai.ID) }
+	return hedgeStream(ctx, r, config.MethodNameFindProviders, count, key, find(r.primary), find(r.secondaries))
+}
+
+func (r *hedgedRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	search := func(router routing.Routing) func(context.Context) (<-chan []byte, error) {
+		return func(ctx context.Context) (<-chan []byte, error) {
+			return router.SearchValue(ctx, key, opts...)
+		}
+	}
+	valKey := func(val []byte) string { return string(val) }
+	return hedgeStream(ctx, r, config.MethodNameGetIPNS, 0, valKey, search(r.primary), search(r.secondaries)), nil
+}
@@ -0,0 +1,250 @@
+package routing
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// slowRouter answers the lookups after its delay, and counts them.
+type slowRouter struct {
+	routinghelpers.Null
+	delay   atomic.Int64
+	ai      peer.AddrInfo
+	lookups atomic.Int32
+}
+
+func (r *slowRouter) wait(ctx context.Context) error {
+	r.lookups.Add(1)
+	select {
+	case <-time.After(time.Duration(r.delay.Load())):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *slowRouter) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	if err := r.wait(ctx); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	return r.ai, nil
+}
+
+func (r *slowRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo, 1)
+	go func() {
+		defer close(out)
+		if r.wait(ctx) == nil {
+			out <- r.ai
+		}
+	}()
+	return out
+}
+
+func newSlowRouter(t *testing.T, delay time.Duration) *slowRouter {
+	pid, _, err := generatePeerID()
+	require.NoError(t, err)
+	p, err := peer.Decode(pid)
+	require.NoError(t, err)
+	r := &slowRouter{ai: peer.AddrInfo{ID: p}}
+	r.delay.Store(int64(delay))
+	return r
+}
+
+func findRouterStat(name string, method config.MethodName) RouterStat {
+	for _, s := range RouterStats() {
+		if s.Router == name && s.Method == method {
+			return s
+		}
+	}
+	return RouterStat{}
+}
+
+func TestMeasuredRouter(t *testing.T) {
+	require := require.New(t)
+
+	inner := newSlowRouter(t, 0)
+	r := MeasureRouter("test-measured", inner).(*measuredRouter)
+	findPeers := findRouterStat("test-measured", config.MethodNameFindPeers)
+	getIPNS := findRouterStat("test-measured", config.MethodNameGetIPNS)
+	putIPNS := findRouterStat("test-measured", config.MethodNamePutIPNS)
+
+	_, ok := r.latency(config.MethodNameFindPeers, 50)
+	require.False(ok, "percentile without samples")
+
+	for i := 0; i < minLatencySamples; i++ {
+		_, err := r.FindPeer(context.Background(), inner.ai.ID)
+		require.NoError(err)
+	}
+	_, err := r.GetValue(context.Background(), "/ipns/foo")
+	require.ErrorIs(err, routing.ErrNotFound)
+	require.Error(r.PutValue(context.Background(), "/ipns/foo", nil))
+
+	_, ok = r.latency(config.MethodNameFindPeers, 50)
+	require.True(ok)
+
+	s := findRouterStat("test-measured", config.MethodNameFindPeers)
+	require.EqualValues(minLatencySamples, s.Requests-findPeers.Requests)
+	require.EqualValues(minLatencySamples, s.Results-findPeers.Results)
+	require.Equal(findPeers.Errors, s.Errors)
+
+	// finding nothing is not a failure
+	s = findRouterStat("test-measured", config.MethodNameGetIPNS)
+	require.EqualValues(1, s.Requests-getIPNS.Requests)
+	require.Equal(getIPNS.Errors, s.Errors)
+
+	s = findRouterStat("test-measured", config.MethodNamePutIPNS)
+	require.EqualValues(1, s.Requests-putIPNS.Requests)
+	require.EqualValues(1, s.Errors-putIPNS.Errors)
+}
+
+func TestLatencyWindow(t *testing.T) {
+	var w latencyWindow
+	for i := 1; i <= latencyWindowSize+100; i++ {
+		w.add(time.Duration(i))
+	}
+	// only the last latencies are kept
+	p, ok := w.percentile(1)
+	require.True(t, ok)
+	require.Equal(t, time.Duration(102), p)
+	p, _ = w.percentile(99)
+	require.Equal(t, time.Duration(227), p)
+}
+
+func TestHedgedRouter(t *testing.T) {
+	require := require.New(t)
+
+	primary := newSlowRouter(t, 20*time.Millisecond)
+	secondary := newSlowRouter(t, 0)
+	measured := MeasureRouter("test-primary", primary)
+	r, err := hedgedRoutingFromConfig("test-hedged", &config.ComposableRouterParams{
+		HedgePercentile: config.NewOptionalInteger(90),
+	}, []*routinghelpers.ParallelRouter{
+		{Router: measured},
+		{Router: secondary},
+	})
+	require.NoError(err)
+	findPeers := findRouterStat("test-hedged", config.MethodNameFindPeers)
+	findProviders := findRouterStat("test-hedged", config.MethodNameFindProviders)
+
+	mh, err := multihash.Sum([]byte("foo"), multihash.SHA2_256, -1)
+	require.NoError(err)
+	c := cid.NewCidV1(cid.Raw, mh)
+
+	// until the latencies of the primary router are known, every lookup goes
+	// to all routers
+	for i := 0; i < minLatencySamples; i++ {
+		_, err := r.FindPeer(context.Background(), primary.ai.ID)
+		require.NoError(err)
+	}
+	require.Eventually(func() bool {
+		return primary.lookups.Load() == minLatencySamples
+	}, time.Second, time.Millisecond)
+	require.EqualValues(minLatencySamples, secondary.lookups.Load())
+
+	// a primary router on time is asked alone
+	for i := 0; i < minLatencySamples; i++ {
+		for ai := range measured.FindProvidersAsync(context.Background(), c, 0) {
+			require.Equal(primary.ai.ID, ai.ID)
+		}
+	}
+	primary.delay.Store(0)
+	secondary.lookups.Store(0)
+	providers := 0
+	for ai := range r.FindProvidersAsync(context.Background(), c, 0) {
+		require.Equal(primary.ai.ID, ai.ID)
+		providers++
+	}
+	require.Equal(1, providers)
+	_, err = r.FindPeer(context.Background(), primary.ai.ID)
+	require.NoError(err)
+	require.Zero(secondary.lookups.Load())
+
+	// a late one is not waited for
+	primary.delay.Store(int64(time.Minute))
+	ai, err := r.FindPeer(context.Background(), primary.ai.ID)
+	require.NoError(err)
+	require.Equal(secondary.ai.ID, ai.ID)
+	require.EqualValues(1, secondary.lookups.Load())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var found []peer.ID
+	for ai := range r.FindProvidersAsync(ctx, c, 1) {
+		found = append(found, ai.ID)
+	}
+	require.Equal([]peer.ID{secondary.ai.ID}, found)
+	require.NoError(ctx.Err())
+
+	s := findRouterStat("test-hedged", config.MethodNameFindPeers)
+	require.EqualValues(minLatencySamples+1, s.Hedged-findPeers.Hedged)
+	s = findRouterStat("test-hedged", config.MethodNameFindProviders)
+	require.EqualValues(1, s.Hedged-findProviders.Hedged)
+}
+
+func TestHedgedRouterSlowPrimary(t *testing.T) {
+	require := require.New(t)
+
+	const latency = 20 * time.Millisecond
+	primary := newSlowRouter(t, latency)
+	secondary := newSlowRouter(t, 0)
+	r, err := hedgedRoutingFromConfig("test-slow-primary", &config.ComposableRouterParams{
+		HedgePercentile: config.NewOptionalInteger(90),
+	}, []*routinghelpers.ParallelRouter{
+		{Router: MeasureRouter("test-slow-primary", primary)},
+		{Router: secondary},
+	})
+	require.NoError(err)
+	hr := r.(*hedgedRouter)
+
+	for i := 0; i < minLatencySamples; i++ {
+		_, err := r.FindPeer(context.Background(), primary.ai.ID)
+		require.NoError(err)
+	}
+	require.Eventually(func() bool {
+		_, known := hr.delay(config.MethodNameFindPeers)
+		return known
+	}, time.Second, time.Millisecond)
+
+	// the primary router is mostly fast, but too slow more than a tenth of
+	// the time: the lookups canceled when the secondary routers answered
+	// keep the delay up
+	for i := 0; i < latencyWindowSize; i++ {
+		if i%5 == 0 {
+			primary.delay.Store(int64(time.Minute))
+		} else {
+			primary.delay.Store(0)
+		}
+		_, err := r.FindPeer(context.Background(), primary.ai.ID)
+		require.NoError(err)
+	}
+	require.Eventually(func() bool {
+		delay, known := hr.delay(config.MethodNameFindPeers)
+		return known && delay >= latency
+	}, time.Second, time.Millisecond)
+}
+
+func TestHedgedRouterConfig(t *testing.T) {
+	measured := MeasureRouter("test-config", routinghelpers.Null{})
+	pr := []*routinghelpers.ParallelRouter{{Router: measured}, {Router: routinghelpers.Null{}}}
+
+	_, err := hedgedRoutingFromConfig("r", &config.ComposableRouterParams{
+		HedgePercentile: config.NewOptionalInteger(100),
+	}, pr)
+	require.Error(t, err)
+
+	_, err = hedgedRoutingFromConfig("r", &config.ComposableRouterParams{
+		HedgePercentile: config.NewOptionalInteger(90),
+	}, pr[:1])
+	require.Error(t, err)
+}
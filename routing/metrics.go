@@ -0,0 +1,358 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/config"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multihash"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// latencyWindowSize is how many of the last latencies of each method of a
+// router are kept for the percentiles of hedging.
+const latencyWindowSize = 128
+
+// minLatencySamples is how many latencies are needed before the percentiles
+// are trusted.
+const minLatencySamples = 10
+
+var (
+	routerRequestDurationMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ipfs_routing_request_duration_seconds",
+		Help:    "Latency of the requests to the routers, until the first result for the lookups returning several.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"router", "method"})
+	routerErrorsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_routing_errors_total",
+		Help: "Number of the requests to the routers that failed, not counting those that found nothing.",
+	}, []string{"router", "method"})
+	routerResultsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_routing_results_total",
+		Help: "Number of providers, peers and values found by the routers.",
+	}, []string{"router", "method"})
+	routerHedgedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ipfs_routing_hedged_requests_total",
+		Help: "Number of the requests of the hedging parallel routers sent to the secondary routers.",
+	}, []string{"router", "method"})
+)
+
+// RouterStat is what the metrics say of a method of a router.
+type RouterStat struct {
+	Router   string
+	Method   config.MethodName
+	Requests uint64
+	Errors   uint64
+	Results  uint64
+	// Hedged is how many requests a hedging parallel router sent to its
+	// secondary routers.
+	Hedged         uint64        `json:",omitempty"`
+	AverageLatency time.Duration `json:",omitempty"`
+}
+
+// RouterStats returns the stats of the routers measured since the node
+// started, sorted by router and method.
+func RouterStats() []RouterStat {
+	stats := make(map[[2]string]*RouterStat)
+	stat := func(m prometheus.Metric) (*RouterStat, *dto.Metric) {
+		var d dto.Metric
+		if err := m.Write(&d); err != nil {
+			return nil, nil
+		}
+		var key [2]string
+		for _, l := range d.GetLabel() {
+			switch l.GetName() {
+			case "router":
+				key[0] = l.GetValue()
+			case "method":
+				key[1] = l.GetValue()
+			}
+		}
+		s, ok := stats[key]
+		if !ok {
+			s = &RouterStat{Router: key[0], Method: config.MethodName(key[1])}
+			stats[key] = s
+		}
+		return s, &d
+	}
+	collect := func(c prometheus.Collector, f func(*RouterStat, *dto.Metric)) {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			c.Collect(ch)
+			close(ch)
+		}()
+		for m := range ch {
+			if s, d := stat(m); s != nil {
+				f(s, d)
+			}
+		}
+	}
+
+	collect(routerRequestDurationMetric, func(s *RouterStat, d *dto.Metric) {
+		h := d.GetHistogram()
+		s.Requests = h.GetSampleCount()
+		if s.Requests > 0 {
+			s.AverageLatency = time.Duration(h.GetSampleSum() / float64(s.Requests) * float64(time.Second))
+		}
+	})
+	collect(routerErrorsMetric, func(s *RouterStat, d *dto.Metric) {
+		s.Errors = uint64(d.GetCounter().GetValue())
+	})
+	collect(routerResultsMetric, func(s *RouterStat, d *dto.Metric) {
+		s.Results = uint64(d.GetCounter().GetValue())
+	})
+	collect(routerHedgedMetric, func(s *RouterStat, d *dto.Metric) {
+		s.Hedged = uint64(d.GetCounter().GetValue())
+	})
+
+	out := make([]RouterStat, 0, len(stats))
+	for _, s := range stats {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Router != out[j].Router {
+			return out[i].Router < out[j].Router
+		}
+		return out[i].Method < out[j].Method
+	})
+	return out
+}
+
+var (
+	_ routing.Routing                  = &measuredRouter{}
+	_ routinghelpers.ProvideManyRouter = &measuredRouter{}
+)
+
+// measuredRouter exports the latencies, errors and results of the requests
+// to a router as metrics, under its name.
+type measuredRouter struct {
+	routing.Routing
+	name string
+
+	mu        sync.Mutex
+	latencies map[config.MethodName]*latencyWindow
+}
+
+// MeasureRouter returns r with the latencies, errors and results of its
+// requests reported by the metrics under name.
+func MeasureRouter(name string, r routing.Routing) routing.Routing {
+	return &measuredRouter{
+		Routing:   r,
+		name:      name,
+		latencies: make(map[config.MethodName]*latencyWindow),
+	}
+}
+
+// Unwrap returns the measured router.
+func (r *measuredRouter) Unwrap() routing.Routing {
+	return r.Routing
+}
+
+// latency returns the percentile of the latencies of the last successful
+// requests of method, and false when there are too few of them yet.
+func (r *measuredRouter) latency(method config.MethodName, percentile int) (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.latencies[method]
+	if !ok {
+		return 0, false
+	}
+	return w.percentile(percentile)
+}
+
+// done records a request of method that took d, and failed with err. Those
+// that found nothing are not failures, and those canceled are not counted,
+// except in the latencies of hedging when another router answered first:
+// they would have taken longer than d, and leaving them out would make the
+// percentiles drift down.
+func (r *measuredRouter) done(ctx context.Context, method config.MethodName, d time.Duration, err error) {
+	if errors.Is(err, context.Canceled) {
+		if errors.Is(context.Cause(ctx), errHedged) {
+			r.observe(method, d)
+		}
+		return
+	}
+	routerRequestDurationMetric.WithLabelValues(r.name, string(method)).Observe(d.Seconds())
+	if err != nil {
+		if !errors.Is(err, routing.ErrNotFound) {
+			routerErrorsMetric.WithLabelValues(r.name, string(method)).Inc()
+		}
+		return
+	}
+	r.observe(method, d)
+}
+
+// observe adds a latency of method to the window of the percentiles.
+func (r *measuredRouter) observe(method config.MethodName, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w, ok := r.latencies[method]
+	if !ok {
+		w = &latencyWindow{}
+		r.latencies[method] = w
+	}
+	w.add(d)
+}
+
+func (r *measuredRouter) results(method config.MethodName, n int) {
+	routerResultsMetric.WithLabelValues(r.name, string(method)).Add(float64(n))
+}
+
+func (r *measuredRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	start := time.Now()
+	err := r.Routing.Provide(ctx, c, announce)
+	r.done(ctx, config.MethodNameProvide, time.Since(start), err)
+	return err
+}
+
+func (r *measuredRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	start := time.Now()
+	var err error
+	if pmr, ok := r.Routing.(routinghelpers.ProvideManyRouter); ok {
+		err = pmr.ProvideMany(ctx, keys)
+	} else {
+		for _, k := range keys {
+			if err = r.Routing.Provide(ctx, cid.NewCidV1(cid.Raw, k), true); err != nil {
+				break
+			}
+		}
+	}
+	r.done(ctx, config.MethodNameProvide, time.Since(start), err)
+	return err
+}
+
+func (r *measuredRouter) Ready() bool {
+	if rr, ok := r.Routing.(routinghelpers.ReadyAbleRouter); ok {
+		return rr.Ready()
+	}
+	return true
+}
+
+func (r *measuredRouter) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	start := time.Now()
+	in := r.Routing.FindProvidersAsync(ctx, c, count)
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		found := 0
+		defer func() {
+			r.results(config.MethodNameFindProviders, found)
+			// a lookup cut short does not tell how long it would have
+			// taken, see done
+			if found == 0 {
+				err := routing.ErrNotFound
+				if ctx.Err() != nil {
+					err = context.Canceled
+				}
+				r.done(ctx, config.MethodNameFindProviders, time.Since(start), err)
+			}
+		}()
+		for p := range in {
+			if found == 0 {
+				r.done(ctx, config.MethodNameFindProviders, time.Since(start), nil)
+			}
+			found++
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+func (r *measuredRouter) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	start := time.Now()
+	ai, err := r.Routing.FindPeer(ctx, p)
+	r.done(ctx, config.MethodNameFindPeers, time.Since(start), err)
+	if err == nil {
+		r.results(config.MethodNameFindPeers, 1)
+	}
+	return ai, err
+}
+
+func (r *measuredRouter) PutValue(ctx context.Context, key string, val []byte, opts ...routing.Option) error {
+	start := time.Now()
+	err := r.Routing.PutValue(ctx, key, val, opts...)
+	r.done(ctx, config.MethodNamePutIPNS, time.Since(start), err)
+	return err
+}
+
+func (r *measuredRouter) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	start := time.Now()
+	val, err := r.Routing.GetValue(ctx, key, opts...)
+	r.done(ctx, config.MethodNameGetIPNS, time.Since(start), err)
+	if err == nil {
+		r.results(config.MethodNameGetIPNS, 1)
+	}
+	return val, err
+}
+
+func (r *measuredRouter) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	start := time.Now()
+	in, err := r.Routing.SearchValue(ctx, key, opts...)
+	if err != nil {
+		r.done(ctx, config.MethodNameGetIPNS, time.Since(start), err)
+		return nil, err
+	}
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+		found := 0
+		defer func() {
+			r.results(config.MethodNameGetIPNS, found)
+			if found == 0 {
+				err := routing.ErrNotFound
+				if ctx.Err() != nil {
+					err = context.Canceled
+				}
+				r.done(ctx, config.MethodNameGetIPNS, time.Since(start), err)
+			}
+		}()
+		for val := range in {
+			if found == 0 {
+				r.done(ctx, config.MethodNameGetIPNS, time.Since(start), nil)
+			}
+			found++
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// latencyWindow keeps the last latencies of a method.
+type latencyWindow struct {
+	samples [latencyWindowSize]time.Duration
+	n       int // samples added, in total
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.samples[w.n%latencyWindowSize] = d
+	w.n++
+}
+
+func (w *latencyWindow) percentile(p int) (time.Duration, bool) {
+	n := min(w.n, latencyWindowSize)
+	if n < minLatencySamples {
+		return 0, false
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := (n*p + 99) / 100
+	return sorted[max(i-1, 0)], true
+}
@@ -0,0 +1,364 @@
+package routing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/dual"
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multihash"
+)
+
+// Names the announcements sent over the DHT protocols are recorded under.
+const (
+	ProvideRouterDHT    = "dht"
+	ProvideRouterLANDHT = "dht-lan"
+)
+
+// ProvideTrackerSize is how many keys the announcements are remembered for.
+// The keys announced the longest ago are forgotten first.
+const ProvideTrackerSize = 100_000
+
+// maxTrackedMessageSize is the largest DHT message looked into for provider
+// records. The larger ones are skipped, provider records are much smaller.
+const maxTrackedMessageSize = 64 << 10
+
+var errNotAnnounced = errors.New("no router announced the key")
+
+var dhtProvideRouters = map[protocol.ID]string{
+	dht.ProtocolDHT: ProvideRouterDHT,
+	dht.DefaultPrefix + dual.LanExtension + "/kad/1.0.0": ProvideRouterLANDHT,
+}
+
+// ProvideStatus is what is known of the announcements of a key since the
+// node started.
+type ProvideStatus struct {
+	// LastProvide is when the last announcement of the key that reached at
+	// least one router finished, zero when none did.
+	LastProvide time.Time
+	// LastAttempt is when the node last started announcing the key.
+	LastAttempt time.Time
+	// Announcing is set while the key is being announced.
+	Announcing bool
+	// Error is why the last announcement failed, if it did.
+	Error string `json:",omitempty"`
+	// Routers are the routers the key was announced with, the last time
+	// each of them did.
+	Routers []RouterProvideStatus
+}
+
+// RouterProvideStatus is what is known of the announcements of a key by one
+// router.
+type RouterProvideStatus struct {
+	Router string
+	// Time is when the router last announced the key.
+	Time time.Time
+	// Peers is how many peers the provider record was sent to by the DHT in
+	// its last announcement of the key.
+	Peers int `json:",omitempty"`
+}
+
+type provideRecord struct {
+	attempt, last time.Time
+	pending       int
+	err           string
+	routers       map[string]*routerProvideRecord
+}
+
+type routerProvideRecord struct {
+	time  time.Time
+	peers int
+}
+
+// ProvideTracker records when the keys of the node were last announced, to
+// how many DHT peers, and by which routers.
+type ProvideTracker struct {
+	now func() time.Time
+
+	mu   sync.Mutex
+	keys *simplelru.LRU[string, *provideRecord]
+}
+
+// NewProvideTracker creates a tracker of the announcements of the keys.
+func NewProvideTracker() (*ProvideTracker, error) {
+	keys, err := simplelru.NewLRU[string, *provideRecord](ProvideTrackerSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ProvideTracker{
+		now:  time.Now,
+		keys: keys,
+	}, nil
+}
+
+// Status returns what is known of the announcements of key, and false when
+// it was not announced since the node started, or was forgotten.
+func (t *ProvideTracker) Status(key multihash.Multihash) (ProvideStatus, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec, ok := t.keys.Peek(string(key))
+	if !ok {
+		return ProvideStatus{}, false
+	}
+	status := ProvideStatus{
+		LastProvide: rec.last,
+		LastAttempt: rec.attempt,
+		Announcing:  rec.pending > 0,
+		Error:       rec.err,
+	}
+	for name, r := range rec.routers {
+		status.Routers = append(status.Routers, RouterProvideStatus{
+			Router: name,
+			Time:   r.time,
+			Peers:  r.peers,
+		})
+	}
+	sort.Slice(status.Routers, func(i, j int) bool {
+		return status.Routers[i].Router < status.Routers[j].Router
+	})
+	return status, true
+}
+
+// record returns the record of key, creating it if needed. Called with mu
+// held.
+func (t *ProvideTracker) record(key multihash.Multihash) *provideRecord {
+	rec, ok := t.keys.Get(string(key))
+	if !ok {
+		rec = &provideRecord{routers: make(map[string]*routerProvideRecord)}
+		t.keys.Add(string(key), rec)
+	}
+	return rec
+}
+
+// start records that the announcement of keys started.
+func (t *ProvideTracker) start(keys []multihash.Multihash) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		rec := t.record(k)
+		rec.attempt = now
+		rec.pending++
+	}
+}
+
+// done records that the announcement of keys finished with err. Those no
+// router announced since it started are failed too.
+func (t *ProvideTracker) done(keys []multihash.Multihash, err error) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		rec := t.record(k)
+		if rec.pending > 0 {
+			rec.pending--
+		}
+
+		announced := false
+		for _, r := range rec.routers {
+			if !r.time.Before(rec.attempt) {
+				announced = true
+				break
+			}
+		}
+		switch {
+		case err != nil:
+			rec.err = err.Error()
+		case !announced:
+			rec.err = errNotAnnounced.Error()
+		default:
+			rec.err = ""
+			rec.last = now
+		}
+	}
+}
+
+// announced records that router announced key, to a peer if toPeer is set.
+// The peers of the previous announcements of the router are forgotten when
+// it is the first time it does since the announcement of key started.
+func (t *ProvideTracker) announced(router string, key multihash.Multihash, toPeer bool) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec := t.record(key)
+	r, ok := rec.routers[router]
+	if !ok {
+		r = &routerProvideRecord{}
+		rec.routers[router] = r
+	}
+	if r.time.Before(rec.attempt) {
+		r.peers = 0
+	}
+	r.time = now
+	if toPeer {
+		r.peers++
+	}
+}
+
+// TrackProvides returns r with the announcements made through it recorded.
+func (t *ProvideTracker) TrackProvides(r ProvideManyRouter) ProvideManyRouter {
+	return &provideTrackingRouter{ProvideManyRouter: r, tracker: t}
+}
+
+type provideTrackingRouter struct {
+	ProvideManyRouter
+	tracker *ProvideTracker
+}
+
+func (r *provideTrackingRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	if !announce {
+		return r.ProvideManyRouter.Provide(ctx, c, announce)
+	}
+	keys := []multihash.Multihash{c.Hash()}
+	r.tracker.start(keys)
+	err := r.ProvideManyRouter.Provide(ctx, c, announce)
+	r.tracker.done(keys, err)
+	return err
+}
+
+func (r *provideTrackingRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	r.tracker.start(keys)
+	err := r.ProvideManyRouter.ProvideMany(ctx, keys)
+	r.tracker.done(keys, err)
+	return err
+}
+
+// announcingRouter records the keys a router without peers of its own, such
+// as an HTTP router, announced successfully under its name.
+type announcingRouter struct {
+	ProvideManyRouter
+	name    string
+	tracker *ProvideTracker
+}
+
+func (r *announcingRouter) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	err := r.ProvideManyRouter.Provide(ctx, c, announce)
+	if err == nil && announce {
+		r.tracker.announced(r.name, c.Hash(), false)
+	}
+	return err
+}
+
+func (r *announcingRouter) ProvideMany(ctx context.Context, keys []multihash.Multihash) error {
+	err := r.ProvideManyRouter.ProvideMany(ctx, keys)
+	if err == nil {
+		for _, k := range keys {
+			r.tracker.announced(r.name, k, false)
+		}
+	}
+	return err
+}
+
+// WrapHost returns h with the provider records it sends over the DHT
+// protocols recorded, along with the peers they are sent to.
+func (t *ProvideTracker) WrapHost(h host.Host) host.Host {
+	return &provideTrackingHost{Host: h, tracker: t}
+}
+
+type provideTrackingHost struct {
+	host.Host
+	tracker *ProvideTracker
+}
+
+func (h *provideTrackingHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (network.Stream, error) {
+	s, err := h.Host.NewStream(ctx, p, pids...)
+	if err != nil {
+		return nil, err
+	}
+	router, ok := dhtProvideRouters[s.Protocol()]
+	if !ok {
+		return s, nil
+	}
+	return &provideTrackingStream{Stream: s, router: router, tracker: h.tracker}, nil
+}
+
+// provideTrackingStream looks into the DHT messages written to it for
+// provider records. The messages are varint length prefixed, and can be
+// split over several writes.
+type provideTrackingStream struct {
+	network.Stream
+	router  string
+	tracker *ProvideTracker
+
+	buf  []byte
+	skip uint64 // bytes left of a message too large to look into
+}
+
+func (s *provideTrackingStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	if n > 0 {
+		s.consume(p[:n])
+	}
+	return n, err
+}
+
+func (s *provideTrackingStream) consume(p []byte) {
+	if s.skip > 0 {
+		if uint64(len(p)) <= s.skip {
+			s.skip -= uint64(len(p))
+			return
+		}
+		p = p[s.skip:]
+		s.skip = 0
+	}
+	s.buf = append(s.buf, p...)
+
+	for len(s.buf) > 0 {
+		size, n := binary.Uvarint(s.buf)
+		if n < 0 {
+			// not a DHT message stream, stop looking into it
+			s.buf = nil
+			s.skip = ^uint64(0)
+			return
+		}
+		if n == 0 {
+			break
+		}
+		if size > maxTrackedMessageSize {
+			rest := uint64(len(s.buf) - n)
+			if rest >= size {
+				s.buf = s.buf[n+int(size):]
+				continue
+			}
+			s.skip = size - rest
+			s.buf = s.buf[:0]
+			return
+		}
+		if len(s.buf) < n+int(size) {
+			break
+		}
+		s.message(s.buf[n : n+int(size)])
+		s.buf = s.buf[n+int(size):]
+	}
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+}
+
+func (s *provideTrackingStream) message(b []byte) {
+	var msg pb.Message
+	if err := msg.Unmarshal(b); err != nil {
+		return
+	}
+	if msg.GetType() != pb.Message_ADD_PROVIDER {
+		return
+	}
+	key, err := multihash.Cast(msg.GetKey())
+	if err != nil {
+		return
+	}
+	s.tracker.announced(s.router, key, true)
+}
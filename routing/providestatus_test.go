@@ -0,0 +1,108 @@
+package routing
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-kad-dht/pb"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func dhtMessage(t *testing.T, typ pb.Message_MessageType, key []byte, padding int) []byte {
+	msg := pb.Message{Type: typ, Key: key}
+	if padding > 0 {
+		msg.ProviderPeers = []pb.Message_Peer{{Addrs: [][]byte{make([]byte, padding)}}}
+	}
+	b, err := msg.Marshal()
+	require.NoError(t, err)
+	return append(binary.AppendUvarint(nil, uint64(len(b))), b...)
+}
+
+func TestProvideTrackerStream(t *testing.T) {
+	tracker, err := NewProvideTracker()
+	require.NoError(t, err)
+
+	key1, err := multihash.Sum([]byte("foo"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	key2, err := multihash.Sum([]byte("bar"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	s := &provideTrackingStream{router: ProvideRouterDHT, tracker: tracker}
+
+	var data []byte
+	data = append(data, dhtMessage(t, pb.Message_FIND_NODE, key2, 0)...)
+	data = append(data, dhtMessage(t, pb.Message_ADD_PROVIDER, key1, 0)...)
+	data = append(data, dhtMessage(t, pb.Message_ADD_PROVIDER, key1, maxTrackedMessageSize)...)
+	data = append(data, dhtMessage(t, pb.Message_ADD_PROVIDER, key1, 0)...)
+	// written in small pieces, split in the middle of the messages
+	for len(data) > 0 {
+		n := min(len(data), 7)
+		s.consume(data[:n])
+		data = data[n:]
+	}
+	require.Empty(t, s.buf)
+	require.Zero(t, s.skip)
+
+	_, ok := tracker.Status(key2)
+	require.False(t, ok)
+
+	status, ok := tracker.Status(key1)
+	require.True(t, ok)
+	require.Len(t, status.Routers, 1)
+	require.Equal(t, ProvideRouterDHT, status.Routers[0].Router)
+	require.Equal(t, 2, status.Routers[0].Peers)
+}
+
+func TestProvideTrackerRounds(t *testing.T) {
+	tracker, err := NewProvideTracker()
+	require.NoError(t, err)
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	key, err := multihash.Sum([]byte("foo"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	keys := []multihash.Multihash{key}
+
+	tracker.start(keys)
+	tracker.announced(ProvideRouterDHT, key, true)
+	tracker.announced(ProvideRouterDHT, key, true)
+	tracker.announced("cid.contact", key, false)
+	status, _ := tracker.Status(key)
+	require.True(t, status.Announcing)
+	require.True(t, status.LastProvide.IsZero())
+
+	tracker.done(keys, nil)
+	status, _ = tracker.Status(key)
+	require.False(t, status.Announcing)
+	require.Equal(t, now, status.LastProvide)
+	require.Equal(t, []RouterProvideStatus{
+		{Router: "cid.contact", Time: now},
+		{Router: ProvideRouterDHT, Time: now, Peers: 2},
+	}, status.Routers)
+
+	// the peers of a new announcement replace those of the previous one
+	now = now.Add(time.Hour)
+	tracker.start(keys)
+	tracker.announced(ProvideRouterDHT, key, true)
+	tracker.done(keys, nil)
+	status, _ = tracker.Status(key)
+	require.Equal(t, now, status.LastProvide)
+	require.Equal(t, 1, status.Routers[1].Peers)
+
+	// an announcement no router made fails
+	last := now
+	now = now.Add(time.Hour)
+	tracker.start(keys)
+	tracker.done(keys, nil)
+	status, _ = tracker.Status(key)
+	require.Equal(t, last, status.LastProvide)
+	require.Equal(t, errNotAnnounced.Error(), status.Error)
+
+	tracker.start(keys)
+	tracker.done(keys, errors.New("boom"))
+	status, _ = tracker.Status(key)
+	require.Equal(t, "boom", status.Error)
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/ipfs/kubo/test/cli/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitswapLedger(t *testing.T) {
+	t.Parallel()
+
+	t.Run("exposes per-peer metrics", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init().StartDaemons().Connect()
+		defer nodes.StopDaemons()
+		fetcher, server := nodes[0], nodes[1]
+
+		cid := server.IPFSAddStr(testutils.RandomStr(1000))
+		fetcher.IPFS("cat", cid)
+
+		metrics := fetcher.APIClient().Get("/debug/metrics/prometheus").Body
+		assert.Contains(t, metrics, fmt.Sprintf(`ipfs_bitswap_peer_received_bytes_total{peer="%s"}`, server.PeerID()))
+		assert.Contains(t, metrics, `ipfs_bitswap_peer_received_bytes_total{peer="other"} 0`)
+		assert.Contains(t, metrics, "ipfs_bitswap_session_fetch_duration_seconds_count")
+
+		metrics = server.APIClient().Get("/debug/metrics/prometheus").Body
+		assert.Contains(t, metrics, fmt.Sprintf(`ipfs_bitswap_peer_sent_bytes_total{peer="%s"}`, fetcher.PeerID()))
+	})
+
+	t.Run("ledger history is disabled by default", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init().StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.RunIPFS("bitswap", "ledger", "history")
+		assert.Error(t, res.Err)
+		assert.Contains(t, res.Stderr.String(), "Bitswap.LedgerHistory.Enabled")
+	})
+
+	t.Run("keeps the ledger history across restarts", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init()
+		fetcher, server := nodes[0], nodes[1]
+		fetcher.UpdateConfig(func(cfg *config.Config) {
+			cfg.Bitswap.LedgerHistory.Enabled = config.True
+		})
+		nodes.StartDaemons().Connect()
+		defer nodes.StopDaemons()
+
+		cid := server.IPFSAddStr(testutils.RandomStr(1000))
+		fetcher.IPFS("cat", cid)
+
+		history := func() []node.LedgerEntry {
+			var out struct{ Entries []node.LedgerEntry }
+			res := fetcher.IPFS("bitswap", "ledger", "history", "--enc=json", server.PeerID().String())
+			require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+			return out.Entries
+		}
+
+		entries := history()
+		require.Len(t, entries, 1)
+		assert.Equal(t, time.Now().UTC().Format("2006-01-02"), entries[0].Day)
+		assert.Equal(t, server.PeerID(), entries[0].Peer)
+		assert.EqualValues(t, 1, entries[0].BlocksReceived)
+		assert.Greater(t, entries[0].Received, uint64(1000))
+
+		fetcher.StopDaemon()
+		fetcher.StartDaemon()
+
+		assert.Equal(t, entries, history())
+		assert.Contains(t, fetcher.IPFS("bitswap", "ledger", "history").Stdout.String(), server.PeerID().String())
+	})
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/ipfs/kubo/test/cli/testutils"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitswapPrioritization(t *testing.T) {
+	t.Parallel()
+
+	t.Run("serves blocks with all the built-in policies", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(3).Init()
+		server, partner, stranger := nodes[0], nodes[1], nodes[2]
+		server.UpdateConfig(func(cfg *config.Config) {
+			cfg.Peering.Peers = []peer.AddrInfo{{ID: partner.PeerID()}}
+			cfg.Bitswap.Prioritization.Policies = []string{"peering", "peer-share", "ledger-ratio"}
+			cfg.Bitswap.Prioritization.MaxPeerShare = config.NewOptionalInteger(25)
+		})
+		nodes.StartDaemons().Connect()
+		defer nodes.StopDaemons()
+
+		data := testutils.RandomStr(3 * 256 * 1024)
+		cid := server.IPFSAddStr(data, "--chunker=size-262144")
+		assert.Equal(t, data, partner.IPFS("cat", cid).Stdout.String())
+		assert.Equal(t, data, stranger.IPFS("cat", cid).Stdout.String())
+	})
+
+	t.Run("fails to start with an unknown policy", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Bitswap.Prioritization.Policies = []string{"unknown"}
+		})
+
+		res := node.RunIPFS("daemon")
+		assert.Error(t, res.Err)
+		assert.Contains(t, res.Stderr.String(), `unknown bitswap prioritization policy "unknown"`)
+	})
+
+	t.Run("fails to start with an invalid share", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Bitswap.Prioritization.Policies = []string{"peer-share"}
+			cfg.Bitswap.Prioritization.MaxPeerShare = config.NewOptionalInteger(150)
+		})
+
+		res := node.RunIPFS("daemon")
+		assert.Error(t, res.Err)
+		assert.Contains(t, res.Stderr.String(), "MaxPeerShare percentage must be between 1 and 100")
+	})
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitswapServePolicies(t *testing.T) {
+	t.Parallel()
+
+	t.Run("restricted pins are only served to the allowed peers", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(3).Init()
+		server, partner, stranger := nodes[0], nodes[1], nodes[2]
+		server.UpdateConfig(func(cfg *config.Config) {
+			cfg.Bitswap.ServePolicies = []config.BitswapServePolicy{{
+				PinNames:     []string{"partner-*"},
+				AllowedPeers: []peer.ID{partner.PeerID()},
+			}}
+		})
+		nodes.StartDaemons().Connect()
+		defer nodes.StopDaemons()
+
+		private := server.IPFSAddStr("private content", "--pin=false")
+		server.IPFS("pin", "add", "--name=partner-data", private)
+		public := server.IPFSAddStr("public content")
+
+		// before the partner fetches it, as it would serve it too
+		res := stranger.RunIPFS("cat", "--timeout=2s", private)
+		assert.NotEqual(t, 0, res.ExitCode())
+		assert.Equal(t, "public content", stranger.IPFS("cat", public).Stdout.String())
+		assert.Equal(t, "private content", partner.IPFS("cat", private).Stdout.String())
+
+		// the policy follows the pins
+		server.IPFS("pin", "rm", private)
+		server.IPFS("pin", "add", "--name=other", private)
+		partner.IPFS("repo", "gc")
+		assert.Equal(t, "private content", stranger.IPFS("cat", "--timeout=10s", private).Stdout.String())
+	})
+
+	t.Run("updated pins are restricted right away", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init()
+		server, stranger := nodes[0], nodes[1]
+		server.UpdateConfig(func(cfg *config.Config) {
+			cfg.Bitswap.ServePolicies = []config.BitswapServePolicy{{PinNames: []string{"partner-*"}}}
+		})
+		nodes.StartDaemons().Connect()
+		defer nodes.StopDaemons()
+
+		v1 := server.IPFSAddStr("private content v1", "--pin=false")
+		v2 := server.IPFSAddStr("private content v2", "--pin=false")
+		server.IPFS("pin", "add", "--name=partner-data", v1)
+		server.IPFS("pin", "update", v1, v2)
+
+		res := stranger.RunIPFS("cat", "--timeout=2s", v2)
+		assert.NotEqual(t, 0, res.ExitCode())
+
+		// the old version is released with the old pin
+		assert.Equal(t, "private content v1", stranger.IPFS("cat", "--timeout=10s", v1).Stdout.String())
+	})
+
+	t.Run("daemon refuses an invalid pin name pattern", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Bitswap.ServePolicies = []config.BitswapServePolicy{{PinNames: []string{"["}}}
+		})
+		res := node.RunIPFS("daemon")
+		assert.NotEqual(t, 0, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "invalid pin name pattern")
+	})
+}
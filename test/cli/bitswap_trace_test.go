@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/core/node"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/ipfs/kubo/test/cli/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBitswapWantlistTrace(t *testing.T) {
+	t.Parallel()
+
+	t.Run("traces the fetch of a DAG", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init().StartDaemons().Connect()
+		defer nodes.StopDaemons()
+		fetcher, server := nodes[0], nodes[1]
+
+		// 3 chunks of 256KiB and the root
+		root := server.IPFSAddStr(testutils.RandomStr(3*256*1024), "--chunker=size-262144")
+
+		trace := fetcher.Runner.Run(harness.RunRequest{
+			Path:    fetcher.IPFSBin,
+			Args:    []string{"bitswap", "wantlist", "--trace", "--enc=json", root},
+			RunFunc: (*exec.Cmd).Start,
+		})
+		defer func() {
+			_ = trace.Cmd.Process.Kill()
+		}()
+		require.Eventually(t, func() bool {
+			return strings.Contains(fetcher.IPFS("diag", "cmds").Stdout.String(), "bitswap/wantlist")
+		}, 10*time.Second, 100*time.Millisecond)
+
+		fetcher.IPFS("cat", root)
+
+		var events []node.BitswapTraceEvent
+		received := map[string]node.BitswapTraceEvent{}
+		assert.Eventually(t, func() bool {
+			events = nil
+			dec := json.NewDecoder(bytes.NewReader(trace.Stdout.Bytes()))
+			for {
+				var ev node.BitswapTraceEvent
+				if err := dec.Decode(&ev); err != nil {
+					break
+				}
+				events = append(events, ev)
+				if ev.Type == node.BitswapTraceBlockReceived && !ev.Duplicate {
+					received[ev.Cid] = ev
+				}
+			}
+			return len(received) == 4
+		}, 10*time.Second, 100*time.Millisecond)
+
+		require.Contains(t, received, root)
+		for _, ev := range received {
+			assert.Equal(t, server.PeerID(), ev.Peer)
+			assert.Positive(t, ev.Size)
+		}
+		assert.Positive(t, received[root].Latency)
+
+		var wantSent bool
+		for _, ev := range events {
+			if ev.Type == node.BitswapTraceWantSent && ev.Cid == root {
+				wantSent = true
+			}
+		}
+		assert.True(t, wantSent)
+	})
+
+	t.Run("CIDs require --trace", func(t *testing.T) {
+		t.Parallel()
+		n := harness.NewT(t).NewNode().Init().StartDaemon()
+		defer n.StopDaemon()
+
+		res := n.RunIPFS("bitswap", "wantlist", "bafkqaaa")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "--trace")
+	})
+}
@@ -1,14 +1,22 @@
 package cli
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"os"
 	"testing"
 
+	"github.com/ipfs/boxo/ipld/merkledag"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/kubo/test/cli/harness"
 	"github.com/ipfs/kubo/test/cli/testutils"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const (
@@ -102,3 +110,69 @@ func TestDag(t *testing.T) {
 		assert.Equal(t, content, stat.Stdout.Bytes())
 	})
 }
+
+// writeCar returns a CARv1 with the given roots and blocks.
+func writeCar(t *testing.T, roots []cid.Cid, blks ...blocks.Block) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	car, err := storage.NewWritable(&buf, roots, carv2.WriteAsCarV1(true))
+	require.NoError(t, err)
+	for _, blk := range blks {
+		require.NoError(t, car.Put(context.Background(), blk.Cid().KeyString(), blk.RawData()))
+	}
+	require.NoError(t, car.Finalize())
+	return &buf
+}
+
+func TestDagImport(t *testing.T) {
+	t.Parallel()
+
+	leaf := merkledag.NewRawNode([]byte("leaf"))
+	root := new(merkledag.ProtoNode)
+	require.NoError(t, root.AddNodeLink("leaf", leaf))
+	extra := merkledag.NewRawNode([]byte("unreachable"))
+
+	t.Run("ipfs dag import --pin-name", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.PipeToIPFS(writeCar(t, []cid.Cid{root.Cid()}, root, leaf), "dag", "import", "--pin-name=imported")
+		res := node.IPFS("pin", "ls", "--names", "--type=recursive")
+		assert.Contains(t, res.Stdout.Lines(), root.Cid().String()+" recursive imported")
+	})
+
+	t.Run("ipfs dag import --pin-name requires --pin-roots", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		res := node.RunPipeToIPFS(writeCar(t, []cid.Cid{root.Cid()}, root, leaf), "dag", "import", "--pin-roots=false", "--pin-name=imported")
+		assert.Error(t, res.Err)
+		assert.Contains(t, res.Stderr.String(), "--pin-name requires --pin-roots")
+	})
+
+	t.Run("ipfs dag import --atomic", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+
+		// the leaf is missing, so the root cannot be pinned
+		res := node.RunPipeToIPFS(writeCar(t, []cid.Cid{root.Cid()}, root), "dag", "import", "--atomic")
+		assert.Error(t, res.Err)
+		assert.Contains(t, res.Stderr.String(), "is incomplete")
+		assert.Error(t, node.RunIPFS("block", "stat", "--offline", root.Cid().String()).Err)
+
+		node.PipeToIPFS(writeCar(t, []cid.Cid{root.Cid()}, root, leaf), "dag", "import", "--atomic")
+		node.IPFS("block", "stat", "--offline", leaf.Cid().String())
+		node.IPFS("pin", "ls", "--type=recursive", root.Cid().String())
+	})
+
+	t.Run("ipfs dag import --reject-unreachable", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+
+		res := node.RunPipeToIPFS(writeCar(t, []cid.Cid{root.Cid()}, root, leaf, extra), "dag", "import", "--reject-unreachable")
+		assert.Error(t, res.Err)
+		assert.Contains(t, res.Stderr.String(), "is not reachable from the roots")
+		assert.Error(t, node.RunIPFS("block", "stat", "--offline", root.Cid().String()).Err)
+
+		node.PipeToIPFS(writeCar(t, []cid.Cid{root.Cid()}, root, leaf), "dag", "import", "--reject-unreachable")
+		node.IPFS("block", "stat", "--offline", root.Cid().String())
+	})
+}
@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/ipfs/kubo/test/cli/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPRetrieval(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fetches a DAG from a trustless gateway", func(t *testing.T) {
+		t.Parallel()
+		h := harness.NewT(t)
+		server := h.NewNode().Init().StartDaemon()
+		defer server.StopDaemon()
+		data := testutils.RandomStr(3 * 256 * 1024)
+		cid := server.IPFSAddStr(data, "--chunker=size-262144")
+
+		gatewayURL, err := url.Parse(server.GatewayURL())
+		require.NoError(t, err)
+		var mu sync.Mutex
+		formats := map[string]int{}
+		proxy := httputil.NewSingleHostReverseProxy(gatewayURL)
+		gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			formats[r.URL.Query().Get("format")]++
+			mu.Unlock()
+			proxy.ServeHTTP(w, r)
+		}))
+		defer gateway.Close()
+
+		// The fetcher is not connected to the server, and cannot find it
+		// with the routing system: the blocks can only come over HTTP.
+		fetcher := h.NewNode().Init()
+		fetcher.UpdateConfig(func(cfg *config.Config) {
+			cfg.Routing.Type = config.NewOptionalString("none")
+			cfg.HTTPRetrieval.Enabled = config.True
+			cfg.HTTPRetrieval.Gateways = []string{gateway.URL}
+		})
+		fetcher.StartDaemon()
+		defer fetcher.StopDaemon()
+
+		assert.Equal(t, data, fetcher.IPFS("cat", "--timeout=30s", cid).Stdout.String())
+		assert.Empty(t, fetcher.IPFS("swarm", "peers").Stdout.String())
+
+		// The DAG of the session was fetched as a CAR.
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, 1, formats["car"])
+		assert.Equal(t, 0, formats["raw"])
+	})
+
+	t.Run("rejects blocks not matching their CID", func(t *testing.T) {
+		t.Parallel()
+		gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.ipld.raw")
+			_, _ = w.Write([]byte("not the block you are looking for"))
+		}))
+		defer gateway.Close()
+
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Routing.Type = config.NewOptionalString("none")
+			cfg.HTTPRetrieval.Enabled = config.True
+			cfg.HTTPRetrieval.Gateways = []string{gateway.URL}
+		})
+		node.StartDaemon()
+		defer node.StopDaemon()
+
+		cid := "bafkreie7ohywtosou76tasm7j63yigtzxe7d5zqus4zu3j6oltvgtibeom"
+		res := node.RunIPFS("block", "get", "--timeout=5s", cid)
+		assert.Error(t, res.Err)
+		res = node.RunIPFS("block", "stat", "--offline", cid)
+		assert.Error(t, res.Err)
+	})
+
+	t.Run("daemon refuses an invalid gateway URL", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.HTTPRetrieval.Enabled = config.True
+			cfg.HTTPRetrieval.Gateways = []string{"example.com"}
+		})
+		res := node.RunIPFS("daemon")
+		assert.NotEqual(t, 0, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), `invalid trustless gateway URL "example.com"`)
+	})
+}
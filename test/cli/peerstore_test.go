@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistentPeerstore(t *testing.T) {
+	t.Parallel()
+
+	nodes := harness.NewT(t).NewNodes(2).Init()
+	node, other := nodes[0], nodes[1]
+	node.UpdateConfig(func(cfg *config.Config) {
+		cfg.Swarm.Peerstore.Persist = config.True
+	})
+	nodes.StartDaemons()
+	defer nodes.StopDaemons()
+
+	node.Connect(other)
+	other.StopDaemon()
+	node.StopDaemon()
+
+	node.StartDaemon()
+	res := node.IPFS("swarm", "addrs")
+	assert.Contains(t, res.Stdout.String(), other.PeerID().String())
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bytes"
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -50,6 +51,7 @@ func TestProvider(t *testing.T) {
 
 		nodes := initNodes(t, 2, func(n *harness.Node) {
 			n.SetIPFSConfig("Experimental.StrategicProviding", true)
+			n.SetIPFSConfig("Routing.TrackProvides", true)
 		})
 		defer nodes.StopDaemons()
 
@@ -146,6 +148,67 @@ func TestProvider(t *testing.T) {
 		expectProviders(t, cidBarDir, nodes[0].PeerID().String(), nodes[1:]...)
 	})
 
+	t.Run("Reprovides with 'mfs' strategy", func(t *testing.T) {
+		t.Parallel()
+
+		foo := testutils.RandomBytes(1000)
+		bar := testutils.RandomBytes(1000)
+
+		nodes := initNodes(t, 2, func(n *harness.Node) {
+			n.SetIPFSConfig("Reprovider.Strategy", "mfs")
+		})
+		defer nodes.StopDaemons()
+
+		cidFoo := nodes[0].IPFSAdd(bytes.NewReader(foo), "--offline")
+		cidBar := nodes[0].IPFSAdd(bytes.NewReader(bar), "--offline", "--pin=false")
+		nodes[0].IPFS("files", "mkdir", "/dir")
+		nodes[0].IPFS("files", "cp", "/ipfs/"+cidBar, "/dir/bar")
+		cidDir := nodes[0].IPFS("files", "stat", "--hash", "/dir").Stdout.Trimmed()
+
+		expectNoProviders(t, cidBar, nodes[1:]...)
+
+		nodes[0].IPFS("bitswap", "reprovide")
+
+		expectNoProviders(t, cidFoo, nodes[1:]...)
+		expectProviders(t, cidBar, nodes[0].PeerID().String(), nodes[1:]...)
+		expectProviders(t, cidDir, nodes[0].PeerID().String(), nodes[1:]...)
+	})
+
+	t.Run("Reprovides with 'pinned+mfs' strategy", func(t *testing.T) {
+		t.Parallel()
+
+		foo := testutils.RandomBytes(1000)
+		bar := testutils.RandomBytes(1000)
+		baz := testutils.RandomBytes(1000)
+
+		nodes := initNodes(t, 2, func(n *harness.Node) {
+			n.SetIPFSConfig("Reprovider.Strategy", "pinned+mfs")
+		})
+		defer nodes.StopDaemons()
+
+		cidFoo := nodes[0].IPFSAdd(bytes.NewReader(foo), "--offline", "--pin=false")
+		cidBar := nodes[0].IPFSAdd(bytes.NewReader(bar), "--offline", "--pin=false")
+		cidBaz := nodes[0].IPFSAdd(bytes.NewReader(baz), "--offline")
+		nodes[0].IPFS("files", "cp", "/ipfs/"+cidBar, "/bar")
+
+		nodes[0].IPFS("bitswap", "reprovide")
+
+		expectNoProviders(t, cidFoo, nodes[1:]...)
+		expectProviders(t, cidBar, nodes[0].PeerID().String(), nodes[1:]...)
+		expectProviders(t, cidBaz, nodes[0].PeerID().String(), nodes[1:]...)
+	})
+
+	t.Run("Refuses to combine the 'all' strategy", func(t *testing.T) {
+		t.Parallel()
+
+		node := harness.NewT(t).NewNode().Init()
+		node.SetIPFSConfig("Reprovider.Strategy", "all+mfs")
+
+		res := node.RunIPFS("daemon")
+		require.Error(t, res.Err)
+		require.Contains(t, res.Stderr.String(), `reprovider strategy "all" cannot be combined with other strategies`)
+	})
+
 	t.Run("Providing works without ticking", func(t *testing.T) {
 		t.Parallel()
 
@@ -162,4 +225,62 @@ func TestProvider(t *testing.T) {
 
 		expectProviders(t, cid, nodes[0].PeerID().String(), nodes[1:]...)
 	})
+
+	t.Run("Reports the provide status of keys", func(t *testing.T) {
+		t.Parallel()
+
+		nodes := initNodes(t, 2, func(n *harness.Node) {
+			n.SetIPFSConfig("Experimental.StrategicProviding", true)
+			n.SetIPFSConfig("Routing.TrackProvides", true)
+		})
+		defer nodes.StopDaemons()
+
+		cid := nodes[0].IPFSAddStr(time.Now().String())
+
+		var status struct {
+			Key         string
+			LastProvide time.Time
+			Error       string
+			Routers     []struct {
+				Router string
+				Time   time.Time
+				Peers  int
+			}
+		}
+		res := nodes[0].IPFS("routing", "provide", "--status", "--enc=json", cid)
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &status))
+		require.Equal(t, cid, status.Key)
+		require.True(t, status.LastProvide.IsZero())
+		require.Empty(t, status.Routers)
+		require.Contains(t, nodes[0].IPFS("routing", "provide", "--status", cid).Stdout.String(), "Last provide: never")
+
+		start := time.Now()
+		nodes[0].IPFS("routing", "provide", cid)
+
+		res = nodes[0].IPFS("routing", "provide", "--status", "--enc=json", cid)
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &status))
+		require.Empty(t, status.Error)
+		require.WithinRange(t, status.LastProvide, start, time.Now())
+		require.NotEmpty(t, status.Routers)
+		peers := 0
+		for _, r := range status.Routers {
+			require.Contains(t, []string{"dht", "dht-lan"}, r.Router)
+			peers += r.Peers
+		}
+		require.GreaterOrEqual(t, peers, 1)
+
+		expectProviders(t, cid, nodes[0].PeerID().String(), nodes[1:]...)
+	})
+
+	t.Run("Refuses to report the provide status when not tracked", func(t *testing.T) {
+		t.Parallel()
+
+		node := harness.NewT(t).NewNode().Init().StartDaemon()
+		defer node.StopDaemon()
+
+		cid := node.IPFSAddStr(time.Now().String())
+		res := node.RunIPFS("routing", "provide", "--status", cid)
+		require.Equal(t, 1, res.ExitCode())
+		require.Contains(t, res.Stderr.String(), "Routing.TrackProvides")
+	})
 }
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ipfs/kubo/test/cli/harness"
+	. "github.com/ipfs/kubo/test/cli/testutils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingCache(t *testing.T) {
+	t.Parallel()
+
+	findProvsCID := "baeabep4vu3ceru7nerjjbk37sxb7wmftteve4hcosmyolsbsiubw2vr6pqzj6mw7kv6tbn6nqkkldnklbjgm5tzbi4hkpkled4xlcr7xz4bq"
+	prov := "12D3KooWAobjw92XDcnQ1rRmRJDA3zAQpdPYUpZKrJxH6yccSpje"
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/routing/v1/providers/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(ToJSONStr(JSONObj{
+			"Providers": []JSONObj{{
+				"Schema":    "peer",
+				"Protocols": []string{"transport-bitswap"},
+				"ID":        prov,
+				"Addrs":     []string{"/ip4/0.0.0.0/tcp/4001"},
+			}},
+		})))
+	}))
+	t.Cleanup(server.Close)
+
+	node := harness.NewT(t).NewNode().Init()
+	node.IPFS("config", "Routing.Type", "custom")
+	node.IPFS("config", "Routing.Routers", "--json", ToJSONStr(JSONObj{
+		"HTTP": JSONObj{
+			"Type":       "http",
+			"Parameters": JSONObj{"Endpoint": server.URL},
+		},
+		"Cache": JSONObj{
+			"Type":       "cache",
+			"Parameters": JSONObj{"RouterName": "HTTP", "TTL": "1h"},
+		},
+	}))
+	node.IPFS("config", "Routing.Methods", "--json", ToJSONStr(JSONObj{
+		"find-peers":     JSONObj{"RouterName": "Cache"},
+		"find-providers": JSONObj{"RouterName": "Cache"},
+		"get-ipns":       JSONObj{"RouterName": "Cache"},
+		"provide":        JSONObj{"RouterName": "HTTP"},
+		"put-ipns":       JSONObj{"RouterName": "HTTP"},
+	}))
+
+	node.StartDaemon()
+	for i := 0; i < 2; i++ {
+		res := node.IPFS("routing", "findprovs", findProvsCID)
+		assert.Equal(t, prov, res.Stdout.Trimmed())
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	metrics := node.APIClient().Get("/debug/metrics/prometheus").Body
+	assert.Contains(t, metrics, `ipfs_routing_cache_hits_total{method="find-providers",router="Cache"} 1`)
+	assert.Contains(t, metrics, `ipfs_routing_cache_misses_total{method="find-providers",router="Cache"} 1`)
+	node.StopDaemon()
+
+	// the cache is kept in the repo across restarts
+	node.StartDaemon()
+	defer node.StopDaemon()
+	res := node.IPFS("routing", "findprovs", findProvsCID)
+	assert.Equal(t, prov, res.Stdout.Trimmed())
+	assert.Equal(t, int32(1), requests.Load())
+}
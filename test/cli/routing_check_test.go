@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type routingCheckOutput struct {
+	Peer    string
+	Verdict string
+	Reason  string
+	Addrs   []struct {
+		Addr    string
+		Success bool
+	}
+}
+
+func routingCheck(t *testing.T, node *harness.Node, cid string) map[string]routingCheckOutput {
+	res := node.IPFS("routing", "check", "--enc=json", cid)
+	outs := map[string]routingCheckOutput{}
+	dec := json.NewDecoder(bytes.NewReader(res.Stdout.Bytes()))
+	for {
+		var out routingCheckOutput
+		err := dec.Decode(&out)
+		if errors.Is(err, io.EOF) {
+			return outs
+		}
+		require.NoError(t, err)
+		outs[out.Peer] = out
+	}
+}
+
+func TestRoutingCheck(t *testing.T) {
+	t.Parallel()
+
+	nodes := harness.NewT(t).NewNodes(3).Init().StartDaemons().Connect()
+	defer nodes.StopDaemons()
+	provider, other, checker := nodes[0], nodes[1], nodes[2]
+
+	cid := provider.IPFSAddStr(time.Now().String())
+	// other announces the block too, but drops it
+	other.IPFS("block", "get", cid)
+	other.IPFS("routing", "provide", cid)
+	other.IPFS("block", "rm", cid)
+
+	outs := routingCheck(t, checker, cid)
+
+	out, ok := outs[provider.PeerID().String()]
+	require.True(t, ok, "provider not checked: %v", outs)
+	assert.Equal(t, "ok", out.Verdict, out.Reason)
+	require.NotEmpty(t, out.Addrs)
+	for _, a := range out.Addrs {
+		assert.True(t, a.Success, a.Addr)
+	}
+
+	out, ok = outs[other.PeerID().String()]
+	require.True(t, ok, "other provider not checked: %v", outs)
+	assert.Equal(t, "dont-have", out.Verdict, out.Reason)
+
+	text := checker.IPFS("routing", "check", cid).Stdout.String()
+	assert.Contains(t, text, provider.PeerID().String()+": ok (answered in ")
+
+	res := checker.RunIPFS("routing", "check", "bafkqaaa")
+	assert.Error(t, res.Err)
+	assert.Contains(t, res.Stderr.String(), "no provider records found")
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/kubo/test/cli/harness"
+	. "github.com/ipfs/kubo/test/cli/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoutingStats(t *testing.T) {
+	t.Parallel()
+
+	findProvsCID := "baeabep4vu3ceru7nerjjbk37sxb7wmftteve4hcosmyolsbsiubw2vr6pqzj6mw7kv6tbn6nqkkldnklbjgm5tzbi4hkpkled4xlcr7xz4bq"
+	prov := "12D3KooWAobjw92XDcnQ1rRmRJDA3zAQpdPYUpZKrJxH6yccSpje"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/routing/v1/providers/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(ToJSONStr(JSONObj{
+			"Providers": []JSONObj{{
+				"Schema":    "peer",
+				"Protocols": []string{"transport-bitswap"},
+				"ID":        prov,
+				"Addrs":     []string{"/ip4/0.0.0.0/tcp/4001"},
+			}},
+		})))
+	}))
+	t.Cleanup(server.Close)
+
+	node := harness.NewT(t).NewNode().Init()
+	node.IPFS("config", "Routing.Type", "custom")
+	node.IPFS("config", "Routing.Routers", "--json", ToJSONStr(JSONObj{
+		"Primary": JSONObj{
+			"Type":       "http",
+			"Parameters": JSONObj{"Endpoint": server.URL},
+		},
+		"Secondary": JSONObj{
+			"Type":       "http",
+			"Parameters": JSONObj{"Endpoint": server.URL},
+		},
+		"Hedged": JSONObj{
+			"Type": "parallel",
+			"Parameters": JSONObj{
+				"Routers": []JSONObj{
+					{"RouterName": "Primary", "Timeout": "10s"},
+					{"RouterName": "Secondary", "Timeout": "10s"},
+				},
+				"HedgePercentile": 90,
+			},
+		},
+	}))
+	node.IPFS("config", "Routing.Methods", "--json", ToJSONStr(JSONObj{
+		"find-peers":     JSONObj{"RouterName": "Hedged"},
+		"find-providers": JSONObj{"RouterName": "Hedged"},
+		"get-ipns":       JSONObj{"RouterName": "Hedged"},
+		"provide":        JSONObj{"RouterName": "Hedged"},
+		"put-ipns":       JSONObj{"RouterName": "Hedged"},
+	}))
+
+	res := node.RunIPFS("stats", "routing")
+	assert.Error(t, res.Err)
+
+	node.StartDaemon()
+	defer node.StopDaemon()
+
+	// the latencies of the primary router are not known yet, both are asked
+	res = node.IPFS("routing", "findprovs", findProvsCID)
+	assert.Equal(t, prov, res.Stdout.Trimmed())
+
+	var out struct {
+		Routers []struct {
+			Router   string
+			Method   string
+			Requests uint64
+			Errors   uint64
+			Results  uint64
+			Hedged   uint64
+		}
+	}
+	res = node.IPFS("stats", "routing", "--enc=json")
+	require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+	stats := map[string]uint64{}
+	for _, s := range out.Routers {
+		if s.Method == "find-providers" {
+			stats[s.Router+"/requests"] = s.Requests
+			stats[s.Router+"/errors"] = s.Errors
+			stats[s.Router+"/hedged"] = s.Hedged
+		}
+	}
+	assert.Equal(t, uint64(1), stats["Primary/requests"], out)
+	assert.Equal(t, uint64(0), stats["Primary/errors"], out)
+	assert.Equal(t, uint64(1), stats["Hedged/requests"], out)
+	assert.Equal(t, uint64(1), stats["Hedged/hedged"], out)
+
+	text := node.IPFS("stats", "routing").Stdout.String()
+	assert.Contains(t, text, "ROUTER")
+	assert.Contains(t, text, "AVG LATENCY")
+
+	metrics := node.APIClient().Get("/debug/metrics/prometheus").Body
+	assert.Contains(t, metrics, `ipfs_routing_request_duration_seconds_count{method="find-providers",router="Primary"} 1`)
+	assert.Contains(t, metrics, `ipfs_routing_results_total{method="find-providers",router="Primary"} 1`)
+	assert.Contains(t, metrics, `ipfs_routing_hedged_requests_total{method="find-providers",router="Hedged"} 1`)
+}
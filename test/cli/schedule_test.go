@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchedules(t *testing.T) {
+	t.Parallel()
+
+	t.Run("stats schedule fails without schedules", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init().StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.RunIPFS("stats", "schedule")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "no schedule configured")
+	})
+
+	t.Run("stats schedule shows the active schedule", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Schedules = []config.Schedule{
+				{
+					Name: "never",
+					Cron: "0 0 31 2 *",
+				},
+				{
+					Name:            "always",
+					Cron:            "* * * * *",
+					BandwidthLimits: &config.BandwidthLimits{RateOut: config.NewOptionalString("1MB")},
+					ConnMgr:         &config.ScheduleConnMgr{HighWater: config.NewOptionalInteger(50)},
+					Reprovide:       config.False,
+					BitswapServer:   config.False,
+				},
+			}
+		})
+		node.StartDaemon()
+		defer node.StopDaemon()
+
+		out := node.IPFS("stats", "schedule").Stdout.String()
+		assert.Regexp(t, `Active:\s+always`, out)
+		assert.Regexp(t, `RateOut:\s+1MB`, out)
+		assert.Regexp(t, `ConnMgr.HighWater:\s+50`, out)
+		assert.Regexp(t, `Reprovide:\s+paused`, out)
+		assert.Regexp(t, `BitswapServer:\s+disabled`, out)
+		assert.NotContains(t, out, "NextChange")
+	})
+
+	t.Run("daemon refuses an invalid schedule", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Schedules = []config.Schedule{{Cron: "* * *"}}
+		})
+
+		res := node.RunIPFS("daemon")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "Schedules[0]")
+	})
+}
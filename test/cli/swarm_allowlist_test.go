@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmPeerAllowList(t *testing.T) {
+	t.Parallel()
+
+	t.Run("only allowed peers can connect", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(3).Init()
+		node, allowed, other := nodes[0], nodes[1], nodes[2]
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.PeerAllowList.Enabled = config.True
+			cfg.Swarm.PeerAllowList.Peers = []peer.ID{allowed.PeerID()}
+		})
+		nodes.StartDaemons()
+		defer nodes.StopDaemons()
+
+		node.Connect(allowed)
+		res := node.RunIPFS("swarm", "connect", other.SwarmAddrsWithPeerIDs()[0].String())
+		assert.Equal(t, 1, res.ExitCode())
+		other.RunIPFS("swarm", "connect", node.SwarmAddrsWithPeerIDs()[0].String())
+		assert.Len(t, node.Peers(), 1)
+	})
+
+	t.Run("allow-list file is reloaded", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init()
+		node, other := nodes[0], nodes[1]
+		file := filepath.Join(node.Dir, "allowed-peers")
+		require.NoError(t, os.WriteFile(file, nil, 0o600))
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.PeerAllowList.Enabled = config.True
+			cfg.Swarm.PeerAllowList.File = config.NewOptionalString("allowed-peers")
+		})
+		nodes.StartDaemons()
+		defer nodes.StopDaemons()
+
+		res := node.RunIPFS("swarm", "connect", other.SwarmAddrsWithPeerIDs()[0].String())
+		assert.Equal(t, 1, res.ExitCode())
+
+		require.NoError(t, os.WriteFile(file, []byte(other.PeerID().String()+"\n"), 0o600))
+		require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Minute)))
+		assert.Eventually(t, func() bool {
+			return node.RunIPFS("swarm", "connect", other.SwarmAddrsWithPeerIDs()[0].String()).ExitCode() == 0
+		}, 20*time.Second, time.Second)
+	})
+
+	t.Run("DHT-only peers cannot use bitswap", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init()
+		node, other := nodes[0], nodes[1]
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.PeerAllowList.Enabled = config.True
+			cfg.Swarm.PeerAllowList.AllowDHT = config.True
+		})
+		nodes.StartDaemons()
+		defer nodes.StopDaemons()
+
+		cid := other.IPFSAddStr("allow-list test")
+		node.Connect(other)
+		assert.Len(t, node.Peers(), 1)
+
+		res := node.RunIPFS("block", "get", "--timeout=3s", cid)
+		assert.Equal(t, 1, res.ExitCode())
+	})
+}
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmBan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ban closes connections and refuses new ones until unbanned", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init().StartDaemons()
+		defer nodes.StopDaemons()
+		node, other := nodes[0], nodes[1]
+		otherID := other.PeerID().String()
+
+		node.Connect(other)
+		require.Len(t, node.Peers(), 1)
+
+		res := node.IPFS("swarm", "ban", otherID)
+		assert.Equal(t, "banned "+otherID, res.Stdout.Trimmed())
+		assert.Empty(t, node.Peers())
+
+		res = node.RunIPFS("swarm", "connect", other.SwarmAddrsWithPeerIDs()[0].String())
+		assert.Equal(t, 1, res.ExitCode())
+		// the dialer may complete its side of the handshake before the
+		// connection is refused
+		other.RunIPFS("swarm", "connect", node.SwarmAddrsWithPeerIDs()[0].String())
+		assert.Empty(t, node.Peers())
+
+		assert.Regexp(t, `^`+otherID+`\s+never$`, node.IPFS("swarm", "bans").Stdout.Trimmed())
+
+		node.IPFS("swarm", "unban", otherID)
+		assert.Empty(t, node.IPFS("swarm", "bans").Stdout.Trimmed())
+		node.Connect(other)
+		assert.Len(t, node.Peers(), 1)
+	})
+
+	t.Run("bans survive restarts and expire", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init().StartDaemon()
+		defer node.StopDaemon()
+
+		node.IPFS("swarm", "ban", "/ip4/192.0.2.1")
+		res := node.IPFS("swarm", "ban", "--ttl=1h", "12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5")
+		assert.Contains(t, res.Stdout.String(), " until ")
+
+		node.StopDaemon()
+		node.StartDaemon()
+		lines := node.IPFS("swarm", "bans").Stdout.Lines()
+		require.Len(t, lines, 2)
+		assert.Regexp(t, `^/ip4/192.0.2.1\s+never$`, lines[0])
+		assert.Regexp(t, `^12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5\s+\d{4}-`, lines[1])
+
+		res = node.RunIPFS("swarm", "unban", "/ip4/192.0.2.2")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "is not banned")
+
+		res = node.RunIPFS("swarm", "ban", node.PeerID().String())
+		assert.Equal(t, 1, res.ExitCode())
+	})
+}
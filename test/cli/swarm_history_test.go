@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type swarmHistoryOutput struct {
+	Connections []libp2p.ConnRecord
+	Peers       []libp2p.PeerConnStats
+}
+
+func TestSwarmHistory(t *testing.T) {
+	t.Parallel()
+
+	t.Run("records closed connections across restarts", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(2).Init()
+		node, other := nodes[0], nodes[1]
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.ConnectionHistory.Enabled = config.True
+		})
+		nodes.StartDaemons()
+		defer nodes.StopDaemons()
+
+		node.Connect(other)
+		node.IPFS("swarm", "disconnect", "/p2p/"+other.PeerID().String())
+		node.Connect(other)
+		node.StopDaemon()
+		node.StartDaemon()
+
+		var out swarmHistoryOutput
+		res := node.IPFS("swarm", "history", "--enc=json")
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+		require.Len(t, out.Connections, 2)
+		assert.Equal(t, libp2p.CloseReasonShutdown, out.Connections[0].Reason)
+		assert.Equal(t, libp2p.CloseReasonClosed, out.Connections[1].Reason)
+		for _, r := range out.Connections {
+			assert.Equal(t, other.PeerID(), r.Peer)
+			assert.Equal(t, "outbound", r.Direction)
+			assert.Contains(t, r.AgentVersion, "kubo")
+		}
+
+		res = node.IPFS("swarm", "history", "--enc=json", "--stats")
+		out = swarmHistoryOutput{}
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+		require.Len(t, out.Peers, 1)
+		assert.Equal(t, 2, out.Peers[0].Connections)
+
+		res = node.IPFS("swarm", "history", "--direction=inbound")
+		assert.Len(t, res.Stdout.Lines(), 1) // only the header
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init().StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.RunIPFS("swarm", "history")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "Swarm.ConnectionHistory.Enabled")
+	})
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type swarmProbeOutput struct {
+	Peer    string
+	Results []struct {
+		Addr      string
+		Transport string
+		Success   bool
+		Error     string
+		Security  string
+		Muxer     string
+	}
+}
+
+func TestSwarmProbe(t *testing.T) {
+	t.Parallel()
+
+	nodes := harness.NewT(t).NewNodes(2).Init()
+	node, other := nodes[0], nodes[1]
+	tcpAddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", harness.NewRandPort())
+	wsAddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d/ws", harness.NewRandPort())
+	other.UpdateConfig(func(cfg *config.Config) {
+		cfg.Addresses.Swarm = []string{tcpAddr, wsAddr}
+	})
+	nodes.StartDaemons()
+	defer nodes.StopDaemons()
+	node.Connect(other)
+
+	res := node.IPFS("swarm", "probe", "--enc=json", other.PeerID().String())
+	var out swarmProbeOutput
+	require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+	assert.Equal(t, other.PeerID().String(), out.Peer)
+
+	results := map[string]bool{}
+	for _, r := range out.Results {
+		results[r.Transport] = r.Success
+		assert.True(t, r.Success, "%s: %s", r.Addr, r.Error)
+		assert.NotEmpty(t, r.Security)
+		assert.NotEmpty(t, r.Muxer)
+	}
+	assert.Contains(t, results, "tcp")
+	assert.Contains(t, results, "ws")
+
+	// the connection used by the node is left untouched
+	assert.Len(t, node.Peers(), 1)
+
+	t.Run("unreachable address", func(t *testing.T) {
+		addr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d/p2p/%s", harness.NewRandPort(), other.PeerID())
+		res := node.IPFS("swarm", "probe", "--enc=json", "--dial-timeout=5s", addr)
+		var out swarmProbeOutput
+		require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &out))
+		require.Len(t, out.Results, 1)
+		assert.False(t, out.Results[0].Success)
+		assert.NotEmpty(t, out.Results[0].Error)
+	})
+}
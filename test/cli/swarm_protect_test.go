@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmProtect(t *testing.T) {
+	t.Parallel()
+
+	const peerID = "12D3KooWGC6TvWhfapngX6wvJHMYvKpDMXPb3ZnCZ6dMoaMtimQ5"
+
+	t.Run("protect and unprotect", func(t *testing.T) {
+		t.Parallel()
+		id, err := peer.Decode(peerID)
+		require.NoError(t, err)
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.ConnMgr.ProtectedPeers = []config.ConnMgrProtectedPeer{
+				{ID: id},
+				{ID: id, Tag: config.NewOptionalString("storage"), Weight: config.NewOptionalInteger(100)},
+			}
+		})
+		node.StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.IPFS("swarm", "protect", peerID)
+		assert.Equal(t, `protect `+peerID+` with tag "user"`, res.Stdout.Trimmed())
+		res = node.IPFS("swarm", "protect", "--tag=app", peerID)
+		assert.Equal(t, `protect `+peerID+` with tag "app"`, res.Stdout.Trimmed())
+		res = node.IPFS("swarm", "protect", "--tag=backup", "--weight=50", peerID)
+		assert.Equal(t, `weigh `+peerID+` at 50 with tag "backup"`, res.Stdout.Trimmed())
+
+		res = node.IPFS("swarm", "unprotect", peerID)
+		assert.Equal(t, `unprotect `+peerID+` with tag "user": still protected by other tags`, res.Stdout.Trimmed())
+		res = node.IPFS("swarm", "unprotect", "--tag=app", peerID)
+		assert.Equal(t, `unprotect `+peerID+` with tag "app": still protected by other tags`, res.Stdout.Trimmed())
+		res = node.IPFS("swarm", "unprotect", "--tag=config", peerID)
+		assert.Equal(t, `unprotect `+peerID+` with tag "config"`, res.Stdout.Trimmed())
+
+		res = node.RunIPFS("swarm", "protect", "not-a-peer")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "invalid peer ID")
+	})
+
+	t.Run("requires the basic connection manager", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.ConnMgr.Type = config.NewOptionalString("none")
+		})
+		node.StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.RunIPFS("swarm", "protect", peerID)
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "Swarm.ConnMgr.Type")
+	})
+}
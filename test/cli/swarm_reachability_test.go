@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmReachability(t *testing.T) {
+	t.Parallel()
+
+	node := harness.NewT(t).NewNode().Init().StartDaemon()
+	defer node.StopDaemon()
+
+	res := node.IPFS("swarm", "reachability", "--enc=json")
+	var status libp2p.ReachabilityStatus
+	require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &status))
+
+	require.Len(t, status.Transports, 1)
+	tcp := status.Transports[0]
+	assert.Equal(t, "ip4", tcp.Family)
+	assert.Equal(t, "tcp", tcp.Transport)
+	// the node only listens on a loopback address
+	assert.Empty(t, tcp.PublicAddrs)
+	assert.False(t, status.PortMapping.Enabled)
+
+	res = node.IPFS("swarm", "reachability")
+	assert.Contains(t, res.Stdout.String(), "AutoNAT (node-wide):")
+	assert.Contains(t, res.Stdout.String(), "Port mapping: disabled")
+
+	node.StopDaemon()
+	res = node.RunIPFS("swarm", "reachability")
+	assert.Equal(t, 1, res.ExitCode())
+}
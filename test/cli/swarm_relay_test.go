@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/kubo/config"
+	"github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/ipfs/kubo/test/cli/harness"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwarmRelayStats(t *testing.T) {
+	t.Parallel()
+
+	t.Run("accounts for the reservations of the allowed peers", func(t *testing.T) {
+		t.Parallel()
+		nodes := harness.NewT(t).NewNodes(3).Init()
+		relay, allowed, denied := nodes[0], nodes[1], nodes[2]
+
+		relayAddr := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", harness.NewRandPort())
+		relay.UpdateConfig(func(cfg *config.Config) {
+			cfg.Addresses.Swarm = []string{relayAddr}
+			cfg.Internal.Libp2pForceReachability = config.NewOptionalString("public")
+			cfg.Swarm.RelayService.Enabled = config.True
+			cfg.Swarm.RelayService.ReservationAllowList = []peer.ID{allowed.PeerID()}
+			cfg.Swarm.RelayService.Accounting = config.True
+		})
+		for _, client := range []*harness.Node{allowed, denied} {
+			client.UpdateConfig(func(cfg *config.Config) {
+				cfg.Addresses.Swarm = []string{"/ip4/127.0.0.1/tcp/0"}
+				cfg.Internal.Libp2pForceReachability = config.NewOptionalString("private")
+				cfg.Swarm.RelayClient.Enabled = config.True
+				cfg.Swarm.RelayClient.StaticRelays = []string{relayAddr + "/p2p/" + relay.PeerID().String()}
+			})
+		}
+		nodes.StartDaemons()
+		defer nodes.StopDaemons()
+
+		var status libp2p.RelayServiceStatus
+		assert.Eventually(t, func() bool {
+			res := relay.IPFS("swarm", "relay", "stats", "--enc=json")
+			status = libp2p.RelayServiceStatus{}
+			require.NoError(t, json.Unmarshal(res.Stdout.Bytes(), &status))
+			return len(status.Reservations) == 1 && status.Denied > 0
+		}, 30*time.Second, 500*time.Millisecond)
+		require.Len(t, status.Reservations, 1)
+		assert.Equal(t, allowed.PeerID(), status.Reservations[0].Peer)
+		assert.True(t, status.Reservations[0].Expires.After(time.Now()))
+
+		res := relay.IPFS("swarm", "relay", "stats")
+		assert.Contains(t, res.Stdout.String(), allowed.PeerID().String())
+		assert.NotContains(t, res.Stdout.String(), denied.PeerID().String())
+	})
+
+	t.Run("fails when the relay service is disabled", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.RelayService.Enabled = config.False
+		})
+		node.StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.RunIPFS("swarm", "relay", "stats")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "Swarm.RelayService.Enabled")
+	})
+
+	t.Run("fails when the accounting is disabled", func(t *testing.T) {
+		t.Parallel()
+		node := harness.NewT(t).NewNode().Init()
+		node.UpdateConfig(func(cfg *config.Config) {
+			cfg.Swarm.RelayService.Enabled = config.True
+		})
+		node.StartDaemon()
+		defer node.StopDaemon()
+
+		res := node.RunIPFS("swarm", "relay", "stats")
+		assert.Equal(t, 1, res.ExitCode())
+		assert.Contains(t, res.Stderr.String(), "Swarm.RelayService.Accounting")
+	})
+}